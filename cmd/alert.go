@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	"github.com/feederco/really-simple-db-backup/pkg/alerting"
+)
+
+const alertStateFileName = "alerts.json"
+const alertDefaultThrottleHours = 6
+
+// alertError alerts a critical failure in stage of the active run
+func alertError(stage string, message string, err error) {
+	alertFailure(alerting.SeverityCritical, stage, message, err)
+}
+
+// alertWarning alerts a failure in stage of the active run that did not stop it
+func alertWarning(stage string, message string, err error) {
+	alertFailure(alerting.SeverityWarning, stage, message, err)
+}
+
+// alertFailure sends a failure alert, unless the same failure was already alerted within the throttle period
+func alertFailure(severity alerting.Severity, stage string, message string, err error) {
+	alert := pkg.NewErrorAlert(severity, message, err)
+	if activeRun != nil {
+		alert.DedupKey = alertDedupKey(activeRun.Hostname, configStruct.Job, activeRun.Job, stage)
+		activeRun.noteAlert(alert.DedupKey)
+	}
+
+	throttle := loadAlertThrottle()
+	if throttle == nil || alert.DedupKey == "" {
+		pkg.SendAlert(configStruct.Alerting, alert)
+		return
+	}
+
+	shouldSend, suppressed := throttle.Failure(alert, time.Now())
+	saveAlertThrottle(throttle)
+
+	if !shouldSend {
+		pkg.LogAlert(alert)
+		pkg.Log.Printf("Not sending alert for %s, it was already sent in the last %s (%d suppressed)\n", alert.DedupKey, alertThrottlePeriod(), suppressed)
+		return
+	}
+
+	if suppressed > 0 {
+		alert.Message += fmt.Sprintf(" (%d similar %s suppressed)", suppressed, pluralize(suppressed, "alert", "alerts"))
+	}
+
+	pkg.SendAlert(configStruct.Alerting, alert)
+}
+
+// resolveAlerts announces that open failures of the job of run are over, after run succeeded.
+// Failures alerted by run itself, like a warning about pruning, stay open
+func resolveAlerts(run *runRecord) {
+	throttle := loadAlertThrottle()
+	if throttle == nil {
+		return
+	}
+
+	keyPrefix := alertDedupKey(run.Hostname, configStruct.Job, run.Job, "")
+	resolved := throttle.Resolve(func(key string) bool {
+		return strings.HasPrefix(key, keyPrefix) && !run.alertKeys[key]
+	})
+	if len(resolved) == 0 {
+		return
+	}
+
+	saveAlertThrottle(throttle)
+
+	for _, open := range resolved {
+		message := fmt.Sprintf("%s succeeded again. Resolves: %s (failing since %s)", run.Job, open.Message, open.FirstSeen.Format(time.RFC3339))
+		pkg.SendAlert(configStruct.Alerting, pkg.NewResolvedAlert(open.Key, message))
+	}
+}
+
+// Commands of the same family share dedup keys, so a failing upload in `perform-full`
+// is resolved by the next successful `perform-incremental`. The key is sent to PagerDuty and Opsgenie,
+// so it starts with the hostname and the backup job of the config to keep the failures of the hosts of a fleet apart
+func alertDedupKey(hostname string, backupJob string, job string, stage string) string {
+	if backupJob != "" {
+		hostname += "/" + backupJob
+	}
+	return hostname + "/" + jobFamily(job) + "/" + stage
+}
+
+func alertThrottlePeriod() time.Duration {
+	hours := alertDefaultThrottleHours
+	if configStruct.Alerting != nil && configStruct.Alerting.ThrottleHours != 0 {
+		hours = configStruct.Alerting.ThrottleHours
+	}
+
+	// A negative value sends every alert
+	if hours < 0 {
+		return 0
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+func loadAlertThrottle() *alerting.Throttle {
+	throttle, err := alerting.LoadThrottle(path.Join(configStruct.PersistentStorage, alertStateFileName), alertThrottlePeriod())
+	if err != nil {
+		pkg.ErrorLog.Println("Warning: Could not read alert state. Alerts will not be throttled.", err)
+		return nil
+	}
+	return throttle
+}
+
+func saveAlertThrottle(throttle *alerting.Throttle) {
+	if err := throttle.Save(); err != nil {
+		pkg.ErrorLog.Println("Warning: Could not save alert state.", err)
+	}
+}
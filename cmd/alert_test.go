@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	"github.com/feederco/really-simple-db-backup/pkg/alerting"
+)
+
+func TestAlertsAreResolvedBySuccessfulRun(t *testing.T) {
+	pkg.Log = log.New(ioutil.Discard, "", 0)
+	pkg.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	directory, err := ioutil.TempDir("", "alerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	previousConfig := configStruct
+	defer func() { configStruct = previousConfig }()
+	configStruct = ConfigStruct{PersistentStorage: directory}
+
+	failedRun := startRun("perform-full", "db1")
+	uploadErr := withStage(stageUpload, errors.New("connection reset"))
+	alertError(stageUpload, "Could not upload backup", uploadErr)
+	recordJob(failedRun, uploadErr)
+
+	// Succeeds, but warns about pruning
+	run := startRun("perform-incremental", "db1")
+	alertWarning(stagePrune, "Could not prune", errors.New("access denied"))
+	recordJob(run, nil)
+
+	throttle, err := alerting.LoadThrottle(path.Join(directory, alertStateFileName), alertThrottlePeriod())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(throttle.Open) != 1 || throttle.Open["db1/perform/prune"] == nil {
+		t.Errorf("Expected upload failure to be resolved and prune warning to stay open: %v", throttle.Open)
+	}
+
+	// Another command doesn't resolve it
+	recordJob(startRun("verify", "db1"), nil)
+
+	throttle, _ = alerting.LoadThrottle(path.Join(directory, alertStateFileName), alertThrottlePeriod())
+	if throttle.Open["db1/perform/prune"] == nil {
+		t.Error("Expected prune warning to stay open after verify")
+	}
+}
+
+func TestAlertsOfOtherHostsStayOpen(t *testing.T) {
+	pkg.Log = log.New(ioutil.Discard, "", 0)
+	pkg.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	directory, err := ioutil.TempDir("", "alerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	previousConfig := configStruct
+	defer func() { configStruct = previousConfig }()
+	configStruct = ConfigStruct{PersistentStorage: directory}
+
+	failedRun := startRun("perform", "db2")
+	uploadErr := withStage(stageUpload, errors.New("connection reset"))
+	alertError(stageUpload, "Could not upload backup", uploadErr)
+	recordJob(failedRun, uploadErr)
+
+	recordJob(startRun("perform", "db1"), nil)
+
+	throttle, err := alerting.LoadThrottle(path.Join(directory, alertStateFileName), alertThrottlePeriod())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if throttle.Open["db2/perform/upload"] == nil {
+		t.Errorf("Expected the failure of db2 to stay open after db1 succeeded: %v", throttle.Open)
+	}
+}
+
+func TestAlertsOfOtherJobsStayOpen(t *testing.T) {
+	pkg.Log = log.New(ioutil.Discard, "", 0)
+	pkg.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	directory, err := ioutil.TempDir("", "alerts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	previousConfig := configStruct
+	defer func() { configStruct = previousConfig }()
+	configStruct = ConfigStruct{PersistentStorage: directory, Job: "analytics"}
+
+	failedRun := startRun("perform", "db1")
+	uploadErr := withStage(stageUpload, errors.New("connection reset"))
+	alertError(stageUpload, "Could not upload backup", uploadErr)
+	recordJob(failedRun, uploadErr)
+
+	configStruct.Job = "main"
+	recordJob(startRun("perform", "db1"), nil)
+
+	throttle, err := alerting.LoadThrottle(path.Join(directory, alertStateFileName), alertThrottlePeriod())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if throttle.Open["db1/analytics/perform/upload"] == nil {
+		t.Errorf("Expected the failure of job analytics to stay open after job main succeeded: %v", throttle.Open)
+	}
+}
@@ -0,0 +1,303 @@
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+const apiDefaultRunsLimit = 50
+
+// apiServer serves the management API of the daemon. Jobs it starts are handed to the daemon over Triggers so they never overlap with scheduled jobs
+type apiServer struct {
+	Token       string
+	Hostname    string
+	Runners     map[string]func(ctx context.Context) error
+	Triggers    chan<- daemonTrigger
+	MinioClient *minio.Client
+}
+
+type apiBackup struct {
+	Name        string    `json:"name"`
+	Path        string    `json:"path"`
+	Type        string    `json:"type"`
+	SizeInBytes int64     `json:"size_in_bytes"`
+	CreatedAt   time.Time `json:"created_at"`
+	LineageID   int64     `json:"lineage_id"`
+}
+
+// apiRun is a run from the journal, or the run in progress with its live progress
+type apiRun struct {
+	runRecord
+	Running  bool          `json:"running"`
+	Stage    string        `json:"stage,omitempty"`
+	Progress *pkg.Progress `json:"progress,omitempty"`
+}
+
+type apiStartedRun struct {
+	RunID string `json:"run_id"`
+	Job   string `json:"job"`
+}
+
+type apiPrunePlan struct {
+	DryRun  bool        `json:"dry_run"`
+	Backups []apiBackup `json:"backups"`
+}
+
+// Handler returns the routes of the API, all behind the token
+func (api *apiServer) Handler() http.Handler {
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/backups", api.handleBackups)
+	serveMux.HandleFunc("/runs", api.handleRuns)
+	serveMux.HandleFunc("/runs/", api.handleRun)
+	serveMux.HandleFunc("/prune", api.handlePrune)
+
+	return api.authenticate(serveMux)
+}
+
+func (api *apiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		authorization := request.Header.Get("Authorization")
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if api.Token == "" || token == authorization || subtle.ConstantTimeCompare([]byte(token), []byte(api.Token)) != 1 {
+			writeAPIError(responseWriter, http.StatusUnauthorized, errors.New("Missing or incorrect token"))
+			return
+		}
+
+		next.ServeHTTP(responseWriter, request)
+	})
+}
+
+// GET lists the backups of this host, or of ?hostname=. POST starts a backup of type perform (the default), full or incremental
+func (api *apiServer) handleBackups(responseWriter http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		hostname := api.Hostname
+		if otherHostname := request.URL.Query().Get("hostname"); otherHostname != "" {
+			hostname = configStruct.backupLayout().hostDirectory(otherHostname)
+		}
+
+		allBackups, err := listAllBackups(hostname, configStruct.DigitalOcean.SpaceName, api.MinioClient)
+		if err != nil {
+			writeAPIError(responseWriter, http.StatusBadGateway, err)
+			return
+		}
+
+		writeAPIResponse(responseWriter, http.StatusOK, toAPIBackups(allBackups))
+	case http.MethodPost:
+		var body struct {
+			Type string `json:"type"`
+		}
+		err := decodeAPIBody(request, &body)
+		if err != nil {
+			writeAPIError(responseWriter, http.StatusBadRequest, err)
+			return
+		}
+
+		job := daemonJobPerform
+		switch body.Type {
+		case "", "perform", backupTypeDecide:
+		case backupTypeFull:
+			job = daemonJobFull
+		case backupTypeIncremental:
+			job = daemonJobIncremental
+		default:
+			writeAPIError(responseWriter, http.StatusBadRequest, errors.New("Unknown backup type: "+body.Type+". Should be full or incremental"))
+			return
+		}
+
+		api.trigger(responseWriter, request, job)
+	default:
+		writeAPIError(responseWriter, http.StatusMethodNotAllowed, errors.New("Use GET or POST"))
+	}
+}
+
+// POST removes the backups outside of the retention window. With {"dry_run": true} it only returns them
+func (api *apiServer) handlePrune(responseWriter http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writeAPIError(responseWriter, http.StatusMethodNotAllowed, errors.New("Use POST"))
+		return
+	}
+
+	var body struct {
+		DryRun bool `json:"dry_run"`
+	}
+	err := decodeAPIBody(request, &body)
+	if err != nil {
+		writeAPIError(responseWriter, http.StatusBadRequest, err)
+		return
+	}
+
+	if configStruct.Retention == nil {
+		writeAPIError(responseWriter, http.StatusConflict, errors.New("No retention config. Nothing to prune"))
+		return
+	}
+
+	if !body.DryRun {
+		api.trigger(responseWriter, request, daemonJobPrune)
+		return
+	}
+
+	backupsToDelete, err := planPrune(api.Hostname, configStruct.DigitalOcean.SpaceName, configStruct.Retention, api.MinioClient)
+	if err != nil {
+		writeAPIError(responseWriter, http.StatusBadGateway, err)
+		return
+	}
+
+	writeAPIResponse(responseWriter, http.StatusOK, apiPrunePlan{DryRun: true, Backups: toAPIBackups(backupsToDelete)})
+}
+
+// GET lists the most recent runs, newest first, limited by ?limit=
+func (api *apiServer) handleRuns(responseWriter http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		writeAPIError(responseWriter, http.StatusMethodNotAllowed, errors.New("Use GET"))
+		return
+	}
+
+	limit := apiDefaultRunsLimit
+	if limitParam := request.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			writeAPIError(responseWriter, http.StatusBadRequest, errors.New("limit should be a positive number"))
+			return
+		}
+	}
+
+	runs, err := api.runs()
+	if err != nil {
+		writeAPIError(responseWriter, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(runs) > limit {
+		runs = runs[:limit]
+	}
+
+	writeAPIResponse(responseWriter, http.StatusOK, runs)
+}
+
+// GET returns the run with the ID in the path
+func (api *apiServer) handleRun(responseWriter http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		writeAPIError(responseWriter, http.StatusMethodNotAllowed, errors.New("Use GET"))
+		return
+	}
+
+	runID := path.Base(request.URL.Path)
+
+	runs, err := api.runs()
+	if err != nil {
+		writeAPIError(responseWriter, http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, run := range runs {
+		if run.ID == runID {
+			writeAPIResponse(responseWriter, http.StatusOK, run)
+			return
+		}
+	}
+
+	writeAPIError(responseWriter, http.StatusNotFound, errors.New("No run with ID "+runID))
+}
+
+// runs returns the run in progress followed by the journal, newest first
+func (api *apiServer) runs() ([]apiRun, error) {
+	journal, err := readJournal(configStruct.PersistentStorage, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]apiRun, 0, len(journal)+1)
+
+	// The daemon itself is not a job
+	if run, running := currentRun(); running && isRecordedJob(run.Job) {
+		runs = append(runs, apiRun{
+			runRecord: run,
+			Running:   true,
+			Stage:     pkg.CurrentLogStage(),
+			Progress:  pkg.CurrentProgress(),
+		})
+	}
+
+	for index := len(journal) - 1; index >= 0; index-- {
+		runs = append(runs, apiRun{runRecord: journal[index]})
+	}
+
+	return runs, nil
+}
+
+// trigger asks the daemon to start job and answers with the ID of its run
+func (api *apiServer) trigger(responseWriter http.ResponseWriter, request *http.Request, job string) {
+	result := make(chan daemonTriggerResult, 1)
+
+	select {
+	case api.Triggers <- daemonTrigger{Job: &daemonJob{Name: job, Run: api.Runners[job]}, Result: result}:
+	case <-request.Context().Done():
+		return
+	}
+
+	started := <-result
+	switch {
+	case errors.Is(started.Err, errDaemonBusy):
+		writeAPIError(responseWriter, http.StatusConflict, started.Err)
+	case started.Err != nil:
+		writeAPIError(responseWriter, http.StatusServiceUnavailable, started.Err)
+	default:
+		pkg.Log.Printf("Started %s run %s requested over the API by %s\n", job, started.RunID, request.RemoteAddr)
+
+		responseWriter.Header().Set("Location", "/runs/"+started.RunID)
+		writeAPIResponse(responseWriter, http.StatusAccepted, apiStartedRun{RunID: started.RunID, Job: daemonJobCommands[job]})
+	}
+}
+
+func toAPIBackups(backups []backupItem) []apiBackup {
+	apiBackups := make([]apiBackup, len(backups))
+	for index, backup := range backups {
+		apiBackups[index] = apiBackup{
+			Name:        path.Base(backup.Path),
+			Path:        backup.Path,
+			Type:        backup.BackupType,
+			SizeInBytes: backup.Size,
+			CreatedAt:   backup.CreatedAt,
+			LineageID:   backup.LineageID,
+		}
+	}
+	return apiBackups
+}
+
+// decodeAPIBody decodes the JSON body of request into value. An empty body leaves value as is
+func decodeAPIBody(request *http.Request, value interface{}) error {
+	err := json.NewDecoder(request.Body).Decode(value)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return errors.New("Could not decode JSON body: " + err.Error())
+	}
+	return nil
+}
+
+func writeAPIResponse(responseWriter http.ResponseWriter, status int, value interface{}) {
+	responseWriter.Header().Set("Content-Type", "application/json")
+	responseWriter.WriteHeader(status)
+
+	encoder := json.NewEncoder(responseWriter)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+func writeAPIError(responseWriter http.ResponseWriter, status int, err error) {
+	writeAPIResponse(responseWriter, status, map[string]string{"error": err.Error()})
+}
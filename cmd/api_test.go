@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+)
+
+func newTestAPI(t *testing.T) (*apiServer, chan daemonTrigger, func()) {
+	pkg.Log = log.New(ioutil.Discard, "", 0)
+	pkg.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	directory, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousConfig := configStruct
+	configStruct = ConfigStruct{PersistentStorage: directory}
+
+	triggers := make(chan daemonTrigger)
+	api := &apiServer{Token: "secret", Hostname: "db1", Triggers: triggers}
+
+	return api, triggers, func() {
+		configStruct = previousConfig
+		os.RemoveAll(directory)
+	}
+}
+
+func apiRequest(api *apiServer, method string, target string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer secret")
+
+	recorder := httptest.NewRecorder()
+	api.Handler().ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestAPIRequiresToken(t *testing.T) {
+	api, _, cleanup := newTestAPI(t)
+	defer cleanup()
+
+	for _, header := range []string{"", "Bearer wrong", "secret"} {
+		request := httptest.NewRequest(http.MethodGet, "/runs", nil)
+		if header != "" {
+			request.Header.Set("Authorization", header)
+		}
+
+		recorder := httptest.NewRecorder()
+		api.Handler().ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for %q, got %d", header, recorder.Code)
+		}
+	}
+}
+
+func TestAPIRuns(t *testing.T) {
+	api, _, cleanup := newTestAPI(t)
+	defer cleanup()
+
+	for index := 1; index <= 3; index++ {
+		run := &runRecord{ID: fmt.Sprintf("run%d", index), Job: "perform", StartedAt: time.Now(), Success: true}
+		if err := appendToJournal(configStruct.PersistentStorage, run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := startRun("perform-full", "db1")
+	defer recordJob(run, nil)
+	enterStage(stageUpload)
+
+	progress := pkg.NewProgressReporter("Uploading", 200)
+	pkg.ShowProgressBars = false
+	defer func() { pkg.ShowProgressBars = true }()
+	progress.Start()
+	progress.Add(50)
+	defer progress.Finish()
+
+	recorder := apiRequest(api, http.MethodGet, "/runs?limit=3", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var runs []apiRun
+	if err := json.Unmarshal(recorder.Body.Bytes(), &runs); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(runs) != 3 || runs[0].ID != run.ID || runs[1].ID != "run3" || runs[2].ID != "run2" {
+		t.Fatalf("Expected the run in progress followed by the newest runs: %v", runs)
+	}
+
+	if !runs[0].Running || runs[0].Stage != stageUpload || runs[0].Progress == nil || runs[0].Progress.Percent != 25 {
+		t.Errorf("Expected live progress of the run in progress: %+v", runs[0])
+	}
+
+	recorder = apiRequest(api, http.MethodGet, "/runs/run1", "")
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"id": "run1"`) {
+		t.Errorf("Expected run1, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	recorder = apiRequest(api, http.MethodGet, "/runs/nope", "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", recorder.Code)
+	}
+}
+
+func TestAPITriggersJobs(t *testing.T) {
+	api, triggers, cleanup := newTestAPI(t)
+	defer cleanup()
+
+	go func() {
+		trigger := <-triggers
+		trigger.Result <- daemonTriggerResult{RunID: "abc", Err: nil}
+
+		trigger = <-triggers
+		trigger.Result <- daemonTriggerResult{Err: fmt.Errorf("%w: %s", errDaemonBusy, trigger.Job.Name)}
+	}()
+
+	recorder := apiRequest(api, http.MethodPost, "/backups", `{"type": "full"}`)
+	if recorder.Code != http.StatusAccepted || recorder.Header().Get("Location") != "/runs/abc" {
+		t.Errorf("Expected 202 with the run, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var started apiStartedRun
+	json.Unmarshal(recorder.Body.Bytes(), &started)
+	if started.Job != "perform-full" {
+		t.Errorf("Expected perform-full to be started: %v", started)
+	}
+
+	recorder = apiRequest(api, http.MethodPost, "/backups", "")
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("Expected 409 while another job runs, got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	recorder = apiRequest(api, http.MethodPost, "/backups", `{"type": "partial"}`)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown type, got %d", recorder.Code)
+	}
+
+	recorder = apiRequest(api, http.MethodPost, "/prune", `{"dry_run": true}`)
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("Expected 409 without a retention config, got %d", recorder.Code)
+	}
+}
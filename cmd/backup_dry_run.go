@@ -0,0 +1,352 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+// volumePlan is the volume a run would write to
+type volumePlan struct {
+	Name            string
+	ID              string
+	SizeInGigaBytes int64
+	MountDirectory  string
+
+	// Whether the run would use a volume or directory passed in instead of creating a volume
+	ExistingVolume    bool
+	ExistingDirectory bool
+}
+
+func (plan volumePlan) String() string {
+	switch {
+	case plan.ExistingDirectory:
+		return "none, writes to " + plan.MountDirectory
+	case plan.ExistingVolume:
+		return fmt.Sprintf("existing volume %s (%s), mounted at %s", plan.Name, plan.ID, plan.MountDirectory)
+	default:
+		return fmt.Sprintf("would create %s with %d GB, mounted at %s", plan.Name, plan.SizeInGigaBytes, plan.MountDirectory)
+	}
+}
+
+// planVolume returns the volume createAndMountVolumeForUse would create or use at now, without creating or mounting it
+func planVolume(volumePrefix string, sizeInGb int64, now time.Time, existingVolumeID string, existingDirectory string, digitalOceanClient *pkg.DigitalOceanClient) (volumePlan, error) {
+	if existingDirectory != "" {
+		return volumePlan{MountDirectory: existingDirectory, ExistingDirectory: true}, nil
+	}
+
+	if existingVolumeID != "" {
+		volume, err := pkg.FindVolume(existingVolumeID, digitalOceanClient)
+		if err != nil {
+			return volumePlan{}, err
+		}
+
+		return volumePlan{
+			Name:            volume.Name,
+			ID:              volume.ID,
+			SizeInGigaBytes: volume.SizeGigaBytes,
+			MountDirectory:  volumeMountDirectory(volume),
+			ExistingVolume:  true,
+		}, nil
+	}
+
+	name := volumePrefix + now.Format(volumeNameTimeFormat)
+	return volumePlan{
+		Name:            name,
+		SizeInGigaBytes: sizeInGb,
+		MountDirectory:  volumeMountDirectory(&godo.Volume{Name: name}),
+	}, nil
+}
+
+// performPlan is what `perform -dry-run` shows
+type performPlan struct {
+	Hostname string
+	Bucket   string
+
+	BackupType       string
+	BackupTypeReason string
+
+	MysqlDataPath   string
+	DataSizeInBytes int64
+	Volume          volumePlan
+
+	// Writes the backup to BackupFile
+	Xtrabackup pkg.Command
+	BackupFile string
+
+	ObjectKey string
+
+	// Only set for full backups
+	ServerConfigKey string
+
+	// Hooks that would run, by name
+	Hooks map[string]string
+
+	// Backups the automatic prune after the backup would remove, or why it wouldn't run
+	Prune       []backupItem
+	PruneReason string
+}
+
+// backupMysqlPerformDryRun prints what backupMysqlPerform would do now. Nothing is created, uploaded or removed
+func backupMysqlPerformDryRun(backupType string, hostname string, backupsBucket string, mysqlDataPath string, existingVolumeID string, existingBackupDirectory string, persistentStorageDirectory string, digitalOceanClient *pkg.DigitalOceanClient, minioClient *minio.Client, output io.Writer) error {
+	now := time.Now()
+
+	if backupType != backupTypeFull && backupType != backupTypeIncremental && backupType != backupTypeDecide {
+		return withStage(stagePrerequisites, errors.New("Invalid backupType: "+backupType))
+	}
+
+	lastLsn, lsnErr := getLastLSNFromFile(path.Join(persistentStorageDirectory, "xtrabackup_checkpoints"))
+	if lsnErr != nil {
+		lastLsn = ""
+	}
+
+	enterStage(stageList)
+	allBackups, err := listAllBackups(hostname, backupsBucket, minioClient)
+	if err != nil {
+		return withStage(stageList, err)
+	}
+
+	dataSizeInBytes, err := pkg.DirSize(mysqlDataPath)
+	if err != nil {
+		return withStage(stagePrerequisites, fmt.Errorf("Could not get size of database: %s", err))
+	}
+
+	volume, err := planVolume(volumePrefixBackup, backupVolumeSizeInGigaBytes(dataSizeInBytes), now, existingVolumeID, existingBackupDirectory, digitalOceanClient)
+	if err != nil {
+		return withStage(stageVolume, err)
+	}
+
+	plan := buildPerformPlan(backupType, hostname, backupsBucket, allBackups, lastLsn, mysqlDataPath, dataSizeInBytes, volume, persistentStorageDirectory, now)
+	plan.Write(output)
+
+	return nil
+}
+
+func buildPerformPlan(backupType string, hostname string, backupsBucket string, allBackups []backupItem, lastLsn string, mysqlDataPath string, dataSizeInBytes int64, volume volumePlan, persistentStorageDirectory string, now time.Time) performPlan {
+	plan := performPlan{
+		Hostname:        hostname,
+		Bucket:          backupsBucket,
+		MysqlDataPath:   mysqlDataPath,
+		DataSizeInBytes: dataSizeInBytes,
+		Volume:          volume,
+		Hooks:           make(map[string]string),
+	}
+
+	switch backupType {
+	case backupTypeDecide:
+		plan.BackupType, plan.BackupTypeReason = describeNextPerform(findRelevantBackupsUpTo(now, allBackups), allBackups, lastLsn != "", configStruct.Retention, now)
+	case backupTypeIncremental:
+		plan.BackupType, plan.BackupTypeReason = backupType, "forced by perform-incremental"
+		if lastLsn == "" {
+			plan.BackupTypeReason += ", but there is no LSN to continue from so xtrabackup takes a full backup"
+		}
+	default:
+		plan.BackupType, plan.BackupTypeReason = backupType, "forced by perform-full"
+	}
+
+	// Only incremental backups continue from the LSN of the previous backup
+	if plan.BackupType != backupTypeIncremental {
+		lastLsn = ""
+	}
+
+	plan.ObjectKey = configStruct.backupLayout().backupKey(hostname, now, plan.BackupType)
+	backupDirectory, backupFile := backupFileLocation(volume.MountDirectory, plan.BackupType, plan.ObjectKey)
+	plan.BackupFile = backupFile + ".incomplete"
+	plan.Xtrabackup = pkg.Command{Name: "xtrabackup", Args: xtrabackupBackupArgs(backupDirectory, persistentStorageDirectory, mysqlDataPath, lastLsn)}
+
+	if plan.BackupType == backupTypeFull {
+		plan.ServerConfigKey = serverConfigArchivePath(plan.ObjectKey)
+	}
+
+	for _, name := range []string{hookPreBackup, hookPostBackup} {
+		if hook := configStruct.Hooks.hook(name); hook != nil {
+			plan.Hooks[name] = hook.Command
+		}
+	}
+
+	switch {
+	case plan.BackupType != backupTypeFull:
+		plan.PruneReason = "only runs after full backups"
+	case configStruct.Retention == nil || !configStruct.Retention.AutomaticallyRemoveOld:
+		plan.PruneReason = "retention.automatically_remove_old is off"
+	default:
+		plan.Prune = findBackupsThatCanBeDeleted(allBackups, now, configStruct.Retention)
+	}
+
+	return plan
+}
+
+// Write prints the plan
+func (plan performPlan) Write(output io.Writer) {
+	fmt.Fprintf(output, "Dry run of a backup of %s. Nothing was changed\n\n", plan.Hostname)
+
+	table := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "Backup type:\t%s (%s)\n", plan.BackupType, plan.BackupTypeReason)
+	fmt.Fprintf(table, "Data:\t%s in %s\n", pkg.FormatBytes(plan.DataSizeInBytes), plan.MysqlDataPath)
+	fmt.Fprintf(table, "Volume:\t%s\n", plan.Volume)
+	if command, exists := plan.Hooks[hookPreBackup]; exists {
+		fmt.Fprintf(table, "Hook pre_backup:\t%s\n", command)
+	}
+	fmt.Fprintf(table, "Backup:\t%s > %s\n", plan.Xtrabackup, plan.BackupFile)
+	fmt.Fprintf(table, "Upload to:\t%s in %s\n", plan.ObjectKey, plan.Bucket)
+	if plan.ServerConfigKey != "" {
+		fmt.Fprintf(table, "Server config:\t%s\n", plan.ServerConfigKey)
+	}
+	if command, exists := plan.Hooks[hookPostBackup]; exists {
+		fmt.Fprintf(table, "Hook post_backup:\t%s\n", command)
+	}
+	table.Flush()
+
+	switch {
+	case plan.PruneReason != "":
+		fmt.Fprintf(output, "Automatic prune: no, %s\n", plan.PruneReason)
+	case len(plan.Prune) == 0:
+		fmt.Fprintln(output, "Automatic prune: no backups are outside of the retention window")
+	default:
+		fmt.Fprintf(output, "Automatic prune: would remove %d %s\n", len(plan.Prune), pluralize(len(plan.Prune), "backup", "backups"))
+		writeBackupTable(output, plan.Prune)
+	}
+}
+
+// restorePlan is what `restore -dry-run` shows
+type restorePlan struct {
+	Hostname string
+	Bucket   string
+
+	// The pieces of the restore, newest first like findRelevantBackupsUpTo returns them
+	Lineage         []backupItem
+	DownloadInBytes int64
+
+	Volume volumePlan
+
+	// What the restore runs, in order
+	Steps []string
+}
+
+// backupMysqlRestoreDryRun prints what a restore of the latest backup of fromHostname at or before restoreTimestamp would do.
+// Nothing is downloaded or moved
+func backupMysqlRestoreDryRun(fromHostname string, restoreTimestamp string, backupBucket string, mysqlDataPath string, existingVolumeID string, existingBackupDirectory string, digitalOceanClient *pkg.DigitalOceanClient, minioClient *minio.Client, output io.Writer) error {
+	var err error
+
+	sinceTimestamp := time.Now()
+	if restoreTimestamp != "" {
+		sinceTimestamp, err = parseBackupTimestamp(restoreTimestamp)
+		if err != nil {
+			return withStage(stagePrerequisites, errors.New("Incorrect timestamp passed in: "+restoreTimestamp+" (error: "+err.Error()+")"))
+		}
+	}
+
+	enterStage(stageList)
+	allBackups, err := listAllBackups(fromHostname, backupBucket, minioClient)
+	if err != nil {
+		return withStage(stageList, err)
+	}
+
+	lineage := findRelevantBackupsUpTo(sinceTimestamp, allBackups)
+	if len(lineage) == 0 {
+		return withStage(stageList, errors.New("No backup found to restore from"))
+	}
+
+	totalSizeInBytes := int64(0)
+	for _, backup := range lineage {
+		totalSizeInBytes += backup.Size
+	}
+
+	volume, err := planVolume(volumePrefixRestore, restoreVolumeSizeInGigaBytes(totalSizeInBytes), time.Now(), existingVolumeID, existingBackupDirectory, digitalOceanClient)
+	if err != nil {
+		return withStage(stageVolume, err)
+	}
+
+	plan := buildRestorePlan(fromHostname, backupBucket, lineage, volume, mysqlDataPath, runtime.NumCPU())
+	plan.Write(output)
+
+	return nil
+}
+
+func buildRestorePlan(hostname string, backupsBucket string, lineage []backupItem, volume volumePlan, mysqlDataPath string, numberOfCPUs int) restorePlan {
+	plan := restorePlan{
+		Hostname: hostname,
+		Bucket:   backupsBucket,
+		Lineage:  lineage,
+		Volume:   volume,
+	}
+
+	restoreDirectory := path.Join(volume.MountDirectory, restoreDirectoryName)
+
+	downloadDirectories := make([]string, len(lineage))
+	for index, backup := range lineage {
+		plan.DownloadInBytes += backup.Size
+
+		downloadDirectories[index] = backupDownloadDirectory(restoreDirectory, backup)
+		plan.Steps = append(plan.Steps,
+			fmt.Sprintf("Download %s and extract it with `%s`", backup.Path, xbstreamExtractCommand(downloadDirectories[index], nil)),
+			pkg.Command{Name: "xtrabackup", Args: xtrabackupDecompressArgs(downloadDirectories[index], numberOfCPUs)}.String(),
+		)
+	}
+
+	for _, prepareArgs := range xtrabackupPrepareArgs(downloadDirectories) {
+		plan.Steps = append(plan.Steps, pkg.Command{Name: "xtrabackup", Args: prepareArgs}.String())
+	}
+
+	if hook := configStruct.Hooks.hook(hookPreRestore); hook != nil {
+		plan.Steps = append(plan.Steps, "Hook pre_restore: "+hook.Command)
+	}
+
+	finalDirectory := downloadDirectories[len(downloadDirectories)-1]
+	plan.Steps = append(plan.Steps,
+		pkg.Command{Name: "mv", Args: []string{mysqlDataPath, "/tmp/"}}.String(),
+		pkg.Command{Name: "xtrabackup", Args: xtrabackupCopyBackArgs(finalDirectory, mysqlDataPath)}.String(),
+		pkg.Command{Name: "chown", Args: []string{"-R", "mysql:mysql", mysqlDataPath}}.String(),
+		"Restore the server config from "+serverConfigArchivePath(lineage[len(lineage)-1].Path)+" if it was captured",
+	)
+
+	if hook := configStruct.Hooks.hook(hookPostRestore); hook != nil {
+		plan.Steps = append(plan.Steps, "Hook post_restore: "+hook.Command)
+	}
+
+	if !volume.ExistingDirectory {
+		plan.Steps = append(plan.Steps, "Unmount and destroy volume "+volume.Name)
+	}
+
+	return plan
+}
+
+// Write prints the plan
+func (plan restorePlan) Write(output io.Writer) {
+	fmt.Fprintf(output, "Dry run of a restore of %s. Nothing was changed\n\n", plan.Hostname)
+
+	fmt.Fprintf(output, "Lineage (%d %s from %s):\n", len(plan.Lineage), pluralize(len(plan.Lineage), "piece", "pieces"), plan.Bucket)
+	oldestFirst := make([]backupItem, 0, len(plan.Lineage))
+	for index := len(plan.Lineage) - 1; index >= 0; index-- {
+		oldestFirst = append(oldestFirst, plan.Lineage[index])
+	}
+	writeBackupTable(output, oldestFirst)
+
+	fmt.Fprintln(output)
+	table := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "Download:\t%s\n", pkg.FormatBytes(plan.DownloadInBytes))
+	fmt.Fprintf(table, "Volume:\t%s\n", plan.Volume)
+	table.Flush()
+
+	fmt.Fprintln(output, "\nSteps:")
+	for index, step := range plan.Steps {
+		fmt.Fprintf(output, "  %d. %s\n", index+1, step)
+	}
+}
+
+// writeBackupTable prints a line for every backup, in the format of the lineage of `status`
+func writeBackupTable(output io.Writer, backups []backupItem) {
+	table := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	for _, backup := range backups {
+		fmt.Fprintf(table, "  %s\t%s\t%s\t%s\n", backup.BackupType, backup.CreatedAt.Format("2006-01-02 15:04"), backup.Path, pkg.FormatBytes(backup.Size))
+	}
+	table.Flush()
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+)
+
+func TestPerformPlanExplainsTheBackup(t *testing.T) {
+	defer func(previous ConfigStruct) { configStruct = previous }(configStruct)
+	configStruct = ConfigStruct{
+		Retention: &RetentionConfig{AutomaticallyRemoveOld: true, RetentionInDays: 7, HoursBetweenFullBackups: 24},
+		Hooks:     &HooksConfig{PreBackup: &pkg.HookConfig{Command: "set-maintenance on"}},
+	}
+
+	allBackups := []backupItem{
+		buildBackup(1, "db1/mysql-backup-201901011000.full.xbstream", 100),
+		buildBackup(1, "db1/mysql-backup-201901011100.incremental.xbstream", 1),
+		buildBackup(2, "db1/mysql-backup-201901141000.full.xbstream", 100),
+	}
+	volume := volumePlan{Name: "mysql-backup-20190114120000", SizeInGigaBytes: 11, MountDirectory: "/mnt/mysql_backup_20190114120000"}
+
+	now, _ := parseBackupTimestamp("201901141200")
+	plan := buildPerformPlan(backupTypeDecide, "db1", "backups", allBackups, "1234", "/var/lib/mysql", 10<<30, volume, "/var/lib/rsdb", now)
+
+	if plan.BackupType != backupTypeIncremental || !strings.Contains(plan.BackupTypeReason, "next full backup due in 22h0m") {
+		t.Errorf("Expected an incremental backup until the next full backup is due, got %s (%s)", plan.BackupType, plan.BackupTypeReason)
+	}
+
+	expectedCommand := "xtrabackup --backup --extra-lsndir /var/lib/rsdb --target-dir /mnt/mysql_backup_20190114120000/mysql-backup-incremental/ --compress --stream=xbstream --slave-info --incremental-lsn 1234"
+	if plan.Xtrabackup.String() != expectedCommand {
+		t.Errorf("Wrong command. Got %s", plan.Xtrabackup)
+	}
+
+	if plan.ObjectKey != "db1/mysql-backup-201901141200.incremental.xbstream" || plan.ServerConfigKey != "" {
+		t.Errorf("Wrong keys. Got %s and %q", plan.ObjectKey, plan.ServerConfigKey)
+	}
+
+	if len(plan.Prune) != 0 || plan.PruneReason != "only runs after full backups" {
+		t.Errorf("Expected no prune after an incremental backup, got %v (%s)", plan.Prune, plan.PruneReason)
+	}
+
+	var output bytes.Buffer
+	plan.Write(&output)
+	for _, expected := range []string{
+		"Backup type:      incremental (next full backup due in 22h0m0s)",
+		"Volume:           would create mysql-backup-20190114120000 with 11 GB",
+		"Hook pre_backup:  set-maintenance on",
+		"Backup:           " + expectedCommand + " > /mnt/mysql_backup_20190114120000/mysql-backup-incremental/mysql-backup-201901141200.incremental.xbstream.incomplete",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Expected the plan to contain %q, got:\n%s", expected, output.String())
+		}
+	}
+}
+
+func TestPerformPlanOfAFullBackupListsThePrune(t *testing.T) {
+	defer func(previous ConfigStruct) { configStruct = previous }(configStruct)
+	configStruct = ConfigStruct{
+		Retention: &RetentionConfig{AutomaticallyRemoveOld: true, RetentionInDays: 7, HoursBetweenFullBackups: 24},
+	}
+
+	allBackups := []backupItem{
+		buildBackup(1, "db1/mysql-backup-201901011000.full.xbstream", 100),
+		buildBackup(1, "db1/mysql-backup-201901011100.incremental.xbstream", 1),
+		buildBackup(2, "db1/mysql-backup-201901131000.full.xbstream", 100),
+	}
+
+	now, _ := parseBackupTimestamp("201901141200")
+	plan := buildPerformPlan(backupTypeDecide, "db1", "backups", allBackups, "1234", "/var/lib/mysql", 10<<30, volumePlan{MountDirectory: "/backups", ExistingDirectory: true}, "/var/lib/rsdb", now)
+
+	if plan.BackupType != backupTypeFull {
+		t.Errorf("Expected a full backup a day after the last one, got %s (%s)", plan.BackupType, plan.BackupTypeReason)
+	}
+
+	if strings.Contains(plan.Xtrabackup.String(), "--incremental-lsn") {
+		t.Errorf("Expected a full backup not to continue from an LSN, got %s", plan.Xtrabackup)
+	}
+
+	if plan.ServerConfigKey != "db1/mysql-backup-201901141200.full.server.tar.gz" {
+		t.Errorf("Expected the server config to be captured, got %q", plan.ServerConfigKey)
+	}
+
+	if len(plan.Prune) != 2 || plan.Prune[0].LineageID != 1 || plan.Prune[1].LineageID != 1 {
+		t.Errorf("Expected the first lineage to be pruned, got %v", plan.Prune)
+	}
+}
+
+func TestRestorePlanListsTheSteps(t *testing.T) {
+	defer func(previous ConfigStruct) { configStruct = previous }(configStruct)
+	configStruct = ConfigStruct{}
+
+	allBackups := []backupItem{
+		buildBackup(1, "db1/mysql-backup-201901011000.full.xbstream", 100),
+		buildBackup(1, "db1/mysql-backup-201901011100.incremental.xbstream", 10),
+		buildBackup(1, "db1/mysql-backup-201901011200.incremental.xbstream", 10),
+	}
+
+	restoreAt, _ := parseBackupTimestamp("201901011130")
+	lineage := findRelevantBackupsUpTo(restoreAt, allBackups)
+	volume := volumePlan{Name: "mysql-restore-20190102000000", SizeInGigaBytes: 5, MountDirectory: "/mnt/restore"}
+
+	plan := buildRestorePlan("db1", "backups", lineage, volume, "/var/lib/mysql", 4)
+
+	if plan.DownloadInBytes != 110 {
+		t.Errorf("Expected the full backup and the first incremental backup to be downloaded, got %d bytes", plan.DownloadInBytes)
+	}
+
+	expectedSteps := []string{
+		"Download db1/mysql-backup-201901011100.incremental.xbstream and extract it with `xbstream -x -C /mnt/restore/really-simple-db-restore/mysql-backup-201901011100.incremental`",
+		"xtrabackup --decompress --target-dir /mnt/restore/really-simple-db-restore/mysql-backup-201901011100.incremental --parallel 4 --remove-original",
+		"Download db1/mysql-backup-201901011000.full.xbstream and extract it with `xbstream -x -C /mnt/restore/really-simple-db-restore/mysql-backup-201901011000.full`",
+		"xtrabackup --decompress --target-dir /mnt/restore/really-simple-db-restore/mysql-backup-201901011000.full --parallel 4 --remove-original",
+		"xtrabackup --prepare --target-dir /mnt/restore/really-simple-db-restore/mysql-backup-201901011000.full --apply-log-only",
+		"xtrabackup --prepare --target-dir /mnt/restore/really-simple-db-restore/mysql-backup-201901011000.full --incremental-dir /mnt/restore/really-simple-db-restore/mysql-backup-201901011100.incremental",
+		"mv /var/lib/mysql /tmp/",
+		"xtrabackup --copy-back --target-dir /mnt/restore/really-simple-db-restore/mysql-backup-201901011000.full --datadir /var/lib/mysql",
+		"chown -R mysql:mysql /var/lib/mysql",
+		"Restore the server config from db1/mysql-backup-201901011000.full.server.tar.gz if it was captured",
+		"Unmount and destroy volume mysql-restore-20190102000000",
+	}
+
+	if strings.Join(plan.Steps, "\n") != strings.Join(expectedSteps, "\n") {
+		t.Errorf("Wrong steps. Got:\n%s", strings.Join(plan.Steps, "\n"))
+	}
+
+	var output bytes.Buffer
+	plan.Write(&output)
+	if !strings.Contains(output.String(), "Lineage (2 pieces from backups):\n  full ") {
+		t.Errorf("Expected the lineage oldest first, got:\n%s", output.String())
+	}
+}
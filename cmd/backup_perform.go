@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/feederco/really-simple-db-backup/pkg"
@@ -14,19 +17,22 @@ import (
 	"github.com/digitalocean/godo"
 )
 
-func backupMysqlPerform(backupType string, backupsBucket string, mysqlDataPath string, existingVolumeID string, existingBackupDirectory string, persistentStorageDirectory string, digitalOceanClient *pkg.DigitalOceanClient, minioClient *minio.Client) error {
+func backupMysqlPerform(ctx context.Context, backupType string, hostname string, backupsBucket string, mysqlDataPath string, existingVolumeID string, existingBackupDirectory string, persistentStorageDirectory string, digitalOceanClient *pkg.DigitalOceanClient, minioClient *minio.Client) error {
 	var err error
 
-	pkg.Log.Println("Backup started", time.Now().Format(time.RFC3339))
+	startedAt := time.Now()
+	pkg.Log.Println("Backup started", startedAt.Format(time.RFC3339))
 	defer pkg.Log.Println("Backup ended", time.Now().Format(time.RFC3339))
 
+	enterStage(stagePrerequisites)
 	err = prerequisites(configStruct.PersistentStorage)
 	if err != nil {
-		pkg.ErrorLog.Fatalln("Failed prerequisite tests", err)
+		pkg.ErrorLog.Println("Failed prerequisite tests", err)
+		return withStage(stagePrerequisites, err)
 	}
 
 	if backupType != backupTypeFull && backupType != backupTypeIncremental && backupType != backupTypeDecide {
-		return errors.New("Invalid backupType: " + backupType)
+		return withStage(stagePrerequisites, errors.New("Invalid backupType: "+backupType))
 	}
 
 	checkpointFilePath := path.Join(persistentStorageDirectory, "xtrabackup_checkpoints")
@@ -34,12 +40,11 @@ func backupMysqlPerform(backupType string, backupsBucket string, mysqlDataPath s
 	// # Game plan
 	err = backupPrerequisites()
 	if err != nil {
-		return err
+		return withStage(stagePrerequisites, err)
 	}
 
-	hostname, _ := os.Hostname()
-
 	if backupType == backupTypeDecide {
+		enterStage(stageDecide)
 		backupType, err = backupDecide(
 			configStruct.Retention,
 			checkpointFilePath,
@@ -48,127 +53,234 @@ func backupMysqlPerform(backupType string, backupsBucket string, mysqlDataPath s
 			minioClient,
 		)
 		if err != nil {
-			pkg.AlertError(configStruct.Alerting, "Could not decide backup type", err)
+			alertError(stageDecide, "Could not decide backup type", err)
+			return withStage(stageDecide, err)
 		}
 
 		pkg.Log.Printf("Decided on backup type: %s\n", backupType)
 	}
 
+	err = runHook(ctx, hookPreBackup, hookBackup{Type: backupType})
+	if err != nil {
+		return err
+	}
+
 	// - Get size of database
 	sizeInBytes, err := pkg.DirSize(mysqlDataPath)
 	if err != nil {
 		pkg.ErrorLog.Println("Could not get size of database", err)
-		pkg.AlertError(configStruct.Alerting, "Could not get size of database", err)
-		return err
+		alertError(stagePrerequisites, "Could not get size of database", err)
+		return withStage(stagePrerequisites, err)
 	}
 
-	sizeInGigaBytes := bytesToGigaBytes(sizeInBytes)
-	aDecentSizeInGigaBytes := sizeInGigaBytes + (sizeInGigaBytes / 10)
+	aDecentSizeInGigaBytes := backupVolumeSizeInGigaBytes(sizeInBytes)
+
+	if ctx.Err() != nil {
+		return withStage(stageInterrupted, ctx.Err())
+	}
 
 	var volume *godo.Volume
 	var mountDirectory string
 
+	enterStage(stageVolume)
 	volume, mountDirectory, err = createAndMountVolumeForUse(
-		"mysql-backup-",
+		volumePrefixBackup,
 		aDecentSizeInGigaBytes,
 		digitalOceanClient,
 		existingVolumeID,
 		existingBackupDirectory,
 	)
 
+	if err == nil && ctx.Err() != nil {
+		return backupCleanupAfterInterrupt(ctx, volume, mountDirectory, digitalOceanClient)
+	}
+
 	if err != nil {
 		pkg.ErrorLog.Println("Could not create a volume for use", err)
-		pkg.AlertError(configStruct.Alerting, "Could not create a volume for use.", err)
-		return backupCleanup(volume, mountDirectory, digitalOceanClient)
+		alertError(stageVolume, "Could not create a volume for use.", err)
+		cleanupErr := backupCleanup(volume, mountDirectory, digitalOceanClient)
+		if cleanupErr != nil {
+			return withStage(stageCleanup, cleanupErr)
+		}
+		return withStage(stageVolume, err)
 	}
 
 	// !! From this point onward we have created things that need to be cleaned up
 
 	pkg.Log.Println("Backups running.")
 
-	backupName := volume.Name + "." + backupType
+	objectKey := configStruct.backupLayout().backupKey(hostname, startedAt, backupType)
+	backupName := strings.TrimSuffix(path.Base(objectKey), backupKeyExtension)
+	pkg.SetLogBackup(backupName)
 
-	backupDirectory := path.Join(mountDirectory, "mysql-backup-"+backupType)
-	backupFileTemporary := path.Join(backupDirectory, backupName+".xbstream.incomplete")
-	backupFile := path.Join(backupDirectory, backupName+".xbstream")
+	backupDirectory, backupFile := backupFileLocation(mountDirectory, backupType, objectKey)
+	backupFileTemporary := backupFile + ".incomplete"
+
+	// xtrabackup updates the checkpoint file in persistent storage. If the run fails or is interrupted
+	// before the backup is uploaded the previous checkpoint is put back so the LSN chain stays intact,
+	// and the volume is cleaned up
+	previousCheckpoint, previousCheckpointErr := ioutil.ReadFile(checkpointFilePath)
+	failed := func(stage string, err error) error {
+		if previousCheckpointErr == nil {
+			ioutil.WriteFile(checkpointFilePath, previousCheckpoint, 0600)
+		} else {
+			os.Remove(checkpointFilePath)
+		}
+
+		if ctx.Err() != nil {
+			return backupCleanupAfterInterrupt(ctx, volume, mountDirectory, digitalOceanClient)
+		}
+
+		enterStage(stageCleanup)
+		cleanupErr := backupCleanup(volume, mountDirectory, digitalOceanClient)
+		if cleanupErr != nil {
+			return withStage(stageCleanup, cleanupErr)
+		}
+
+		return withStage(stage, err)
+	}
 
-	// - Start Percona XtraBackup
+	// - Start Percona XtraBackup. Failures are alerted once, below
+	enterStage(stageXtrabackup)
 	err = (func() error {
 		err = os.MkdirAll(backupDirectory, 0700)
 		if err != nil {
-			pkg.AlertError(configStruct.Alerting, "Could not create backup directory.", err)
-			return err
-		}
-
-		backupArgs := []string{
-			"--backup",
-			"--extra-lsndir",
-			persistentStorageDirectory,
-			"--target-dir",
-			backupDirectory + "/",
-			"--compress",
-			"--stream=xbstream",
-			"--slave-info",
+			return fmt.Errorf("Could not create backup directory: %w", err)
 		}
 
 		// Add option to read LSN (log sequence number) if taking an incremental backup
+		lastLsn := ""
 		if backupType == backupTypeIncremental {
-			lastLsn, lsnErr := getLastLSNFromFile(checkpointFilePath)
+			var lsnErr error
+			lastLsn, lsnErr = getLastLSNFromFile(checkpointFilePath)
 			if lsnErr != nil {
-				pkg.AlertError(configStruct.Alerting, "Could not fetch LSN from checkpoint file while doing incremental backup.", lsnErr)
-				return lsnErr
+				return fmt.Errorf("Could not fetch LSN from checkpoint file while doing incremental backup: %w", lsnErr)
 			}
 
 			if lastLsn == "" {
 				pkg.Log.Print("No last LSN found, doing full backup instead.")
-			} else {
-				backupArgs = append(backupArgs, "--incremental-lsn", lastLsn)
 			}
 		}
 
-		err = pkg.PerformCommandWithFileOutput(backupFileTemporary, "xtrabackup", backupArgs...)
+		backupArgs := xtrabackupBackupArgs(backupDirectory, persistentStorageDirectory, mysqlDataPath, lastLsn)
+
+		err = pkg.PerformCommandWithFileOutputContext(ctx, backupFileTemporary, "xtrabackup", backupArgs...)
 		if err != nil {
-			pkg.AlertError(configStruct.Alerting, "xtrabackup cmd failed", err)
-			return err
+			return fmt.Errorf("xtrabackup cmd failed: %w", err)
 		}
 
 		err = os.Rename(backupFileTemporary, backupFile)
 		if err != nil {
-			pkg.AlertError(configStruct.Alerting, "Backup was completed but couldnt rename the file to reflect this.", err)
-			return err
+			return fmt.Errorf("Backup was completed but couldnt rename the file to reflect this: %w", err)
 		}
 
 		return nil
 	})()
 
 	if err != nil {
-		pkg.AlertError(configStruct.Alerting, "Could not create backup. Leaving it as is!", err)
-		return err
+		if ctx.Err() == nil {
+			alertError(stageXtrabackup, "Could not create backup.", err)
+		}
+		return failed(stageXtrabackup, err)
+	}
+
+	backupFileStat, err := os.Stat(backupFile)
+	if err != nil {
+		return failed(stageXtrabackup, err)
 	}
 
 	// - On success: upload to a bucket
-	err = backupMysqlUpload(backupFile, backupsBucket, minioClient)
+	enterStage(stageUpload)
+	uploadStartedAt := time.Now()
+	err = backupMysqlUpload(ctx, backupFile, objectKey, backupsBucket, minioClient)
 	if err != nil {
-		pkg.AlertError(configStruct.Alerting, "Could not upload backup to directory. Leaving it as is!", err)
-		return err
+		if ctx.Err() == nil {
+			alertError(stageUpload, "Could not upload backup.", err)
+		}
+		return failed(stageUpload, err)
+	}
+
+	metrics.RecordBackup(backupType, time.Since(startedAt), backupFileStat.Size(), time.Since(uploadStartedAt))
+	activeRun.noteBackup(backupType, path.Base(backupFile), objectKey, backupFileStat.Size())
+
+	// The data files don't include the config and grants needed to restore onto a fresh server
+	if backupType == backupTypeFull {
+		enterStage(stageServerConfig)
+		serverConfigErr := backupServerConfig(ctx, backupFile, objectKey, hostname, backupsBucket, minioClient)
+		if serverConfigErr != nil {
+			alertWarning(stageServerConfig, "Backup completed, but could not capture the server config.", serverConfigErr)
+		}
+	}
+
+	if configStruct.Hooks.hook(hookPostBackup) != nil {
+		postBackup := hookBackup{
+			Type:   backupType,
+			File:   backupFile,
+			Object: objectKey,
+			Size:   backupFileStat.Size(),
+		}
+
+		postBackup.Lineage, err = lineageOfLatestBackup(hostname, backupsBucket, minioClient)
+		if err != nil {
+			pkg.ErrorLog.Println("Warning: Could not find the lineage of the backup for the post_backup hook.", err)
+		}
+
+		runHook(ctx, hookPostBackup, postBackup)
 	}
 
 	// Success! Now we can consider removing old backups
 	if backupType == backupTypeFull && configStruct.Retention != nil && configStruct.Retention.AutomaticallyRemoveOld {
-		allBackups, backupErr := listAllBackups(hostname, backupsBucket, minioClient)
+		enterStage(stagePrune)
+		deletedBackups, backupErr := pruneBackups(hostname, backupsBucket, configStruct.Retention, minioClient)
 		if backupErr != nil {
-			pkg.AlertError(configStruct.Alerting, "Backup completed, but could not perform pruning. Failed on listing backups.", err)
-		} else {
-			backupsToDelete := findBackupsThatCanBeDeleted(allBackups, time.Now(), configStruct.Retention)
-			deletedBackups, backupErr := removeBackups(backupsToDelete, backupsBucket, minioClient)
-
-			if backupErr != nil {
-				pkg.AlertError(configStruct.Alerting, fmt.Sprintf("Backup completed, but could not delete backups pruning. Failed on deleting. Was able delete %d %s before failure.", len(deletedBackups), pluralize(len(deletedBackups), "backup", "backups")), err)
-			}
+			alertWarning(stagePrune, fmt.Sprintf("Backup completed, but could not perform pruning. Was able delete %d %s before failure.", len(deletedBackups), pluralize(len(deletedBackups), "backup", "backups")), backupErr)
 		}
 	}
 
-	return backupCleanup(volume, mountDirectory, digitalOceanClient)
+	enterStage(stageCleanup)
+	return withStage(stageCleanup, backupCleanup(volume, mountDirectory, digitalOceanClient))
+}
+
+// xtrabackupBackupArgs are the arguments of the xtrabackup command that streams a backup of mysqlDataPath.
+// The backup is incremental from lastLsn when it isn't empty
+func xtrabackupBackupArgs(backupDirectory string, persistentStorageDirectory string, mysqlDataPath string, lastLsn string) []string {
+	backupArgs := []string{
+		"--backup",
+		"--extra-lsndir",
+		persistentStorageDirectory,
+		"--target-dir",
+		backupDirectory + "/",
+		"--compress",
+		"--stream=xbstream",
+		"--slave-info",
+	}
+
+	// The MySQL config files only describe one instance. Jobs of servers running several say which one to back up
+	if configStruct.Job != "" {
+		backupArgs = append(backupArgs, "--datadir", mysqlDataPath)
+	}
+	if configStruct.Mysql.Socket != "" {
+		backupArgs = append(backupArgs, "--socket", configStruct.Mysql.Socket)
+	}
+
+	if lastLsn != "" {
+		backupArgs = append(backupArgs, "--incremental-lsn", lastLsn)
+	}
+
+	return backupArgs
+}
+
+// backupFileLocation returns the directory in mountDirectory a backup is written to, and the file it is written as
+func backupFileLocation(mountDirectory string, backupType string, objectKey string) (string, string) {
+	backupName := strings.TrimSuffix(path.Base(objectKey), backupKeyExtension)
+	backupDirectory := path.Join(mountDirectory, "mysql-backup-"+backupType)
+	return backupDirectory, path.Join(backupDirectory, backupName+".xbstream")
+}
+
+// backupVolumeSizeInGigaBytes is the size of the volume a backup of a data directory of dataSizeInBytes is written to
+func backupVolumeSizeInGigaBytes(dataSizeInBytes int64) int64 {
+	sizeInGigaBytes := bytesToGigaBytes(dataSizeInBytes)
+	return sizeInGigaBytes + (sizeInGigaBytes / 10)
 }
 
 func bytesToGigaBytes(bytes int64) int64 {
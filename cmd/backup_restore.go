@@ -1,23 +1,25 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"io"
 	"os"
-	"os/exec"
 	"path"
-	"runtime"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/cheggaaa/pb"
 	"github.com/digitalocean/godo"
 	"github.com/feederco/really-simple-db-backup/pkg"
 	minio "github.com/minio/minio-go"
 )
 
+// Backups are downloaded into this directory on the volume of a restore
+const restoreDirectoryName = "really-simple-db-restore"
+
 func backupMysqlDownloadAndPrepare(
+	ctx context.Context,
 	fromHostname string,
 	restoreTimestamp string,
 	backupBucket string,
@@ -28,38 +30,42 @@ func backupMysqlDownloadAndPrepare(
 ) (string, string, *godo.Volume, error) {
 	var err error
 
+	enterStage(stagePrerequisites)
 	err = prerequisites(configStruct.PersistentStorage)
 	if err != nil {
-		return "", "", nil, err
+		return "", "", nil, withStage(stagePrerequisites, err)
 	}
 
 	err = backupPrerequisites()
 	if err != nil {
-		return "", "", nil, err
+		return "", "", nil, withStage(stagePrerequisites, err)
 	}
 
 	sinceTimestamp := time.Now()
 	if restoreTimestamp != "" {
 		sinceTimestamp, err = parseBackupTimestamp(restoreTimestamp)
 		if err != nil {
-			return "", "", nil, errors.New("Incorrect timestamp passed in: " + restoreTimestamp + " (error: " + err.Error() + ")")
+			return "", "", nil, withStage(stagePrerequisites, errors.New("Incorrect timestamp passed in: "+restoreTimestamp+" (error: "+err.Error()+")"))
 		}
 	}
 
 	pkg.Log.Println("Listing backups since", sinceTimestamp.Format(time.RFC3339))
 
 	// - List all backups we need
+	enterStage(stageList)
 	allBackups, err := listAllBackups(fromHostname, backupBucket, minioClient)
 	if err != nil {
-		return "", "", nil, err
+		return "", "", nil, withStage(stageList, err)
 	}
 
 	backupFiles := findRelevantBackupsUpTo(sinceTimestamp, allBackups)
 	if len(backupFiles) == 0 {
-		return "", "", nil, errors.New("No backup found to restore from")
+		return "", "", nil, withStage(stageList, errors.New("No backup found to restore from"))
 	}
 
 	pkg.Log.Printf("%d backup files found\n", len(backupFiles))
+	pkg.SetLogBackup(path.Base(backupFiles[0].Path))
+	activeRun.noteRestoredBackups(backupFiles)
 
 	totalSizeInBytes := int64(0)
 	for _, backupFile := range backupFiles {
@@ -67,14 +73,14 @@ func backupMysqlDownloadAndPrepare(
 	}
 
 	// - Create & mount volume to house backup
-	sizeInGigaBytes := bytesToGigaBytes(totalSizeInBytes)
-	aDecentSizeInGigaBytes := sizeInGigaBytes * 5
+	aDecentSizeInGigaBytes := restoreVolumeSizeInGigaBytes(totalSizeInBytes)
 
 	var volume *godo.Volume
 	var mountDirectory string
 
+	enterStage(stageVolume)
 	volume, mountDirectory, err = createAndMountVolumeForUse(
-		"mysql-restore-",
+		volumePrefixRestore,
 		aDecentSizeInGigaBytes,
 		digitalOceanClient,
 		existingVolumeID,
@@ -83,57 +89,59 @@ func backupMysqlDownloadAndPrepare(
 
 	if err != nil {
 		pkg.ErrorLog.Println("Could not create mount volume.", err)
-		return "", mountDirectory, volume, nil
+		return "", mountDirectory, volume, withStage(stageVolume, err)
+	}
+
+	if ctx.Err() != nil {
+		return "", "", nil, backupCleanupAfterInterrupt(ctx, volume, mountDirectory, digitalOceanClient)
 	}
 
-	restoreDirectory := path.Join(mountDirectory, "really-simple-db-restore")
+	restoreDirectory := path.Join(mountDirectory, restoreDirectoryName)
 	err = os.MkdirAll(restoreDirectory, 0755)
 	if err != nil {
 		pkg.ErrorLog.Println("Could not create directory to house backup files.")
-		return restoreDirectory, mountDirectory, volume, nil
+		return restoreDirectory, mountDirectory, volume, withStage(stageVolume, err)
 	}
 
 	pkg.Log.Println("Downloading and extracting backups")
 
 	// - Download full backup and incremental pieces
+	enterStage(stageDownload)
 	var downloadDirectories []string
-	downloadDirectories, err = downloadBackups(backupFiles, restoreDirectory, backupBucket, minioClient)
+	downloadDirectories, err = downloadBackups(ctx, backupFiles, restoreDirectory, backupBucket, newDownloadSettings(configStruct.Download), minioClient)
 	if err != nil {
+		if ctx.Err() != nil {
+			return "", "", nil, backupCleanupAfterInterrupt(ctx, volume, mountDirectory, digitalOceanClient)
+		}
+
 		pkg.ErrorLog.Println("Could not download backups!")
-		return restoreDirectory, mountDirectory, volume, err
+		if volume != nil {
+			pkg.ErrorLog.Printf("Volume %s is kept. Run again with -existing-volume-id %s to continue the download where it stopped\n", volume.Name, volume.ID)
+		}
+		return restoreDirectory, mountDirectory, volume, withStage(stageDownload, err)
 	}
 
 	pkg.Log.Println("Preparing backups")
 
 	// - Prepare backup
+	enterStage(stagePrepare)
 	// When an incremental backup the steps required are a bit different
-	lastIndex := len(downloadDirectories) - 1
-	finalDirectory := downloadDirectories[lastIndex]
+	finalDirectory := downloadDirectories[len(downloadDirectories)-1]
 
-	hasIncrementals := len(downloadDirectories) > 1
-
-	for index := lastIndex; index >= 0; index-- {
-		directory := downloadDirectories[index]
-		isFull := lastIndex == index
-
-		prepareArgs := []string{
-			"xtrabackup",
-			"--prepare",
-			"--target-dir",
-			finalDirectory,
-		}
-
-		if isFull && hasIncrementals {
-			prepareArgs = append(prepareArgs, "--apply-log-only")
-		} else if !isFull {
-			prepareArgs = append(prepareArgs, "--incremental-dir", directory)
-		}
-
-		_, err = pkg.PerformCommand(prepareArgs...)
+	for _, prepareArgs := range xtrabackupPrepareArgs(downloadDirectories) {
+		_, err = pkg.PerformCommandContext(ctx, append([]string{"xtrabackup"}, prepareArgs...)...)
 
 		if err != nil {
-			pkg.AlertError(configStruct.Alerting, "Could not prepare backup.", err)
-			return restoreDirectory, mountDirectory, volume, backupCleanup(volume, mountDirectory, digitalOceanClient)
+			if ctx.Err() != nil {
+				return "", "", nil, backupCleanupAfterInterrupt(ctx, volume, mountDirectory, digitalOceanClient)
+			}
+
+			alertError(stagePrepare, "Could not prepare backup.", err)
+			cleanupErr := backupCleanup(volume, mountDirectory, digitalOceanClient)
+			if cleanupErr != nil {
+				pkg.ErrorLog.Println("Could not clean up after failed prepare.", cleanupErr)
+			}
+			return "", "", nil, withStage(stagePrepare, err)
 		}
 	}
 
@@ -146,11 +154,18 @@ func backupMysqlFinalizeRestore(
 	mysqlDataPath string,
 	mountDirectory string,
 	volume *godo.Volume,
+	assumeYes bool,
 	digitalOceanClient *pkg.DigitalOceanClient,
 	minioClient *minio.Client,
 ) error {
 	var err error
 
+	err = runHook(context.Background(), hookPreRestore, hookRestore(activeRun))
+	if err != nil {
+		return err
+	}
+
+	enterStage(stageCopyBack)
 	pkg.Log.Println("Starting to put everything back")
 	pkg.Log.Println("Warning: Removing everything in the MySQL data directory")
 
@@ -162,19 +177,12 @@ func backupMysqlFinalizeRestore(
 	go pkg.ReportProgressOnCopy(restoreDirectory, mysqlDataPath, copyCompletedChannel)
 
 	// - Move to MySQL data directory
-	_, err = pkg.PerformCommand(
-		"xtrabackup",
-		"--copy-back",
-		"--target-dir",
-		restoreDirectory,
-		"--datadir",
-		mysqlDataPath,
-	)
+	_, err = pkg.PerformCommand(append([]string{"xtrabackup"}, xtrabackupCopyBackArgs(restoreDirectory, mysqlDataPath)...)...)
 	copyCompletedChannel <- true
 
 	if err != nil {
-		pkg.AlertError(configStruct.Alerting, "Could not copy back data files.", err)
-		return err
+		alertError(stageCopyBack, "Could not copy back data files.", err)
+		return withStage(stageCopyBack, err)
 	}
 
 	pkg.Log.Println("Last step: Set correct permissions on backup files")
@@ -182,99 +190,89 @@ func backupMysqlFinalizeRestore(
 	// - Set correct permissions
 	_, err = pkg.PerformCommand("chown", "-R", "mysql:mysql", mysqlDataPath)
 	if err != nil {
-		pkg.AlertError(configStruct.Alerting, "Could not set correct permissions on MySQL data file", err)
+		alertError(stageCopyBack, "Could not set correct permissions on MySQL data file", err)
+	}
+
+	enterStage(stageServerConfig)
+	err = restoreServerConfig(context.Background(), activeRun, assumeYes, configStruct.DigitalOcean.SpaceName, minioClient)
+	if err != nil {
+		alertWarning(stageServerConfig, "Could not restore the server config of the backup. Check the MySQL config before starting MySQL.", err)
 	}
 
+	runHook(context.Background(), hookPostRestore, hookRestore(activeRun))
+
 	pkg.AlertMessage(configStruct.Alerting, "Backup restore complete. Now it is safe to start MySQL.")
 
-	return backupCleanup(volume, mountDirectory, digitalOceanClient)
+	enterStage(stageCleanup)
+	return withStage(stageCleanup, backupCleanup(volume, mountDirectory, digitalOceanClient))
 }
 
-func downloadBackups(backups []backupItem, restoreDirectory string, bucketName string, minioClient *minio.Client) ([]string, error) {
-	numberOfCPUs := runtime.NumCPU()
+// backupDownloadDirectory is the directory in restoreDirectory backup is extracted into
+func backupDownloadDirectory(restoreDirectory string, backup backupItem) string {
+	fileName := path.Base(backup.Path)
+	return path.Join(restoreDirectory, strings.TrimSuffix(fileName, path.Ext(fileName)))
+}
 
-	directoryPieces := make([]string, len(backups))
+// restoreVolumeSizeInGigaBytes is the size of the volume backups of backupSizeInBytes are downloaded, extracted and decompressed on
+func restoreVolumeSizeInGigaBytes(backupSizeInBytes int64) int64 {
+	return bytesToGigaBytes(backupSizeInBytes) * 5
+}
 
-	for index, backup := range backups {
-		fileName := path.Base(backup.Path)
-		fileNameWithoutExtension := strings.TrimSuffix(fileName, path.Ext(fileName))
+// xtrabackupDecompressArgs are the arguments of the xtrabackup command that decompresses the extracted backup in downloadDirectory
+func xtrabackupDecompressArgs(downloadDirectory string, numberOfCPUs int) []string {
+	return []string{
+		"--decompress",
+		"--target-dir",
+		downloadDirectory,
+		"--parallel",
+		strconv.FormatInt(int64(numberOfCPUs), 10),
+		"--remove-original",
+	}
+}
 
-		downloadDirectory := path.Join(restoreDirectory, fileNameWithoutExtension)
-		directoryPieces[index] = downloadDirectory
+// xtrabackupPrepareArgs are the arguments of the xtrabackup commands that prepare the backups downloaded into downloadDirectories,
+// in the order they run. The full backup is last in downloadDirectories and the incremental backups are applied to it oldest first
+func xtrabackupPrepareArgs(downloadDirectories []string) [][]string {
+	lastIndex := len(downloadDirectories) - 1
+	finalDirectory := downloadDirectories[lastIndex]
 
-		err := os.MkdirAll(downloadDirectory, 0700)
-		if err != nil {
-			return nil, err
-		}
+	hasIncrementals := len(downloadDirectories) > 1
 
-		reader, size, err := getBackupReaderAndSize(minioClient, bucketName, backup.Path)
+	commands := make([][]string, 0, len(downloadDirectories))
+	for index := lastIndex; index >= 0; index-- {
+		directory := downloadDirectories[index]
+		isFull := lastIndex == index
 
-		if err != nil {
-			return nil, err
+		prepareArgs := []string{
+			"--prepare",
+			"--target-dir",
+			finalDirectory,
 		}
 
-		progressBar := pb.New(int(size))
-		progressBar.SetUnits(pb.U_BYTES)
-		progressBar.ShowSpeed = true
-		progressReader := progressBar.NewProxyReader(reader)
-
-		progressBar.Start()
-
-		err = decompressBackupFile(progressReader, downloadDirectory, numberOfCPUs)
-
-		progressBar.Finish()
-
-		if err != nil {
-			return nil, err
+		if isFull && hasIncrementals {
+			prepareArgs = append(prepareArgs, "--apply-log-only")
+		} else if !isFull {
+			prepareArgs = append(prepareArgs, "--incremental-dir", directory)
 		}
 
-		pkg.Log.Println("Decompressing backups")
-
-		numberOfCPUs := runtime.NumCPU()
-
-		// - Decompress files with as many cores as possible
-		_, err = pkg.PerformCommand(
-			"xtrabackup",
-			"--decompress",
-			"--target-dir",
-			downloadDirectory,
-			"--parallel",
-			strconv.FormatInt(int64(numberOfCPUs), 10),
-			"--remove-original",
-		)
-
-		if err != nil {
-			return nil, err
-		}
+		commands = append(commands, prepareArgs)
 	}
 
-	return directoryPieces, nil
+	return commands
 }
 
-func decompressBackupFile(dataReader io.Reader, restoreDirectory string, numberOfCPUs int) error {
-	execCmd := exec.Command("xbstream", "-x", "-C", restoreDirectory)
-	execCmd.Stdin = dataReader
-
-	err := execCmd.Start()
-	if err != nil {
-		return err
+// xtrabackupCopyBackArgs are the arguments of the xtrabackup command that moves the prepared backup in restoreDirectory into mysqlDataPath
+func xtrabackupCopyBackArgs(restoreDirectory string, mysqlDataPath string) []string {
+	return []string{
+		"--copy-back",
+		"--target-dir",
+		restoreDirectory,
+		"--datadir",
+		mysqlDataPath,
 	}
-
-	err = execCmd.Wait()
-	return err
 }
 
-func getBackupReaderAndSize(client *minio.Client, bucketName, objectName string) (io.Reader, int64, error) {
-	objectStat, err := client.StatObject(bucketName, objectName, minio.StatObjectOptions{})
-	if err != nil {
-		return nil, 0, err
-	}
-
-	// Seek to current position for incoming reader.
-	objectReader, err := client.GetObject(bucketName, objectName, minio.GetObjectOptions{})
-	if err != nil {
-		return nil, 0, err
-	}
-
-	return objectReader, objectStat.Size, nil
+// xbstreamExtractCommand extracts the backup streamed to it from input into restoreDirectory
+func xbstreamExtractCommand(restoreDirectory string, input io.Reader) pkg.Command {
+	return pkg.Command{Name: "xbstream", Args: []string{"-x", "-C", restoreDirectory}, Stdin: input}
 }
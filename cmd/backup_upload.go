@@ -1,24 +1,19 @@
 package cmd
 
 import (
-	"os"
-	"path"
+	"context"
 	"time"
 
 	"github.com/feederco/really-simple-db-backup/pkg"
 	minio "github.com/minio/minio-go"
 )
 
-func backupMysqlUpload(backupFile string, backupsBucket string, minioClient *minio.Client) error {
+// backupMysqlUpload uploads backupFile to objectKey in the bucket
+func backupMysqlUpload(ctx context.Context, backupFile string, objectKey string, backupsBucket string, minioClient *minio.Client) error {
 	pkg.Log.Println("Backup started", time.Now().Format(time.RFC3339))
 	defer pkg.Log.Println("Backup ended", time.Now().Format(time.RFC3339))
 
-	hostname, _ := os.Hostname()
-
-	fileName := path.Base(backupFile)
-	targetFileName := path.Join(hostname, fileName)
-
-	return pkg.WithRetry("upload", func() error {
-		return pkg.UploadFileToBucket(backupsBucket, targetFileName, backupFile, minioClient)
+	return pkg.WithRetryContext(ctx, "upload", func() error {
+		return pkg.UploadFileToBucket(ctx, backupsBucket, objectKey, backupFile, minioClient)
 	})
 }
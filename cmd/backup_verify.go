@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+// backupMysqlVerify checks that the most recent backup lineage of a host is complete and can be restored from
+func backupMysqlVerify(hostname string, backupsBucket string, minioClient *minio.Client) error {
+	pkg.Log.Println("Verifying backups for", hostname)
+
+	enterStage(stageList)
+	allBackups, err := listAllBackups(hostname, backupsBucket, minioClient)
+	if err != nil {
+		return withStage(stageList, err)
+	}
+
+	backupsToRestore := findRelevantBackupsUpTo(time.Now(), allBackups)
+	if len(backupsToRestore) == 0 {
+		return withStage(stageVerify, errors.New("No restorable backup found for "+hostname))
+	}
+
+	enterStage(stageVerify)
+	pkg.SetLogBackup(path.Base(backupsToRestore[0].Path))
+
+	for _, backup := range backupsToRestore {
+		objectStat, statErr := minioClient.StatObject(backupsBucket, backup.Path, minio.StatObjectOptions{})
+		if statErr != nil {
+			return withStage(stageVerify, fmt.Errorf("Could not stat %s: %s", backup.Path, statErr))
+		}
+
+		if objectStat.Size == 0 {
+			return withStage(stageVerify, errors.New("Backup file is empty: "+backup.Path))
+		}
+	}
+
+	pkg.Log.Printf(
+		"Latest lineage is complete. %d %s, last backup created at %s\n",
+		len(backupsToRestore),
+		pluralize(len(backupsToRestore), "piece", "pieces"),
+		backupsToRestore[0].CreatedAt.Format(time.RFC3339),
+	)
+
+	return nil
+}
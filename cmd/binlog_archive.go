@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+// Archived binary logs are stored in the directory of the host, like db1/_binlogs/binlog.000307
+const binlogDirectory = "_binlogs"
+
+// binaryLog is a binary log as listed by `SHOW BINARY LOGS`
+type binaryLog struct {
+	Name        string
+	SizeInBytes int64
+}
+
+// backupArchiveBinlogs uploads the binary logs MySQL has finished writing that are not in the bucket yet.
+// The current binary log is closed first, so the events up to now are archived too
+func backupArchiveBinlogs(ctx context.Context, hostname string, backupsBucket string, minioClient *minio.Client) error {
+	enterStage(stagePrerequisites)
+	_, err := mysqlQuery(ctx, "FLUSH BINARY LOGS")
+	if err != nil {
+		return withStage(stagePrerequisites, fmt.Errorf("Could not close the current binary log. Is binary logging enabled? %s", err))
+	}
+
+	listing, err := mysqlQuery(ctx, "SHOW BINARY LOGS")
+	if err != nil {
+		return withStage(stagePrerequisites, fmt.Errorf("Could not list the binary logs: %s", err))
+	}
+
+	binaryLogs, err := parseBinaryLogs(listing)
+	if err != nil {
+		return withStage(stagePrerequisites, err)
+	}
+
+	// The last one is the binary log MySQL writes to now
+	if len(binaryLogs) < 2 {
+		pkg.Log.Println("No finished binary logs. Nothing to archive.")
+		return nil
+	}
+	binaryLogs = binaryLogs[:len(binaryLogs)-1]
+
+	basename, err := mysqlQuery(ctx, "SELECT @@log_bin_basename")
+	if err != nil {
+		return withStage(stagePrerequisites, fmt.Errorf("Could not find the directory of the binary logs: %s", err))
+	}
+	binlogPath := path.Dir(strings.TrimSpace(basename))
+
+	enterStage(stageList)
+	archived, err := listArchivedBinlogs(hostname, backupsBucket, minioClient)
+	if err != nil {
+		return withStage(stageList, err)
+	}
+
+	enterStage(stageUpload)
+	uploaded := 0
+	for _, binaryLog := range binaryLogs {
+		// Archived by an earlier run
+		if size, exists := archived[binaryLog.Name]; exists && size == binaryLog.SizeInBytes {
+			continue
+		}
+
+		objectKey := path.Join(hostname, binlogDirectory, binaryLog.Name)
+		err = pkg.WithRetryContext(ctx, "upload binary log", func() error {
+			return pkg.UploadFileToBucket(ctx, backupsBucket, objectKey, path.Join(binlogPath, binaryLog.Name), minioClient)
+		})
+		if err != nil {
+			return withStage(stageUpload, fmt.Errorf("Could not archive %s. Archived %d %s before failing: %s", binaryLog.Name, uploaded, pluralize(uploaded, "binary log", "binary logs"), err))
+		}
+
+		pkg.Log.Printf("Archived %s to %s\n", binaryLog.Name, objectKey)
+		uploaded++
+	}
+
+	pkg.Log.Printf("Archived %d %s. %d %s already archived\n", uploaded, pluralize(uploaded, "binary log", "binary logs"), len(binaryLogs)-uploaded, pluralize(len(binaryLogs)-uploaded, "was", "were"))
+	return nil
+}
+
+// parseBinaryLogs parses the output of `SHOW BINARY LOGS` in batch mode, oldest first
+func parseBinaryLogs(listing string) ([]binaryLog, error) {
+	binaryLogs := make([]binaryLog, 0)
+	for _, line := range strings.Split(strings.TrimSpace(listing), "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			return nil, errors.New("Unexpected line in the list of binary logs: " + line)
+		}
+
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, errors.New("Unexpected size in the list of binary logs: " + line)
+		}
+
+		binaryLogs = append(binaryLogs, binaryLog{Name: fields[0], SizeInBytes: size})
+	}
+	return binaryLogs, nil
+}
+
+// listArchivedBinlogs returns the size of every binary log of hostname in the bucket by its name
+func listArchivedBinlogs(hostname string, backupsBucket string, minioClient *minio.Client) (map[string]int64, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	prefix := path.Join(hostname, binlogDirectory) + "/"
+	archived := make(map[string]int64)
+	for object := range minioClient.ListObjectsV2(backupsBucket, prefix, true, doneCh) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		archived[strings.TrimPrefix(object.Key, prefix)] = object.Size
+	}
+	return archived, nil
+}
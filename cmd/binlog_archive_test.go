@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBinaryLogs(t *testing.T) {
+	binaryLogs, err := parseBinaryLogs("binlog.000306\t1073742061\tNo\nbinlog.000307\t965530976\tNo\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []binaryLog{{"binlog.000306", 1073742061}, {"binlog.000307", 965530976}}
+	if !reflect.DeepEqual(binaryLogs, expected) {
+		t.Errorf("Expected %v, got %v", expected, binaryLogs)
+	}
+
+	if _, err := parseBinaryLogs("binlog.000307\tNo\n"); err == nil {
+		t.Error("Expected an error for a line without a size")
+	}
+}
@@ -0,0 +1,508 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+// Exit codes by class of failure
+const (
+	exitOK            = 0
+	exitFailure       = 1
+	exitUsage         = 2
+	exitConfig        = 3
+	exitLocked        = 4
+	exitPrerequisites = 5
+	exitInterrupted   = 130
+)
+
+// command is a subcommand of the CLI with its own flags
+type command struct {
+	Name    string
+	Summary string
+
+	// Positional arguments shown in the usage, like `bash|zsh`. Commands without any reject them
+	Args string
+
+	// Flags that must be set
+	Required []string
+
+	// Adds the -hostname flag to act on the backups of another host
+	TakesHostname bool
+
+	// Runs without loading the config or connecting to the bucket
+	Offline bool
+
+	// Takes the config flags but loads the config itself, so it also runs with an incomplete config
+	LoadsConfig bool
+
+	// Handles SIGINT and SIGTERM itself instead of cancelling the run
+	HandlesSignals bool
+
+	// Runs once for every job of the config unless one is chosen with -job
+	ForEachJob bool
+
+	// Needs -job when the config defines more than one job
+	NeedsJob bool
+
+	// Adds the -dry-run flag. Dry runs aren't recorded, take no lock and only print the programs they would run
+	DryRuns bool
+
+	// Defines the flags of the command on flags and returns what runs it
+	Setup func(flags *flag.FlagSet) commandRunner
+}
+
+type commandRunner func(env *commandEnv) error
+
+// commandEnv is what a command runs with
+type commandEnv struct {
+	Context context.Context
+	Args    []string
+
+	// Hostname is the directory of the backups of the host in the bucket, rendered from the key template.
+	// StoragePrefix is the value of {hostname} in it
+	Hostname      string
+	StoragePrefix string
+
+	// Only set for commands that load the config themselves
+	ConfigFlags *configFlags
+
+	// Print what the command would do instead of doing it
+	DryRun bool
+
+	DigitalOcean *pkg.DigitalOceanClient
+	Minio        *minio.Client
+}
+
+// globalFlags are accepted by every command that loads the config
+type globalFlags struct {
+	Config    *configFlags
+	Verbose   *bool
+	LogFormat *string
+	Hostname  *string
+	DryRun    *bool
+}
+
+type usageError struct {
+	message string
+}
+
+func (err *usageError) Error() string {
+	return err.message
+}
+
+func newUsageError(format string, args ...interface{}) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+type configError struct {
+	Err error
+}
+
+func (err *configError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *configError) Unwrap() error {
+	return err.Err
+}
+
+// exitCode returns the exit code for the class of err
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		return exitUsage
+	}
+
+	var configErr *configError
+	if errors.As(err, &configErr) {
+		return exitConfig
+	}
+
+	switch errorStage(err) {
+	case stageLock:
+		return exitLocked
+	case stagePrerequisites:
+		return exitPrerequisites
+	case stageInterrupted:
+		return exitInterrupted
+	}
+
+	return exitFailure
+}
+
+func programName() string {
+	return path.Base(os.Args[0])
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commandRegistry() {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// commandFlags builds the flag set of cmd. ownFlags are the flags of the command itself, without the global ones
+func commandFlags(cmd *command) (flags *flag.FlagSet, ownFlags []*flag.Flag, global *globalFlags, runner commandRunner) {
+	flags = flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+
+	if cmd.Setup != nil {
+		runner = cmd.Setup(flags)
+	}
+
+	global = &globalFlags{}
+	if cmd.TakesHostname {
+		global.Hostname = flags.String("hostname", "", "Act on the backups of this host instead of this server")
+	}
+	if cmd.DryRuns {
+		global.DryRun = flags.Bool("dry-run", false, "Print what would happen without changing anything")
+	}
+
+	flags.VisitAll(func(flag *flag.Flag) {
+		ownFlags = append(ownFlags, flag)
+	})
+
+	if !cmd.Offline {
+		global.Config = addConfigFlags(flags)
+		global.Verbose = flags.Bool("v", false, "Verbose logging")
+		global.LogFormat = flags.String("log-format", "", "Log format: text or json (Default: text)")
+	}
+
+	return flags, ownFlags, global, runner
+}
+
+// parseCommandFlags parses args with flags anywhere between the positional arguments
+func parseCommandFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		err := flags.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// Begin begin!
+func Begin(cliArgs []string) {
+	os.Exit(runCommandLine(cliArgs[1:]))
+}
+
+// runCommandLine runs the command in args and returns the exit code
+func runCommandLine(args []string) int {
+	pkg.Log = log.New(os.Stdout, "", log.LstdFlags)
+	pkg.ErrorLog = log.New(os.Stderr, "", log.LstdFlags)
+
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+
+	name := args[0]
+	if name == "-h" || name == "-help" || name == "--help" {
+		name = "help"
+	}
+
+	cmd := findCommand(name)
+	if cmd == nil {
+		pkg.ErrorLog.Printf("Unknown command: %s\n\n", name)
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+
+	err := runCommand(cmd, args[1:])
+	if err != nil {
+		var usageErr *usageError
+		if errors.As(err, &usageErr) {
+			pkg.ErrorLog.Printf("%s\n\nRun `%s help %s` for usage.\n", err, programName(), cmd.Name)
+		} else {
+			pkg.ErrorLog.Printf("Error running `%s`\n\n\t%v\n\n", cmd.Name, err)
+		}
+	}
+
+	return exitCode(err)
+}
+
+func runCommand(cmd *command, args []string) error {
+	flags, _, global, runner := commandFlags(cmd)
+
+	positional, err := parseCommandFlags(flags, args)
+	if err == flag.ErrHelp {
+		printCommandUsage(os.Stdout, cmd)
+		return nil
+	}
+	if err != nil {
+		return &usageError{message: err.Error()}
+	}
+
+	if cmd.Args == "" && len(positional) > 0 {
+		return newUsageError("Unexpected argument: %s", positional[0])
+	}
+
+	for _, required := range cmd.Required {
+		if flags.Lookup(required).Value.String() == "" {
+			return newUsageError("-%s is required", required)
+		}
+	}
+
+	env := &commandEnv{
+		Context: context.Background(),
+		Args:    positional,
+	}
+
+	if cmd.Offline {
+		return runner(env)
+	}
+
+	pkg.VerboseMode = *global.Verbose
+
+	if cmd.LoadsConfig {
+		env.ConfigFlags = global.Config
+		return runner(env)
+	}
+
+	configStruct, err = global.Config.load()
+	if err != nil {
+		return &configError{Err: err}
+	}
+
+	logFormat := *global.LogFormat
+	if logFormat == "" && configStruct.Logging != nil {
+		logFormat = configStruct.Logging.Format
+	}
+
+	err = pkg.ConfigureLogging(logFormat, os.Stdout, os.Stderr)
+	if err != nil {
+		return &configError{Err: err}
+	}
+
+	err = validateConfig(configStruct)
+	if err != nil {
+		return &configError{Err: err}
+	}
+
+	pkg.CommandRunner = pkg.NewExecRunner(configStruct.Commands)
+	if global.DryRun != nil && *global.DryRun {
+		env.DryRun = true
+		pkg.CommandRunner = &pkg.DryRunRunner{Output: os.Stdout}
+	}
+
+	jobs, err := commandJobs(cmd, configStruct, global.Hostname != nil && *global.Hostname != "")
+	if err != nil {
+		return err
+	}
+
+	env.DigitalOcean, env.Minio, err = connectClients(configStruct)
+	if err != nil {
+		return &configError{Err: err}
+	}
+
+	historyClient = env.Minio
+
+	if !cmd.HandlesSignals {
+		var stopSignals func()
+		env.Context, stopSignals = cancelOnSignal(env.Context)
+		defer stopSignals()
+	}
+
+	if len(jobs) == 0 {
+		return runCommandForConfig(cmd, env, global, runner)
+	}
+
+	// A failing job doesn't stop the others. The first error decides the exit code
+	baseConfig := configStruct
+	var firstErr error
+	for _, job := range jobs {
+		if env.Context.Err() != nil {
+			break
+		}
+
+		configStruct, err = global.Config.applyJob(baseConfig, job)
+		if err != nil {
+			return &configError{Err: err}
+		}
+
+		pkg.Log.Printf("Running %s for job %s\n", cmd.Name, job)
+		err = runCommandForConfig(cmd, env, global, runner)
+		if err != nil {
+			pkg.ErrorLog.Printf("Job %s failed: %s\n", job, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// connectClients returns the clients of the DigitalOcean API and the bucket of config. The integration tests replace it to connect to fakes
+var connectClients = func(config ConfigStruct) (*pkg.DigitalOceanClient, *minio.Client, error) {
+	minioClient, err := minio.New(config.DigitalOcean.SpaceEndpoint, config.DigitalOcean.SpaceKey, config.DigitalOcean.SpaceSecret, true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not construct minio client. %s", err)
+	}
+
+	return pkg.NewDigitalOceanClient(config.DigitalOcean.Key), minioClient, nil
+}
+
+// commandJobs returns the jobs of config that cmd runs for one by one. None means it runs once with config as is
+func commandJobs(cmd *command, config ConfigStruct, hostnameIsSet bool) ([]string, error) {
+	if config.Job != "" || len(config.Jobs) == 0 {
+		return nil, nil
+	}
+
+	names := config.jobNames()
+	switch {
+	case cmd.ForEachJob && hostnameIsSet && len(names) > 1:
+		return nil, newUsageError("-job is required with -hostname since the config defines several jobs: %s", strings.Join(names, ", "))
+	case cmd.ForEachJob:
+		return names, nil
+	case cmd.NeedsJob && len(names) > 1:
+		return nil, newUsageError("-job is required since the config defines several jobs: %s", strings.Join(names, ", "))
+	case cmd.NeedsJob:
+		return names, nil
+	}
+
+	return nil, nil
+}
+
+// runCommandForConfig runs cmd once with the current configStruct and records the run
+func runCommandForConfig(cmd *command, env *commandEnv, global *globalFlags, runner commandRunner) error {
+	metrics = pkg.NewMetrics(configStruct.Metrics, configStruct.PersistentStorage, configStruct.Job)
+
+	env.StoragePrefix = configStruct.storagePrefix()
+	if global.Hostname != nil && *global.Hostname != "" {
+		env.StoragePrefix = *global.Hostname
+	}
+	env.Hostname = configStruct.backupLayout().hostDirectory(env.StoragePrefix)
+
+	if env.DryRun {
+		return runner(env)
+	}
+
+	run := startRun(cmd.Name, env.Hostname)
+
+	err := runner(env)
+	recordJob(run, err)
+
+	return err
+}
+
+func printUsage(output io.Writer) {
+	fmt.Fprintf(output, "Usage: %s COMMAND [flags]\n\nCommands:\n", programName())
+	for _, cmd := range commandRegistry() {
+		fmt.Fprintf(output, "  %-20s %s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintf(output, "\nRun `%s help COMMAND` for the flags of a command.\n", programName())
+}
+
+func printCommandUsage(output io.Writer, cmd *command) {
+	flags, ownFlags, _, _ := commandFlags(cmd)
+
+	synopsis := programName() + " " + cmd.Name
+	if cmd.Args != "" {
+		synopsis += " " + cmd.Args
+	}
+	if len(ownFlags) > 0 || !cmd.Offline {
+		synopsis += " [flags]"
+	}
+
+	fmt.Fprintf(output, "Usage: %s\n\n%s\n", synopsis, cmd.Summary)
+
+	own := make(map[string]bool)
+	globalFlags := make([]*flag.Flag, 0)
+	for _, ownFlag := range ownFlags {
+		own[ownFlag.Name] = true
+	}
+	flags.VisitAll(func(flag *flag.Flag) {
+		if !own[flag.Name] {
+			globalFlags = append(globalFlags, flag)
+		}
+	})
+
+	if len(ownFlags) > 0 {
+		fmt.Fprintf(output, "\nFlags:\n")
+		printFlags(output, ownFlags, cmd.Required)
+	}
+
+	if len(globalFlags) > 0 {
+		fmt.Fprintf(output, "\nGlobal flags:\n")
+		printFlags(output, globalFlags, nil)
+	}
+}
+
+func printFlags(output io.Writer, flags []*flag.Flag, required []string) {
+	for _, definedFlag := range flags {
+		valueName, usage := flag.UnquoteUsage(definedFlag)
+
+		line := "  -" + definedFlag.Name
+		if valueName != "" {
+			line += " " + valueName
+		}
+
+		for _, name := range required {
+			if name == definedFlag.Name {
+				usage += " (Required)"
+			}
+		}
+
+		if definedFlag.DefValue != "" && definedFlag.DefValue != "false" && definedFlag.DefValue != "0" {
+			usage += fmt.Sprintf(" (Default: %s)", definedFlag.DefValue)
+		}
+
+		fmt.Fprintf(output, "%s\n    \t%s\n", line, usage)
+	}
+}
+
+// timestampValue is a flag holding a backup timestamp in the format YYYYMMDDHHII
+type timestampValue struct {
+	value string
+}
+
+func (timestamp *timestampValue) String() string {
+	return timestamp.value
+}
+
+func (timestamp *timestampValue) Set(value string) error {
+	if _, err := parseBackupTimestamp(value); err != nil {
+		return errors.New("should be in format YYYYMMDDHHII")
+	}
+
+	timestamp.value = value
+	return nil
+}
+
+func (timestamp *timestampValue) time() (time.Time, error) {
+	return parseBackupTimestamp(timestamp.value)
+}
+
+func timestampFlag(flags *flag.FlagSet, name string, usage string) *timestampValue {
+	timestamp := &timestampValue{}
+	flags.Var(timestamp, name, usage)
+	return timestamp
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{nil, exitOK},
+		{errors.New("boom"), exitFailure},
+		{newUsageError("-limit should be a positive number"), exitUsage},
+		{&configError{Err: errors.New("broken config")}, exitConfig},
+		{withStage(stageLock, errors.New("already running")), exitLocked},
+		{fmt.Errorf("wrapped: %w", withStage(stagePrerequisites, errors.New("no xtrabackup"))), exitPrerequisites},
+		{withStage(stageInterrupted, errors.New("interrupted")), exitInterrupted},
+		{withStage(stageUpload, errors.New("upload failed")), exitFailure},
+	}
+
+	for _, test := range tests {
+		if code := exitCode(test.err); code != test.expected {
+			t.Errorf("Expected exit code %d for %v, got %d", test.expected, test.err, code)
+		}
+	}
+}
+
+func TestParseCommandFlagsBetweenArguments(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	yes := flags.Bool("yes", false, "")
+	limit := flags.Int("limit", 10, "")
+
+	positional, err := parseCommandFlags(flags, []string{"first", "-yes", "second", "-limit", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(positional, ",") != "first,second" || !*yes || *limit != 3 {
+		t.Errorf("Expected both arguments and flags to be parsed: %v %v %v", positional, *yes, *limit)
+	}
+}
+
+func TestRunCommandUsageErrors(t *testing.T) {
+	pkg.Log = log.New(ioutil.Discard, "", 0)
+	pkg.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	ran := false
+	testCommand := &command{
+		Name:     "test",
+		Offline:  true,
+		Required: []string{"file"},
+		Setup: func(flags *flag.FlagSet) commandRunner {
+			flags.String("file", "", "")
+			timestampFlag(flags, "timestamp", "")
+
+			return func(env *commandEnv) error {
+				ran = true
+				return nil
+			}
+		},
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"-file", "a", "extra"},
+		{"-file", "a", "-nope"},
+		{"-file", "a", "-timestamp", "yesterday"},
+	} {
+		err := runCommand(testCommand, args)
+		if exitCode(err) != exitUsage {
+			t.Errorf("Expected a usage error for %v, got %v", args, err)
+		}
+	}
+
+	if ran {
+		t.Error("Expected the command not to run on a usage error")
+	}
+
+	err := runCommand(testCommand, []string{"-file", "a", "-timestamp", "201901021504"})
+	if err != nil || !ran {
+		t.Errorf("Expected the command to run: %v", err)
+	}
+}
+
+func TestCommandRegistry(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, cmd := range commandRegistry() {
+		if seen[cmd.Name] {
+			t.Errorf("Command %s registered twice", cmd.Name)
+		}
+		seen[cmd.Name] = true
+
+		flags, _, _, runner := commandFlags(cmd)
+		if runner == nil {
+			t.Errorf("Command %s has nothing to run", cmd.Name)
+		}
+
+		for _, required := range cmd.Required {
+			if flags.Lookup(required) == nil {
+				t.Errorf("Command %s requires undefined flag -%s", cmd.Name, required)
+			}
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	commands := commandRegistry()
+
+	bash := bashCompletion(commands)
+	zsh := zshCompletion(commands)
+
+	for _, expected := range []string{"complete -o default -F", "restore) words=\"", "-timestamp", "-upload-file", "-do-space-name", "bash zsh"} {
+		if !strings.Contains(bash, expected) {
+			t.Errorf("Expected bash completion to contain %q", expected)
+		}
+	}
+
+	for _, expected := range []string{"#compdef", "'fleet-status:Show", "'-json[Print JSON instead of a table]'", "'-config[", ":_files'"} {
+		if !strings.Contains(zsh, expected) {
+			t.Errorf("Expected zsh completion to contain %q", expected)
+		}
+	}
+}
+
+func TestCommandJobs(t *testing.T) {
+	config := ConfigStruct{Jobs: map[string]JobConfig{"main": {}, "analytics": {}}}
+
+	jobs, err := commandJobs(findCommand("perform"), config, false)
+	if err != nil || len(jobs) != 2 || jobs[0] != "analytics" {
+		t.Errorf("Expected perform to run for every job, got %v (%v)", jobs, err)
+	}
+
+	for _, name := range []string{"restore", "daemon"} {
+		if _, err := commandJobs(findCommand(name), config, false); exitCode(err) != exitUsage {
+			t.Errorf("Expected %s to need -job, got %v", name, err)
+		}
+	}
+
+	if _, err := commandJobs(findCommand("list-backups"), config, true); exitCode(err) != exitUsage {
+		t.Errorf("Expected -hostname to need -job, got %v", err)
+	}
+
+	if jobs, _ := commandJobs(findCommand("fleet-status"), config, false); len(jobs) != 0 {
+		t.Errorf("Expected fleet-status to run once, got %v", jobs)
+	}
+
+	config.Job = "main"
+	if jobs, _ := commandJobs(findCommand("perform"), config, false); len(jobs) != 0 {
+		t.Errorf("Expected a config selected with -job to run once, got %v", jobs)
+	}
+
+	config = ConfigStruct{Jobs: map[string]JobConfig{"main": {}}}
+	if jobs, err := commandJobs(findCommand("restore"), config, false); err != nil || len(jobs) != 1 {
+		t.Errorf("Expected restore to use the only job, got %v (%v)", jobs, err)
+	}
+}
@@ -0,0 +1,492 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+)
+
+// commandRegistry returns every command in the order they are listed in the usage
+func commandRegistry() []*command {
+	return []*command{
+		{
+			Name:       "perform",
+			Summary:    "Take an incremental backup, or a full backup when one is due",
+			ForEachJob: true,
+			DryRuns:    true,
+			Setup:      performCommand(backupTypeDecide),
+		},
+		{
+			Name:       "perform-full",
+			Summary:    "Take a full backup",
+			ForEachJob: true,
+			DryRuns:    true,
+			Setup:      performCommand(backupTypeFull),
+		},
+		{
+			Name:       "perform-incremental",
+			Summary:    "Take an incremental backup",
+			ForEachJob: true,
+			DryRuns:    true,
+			Setup:      performCommand(backupTypeIncremental),
+		},
+		{
+			Name:     "upload",
+			Summary:  "Upload a backup file to the bucket",
+			Required: []string{"upload-file"},
+			NeedsJob: true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				uploadFile := flags.String("upload-file", "", "Backup file to upload to the bucket")
+
+				return func(env *commandEnv) error {
+					objectKey := path.Join(env.Hostname, path.Base(*uploadFile))
+					return withStage(stageUpload, backupMysqlUpload(env.Context, *uploadFile, objectKey, configStruct.DigitalOcean.SpaceName, env.Minio))
+				}
+			},
+		},
+		{
+			Name:          "restore",
+			Summary:       "Download and prepare the latest backup and move it into the MySQL data directory",
+			TakesHostname: true,
+			NeedsJob:      true,
+			DryRuns:       true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				timestamp := timestampFlag(flags, "timestamp", "Restore the latest backup at or before `YYYYMMDDHHII`")
+				existingVolumeID := flags.String("existing-volume-id", "", "Download into this volume instead of creating one")
+				existingBackupDirectory := flags.String("existing-backup-directory", "", "Download into this directory instead of creating a volume")
+				yes := flags.Bool("yes", false, "Install the MySQL config files of the backup without asking")
+
+				return func(env *commandEnv) error {
+					if env.DryRun {
+						return backupMysqlRestoreDryRun(
+							env.Hostname,
+							timestamp.String(),
+							configStruct.DigitalOcean.SpaceName,
+							configStruct.Mysql.DataPath,
+							*existingVolumeID,
+							*existingBackupDirectory,
+							env.DigitalOcean,
+							env.Minio,
+							os.Stdout,
+						)
+					}
+
+					err := ensureMysqlDataPath(configStruct.Mysql.DataPath)
+					if err != nil {
+						return withStage(stagePrerequisites, err)
+					}
+
+					return withHostLock("restore", env.Hostname, false, env.Minio, func() error {
+						restoreDirectory, mountDirectory, volume, restoreErr := backupMysqlDownloadAndPrepare(
+							env.Context,
+							env.Hostname,
+							timestamp.String(),
+							configStruct.DigitalOcean.SpaceName,
+							*existingVolumeID,
+							*existingBackupDirectory,
+							env.DigitalOcean,
+							env.Minio,
+						)
+						if restoreErr != nil {
+							return restoreErr
+						}
+
+						return backupMysqlFinalizeRestore(
+							restoreDirectory,
+							configStruct.Mysql.DataPath,
+							mountDirectory,
+							volume,
+							*yes,
+							env.DigitalOcean,
+							env.Minio,
+						)
+					})
+				}
+			},
+		},
+		{
+			Name:          "download",
+			Summary:       "Download and prepare a backup without moving it into the MySQL data directory",
+			TakesHostname: true,
+			NeedsJob:      true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				timestamp := timestampFlag(flags, "timestamp", "Download the latest backup at or before `YYYYMMDDHHII`")
+				existingVolumeID := flags.String("existing-volume-id", "", "Download into this volume instead of creating one")
+				existingRestoreDirectory := flags.String("existing-restore-directory", "", "Download into this directory instead of creating a volume")
+
+				return func(env *commandEnv) error {
+					return withHostLock("download", env.Hostname, false, env.Minio, func() error {
+						restoreDirectory, _, _, downloadErr := backupMysqlDownloadAndPrepare(
+							env.Context,
+							env.Hostname,
+							timestamp.String(),
+							configStruct.DigitalOcean.SpaceName,
+							*existingVolumeID,
+							*existingRestoreDirectory,
+							env.DigitalOcean,
+							env.Minio,
+						)
+						if downloadErr != nil {
+							return downloadErr
+						}
+
+						pkg.Log.Printf("Downloaded complete. Directory: %s\n", restoreDirectory)
+						return nil
+					})
+				}
+			},
+		},
+		{
+			Name:     "finalize-restore",
+			Summary:  "Move a backup prepared by `download` into the MySQL data directory",
+			Required: []string{"existing-restore-directory"},
+			NeedsJob: true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				existingRestoreDirectory := flags.String("existing-restore-directory", "", "Directory of the prepared backup")
+				yes := flags.Bool("yes", false, "Don't ask for confirmation")
+
+				return func(env *commandEnv) error {
+					err := backupMysqlFinalizeRestore(
+						*existingRestoreDirectory,
+						configStruct.Mysql.DataPath,
+						"",
+						nil,
+						*yes,
+						env.DigitalOcean,
+						env.Minio,
+					)
+
+					if err == nil {
+						pkg.Log.Printf("Restore complete. Don't forget to cleanup manually!")
+					}
+					return err
+				}
+			},
+		},
+		{
+			Name:          "list-backups",
+			Summary:       "List the backups of a host",
+			TakesHostname: true,
+			ForEachJob:    true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				timestamp := timestampFlag(flags, "timestamp", "Only list the backups a restore at `YYYYMMDDHHII` would use")
+
+				return func(env *commandEnv) error {
+					pkg.Log.Printf("Loading backups for %s\n", env.Hostname)
+
+					backups, err := listAllBackups(env.Hostname, configStruct.DigitalOcean.SpaceName, env.Minio)
+					if err != nil {
+						return withStage(stageList, fmt.Errorf("Could not list backups: %s", err))
+					}
+
+					if timestamp.String() != "" {
+						sinceTimestamp, _ := timestamp.time()
+
+						pkg.Log.Printf("Listing backups since %s\n", sinceTimestamp.Format(time.RFC3339))
+						backups = findRelevantBackupsUpTo(sinceTimestamp, backups)
+					}
+
+					for index, backup := range backups {
+						pkg.Log.Printf("%d:\t%s (created at %s)", index, backup.Path, backup.CreatedAt)
+					}
+					return nil
+				}
+			},
+		},
+		{
+			Name:          "prune",
+			Summary:       "Remove the backups outside of the retention window",
+			TakesHostname: true,
+			ForEachJob:    true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				yes := flags.Bool("yes", false, "Don't ask for confirmation")
+
+				return func(env *commandEnv) error {
+					if configStruct.Retention == nil {
+						pkg.Log.Println("No retention config. Nothing to do. Exiting")
+						return nil
+					}
+
+					return withHostLock("prune", env.Hostname, true, env.Minio, func() error {
+						return backupMysqlPruneInteractive(env.Hostname, configStruct.DigitalOcean.SpaceName, *yes, env.Minio)
+					})
+				}
+			},
+		},
+		{
+			Name:          "verify",
+			Summary:       "Check that the latest backup can be downloaded and extracted",
+			TakesHostname: true,
+			ForEachJob:    true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				return func(env *commandEnv) error {
+					return backupMysqlVerify(env.Hostname, configStruct.DigitalOcean.SpaceName, env.Minio)
+				}
+			},
+		},
+		{
+			Name:       "archive-binlogs",
+			Summary:    "Upload the binary logs MySQL has finished writing to the bucket",
+			ForEachJob: true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				return func(env *commandEnv) error {
+					return backupArchiveBinlogs(env.Context, env.Hostname, configStruct.DigitalOcean.SpaceName, env.Minio)
+				}
+			},
+		},
+		{
+			Name:    "gc-volumes",
+			Summary: "Remove volumes left behind by interrupted runs",
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				olderThanHours := flags.Int("older-than-hours", 0, "List volumes attached to this droplet for longer than this (Default: volumes.gc_older_than_hours or 48)")
+				includeUntagged := flags.Bool("include-untagged", false, "Include volumes created before volumes were tagged")
+				yes := flags.Bool("yes", false, "Don't ask for confirmation")
+
+				return func(env *commandEnv) error {
+					hours := *olderThanHours
+					if hours == 0 && configStruct.Volumes != nil {
+						hours = configStruct.Volumes.GCOlderThanHours
+					}
+
+					return withStage(stageVolume, backupGCVolumes(hours, *includeUntagged, *yes, env.DigitalOcean))
+				}
+			},
+		},
+		{
+			Name:          "status",
+			Summary:       "Show the last runs and the state of the backups of a host",
+			TakesHostname: true,
+			ForEachJob:    true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				limit := flags.Int("limit", 10, "Number of runs to show")
+
+				return func(env *commandEnv) error {
+					if *limit <= 0 {
+						return newUsageError("-limit should be a positive number")
+					}
+					return backupStatus(env.Hostname, *limit, env.Minio)
+				}
+			},
+		},
+		{
+			Name:    "fleet-status",
+			Summary: "Show the backup health of every host in the bucket",
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				asJSON := flags.Bool("json", false, "Print JSON instead of a table")
+
+				return func(env *commandEnv) error {
+					return backupFleetStatus(*asJSON, env.Minio)
+				}
+			},
+		},
+		{
+			Name:          "migrate-layout",
+			Summary:       "Copy the backups of a host to their keys in the layout of the config",
+			TakesHostname: true,
+			ForEachJob:    true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				fromTemplate := flags.String("from-template", defaultKeyTemplate, "Key template the backups are stored under now")
+				removeOld := flags.Bool("remove-old", false, "Remove the old objects once copied")
+				yes := flags.Bool("yes", false, "Don't ask for confirmation")
+
+				return func(env *commandEnv) error {
+					return withHostLock("migrate-layout", env.Hostname, true, env.Minio, func() error {
+						return backupMigrateLayout(env.StoragePrefix, *fromTemplate, *removeOld, *yes, configStruct.DigitalOcean.SpaceName, env.Minio)
+					})
+				}
+			},
+		},
+		{
+			Name:       "send-digest",
+			Summary:    "Email the digest of the runs since the last digest",
+			ForEachJob: true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				return func(env *commandEnv) error {
+					return backupSendDigest(env.Hostname, configStruct.PersistentStorage)
+				}
+			},
+		},
+		{
+			Name:           "daemon",
+			Summary:        "Run the jobs of the `schedule` config and serve the management API",
+			HandlesSignals: true,
+			NeedsJob:       true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				return func(env *commandEnv) error {
+					return backupDaemon(configStruct.Schedule, env.Hostname, env.DigitalOcean, env.Minio)
+				}
+			},
+		},
+		{
+			Name:    "test-alert",
+			Summary: "Send a test alert to every configured alerting provider",
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				return func(env *commandEnv) error {
+					return backupTestAlert()
+				}
+			},
+		},
+		{
+			Name:        "config",
+			Summary:     "Check the config, show it with the secrets redacted, or print its JSON Schema",
+			Args:        "validate|show|schema",
+			LoadsConfig: true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				skipCredentials := flags.Bool("skip-credentials", false, "When validating, don't check the credentials against the bucket and the DigitalOcean API")
+
+				return func(env *commandEnv) error {
+					if len(env.Args) != 1 {
+						return newUsageError("Expected one action: validate, show or schema")
+					}
+
+					switch env.Args[0] {
+					case "validate":
+						return backupConfigValidate(env.ConfigFlags, *skipCredentials, os.Stdout)
+					case "show":
+						return backupConfigShow(env.ConfigFlags, os.Stdout)
+					case "schema":
+						schemaJSON, err := configSchemaJSON()
+						if err != nil {
+							return err
+						}
+
+						os.Stdout.Write(schemaJSON)
+						return nil
+					default:
+						return newUsageError("Unknown action: %s. Should be validate, show or schema", env.Args[0])
+					}
+				}
+			},
+		},
+		{
+			Name:    "completion",
+			Summary: "Print a completion script for bash or zsh",
+			Args:    "bash|zsh",
+			Offline: true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				return func(env *commandEnv) error {
+					if len(env.Args) != 1 {
+						return newUsageError("Expected one shell: bash or zsh")
+					}
+
+					switch env.Args[0] {
+					case "bash":
+						fmt.Print(bashCompletion(commandRegistry()))
+					case "zsh":
+						fmt.Print(zshCompletion(commandRegistry()))
+					default:
+						return newUsageError("Unknown shell: %s. Should be bash or zsh", env.Args[0])
+					}
+					return nil
+				}
+			},
+		},
+		{
+			Name:    "help",
+			Summary: "Show the commands, or the flags of a command",
+			Args:    "[COMMAND]",
+			Offline: true,
+			Setup: func(flags *flag.FlagSet) commandRunner {
+				return func(env *commandEnv) error {
+					if len(env.Args) == 0 {
+						printUsage(os.Stdout)
+						return nil
+					}
+
+					cmd := findCommand(env.Args[0])
+					if cmd == nil {
+						return newUsageError("Unknown command: %s", env.Args[0])
+					}
+
+					printCommandUsage(os.Stdout, cmd)
+					return nil
+				}
+			},
+		},
+	}
+}
+
+func performCommand(backupType string) func(flags *flag.FlagSet) commandRunner {
+	return func(flags *flag.FlagSet) commandRunner {
+		existingVolumeID := flags.String("existing-volume-id", "", "Write the backup to this volume instead of creating one")
+		existingBackupDirectory := flags.String("existing-backup-directory", "", "Write the backup to this directory instead of creating a volume")
+
+		return func(env *commandEnv) error {
+			if env.DryRun {
+				return backupMysqlPerformDryRun(
+					backupType,
+					env.Hostname,
+					configStruct.DigitalOcean.SpaceName,
+					configStruct.Mysql.DataPath,
+					*existingVolumeID,
+					*existingBackupDirectory,
+					configStruct.PersistentStorage,
+					env.DigitalOcean,
+					env.Minio,
+					os.Stdout,
+				)
+			}
+
+			return withHostLock(flags.Name(), env.Hostname, true, env.Minio, func() error {
+				return backupMysqlPerform(
+					env.Context,
+					backupType,
+					env.Hostname,
+					configStruct.DigitalOcean.SpaceName,
+					configStruct.Mysql.DataPath,
+					*existingVolumeID,
+					*existingBackupDirectory,
+					configStruct.PersistentStorage,
+					env.DigitalOcean,
+					env.Minio,
+				)
+			})
+		}
+	}
+}
+
+// ensureMysqlDataPath creates the MySQL data directory if it doesn't exist
+func ensureMysqlDataPath(mysqlDataPath string) error {
+	_, err := os.Stat(mysqlDataPath)
+	if err == nil {
+		return nil
+	}
+
+	if !os.IsNotExist(err) {
+		return errors.New("Could not access the MySQL data path: " + err.Error())
+	}
+
+	// It did not exist, just to be sure we try to create it. If that fails this script can't continue
+	err = os.MkdirAll(mysqlDataPath, 0700)
+	if err != nil {
+		return errors.New("Could not access nor create the MySQL data path: " + err.Error())
+	}
+	return nil
+}
+
+func backupTestAlert() error {
+	results := pkg.SendTestAlert(configStruct.Alerting)
+	if len(results) == 0 {
+		return errors.New("No alerting providers configured")
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			pkg.ErrorLog.Printf("%s: FAILED (%s)\n", result.Name, result.Err)
+		} else {
+			pkg.Log.Printf("%s: OK\n", result.Name)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d alerting %s failed", failed, len(results), pluralize(len(results), "provider", "providers"))
+	}
+	return nil
+}
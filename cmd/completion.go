@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// completionFlags returns the flags of cmd, including the global ones, as they are typed on the command line
+func completionFlags(cmd *command) []string {
+	flags, _, _, _ := commandFlags(cmd)
+
+	names := make([]string, 0)
+	flags.VisitAll(func(flag *flag.Flag) {
+		names = append(names, "-"+flag.Name)
+	})
+	return names
+}
+
+// commandArgChoices returns the values of a positional argument like `bash|zsh`
+func commandArgChoices(cmd *command) []string {
+	if cmd.Args == "" || strings.HasPrefix(cmd.Args, "[") {
+		return nil
+	}
+	return strings.Split(cmd.Args, "|")
+}
+
+func commandNames(commands []*command) []string {
+	names := make([]string, len(commands))
+	for index, cmd := range commands {
+		names[index] = cmd.Name
+	}
+	return names
+}
+
+func bashCompletion(commands []*command) string {
+	program := programName()
+	function := "_" + strings.Replace(program, "-", "_", -1)
+
+	var script strings.Builder
+	fmt.Fprintf(&script, "# bash completion for %s. Load with: source <(%s completion bash)\n", program, program)
+	fmt.Fprintf(&script, "%s() {\n", function)
+	fmt.Fprintf(&script, "    local current=\"${COMP_WORDS[COMP_CWORD]}\"\n\n")
+	fmt.Fprintf(&script, "    if [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(&script, "        COMPREPLY=($(compgen -W \"%s\" -- \"$current\"))\n", strings.Join(commandNames(commands), " "))
+	fmt.Fprintf(&script, "        return\n")
+	fmt.Fprintf(&script, "    fi\n\n")
+	fmt.Fprintf(&script, "    local words\n")
+	fmt.Fprintf(&script, "    case \"${COMP_WORDS[1]}\" in\n")
+	for _, cmd := range commands {
+		words := append(completionFlags(cmd), commandArgChoices(cmd)...)
+		if cmd.Name == "help" {
+			words = append(words, commandNames(commands)...)
+		}
+
+		fmt.Fprintf(&script, "        %s) words=\"%s\" ;;\n", cmd.Name, strings.Join(words, " "))
+	}
+	fmt.Fprintf(&script, "    esac\n\n")
+	fmt.Fprintf(&script, "    COMPREPLY=($(compgen -W \"$words\" -- \"$current\"))\n")
+	fmt.Fprintf(&script, "}\n")
+	fmt.Fprintf(&script, "complete -o default -F %s %s\n", function, program)
+
+	return script.String()
+}
+
+func zshCompletion(commands []*command) string {
+	program := programName()
+	function := "_" + strings.Replace(program, "-", "_", -1)
+
+	var script strings.Builder
+	fmt.Fprintf(&script, "#compdef %s\n", program)
+	fmt.Fprintf(&script, "# zsh completion for %s. Save as %s in a directory of $fpath\n\n", program, function)
+	fmt.Fprintf(&script, "%s() {\n", function)
+	fmt.Fprintf(&script, "    local -a commands\n")
+	fmt.Fprintf(&script, "    commands=(\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&script, "        '%s:%s'\n", cmd.Name, zshEscape(cmd.Summary))
+	}
+	fmt.Fprintf(&script, "    )\n\n")
+	fmt.Fprintf(&script, "    if (( CURRENT == 2 )); then\n")
+	fmt.Fprintf(&script, "        _describe 'command' commands\n")
+	fmt.Fprintf(&script, "        return\n")
+	fmt.Fprintf(&script, "    fi\n\n")
+	fmt.Fprintf(&script, "    local subcommand=\"$words[2]\"\n")
+	fmt.Fprintf(&script, "    shift words\n")
+	fmt.Fprintf(&script, "    (( CURRENT-- ))\n\n")
+	fmt.Fprintf(&script, "    case \"$subcommand\" in\n")
+	for _, cmd := range commands {
+		if cmd.Name == "help" {
+			fmt.Fprintf(&script, "        help) _describe 'command' commands ;;\n")
+			continue
+		}
+
+		flags, _, _, _ := commandFlags(cmd)
+		specs := make([]string, 0)
+		flags.VisitAll(func(definedFlag *flag.Flag) {
+			spec := "'-" + definedFlag.Name + "[" + zshEscape(definedFlag.Usage) + "]"
+			if valueName, _ := flag.UnquoteUsage(definedFlag); valueName != "" {
+				spec += ":" + valueName + ":"
+				if definedFlag.Name == "config" || strings.HasSuffix(definedFlag.Name, "-file") || strings.HasSuffix(definedFlag.Name, "-directory") {
+					spec += "_files"
+				}
+			}
+			specs = append(specs, spec+"'")
+		})
+
+		if choices := commandArgChoices(cmd); len(choices) > 0 {
+			specs = append(specs, "'1:"+cmd.Name+":("+strings.Join(choices, " ")+")'")
+		}
+
+		fmt.Fprintf(&script, "        %s) _arguments %s ;;\n", cmd.Name, strings.Join(specs, " "))
+	}
+	fmt.Fprintf(&script, "    esac\n")
+	fmt.Fprintf(&script, "}\n\n")
+	fmt.Fprintf(&script, "%s \"$@\"\n", function)
+
+	return script.String()
+}
+
+// zshEscape makes value safe inside a single quoted zsh spec
+func zshEscape(value string) string {
+	replacer := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:", "`", "")
+	return replacer.Replace(value)
+}
@@ -1,43 +1,100 @@
 package cmd
 
 import (
-	"encoding/json"
 	"errors"
 	"flag"
-	"io/ioutil"
+	"fmt"
 	"os"
+	"path"
+	"sort"
+	"strings"
 
 	"github.com/feederco/really-simple-db-backup/pkg"
 )
 
-// ConfigStruct contains information that can be preloaded from a .json file
+// ConfigStruct contains information that can be preloaded from a JSON, YAML or TOML file.
+// Fields tagged `secret:"true"`, here and in the nested configs, are redacted by `config show` and accept `env:`, `file:` and `vault:` references
 type ConfigStruct struct {
-	LegacyDOKey           string `json:"do_key"`
-	LegacyDOSpaceEndpoint string `json:"do_space_endpoint"`
-	LegacyDOSpaceName     string `json:"do_space_name"`
-	LegacyDOSpaceKey      string `json:"do_space_key"`
-	LegacyDOSpaceSecret   string `json:"do_space_secret"`
-	LegacyMysqlDataPath   string `json:"mysql_data_path"`
+	LegacyDOKey           string `json:"do_key,omitempty" secret:"true"`
+	LegacyDOSpaceEndpoint string `json:"do_space_endpoint,omitempty"`
+	LegacyDOSpaceName     string `json:"do_space_name,omitempty"`
+	LegacyDOSpaceKey      string `json:"do_space_key,omitempty"`
+	LegacyDOSpaceSecret   string `json:"do_space_secret,omitempty" secret:"true"`
+	LegacyMysqlDataPath   string `json:"mysql_data_path,omitempty"`
 
 	DigitalOcean      DigitalOceanConfigStruct `json:"digitalocean"`
 	Mysql             MysqlConfigStruct        `json:"mysql"`
 	PersistentStorage string                   `json:"persistent_storage"`
 	Alerting          *pkg.AlertingConfig      `json:"alerting"`
 	Retention         *RetentionConfig         `json:"retention"`
+	Schedule          *ScheduleConfig          `json:"schedule"`
+	Lock              *LockConfig              `json:"lock"`
+	Volumes           *VolumesConfig           `json:"volumes"`
+	Download          *DownloadConfig          `json:"download"`
+	Metrics           *pkg.MetricsConfig       `json:"metrics"`
+	Heartbeat         *HeartbeatsConfig        `json:"heartbeat"`
+	Logging           *LoggingConfig           `json:"logging"`
+	Fleet             *FleetConfig             `json:"fleet"`
+	API               *APIConfig               `json:"api"`
+	Hooks             *HooksConfig             `json:"hooks"`
+	Vault             *pkg.VaultConfig         `json:"vault"`
+	Commands          *pkg.CommandsConfig      `json:"commands"`
+
+	// Prefix of the objects of this server in the bucket. Default: the hostname
+	StoragePrefix string        `json:"storage_prefix"`
+	Layout        *LayoutConfig `json:"layout"`
+
+	// Named backups of other MySQL instances on this server, selected with -job. Their settings override the ones above
+	Jobs map[string]JobConfig `json:"jobs"`
+
+	// The job the config was selected for with forJob
+	Job string `json:"-"`
+
+	// Config files this one is layered on top of. Merged in by readConfigFile
+	Include []string `json:"include,omitempty"`
 }
 
 // DigitalOceanConfigStruct contains information related to DigitalOcean
 type DigitalOceanConfigStruct struct {
-	Key           string `json:"key"`
+	Key           string `json:"key" secret:"true"`
 	SpaceEndpoint string `json:"space_endpoint"`
 	SpaceName     string `json:"space_name"`
 	SpaceKey      string `json:"space_key"`
-	SpaceSecret   string `json:"space_secret"`
+	SpaceSecret   string `json:"space_secret" secret:"true"`
 }
 
 // MysqlConfigStruct contains information related to MySQL
 type MysqlConfigStruct struct {
 	DataPath string `json:"data_path"`
+
+	// Socket xtrabackup and the mysql client connect to. Default: the one in the MySQL config files
+	Socket string `json:"socket"`
+}
+
+// LayoutConfig contains where backups are stored in the bucket
+type LayoutConfig struct {
+	// Key of every backup, like `{environment}/{cluster}/{hostname}/mysql-backup-{timestamp_utc}.{type}.xbstream`.
+	// Default: {hostname}/mysql-backup-{timestamp}.{type}.xbstream
+	KeyTemplate string `json:"key_template"`
+
+	Environment string `json:"environment"`
+	Cluster     string `json:"cluster"`
+}
+
+// JobConfig contains the settings of one MySQL instance when a server runs several
+type JobConfig struct {
+	Mysql MysqlConfigStruct `json:"mysql"`
+
+	// Default: <storage prefix or hostname>-<job>
+	StoragePrefix string `json:"storage_prefix"`
+
+	// Default: <persistent_storage>/<job>
+	PersistentStorage string `json:"persistent_storage"`
+
+	Retention *RetentionConfig `json:"retention"`
+
+	// Jobs don't share the heartbeat of the config, a success of one would hide a failure of another
+	Heartbeat *HeartbeatsConfig `json:"heartbeat"`
 }
 
 // RetentionConfig contains options for scheduling: how often full backups are run, retention of old backups
@@ -48,37 +105,171 @@ type RetentionConfig struct {
 	HoursBetweenFullBackups int  `json:"hours_between_full_backups"`
 }
 
-func loadConfig(args []string) ConfigStruct {
-	const defaultConfigPath = "/etc/really-simple-db-backup.json"
+// ScheduleConfig contains cron expressions for the jobs run by the `daemon` command. Empty means not scheduled
+type ScheduleConfig struct {
+	Full          string `json:"full"`
+	Incremental   string `json:"incremental"`
+	Prune         string `json:"prune"`
+	Verify        string `json:"verify"`
+	BinlogArchive string `json:"binlog_archive"`
+	GCVolumes     string `json:"gc_volumes"`
+	Digest        string `json:"digest"`
+}
+
+// LockConfig contains options for preventing concurrent runs across droplets
+type LockConfig struct {
+	BucketLease          bool `json:"bucket_lease"`
+	LeaseDurationInHours int  `json:"lease_duration_in_hours"`
+}
+
+// VolumesConfig contains options for the volumes created during backups and restores
+type VolumesConfig struct {
+	GCOlderThanHours int `json:"gc_older_than_hours"`
+}
+
+// DownloadConfig contains options for downloading backups during restores
+type DownloadConfig struct {
+	// Number of parts of a backup downloaded at the same time (Default: 4)
+	Connections int `json:"connections"`
+
+	// Backups are downloaded in ranges of this size (Default: 64)
+	PartSizeInMegaBytes int `json:"part_size_in_megabytes"`
+}
+
+// HeartbeatsConfig contains the dead man's switch pinged around each job
+type HeartbeatsConfig struct {
+	Perform *pkg.HeartbeatConfig `json:"perform"`
+	Prune   *pkg.HeartbeatConfig `json:"prune"`
+}
+
+// LoggingConfig contains options for the log output
+type LoggingConfig struct {
+	Format string `json:"format"`
+}
+
+// APIConfig contains the settings of the management API served by the daemon
+type APIConfig struct {
+	ListenAddress string `json:"listen_address"`
+
+	// Every request must send it as `Authorization: Bearer <token>`
+	Token string `json:"token" secret:"true"`
+}
+
+// FleetConfig contains the expected backup schedule of the hosts shown by `fleet-status`.
+// Hosts without an expected interval have it inferred from their own backups
+type FleetConfig struct {
+	FleetHostConfig
+	Hosts map[string]FleetHostConfig `json:"hosts"`
+}
+
+// FleetHostConfig contains the expected backup schedule of a host
+type FleetHostConfig struct {
+	ExpectedIntervalHours     int `json:"expected_interval_hours"`
+	ExpectedFullIntervalHours int `json:"expected_full_interval_hours"`
+}
+
+// hostConfig returns the schedule of hostname, falling back to the fleet wide defaults, and whether the host is listed
+func (fleetConfig *FleetConfig) hostConfig(hostname string) (FleetHostConfig, bool) {
+	if fleetConfig == nil {
+		return FleetHostConfig{}, false
+	}
+
+	hostConfig, listed := fleetConfig.Hosts[hostname]
+	if hostConfig.ExpectedIntervalHours == 0 {
+		hostConfig.ExpectedIntervalHours = fleetConfig.ExpectedIntervalHours
+	}
+	if hostConfig.ExpectedFullIntervalHours == 0 {
+		hostConfig.ExpectedFullIntervalHours = fleetConfig.ExpectedFullIntervalHours
+	}
+
+	return hostConfig, listed
+}
 
-	configFlag := flag.String("config", "", "Path to a config file to load default configs from. (Default: "+defaultConfigPath+")")
+const defaultConfigPath = "/etc/really-simple-db-backup.json"
+
+// Every config flag can also be set with an environment variable named after it, like RSDB_DO_SPACE_SECRET for -do-space-secret
+const configEnvPrefix = "RSDB_"
+
+// configFlags override the values of the config file
+type configFlags struct {
+	Config            *string
+	DOKey             *string
+	DOSpaceEndpoint   *string
+	DOSpaceName       *string
+	DOSpaceKey        *string
+	DOSpaceSecret     *string
+	MysqlDataPath     *string
+	PersistentStorage *string
+	Job               *string
+}
 
-	doKeyFlag := flag.String("do-key", "", "DigitalOcean OAuth2 key created in \"Applications & API\"")
-	doSpaceEndpointFlag := flag.String("do-space-endpoint", "", "DigitalOcean Space endpoint to use when uploading backups")
-	doSpaceNameFlag := flag.String("do-space-name", "", "DigitalOcean Space bucket name")
-	doSpaceKeyFlag := flag.String("do-space-key", "", "DigitalOcean Space key")
-	doSpaceSecretFlag := flag.String("do-space-secret", "", "DigitalOcean Space secret")
+func addConfigFlags(flags *flag.FlagSet) *configFlags {
+	return &configFlags{
+		Config: configFlag(flags, "config", "Path to a config file to load default configs from. (Default: "+defaultConfigPath+")"),
 
-	mysqlDataPathFlag := flag.String("mysql-data-path", "", "Path to MySQL data directory to backup (Default: /var/lib/mysql)")
-	persistentStorageDirectoryFlag := flag.String("persistent-storage", "", "Path to store persistent data about backups. (Default: /var/lib/backup-mysql)")
+		DOKey:           configFlag(flags, "do-key", "DigitalOcean OAuth2 key created in \"Applications & API\""),
+		DOSpaceEndpoint: configFlag(flags, "do-space-endpoint", "DigitalOcean Space endpoint to use when uploading backups"),
+		DOSpaceName:     configFlag(flags, "do-space-name", "DigitalOcean Space bucket name"),
+		DOSpaceKey:      configFlag(flags, "do-space-key", "DigitalOcean Space key"),
+		DOSpaceSecret:   configFlag(flags, "do-space-secret", "DigitalOcean Space secret"),
 
-	err := pkg.ParseCommandLineFlags(args)
+		MysqlDataPath:     configFlag(flags, "mysql-data-path", "Path to MySQL data directory to backup (Default: /var/lib/mysql)"),
+		PersistentStorage: configFlag(flags, "persistent-storage", "Path to store persistent data about backups. (Default: /var/lib/backup-mysql)"),
+
+		Job: configFlag(flags, "job", "Only act on this job of the config. (Default: every job)"),
+	}
+}
+
+func configFlag(flags *flag.FlagSet, name string, usage string) *string {
+	return flags.String(name, "", usage+" (Env: "+configEnvName(name)+")")
+}
+
+// configEnvName returns the environment variable that sets the config flag name
+func configEnvName(name string) string {
+	return configEnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// configOverride returns the value of the flag name, falling back to its environment variable
+func configOverride(flagValue *string, name string) string {
+	if *flagValue != "" {
+		return *flagValue
+	}
+	return os.Getenv(configEnvName(name))
+}
+
+// path returns the config file to load and whether it was chosen with -config or RSDB_CONFIG
+func (flags *configFlags) path() (string, bool) {
+	if configPath := configOverride(flags.Config, "config"); configPath != "" {
+		return configPath, true
+	}
+	return defaultConfigPath, false
+}
+
+// load loads the config file, applies the environment variables, flags and defaults on top of it and resolves the secret references
+func (flags *configFlags) load() (ConfigStruct, error) {
+	newConfigStruct, err := flags.loadUnresolved()
 	if err != nil {
-		flag.Usage()
-		os.Exit(1)
+		return newConfigStruct, err
 	}
 
+	err = resolveConfigSecrets(&newConfigStruct)
+	return newConfigStruct, err
+}
+
+// loadUnresolved is load without resolving the secret references
+func (flags *configFlags) loadUnresolved() (ConfigStruct, error) {
+	var err error
 	newConfigStruct := ConfigStruct{}
 
-	if *configFlag != "" {
+	if configPath, explicit := flags.path(); explicit {
 		var didExist bool
-		newConfigStruct, didExist, err = loadConfigAtPath(*configFlag)
+		newConfigStruct, didExist, err = loadConfigAtPath(configPath)
 		if !didExist {
-			pkg.ErrorLog.Fatalln("Could not load file from -config flag:", err)
+			return newConfigStruct, fmt.Errorf("Could not load config file: %s", configPath)
 		}
 
 		if err != nil {
-			pkg.ErrorLog.Fatalln(err.Error())
+			return newConfigStruct, err
 		}
 	} else {
 		var didExist bool
@@ -86,10 +277,33 @@ func loadConfig(args []string) ConfigStruct {
 
 		// If default file doesn't exist we don't error. But if it does and is broken we error.
 		if didExist && err != nil {
-			pkg.ErrorLog.Fatalln(err.Error())
+			return newConfigStruct, err
 		}
 	}
 
+	newConfigStruct = flags.apply(newConfigStruct)
+	if job := flags.job(); job != "" {
+		return flags.applyJob(newConfigStruct, job)
+	}
+	return newConfigStruct, nil
+}
+
+// job returns the job chosen with -job or RSDB_JOB
+func (flags *configFlags) job() string {
+	return configOverride(flags.Job, "job")
+}
+
+// applyJob returns the config of the job name, with the flags and environment variables still taking precedence
+func (flags *configFlags) applyJob(config ConfigStruct, name string) (ConfigStruct, error) {
+	jobConfig, err := config.forJob(name)
+	if err != nil {
+		return config, err
+	}
+	return flags.apply(jobConfig), nil
+}
+
+// apply merges the legacy properties, the environment variables, the flags and the defaults into the config loaded from the config file
+func (flags *configFlags) apply(newConfigStruct ConfigStruct) ConfigStruct {
 	// Setting legacy properties
 
 	if newConfigStruct.LegacyMysqlDataPath != "" && newConfigStruct.Mysql.DataPath == "" {
@@ -112,36 +326,32 @@ func loadConfig(args []string) ConfigStruct {
 		newConfigStruct.DigitalOcean.SpaceSecret = newConfigStruct.LegacyDOSpaceSecret
 	}
 
-	if *doKeyFlag != "" {
-		newConfigStruct.DigitalOcean.Key = *doKeyFlag
+	if value := configOverride(flags.DOKey, "do-key"); value != "" {
+		newConfigStruct.DigitalOcean.Key = value
 	}
 
-	if *doSpaceNameFlag != "" {
-		newConfigStruct.DigitalOcean.SpaceName = *doSpaceNameFlag
+	if value := configOverride(flags.DOSpaceName, "do-space-name"); value != "" {
+		newConfigStruct.DigitalOcean.SpaceName = value
 	}
 
-	if *doSpaceEndpointFlag != "" {
-		newConfigStruct.DigitalOcean.SpaceEndpoint = *doSpaceEndpointFlag
+	if value := configOverride(flags.DOSpaceEndpoint, "do-space-endpoint"); value != "" {
+		newConfigStruct.DigitalOcean.SpaceEndpoint = value
 	}
 
-	if *doSpaceKeyFlag != "" {
-		newConfigStruct.DigitalOcean.SpaceKey = *doSpaceKeyFlag
+	if value := configOverride(flags.DOSpaceKey, "do-space-key"); value != "" {
+		newConfigStruct.DigitalOcean.SpaceKey = value
 	}
 
-	if *doSpaceSecretFlag != "" {
-		newConfigStruct.DigitalOcean.SpaceSecret = *doSpaceSecretFlag
+	if value := configOverride(flags.DOSpaceSecret, "do-space-secret"); value != "" {
+		newConfigStruct.DigitalOcean.SpaceSecret = value
 	}
 
-	if *doKeyFlag != "" {
-		newConfigStruct.DigitalOcean.Key = *doKeyFlag
+	if value := configOverride(flags.MysqlDataPath, "mysql-data-path"); value != "" {
+		newConfigStruct.Mysql.DataPath = value
 	}
 
-	if *mysqlDataPathFlag != "" {
-		newConfigStruct.Mysql.DataPath = *mysqlDataPathFlag
-	}
-
-	if *persistentStorageDirectoryFlag != "" {
-		newConfigStruct.PersistentStorage = *persistentStorageDirectoryFlag
+	if value := configOverride(flags.PersistentStorage, "persistent-storage"); value != "" {
+		newConfigStruct.PersistentStorage = value
 	}
 
 	// Setting defaults
@@ -157,16 +367,143 @@ func loadConfig(args []string) ConfigStruct {
 	return newConfigStruct
 }
 
+// jobNames returns the names of the jobs of the config in alphabetical order
+func (config ConfigStruct) jobNames() []string {
+	names := make([]string, 0, len(config.Jobs))
+	for name := range config.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// storagePrefix returns the prefix of the objects of this server, or of its job, in the bucket
+func (config ConfigStruct) storagePrefix() string {
+	if config.StoragePrefix != "" {
+		return config.StoragePrefix
+	}
+
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// backupLayout returns the layout of the keys of backups. Invalid key templates are rejected by validateConfig
+func (config ConfigStruct) backupLayout() *objectLayout {
+	layout, err := newObjectLayout(config.Layout)
+	if err != nil {
+		layout, _ = newObjectLayout(nil)
+	}
+	return layout
+}
+
+// hostDirectory returns the directory of the backups of this server, or of its job, in the bucket
+func (config ConfigStruct) hostDirectory() string {
+	return config.backupLayout().hostDirectory(config.storagePrefix())
+}
+
+// forJob returns config with the settings of the job name layered on top
+func (config ConfigStruct) forJob(name string) (ConfigStruct, error) {
+	job, exists := config.Jobs[name]
+	if !exists {
+		if len(config.Jobs) == 0 {
+			return config, fmt.Errorf("Unknown job %s. The config defines no jobs", name)
+		}
+		return config, fmt.Errorf("Unknown job %s. Should be one of: %s", name, strings.Join(config.jobNames(), ", "))
+	}
+
+	config.Job = name
+
+	if job.Mysql.DataPath != "" {
+		config.Mysql.DataPath = job.Mysql.DataPath
+	}
+	if job.Mysql.Socket != "" {
+		config.Mysql.Socket = job.Mysql.Socket
+	}
+
+	if job.StoragePrefix != "" {
+		config.StoragePrefix = job.StoragePrefix
+	} else {
+		config.StoragePrefix = config.storagePrefix() + "-" + name
+	}
+
+	if job.PersistentStorage != "" {
+		config.PersistentStorage = job.PersistentStorage
+	} else {
+		config.PersistentStorage = path.Join(config.PersistentStorage, name)
+	}
+
+	if job.Retention != nil {
+		config.Retention = job.Retention
+	}
+
+	if job.Heartbeat != nil {
+		config.Heartbeat = job.Heartbeat
+	} else if len(config.Jobs) > 1 {
+		config.Heartbeat = nil
+	}
+
+	return config, nil
+}
+
+// validateConfig checks the values every command that connects to the bucket needs
+func validateConfig(config ConfigStruct) error {
+	problems := requiredConfigProblems(config)
+	if len(problems) > 0 {
+		return errors.New(problems[0].Message)
+	}
+
+	return nil
+}
+
+func requiredConfigProblems(config ConfigStruct) []configProblem {
+	problems := make([]configProblem, 0)
+
+	required := []struct {
+		value string
+		key   string
+		flag  string
+	}{
+		{config.DigitalOcean.SpaceName, "digitalocean.space_name", "-do-space-name"},
+		{config.DigitalOcean.SpaceEndpoint, "digitalocean.space_endpoint", "-do-space-endpoint"},
+		{config.DigitalOcean.SpaceKey, "digitalocean.space_key", "-do-space-key"},
+		{config.DigitalOcean.SpaceSecret, "digitalocean.space_secret", "-do-space-secret"},
+	}
+
+	for _, field := range required {
+		if field.value == "" {
+			problems = append(problems, configProblem{Severity: configSeverityError, Message: field.key + " is required. Set it in the config file or with " + field.flag})
+		}
+	}
+
+	if _, err := newObjectLayout(config.Layout); err != nil {
+		problems = append(problems, configProblem{Severity: configSeverityError, Message: "layout.key_template " + err.Error()})
+	}
+
+	if config.API != nil && config.API.ListenAddress != "" && config.API.Token == "" {
+		problems = append(problems, configProblem{Severity: configSeverityError, Message: "api.token is required when api.listen_address is set"})
+	}
+
+	return problems
+}
+
 func loadConfigAtPath(path string) (ConfigStruct, bool, error) {
 	var newConfigStruct ConfigStruct
 
-	configFile, err := ioutil.ReadFile(path)
+	contents, err := readConfigFile(path)
 	if os.IsNotExist(err) {
 		return newConfigStruct, false, nil
 	}
+	if err != nil {
+		return newConfigStruct, true, errors.New("Could not load config file. " + err.Error())
+	}
+
+	newConfigStruct, err = decodeConfigValues(contents)
+	if err != nil {
+		return newConfigStruct, true, errors.New("Could not load config file. " + err.Error())
+	}
 
-	if err = json.Unmarshal(configFile, &newConfigStruct); err != nil {
-		return newConfigStruct, true, errors.New("Could not load config file. JSON decode failed: " + err.Error())
+	for _, unknownKey := range findUnknownConfigKeys(contents) {
+		pkg.ErrorLog.Printf("Warning: %s: %s is ignored. Run `config validate` to check the config\n", path, unknownKey)
 	}
 
 	return newConfigStruct, true, nil
@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+const configSeverityError = "error"
+const configSeverityWarning = "warning"
+
+const redactedSecret = "REDACTED"
+
+const configCredentialsTimeout = 30 * time.Second
+
+// configProblem is something `config validate` found wrong with the config
+type configProblem struct {
+	Severity string
+	Message  string
+}
+
+// backupConfigValidate checks the config file strictly, sanity checks its values and, unless skipCredentials is set, checks the credentials against the bucket and the DigitalOcean API
+func backupConfigValidate(flags *configFlags, skipCredentials bool, output io.Writer) error {
+	configPath, explicit := flags.path()
+
+	fileConfig, problems, err := loadConfigStrict(configPath)
+	if os.IsNotExist(err) && !explicit {
+		fmt.Fprintf(output, "No config file at %s. Only flags are used\n", configPath)
+	} else if err != nil {
+		return &configError{Err: fmt.Errorf("Could not load config file %s: %s", configPath, err)}
+	} else {
+		fmt.Fprintf(output, "Checking %s\n", configPath)
+	}
+
+	config := flags.apply(fileConfig)
+	problems = append(problems, checkConfigJobs(config)...)
+
+	if job := flags.job(); job != "" {
+		config, err = flags.applyJob(config, job)
+		if err != nil {
+			problems = append(problems, configProblem{Severity: configSeverityError, Message: err.Error()})
+		}
+	}
+
+	if err = resolveConfigSecrets(&config); err != nil {
+		problems = append(problems, configProblem{Severity: configSeverityError, Message: "Could not resolve secret " + err.Error()})
+	}
+	problems = append(problems, checkConfig(config)...)
+
+	if !skipCredentials && countConfigProblems(problems, configSeverityError) == 0 {
+		problems = append(problems, checkConfigCredentials(config)...)
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintf(output, "%-8s %s\n", problem.Severity+":", problem.Message)
+	}
+
+	errorCount := countConfigProblems(problems, configSeverityError)
+	warningCount := countConfigProblems(problems, configSeverityWarning)
+	if errorCount > 0 {
+		return &configError{Err: fmt.Errorf("Config is invalid: %d %s, %d %s", errorCount, pluralize(errorCount, "error", "errors"), warningCount, pluralize(warningCount, "warning", "warnings"))}
+	}
+
+	fmt.Fprintf(output, "Config is valid (%d %s)\n", warningCount, pluralize(warningCount, "warning", "warnings"))
+	return nil
+}
+
+// loadConfigStrict loads the config file at configPath and reports anything that loadConfigAtPath would silently ignore
+func loadConfigStrict(configPath string) (ConfigStruct, []configProblem, error) {
+	var config ConfigStruct
+	problems := make([]configProblem, 0)
+
+	contents, err := readConfigFile(configPath)
+	if os.IsNotExist(err) {
+		return config, problems, err
+	}
+	if err != nil {
+		return config, append(problems, configProblem{Severity: configSeverityError, Message: err.Error()}), nil
+	}
+
+	for _, unknownKey := range findUnknownConfigKeys(contents) {
+		problems = append(problems, configProblem{Severity: configSeverityError, Message: unknownKey.String()})
+	}
+
+	config, err = decodeConfigValues(contents)
+	if typeErr, isTypeErr := err.(*json.UnmarshalTypeError); isTypeErr {
+		problems = append(problems, configProblem{Severity: configSeverityError, Message: fmt.Sprintf("%s should be of type %s, not %s", typeErr.Field, typeErr.Type, typeErr.Value)})
+	} else if err != nil {
+		problems = append(problems, configProblem{Severity: configSeverityError, Message: err.Error()})
+	}
+
+	return config, problems, nil
+}
+
+// checkConfig sanity checks the values of the effective config
+func checkConfig(config ConfigStruct) []configProblem {
+	problems := requiredConfigProblems(config)
+	addError := func(format string, args ...interface{}) {
+		problems = append(problems, configProblem{Severity: configSeverityError, Message: fmt.Sprintf(format, args...)})
+	}
+	addWarning := func(format string, args ...interface{}) {
+		problems = append(problems, configProblem{Severity: configSeverityWarning, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if config.DigitalOcean.Key == "" {
+		addWarning("digitalocean.key is not set. Commands that create volumes will fail")
+	}
+
+	retention := config.Retention
+	if retention == nil {
+		addWarning("retention is not set. Backups are kept forever and `perform` can't decide between a full and an incremental backup")
+	} else {
+		if retention.RetentionInDays < 0 {
+			addError("retention.retention_in_days should not be negative")
+		}
+		if retention.RetentionInHours < 0 {
+			addError("retention.retention_in_hours should not be negative")
+		}
+		if retention.HoursBetweenFullBackups < 0 {
+			addError("retention.hours_between_full_backups should not be negative")
+		}
+
+		if retention.RetentionInDays > 0 && retention.RetentionInHours > 0 {
+			addWarning("retention.retention_in_hours is ignored since retention.retention_in_days is set")
+		}
+		if retention.AutomaticallyRemoveOld && retention.RetentionInDays <= 0 && retention.RetentionInHours <= 0 {
+			addWarning("retention.automatically_remove_old is set without retention.retention_in_days or retention.retention_in_hours. No backups will be removed")
+		}
+		if retention.HoursBetweenFullBackups == 0 {
+			addWarning("retention.hours_between_full_backups is not set. Every `perform` will take a full backup")
+		}
+	}
+
+	if config.Schedule != nil {
+		if _, err := buildDaemonJobs(config.Schedule, nil); err != nil {
+			addError("schedule is invalid: %s", err)
+		}
+	}
+
+	if config.Logging != nil && config.Logging.Format != "" && config.Logging.Format != pkg.LogFormatText && config.Logging.Format != pkg.LogFormatJSON {
+		addError("logging.format should be %s or %s, not %s", pkg.LogFormatText, pkg.LogFormatJSON, config.Logging.Format)
+	}
+
+	if config.Heartbeat != nil {
+		heartbeats := map[string]*pkg.HeartbeatConfig{"perform": config.Heartbeat.Perform, "prune": config.Heartbeat.Prune}
+		for _, name := range []string{"perform", "prune"} {
+			heartbeat := heartbeats[name]
+			if heartbeat != nil && heartbeat.Style != "" && heartbeat.Style != pkg.HeartbeatStyleHealthchecks && heartbeat.Style != pkg.HeartbeatStyleCronitor {
+				addError("heartbeat.%s.style should be %s or %s, not %s", name, pkg.HeartbeatStyleHealthchecks, pkg.HeartbeatStyleCronitor, heartbeat.Style)
+			}
+		}
+	}
+
+	if config.Download != nil {
+		if config.Download.Connections < 0 {
+			addError("download.connections should be a positive number")
+		}
+		if config.Download.PartSizeInMegaBytes < 0 {
+			addError("download.part_size_in_megabytes should be a positive number of megabytes")
+		}
+	}
+
+	if config.Commands != nil {
+		programs := make([]string, 0, len(config.Commands.TimeoutSeconds))
+		for program := range config.Commands.TimeoutSeconds {
+			programs = append(programs, program)
+		}
+		sort.Strings(programs)
+
+		for _, program := range programs {
+			if config.Commands.TimeoutSeconds[program] <= 0 {
+				addError("commands.timeout_seconds.%s should be a positive number of seconds", program)
+			}
+		}
+	}
+
+	if len(config.Alerting.Registry().Alerters()) == 0 {
+		addWarning("alerting has no providers. Failures are only logged")
+	}
+
+	return problems
+}
+
+// checkConfigJobs checks that the jobs of config don't share their backups or persistent storage
+func checkConfigJobs(config ConfigStruct) []configProblem {
+	problems := make([]configProblem, 0)
+
+	prefixes := make(map[string]string)
+	persistentStorages := make(map[string]string)
+	for _, name := range config.jobNames() {
+		jobConfig, _ := config.forJob(name)
+
+		if config.Jobs[name].Mysql.DataPath == "" {
+			problems = append(problems, configProblem{Severity: configSeverityWarning, Message: fmt.Sprintf("jobs.%s.mysql.data_path is not set. The job backs up %s", name, jobConfig.Mysql.DataPath)})
+		}
+
+		if other, exists := prefixes[jobConfig.StoragePrefix]; exists {
+			problems = append(problems, configProblem{Severity: configSeverityError, Message: fmt.Sprintf("jobs.%s and jobs.%s both store their backups under %s", other, name, jobConfig.StoragePrefix)})
+		}
+		prefixes[jobConfig.StoragePrefix] = name
+
+		if other, exists := persistentStorages[jobConfig.PersistentStorage]; exists {
+			problems = append(problems, configProblem{Severity: configSeverityError, Message: fmt.Sprintf("jobs.%s and jobs.%s both keep their checkpoints in %s", other, name, jobConfig.PersistentStorage)})
+		}
+		persistentStorages[jobConfig.PersistentStorage] = name
+	}
+
+	if config.Heartbeat != nil && len(config.Jobs) > 1 {
+		problems = append(problems, configProblem{Severity: configSeverityWarning, Message: "heartbeat is not used by the jobs, since they would share it. Set jobs.<name>.heartbeat for every job instead"})
+	}
+
+	return problems
+}
+
+// checkConfigCredentials checks that the bucket can be listed and that the DigitalOcean API accepts the key
+func checkConfigCredentials(config ConfigStruct) []configProblem {
+	problems := make([]configProblem, 0)
+
+	minioClient, err := minio.New(config.DigitalOcean.SpaceEndpoint, config.DigitalOcean.SpaceKey, config.DigitalOcean.SpaceSecret, true)
+	if err != nil {
+		problems = append(problems, configProblem{Severity: configSeverityError, Message: "Could not construct minio client: " + err.Error()})
+	} else if err = checkBucketAccess(config.DigitalOcean.SpaceName, minioClient); err != nil {
+		problems = append(problems, configProblem{Severity: configSeverityError, Message: fmt.Sprintf("Could not list bucket %s at %s: %s", config.DigitalOcean.SpaceName, config.DigitalOcean.SpaceEndpoint, err)})
+	}
+
+	if config.DigitalOcean.Key != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), configCredentialsTimeout)
+		defer cancel()
+
+		digitalOceanClient := pkg.NewDigitalOceanClient(config.DigitalOcean.Key)
+		if _, _, err = digitalOceanClient.Client.Account.Get(ctx); err != nil {
+			problems = append(problems, configProblem{Severity: configSeverityError, Message: "DigitalOcean API rejected digitalocean.key: " + err.Error()})
+		}
+	}
+
+	return problems
+}
+
+func checkBucketAccess(bucket string, minioClient *minio.Client) error {
+	exists, err := minioClient.BucketExists(bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("Bucket does not exist")
+	}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	for item := range minioClient.ListObjectsV2(bucket, "", false, doneCh) {
+		return item.Err
+	}
+	return nil
+}
+
+func countConfigProblems(problems []configProblem, severity string) int {
+	count := 0
+	for _, problem := range problems {
+		if problem.Severity == severity {
+			count++
+		}
+	}
+	return count
+}
+
+// backupConfigShow prints the effective config, after merging the legacy properties, environment variables, flags and defaults, with the secrets redacted
+func backupConfigShow(flags *configFlags, output io.Writer) error {
+	config, err := flags.loadUnresolved()
+	if err != nil {
+		return &configError{Err: err}
+	}
+
+	config, err = redactConfig(config)
+	if err != nil {
+		return err
+	}
+
+	configJSON, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(output, "%s\n", configJSON)
+	return nil
+}
+
+// redactConfig returns a copy of config with the legacy properties, which are merged already, removed and the secrets redacted
+func redactConfig(config ConfigStruct) (ConfigStruct, error) {
+	config.LegacyDOKey = ""
+	config.LegacyDOSpaceEndpoint = ""
+	config.LegacyDOSpaceName = ""
+	config.LegacyDOSpaceKey = ""
+	config.LegacyDOSpaceSecret = ""
+	config.LegacyMysqlDataPath = ""
+
+	// Round trip through JSON so the pointers to nested configs aren't shared with config
+	var redacted ConfigStruct
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return redacted, err
+	}
+	if err = json.Unmarshal(configJSON, &redacted); err != nil {
+		return redacted, err
+	}
+
+	err = visitSecrets(reflect.ValueOf(&redacted).Elem(), "", func(path string, secret string) (string, error) {
+		return redactSecretString(secret), nil
+	})
+	return redacted, err
+}
+
+// redactSecretString keeps the host of URLs so it's still clear where they point. References to secrets are kept as is
+func redactSecretString(secret string) string {
+	if pkg.IsSecretRef(secret) {
+		return secret
+	}
+
+	parsedURL, err := url.Parse(secret)
+	if err == nil && parsedURL.Scheme != "" && parsedURL.Host != "" {
+		return parsedURL.Scheme + "://" + parsedURL.Host + "/" + redactedSecret
+	}
+	return redactedSecret
+}
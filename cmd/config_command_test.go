@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	"github.com/feederco/really-simple-db-backup/pkg/alerting"
+)
+
+const exampleConfigWithTypos = `
+{
+	"digitalocean": {
+	  "space_endpoint": "fra1.digitaloceanspaces.com",
+	  "space_name": "backups",
+	  "space_key": "key",
+	  "space_secret": "secret"
+	},
+	"retention": {
+	  "automatically_remove_old": true,
+	  "retention_days": 7,
+	  "hours_between_full_backups": 24
+	},
+	"alerting": {
+	  "webhooks": [{"url": "https://example.com/hook", "methd": "PUT"}]
+	},
+	"lock": {
+	  "bucket_lease": "yes"
+	},
+	"schedule": {
+	  "full": "every night"
+	}
+}
+`
+
+func TestFindUnknownConfigKeys(t *testing.T) {
+	var contents interface{}
+	contents = map[string]interface{}{
+		"do_key":    "legacy keys are known",
+		"retention": map[string]interface{}{"retention_days": 7.0},
+		"alerting": map[string]interface{}{
+			"webhooks": []interface{}{map[string]interface{}{"URL": "matched case insensitively", "methd": "PUT"}},
+		},
+		"fleet": map[string]interface{}{
+			"expected_interval_hours": 24.0,
+			"hosts":                   map[string]interface{}{"db1": map[string]interface{}{"expected_interval_hour": 12.0}},
+		},
+		"completely_unrelated": true,
+	}
+
+	unknownKeys := findUnknownConfigKeys(contents)
+
+	expected := []string{
+		"Unknown key alerting.webhooks[0].methd (did you mean alerting.webhooks[0].method?)",
+		"Unknown key completely_unrelated",
+		"Unknown key fleet.hosts.db1.expected_interval_hour (did you mean fleet.hosts.db1.expected_interval_hours?)",
+		"Unknown key retention.retention_days (did you mean retention.retention_in_days?)",
+	}
+
+	if len(unknownKeys) != len(expected) {
+		t.Fatalf("Expected %d unknown keys, got %v", len(expected), unknownKeys)
+	}
+
+	for index, unknownKey := range unknownKeys {
+		if unknownKey.String() != expected[index] {
+			t.Errorf("Expected %q, got %q", expected[index], unknownKey.String())
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	pkg.Log = log.New(ioutil.Discard, "", 0)
+	pkg.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	directory, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	configPath := path.Join(directory, "config.json")
+	ioutil.WriteFile(configPath, []byte(exampleConfigWithTypos), 0600)
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	configFlags := addConfigFlags(flags)
+	flags.Parse([]string{"-config", configPath})
+
+	var output bytes.Buffer
+	err = backupConfigValidate(configFlags, true, &output)
+	if exitCode(err) != exitConfig {
+		t.Fatalf("Expected a config error, got %v", err)
+	}
+
+	for _, expected := range []string{
+		"error:   Unknown key retention.retention_days (did you mean retention.retention_in_days?)",
+		"error:   Unknown key alerting.webhooks[0].methd (did you mean alerting.webhooks[0].method?)",
+		"error:   lock.bucket_lease should be of type bool, not string",
+		"error:   schedule is invalid: Invalid",
+		"warning: retention.automatically_remove_old is set without",
+		"warning: digitalocean.key is not set",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Expected output to contain %q:\n%s", expected, output.String())
+		}
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	config := ConfigStruct{
+		DigitalOcean: DigitalOceanConfigStruct{Key: "key", SpaceEndpoint: "endpoint", SpaceName: "name", SpaceKey: "key", SpaceSecret: "secret"},
+		Retention:    &RetentionConfig{RetentionInDays: 7, HoursBetweenFullBackups: 24},
+		Alerting:     &pkg.AlertingConfig{Slack: &alerting.SlackConfig{WebhookURL: "https://hooks.slack.com/services/abc"}},
+	}
+
+	if problems := checkConfig(config); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+
+	config.DigitalOcean.SpaceSecret = ""
+	config.Retention = &RetentionConfig{RetentionInDays: -1, RetentionInHours: 12}
+	config.Logging = &LoggingConfig{Format: "xml"}
+	config.Heartbeat = &HeartbeatsConfig{Prune: &pkg.HeartbeatConfig{URL: "https://hc-ping.com/abc", Style: "deadmanssnitch"}}
+
+	problems := checkConfig(config)
+
+	expected := []configProblem{
+		{configSeverityError, "digitalocean.space_secret is required. Set it in the config file or with -do-space-secret"},
+		{configSeverityError, "retention.retention_in_days should not be negative"},
+		{configSeverityWarning, "retention.hours_between_full_backups is not set. Every `perform` will take a full backup"},
+		{configSeverityError, "logging.format should be text or json, not xml"},
+		{configSeverityError, "heartbeat.prune.style should be healthchecks or cronitor, not deadmanssnitch"},
+	}
+
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %v", len(expected), problems)
+	}
+
+	for index, problem := range problems {
+		if problem != expected[index] {
+			t.Errorf("Expected %v, got %v", expected[index], problem)
+		}
+	}
+}
+
+func TestCheckConfigJobs(t *testing.T) {
+	config := ConfigStruct{
+		StoragePrefix:     "db1",
+		PersistentStorage: "/var/lib/backup-mysql",
+		Heartbeat:         &HeartbeatsConfig{Perform: &pkg.HeartbeatConfig{URL: "https://hc-ping.com/abc"}},
+		Jobs: map[string]JobConfig{
+			"analytics": {Mysql: MysqlConfigStruct{DataPath: "/var/lib/mysql-analytics"}, StoragePrefix: "db1-main"},
+			"main":      {PersistentStorage: "/var/lib/backup-mysql/analytics"},
+		},
+	}
+
+	problems := checkConfigJobs(config)
+
+	expected := []configProblem{
+		{configSeverityWarning, "jobs.main.mysql.data_path is not set. The job backs up "},
+		{configSeverityError, "jobs.analytics and jobs.main both store their backups under db1-main"},
+		{configSeverityError, "jobs.analytics and jobs.main both keep their checkpoints in /var/lib/backup-mysql/analytics"},
+		{configSeverityWarning, "heartbeat is not used by the jobs, since they would share it. Set jobs.<name>.heartbeat for every job instead"},
+	}
+
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %v", len(expected), problems)
+	}
+
+	for index, problem := range problems {
+		if problem != expected[index] {
+			t.Errorf("Expected %v, got %v", expected[index], problem)
+		}
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	config := ConfigStruct{
+		LegacyDOSpaceSecret: "legacy",
+		DigitalOcean:        DigitalOceanConfigStruct{Key: "dop_v1_abc", SpaceKey: "key", SpaceSecret: "secret"},
+		API:                 &APIConfig{ListenAddress: ":8080", Token: "token"},
+		Alerting: &pkg.AlertingConfig{
+			Slack:    &alerting.SlackConfig{WebhookURL: "https://hooks.slack.com/services/abc", Channel: "#backups"},
+			Webhooks: []*alerting.WebhookConfig{{URL: "https://example.com/hook?token=abc", Headers: map[string]string{"Authorization": "Bearer abc"}}},
+		},
+		Jobs: map[string]JobConfig{
+			"main": {Heartbeat: &HeartbeatsConfig{Perform: &pkg.HeartbeatConfig{URL: "https://hc-ping.com/abc"}}},
+		},
+	}
+
+	redacted, err := redactConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if redacted.LegacyDOSpaceSecret != "" || redacted.DigitalOcean.Key != redactedSecret || redacted.DigitalOcean.SpaceSecret != redactedSecret || redacted.API.Token != redactedSecret {
+		t.Errorf("Expected secrets to be redacted: %+v", redacted)
+	}
+
+	if redacted.DigitalOcean.SpaceKey != "key" || redacted.API.ListenAddress != ":8080" || redacted.Alerting.Slack.Channel != "#backups" {
+		t.Errorf("Expected other values to be kept: %+v", redacted)
+	}
+
+	if redacted.Alerting.Slack.WebhookURL != "https://hooks.slack.com/REDACTED" || redacted.Alerting.Webhooks[0].URL != "https://example.com/REDACTED" || redacted.Alerting.Webhooks[0].Headers["Authorization"] != redactedSecret {
+		t.Errorf("Expected URLs to keep their host: %+v %+v", redacted.Alerting.Slack, redacted.Alerting.Webhooks[0])
+	}
+
+	if redacted.Jobs["main"].Heartbeat.Perform.URL != "https://hc-ping.com/REDACTED" {
+		t.Errorf("Expected the heartbeat of a job to be redacted: %+v", redacted.Jobs["main"].Heartbeat.Perform)
+	}
+
+	if config.DigitalOcean.Key != "dop_v1_abc" || config.Alerting.Slack.WebhookURL != "https://hooks.slack.com/services/abc" || config.Alerting.Webhooks[0].Headers["Authorization"] != "Bearer abc" || config.Jobs["main"].Heartbeat.Perform.URL != "https://hc-ping.com/abc" {
+		t.Error("Expected the original config to be left alone")
+	}
+}
+
+func TestPublishedConfigSchemaIsCurrent(t *testing.T) {
+	published, err := ioutil.ReadFile("../config.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generated, err := configSchemaJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(published, generated) {
+		t.Error("config.schema.json is out of date. Regenerate it with `really-simple-db-backup config schema > config.schema.json`")
+	}
+}
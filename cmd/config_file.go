@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const configIncludeKey = "include"
+
+// readConfigFile returns the contents of the config file at configPath as decoded JSON, whatever its format, with its includes merged in
+func readConfigFile(configPath string) (map[string]interface{}, error) {
+	return readConfigFileWithIncludes(configPath, nil)
+}
+
+// readConfigFileWithIncludes reads configPath on top of the files it includes. including is the chain of files that led to configPath
+func readConfigFileWithIncludes(configPath string, including []string) (map[string]interface{}, error) {
+	absolutePath, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, includingPath := range including {
+		if includingPath == absolutePath {
+			return nil, fmt.Errorf("Config file %s includes itself through %s", configPath, strings.Join(append(including, absolutePath), " -> "))
+		}
+	}
+
+	contents, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := decodeConfigFile(configPath, contents)
+	if err != nil {
+		return nil, err
+	}
+
+	includes, err := configIncludes(config)
+	if err != nil {
+		return nil, fmt.Errorf("%s in %s", err, configPath)
+	}
+	delete(config, configIncludeKey)
+
+	merged := make(map[string]interface{})
+	for _, include := range includes {
+		// Relative includes are relative to the file including them
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(configPath), include)
+		}
+
+		included, err := readConfigFileWithIncludes(include, append(including, absolutePath))
+		if err != nil {
+			return nil, fmt.Errorf("Could not include %s from %s: %s", include, configPath, err)
+		}
+
+		merged = mergeConfigValues(merged, included)
+	}
+
+	return mergeConfigValues(merged, config), nil
+}
+
+// decodeConfigValues decodes the contents of a config file into a ConfigStruct
+func decodeConfigValues(contents map[string]interface{}) (ConfigStruct, error) {
+	var config ConfigStruct
+
+	// The json tags of ConfigStruct are the keys of every format
+	configJSON, err := json.Marshal(contents)
+	if err != nil {
+		return config, err
+	}
+
+	err = json.Unmarshal(configJSON, &config)
+	return config, err
+}
+
+// decodeConfigFile decodes contents by the extension of configPath: .yaml, .yml, .toml or JSON for anything else
+func decodeConfigFile(configPath string, contents []byte) (map[string]interface{}, error) {
+	config := make(map[string]interface{})
+
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".yaml", ".yml":
+		var decoded interface{}
+		if err := yaml.Unmarshal(contents, &decoded); err != nil {
+			return nil, fmt.Errorf("YAML decode of %s failed: %s", configPath, err)
+		}
+
+		// An empty file decodes to nil
+		if decoded == nil {
+			return config, nil
+		}
+
+		object, isObject := normalizeYAMLValue(decoded).(map[string]interface{})
+		if !isObject {
+			return nil, fmt.Errorf("YAML decode of %s failed: expected a mapping at the top level", configPath)
+		}
+		return object, nil
+	case ".toml":
+		if _, err := toml.Decode(string(contents), &config); err != nil {
+			return nil, fmt.Errorf("TOML decode of %s failed: %s", configPath, err)
+		}
+		return config, nil
+	}
+
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return nil, fmt.Errorf("JSON decode of %s failed: %s", configPath, err)
+	}
+	return config, nil
+}
+
+// normalizeYAMLValue turns the map[interface{}]interface{} of YAML mappings into map[string]interface{} like decoded JSON
+func normalizeYAMLValue(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(typedValue))
+		for key, item := range typedValue {
+			object[fmt.Sprint(key)] = normalizeYAMLValue(item)
+		}
+		return object
+	case []interface{}:
+		items := make([]interface{}, len(typedValue))
+		for index, item := range typedValue {
+			items[index] = normalizeYAMLValue(item)
+		}
+		return items
+	}
+
+	return value
+}
+
+func configIncludes(config map[string]interface{}) ([]string, error) {
+	value, exists := config[configIncludeKey]
+	if !exists {
+		return nil, nil
+	}
+
+	switch typedValue := value.(type) {
+	case string:
+		return []string{typedValue}, nil
+	case []interface{}:
+		includes := make([]string, len(typedValue))
+		for index, item := range typedValue {
+			include, isString := item.(string)
+			if !isString {
+				return nil, fmt.Errorf("%s should be a list of file paths", configIncludeKey)
+			}
+			includes[index] = include
+		}
+		return includes, nil
+	}
+
+	return nil, fmt.Errorf("%s should be a list of file paths", configIncludeKey)
+}
+
+// mergeConfigValues layers override on top of base. Objects are merged key by key, anything else in override replaces what is in base
+func mergeConfigValues(base map[string]interface{}, override map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+
+	for key, value := range override {
+		baseObject, baseIsObject := merged[key].(map[string]interface{})
+		overrideObject, overrideIsObject := value.(map[string]interface{})
+		if baseIsObject && overrideIsObject {
+			merged[key] = mergeConfigValues(baseObject, overrideObject)
+		} else {
+			merged[key] = value
+		}
+	}
+
+	return merged
+}
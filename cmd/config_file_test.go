@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const exampleBaseYAMLContents = `
+do_space_endpoint: ams3.digitaloceanspaces.com
+do_space_name: fleet-backups
+digitalocean:
+  key: base.key
+retention:
+  automatically_remove_old: true
+  retention_in_days: 30
+  hours_between_full_backups: 24
+`
+
+const exampleHostTOMLContents = `
+include = ["base.yaml"]
+persistent_storage = "/var/lib/host-backups"
+
+[digitalocean]
+key = "host.key"
+
+[retention]
+retention_in_days = 7
+`
+
+func writeConfigTestFiles(t *testing.T, files map[string]string) string {
+	directory, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, contents := range files {
+		if err := ioutil.WriteFile(path.Join(directory, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return directory
+}
+
+func TestLoadConfigWithIncludes(t *testing.T) {
+	setupTest()
+
+	directory := writeConfigTestFiles(t, map[string]string{
+		"base.yaml": exampleBaseYAMLContents,
+		"host.toml": exampleHostTOMLContents,
+	})
+	defer os.RemoveAll(directory)
+
+	configStruct := loadConfigFromArgs(t, []string{"-config", path.Join(directory, "host.toml")})
+
+	if configStruct.DigitalOcean.Key != "host.key" {
+		t.Errorf("Expected the host file to override the base file, got key %s", configStruct.DigitalOcean.Key)
+	}
+	if configStruct.DigitalOcean.SpaceName != "fleet-backups" || configStruct.DigitalOcean.SpaceEndpoint != "ams3.digitaloceanspaces.com" {
+		t.Errorf("Expected the legacy keys of the base file to be mapped, got %+v", configStruct.DigitalOcean)
+	}
+	if configStruct.PersistentStorage != "/var/lib/host-backups" {
+		t.Errorf("Incorrect PersistentStorage found: %s", configStruct.PersistentStorage)
+	}
+
+	retention := configStruct.Retention
+	if retention == nil || retention.RetentionInDays != 7 || !retention.AutomaticallyRemoveOld || retention.HoursBetweenFullBackups != 24 {
+		t.Errorf("Expected retention to be merged key by key, got %+v", retention)
+	}
+}
+
+func TestDecodeConfigFileFormats(t *testing.T) {
+	tests := map[string]string{
+		"config.json": `{"mysql": {"data_path": "/data"}}`,
+		"config.yaml": "mysql:\n  data_path: /data\n",
+		"config.YML":  "mysql:\n  data_path: /data\n",
+		"config.toml": "[mysql]\ndata_path = \"/data\"\n",
+	}
+
+	for name, contents := range tests {
+		decoded, err := decodeConfigFile(name, []byte(contents))
+		if err != nil {
+			t.Errorf("Could not decode %s: %s", name, err)
+			continue
+		}
+
+		config, err := decodeConfigValues(decoded)
+		if err != nil || config.Mysql.DataPath != "/data" {
+			t.Errorf("Expected %s to set mysql.data_path, got %+v (%v)", name, config.Mysql, err)
+		}
+	}
+
+	if _, err := decodeConfigFile("config.yaml", []byte("- a list")); err == nil {
+		t.Error("Expected a YAML file that isn't a mapping to fail")
+	}
+	if _, err := decodeConfigFile("config.toml", []byte("{}")); err == nil || !strings.Contains(err.Error(), "TOML") {
+		t.Errorf("Expected invalid TOML to fail, got %v", err)
+	}
+}
+
+func TestConfigIncludeCycle(t *testing.T) {
+	directory := writeConfigTestFiles(t, map[string]string{
+		"a.json": `{"include": "b.yaml"}`,
+		"b.yaml": "include: [a.json]\n",
+	})
+	defer os.RemoveAll(directory)
+
+	_, err := readConfigFile(path.Join(directory, "a.json"))
+	if err == nil || !strings.Contains(err.Error(), "includes itself") {
+		t.Errorf("Expected an include cycle to fail, got %v", err)
+	}
+
+	_, err = readConfigFile(path.Join(directory, "missing.json"))
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected a missing config file to be reported as not existing, got %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configField is a key of a config object and the struct field it decodes into
+type configField struct {
+	Name   string
+	Field  reflect.StructField
+	Secret bool
+}
+
+// configFields returns the JSON keys of structType, with the fields of embedded structs promoted like encoding/json does
+func configFields(structType reflect.Type) []configField {
+	fields := make([]configField, 0)
+
+	for index := 0; index < structType.NumField(); index++ {
+		field := structType.Field(index)
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, configFields(field.Type)...)
+			continue
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		fields = append(fields, configField{Name: name, Field: field, Secret: field.Tag.Get("secret") == "true"})
+	}
+
+	return fields
+}
+
+func findConfigField(fields []configField, name string) (configField, bool) {
+	for _, field := range fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+
+	// encoding/json falls back to a case insensitive match
+	for _, field := range fields {
+		if strings.EqualFold(field.Name, name) {
+			return field, true
+		}
+	}
+
+	return configField{}, false
+}
+
+// configSchema returns the JSON Schema of the config file
+func configSchema() map[string]interface{} {
+	schema := configTypeSchema(reflect.TypeOf(ConfigStruct{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "really-simple-db-backup config"
+	return schema
+}
+
+func configTypeSchema(valueType reflect.Type) map[string]interface{} {
+	for valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+
+	switch valueType.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": configTypeSchema(valueType.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": configTypeSchema(valueType.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for _, field := range configFields(valueType) {
+			property := configTypeSchema(field.Field.Type)
+			if field.Secret {
+				property["writeOnly"] = true
+			}
+			properties[field.Name] = property
+		}
+
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	}
+
+	return map[string]interface{}{}
+}
+
+// configSchemaJSON returns the schema as it is published in config.schema.json
+func configSchemaJSON() ([]byte, error) {
+	schemaJSON, err := json.MarshalIndent(configSchema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(schemaJSON, '\n'), nil
+}
+
+// unknownConfigKey is a key in the config file that no config field decodes
+type unknownConfigKey struct {
+	Path       string
+	Suggestion string
+}
+
+func (unknownKey unknownConfigKey) String() string {
+	if unknownKey.Suggestion == "" {
+		return "Unknown key " + unknownKey.Path
+	}
+
+	parent := unknownKey.Path[:strings.LastIndex(unknownKey.Path, ".")+1]
+	return "Unknown key " + unknownKey.Path + " (did you mean " + parent + unknownKey.Suggestion + "?)"
+}
+
+// findUnknownConfigKeys returns the keys of the decoded config file contents that would be ignored
+func findUnknownConfigKeys(contents interface{}) []unknownConfigKey {
+	unknownKeys := make([]unknownConfigKey, 0)
+	collectUnknownConfigKeys(contents, reflect.TypeOf(ConfigStruct{}), "", &unknownKeys)
+	return unknownKeys
+}
+
+func collectUnknownConfigKeys(value interface{}, valueType reflect.Type, path string, unknownKeys *[]unknownConfigKey) {
+	for valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+
+	switch valueType.Kind() {
+	case reflect.Struct:
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return
+		}
+
+		fields := configFields(valueType)
+		for _, key := range sortedKeys(object) {
+			field, found := findConfigField(fields, key)
+			if !found {
+				*unknownKeys = append(*unknownKeys, unknownConfigKey{
+					Path:       joinConfigPath(path, key),
+					Suggestion: suggestConfigKey(key, fields),
+				})
+				continue
+			}
+
+			collectUnknownConfigKeys(object[key], field.Field.Type, joinConfigPath(path, field.Name), unknownKeys)
+		}
+	case reflect.Map:
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return
+		}
+
+		for _, key := range sortedKeys(object) {
+			collectUnknownConfigKeys(object[key], valueType.Elem(), joinConfigPath(path, key), unknownKeys)
+		}
+	case reflect.Slice, reflect.Array:
+		items, isArray := value.([]interface{})
+		if !isArray {
+			return
+		}
+
+		for index, item := range items {
+			collectUnknownConfigKeys(item, valueType.Elem(), path+"["+strconv.Itoa(index)+"]", unknownKeys)
+		}
+	}
+}
+
+// suggestConfigKey returns the known key closest to key, if any is close enough to be a typo
+func suggestConfigKey(key string, fields []configField) string {
+	suggestion := ""
+	bestDistance := len(key)/3 + 2
+
+	for _, field := range fields {
+		distance := editDistance(strings.ToLower(key), field.Name)
+		if distance < bestDistance {
+			suggestion = field.Name
+			bestDistance = distance
+		}
+	}
+
+	return suggestion
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for index := range previous {
+		previous[index] = index
+	}
+
+	for indexA := 1; indexA <= len(a); indexA++ {
+		current[0] = indexA
+		for indexB := 1; indexB <= len(b); indexB++ {
+			cost := 1
+			if a[indexA-1] == b[indexB-1] {
+				cost = 0
+			}
+
+			current[indexB] = minInt(minInt(previous[indexB]+1, current[indexB-1]+1), previous[indexB-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func joinConfigPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/feederco/really-simple-db-backup/pkg"
@@ -39,15 +40,29 @@ const exampleLegacyJSONContents = `
 `
 
 func setupTest() {
-	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	pkg.Log = log.New(os.Stdout, "", log.LstdFlags)
 	pkg.ErrorLog = log.New(os.Stderr, "", log.LstdFlags)
 }
 
+func loadConfigFromArgs(t *testing.T, args []string) ConfigStruct {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	configFlags := addConfigFlags(flags)
+
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := configFlags.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
 func TestLoadConfigFromCommandLine(t *testing.T) {
 	setupTest()
 
-	configStruct := loadConfig([]string{
+	configStruct := loadConfigFromArgs(t, []string{
 		"-do-key",
 		"do_key",
 		"-do-space-endpoint",
@@ -93,7 +108,7 @@ func TestLoadConfigFromConfigFile(t *testing.T) {
 	ioutil.WriteFile("_test_file.json", []byte(exampleJSONContents), 0755)
 	defer os.Remove("_test_file.json")
 
-	configStruct := loadConfig([]string{
+	configStruct := loadConfigFromArgs(t, []string{
 		"-config",
 		"_test_file.json",
 	})
@@ -127,7 +142,7 @@ func TestLoadLegacyConfigFromConfigFile(t *testing.T) {
 	ioutil.WriteFile("_test_file.json", []byte(exampleLegacyJSONContents), 0755)
 	defer os.Remove("_test_file.json")
 
-	configStruct := loadConfig([]string{
+	configStruct := loadConfigFromArgs(t, []string{
 		"-config",
 		"_test_file.json",
 	})
@@ -161,7 +176,7 @@ func TestLoadConfigOverrideFromCommandLine(t *testing.T) {
 	ioutil.WriteFile("_test_file.json", []byte(exampleLegacyJSONContents), 0755)
 	defer os.Remove("_test_file.json")
 
-	configStruct := loadConfig([]string{
+	configStruct := loadConfigFromArgs(t, []string{
 		"-config",
 		"_test_file.json",
 
@@ -203,3 +218,139 @@ func TestLoadConfigOverrideFromCommandLine(t *testing.T) {
 		t.Errorf("Incorrect PersistentStorage found: %s", configStruct.PersistentStorage)
 	}
 }
+
+func TestLoadConfigPrecedenceAndSecretReferences(t *testing.T) {
+	setupTest()
+
+	ioutil.WriteFile("_test_file.json", []byte(`{
+		"digitalocean": {
+		  "space_name": "file_space_name",
+		  "space_key": "file_space_key",
+		  "space_secret": "env:RSDB_TEST_SPACE_SECRET"
+		},
+		"alerting": {
+		  "slack": {"webhook_url": "file:_test_webhook_url"},
+		  "webhooks": [{"headers": {"Authorization": "env:RSDB_TEST_SPACE_SECRET"}}]
+		},
+		"jobs": {
+		  "main": {"heartbeat": {"perform": {"url": "file:_test_webhook_url"}}}
+		}
+	}`), 0600)
+	defer os.Remove("_test_file.json")
+
+	ioutil.WriteFile("_test_webhook_url", []byte("https://hooks.slack.com/services/abc\n"), 0600)
+	defer os.Remove("_test_webhook_url")
+
+	environment := map[string]string{
+		"RSDB_CONFIG":            "_test_file.json",
+		"RSDB_DO_SPACE_NAME":     "env_space_name",
+		"RSDB_DO_SPACE_KEY":      "env_space_key",
+		"RSDB_TEST_SPACE_SECRET": "resolved_secret",
+	}
+	for name, value := range environment {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	configStruct := loadConfigFromArgs(t, []string{
+		"-do-space-key",
+		"flag_space_key",
+	})
+
+	if configStruct.DigitalOcean.SpaceName != "env_space_name" {
+		t.Errorf("Expected environment variable to override the config file: %s", configStruct.DigitalOcean.SpaceName)
+	}
+	if configStruct.DigitalOcean.SpaceKey != "flag_space_key" {
+		t.Errorf("Expected flag to override the environment variable: %s", configStruct.DigitalOcean.SpaceKey)
+	}
+	if configStruct.DigitalOcean.SpaceSecret != "resolved_secret" || configStruct.Alerting.Webhooks[0].Headers["Authorization"] != "resolved_secret" {
+		t.Errorf("Expected env: references to be resolved: %+v", configStruct.DigitalOcean)
+	}
+	if configStruct.Alerting.Slack.WebhookURL != "https://hooks.slack.com/services/abc" {
+		t.Errorf("Expected file: reference to be resolved: %s", configStruct.Alerting.Slack.WebhookURL)
+	}
+
+	// Jobs are applied to the resolved config when a command runs for every job
+	mainConfig, err := configStruct.forJob("main")
+	if err != nil || mainConfig.Heartbeat.Perform.URL != "https://hooks.slack.com/services/abc" {
+		t.Errorf("Expected the heartbeat of the job to be resolved: %+v (%v)", mainConfig.Heartbeat, err)
+	}
+
+	os.Unsetenv("RSDB_TEST_SPACE_SECRET")
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	configFlags := addConfigFlags(flags)
+	if _, err := configFlags.load(); err == nil || !strings.Contains(err.Error(), "digitalocean.space_secret") {
+		t.Errorf("Expected an error naming the unresolved secret, got %v", err)
+	}
+}
+
+const exampleJobsJSONContents = `
+{
+  "storage_prefix": "db1",
+  "persistent_storage": "/var/lib/backup-mysql",
+  "retention": {"retention_in_days": 7},
+  "jobs": {
+    "main": {
+      "mysql": {"data_path": "/var/lib/mysql", "socket": "/run/mysqld/main.sock"}
+    },
+    "analytics": {
+      "mysql": {"data_path": "/var/lib/mysql-analytics", "socket": "/run/mysqld/analytics.sock"},
+      "storage_prefix": "db1-stats",
+      "persistent_storage": "/var/lib/backup-analytics",
+      "retention": {"retention_in_days": 30}
+    }
+  }
+}
+`
+
+func TestLoadConfigForJob(t *testing.T) {
+	setupTest()
+
+	ioutil.WriteFile("_test_file.json", []byte(exampleJobsJSONContents), 0755)
+	defer os.Remove("_test_file.json")
+
+	base := loadConfigFromArgs(t, []string{"-config", "_test_file.json"})
+	if base.Job != "" || base.storagePrefix() != "db1" {
+		t.Errorf("Expected no job to be selected without -job, got %s with prefix %s", base.Job, base.storagePrefix())
+	}
+	if names := base.jobNames(); len(names) != 2 || names[0] != "analytics" || names[1] != "main" {
+		t.Errorf("Expected the jobs in alphabetical order, got %v", names)
+	}
+
+	main := loadConfigFromArgs(t, []string{"-config", "_test_file.json", "-job", "main"})
+	if main.Job != "main" || main.StoragePrefix != "db1-main" || main.PersistentStorage != "/var/lib/backup-mysql/main" {
+		t.Errorf("Expected the defaults of job main to derive from the config, got %s, %s", main.StoragePrefix, main.PersistentStorage)
+	}
+	if main.Mysql.Socket != "/run/mysqld/main.sock" || main.Retention.RetentionInDays != 7 {
+		t.Errorf("Expected job main to keep the retention of the config, got %+v", main.Retention)
+	}
+
+	analytics := loadConfigFromArgs(t, []string{"-config", "_test_file.json", "-job", "analytics", "-mysql-data-path", "/mnt/analytics"})
+	if analytics.StoragePrefix != "db1-stats" || analytics.PersistentStorage != "/var/lib/backup-analytics" || analytics.Retention.RetentionInDays != 30 {
+		t.Errorf("Expected the settings of job analytics, got %s, %s, %+v", analytics.StoragePrefix, analytics.PersistentStorage, analytics.Retention)
+	}
+	if analytics.Mysql.DataPath != "/mnt/analytics" {
+		t.Errorf("Expected the flag to override the job, got %s", analytics.Mysql.DataPath)
+	}
+
+	heartbeat := &HeartbeatsConfig{Perform: &pkg.HeartbeatConfig{URL: "https://hc-ping.com/main"}}
+	withHeartbeats := ConfigStruct{
+		Heartbeat: &HeartbeatsConfig{Perform: &pkg.HeartbeatConfig{URL: "https://hc-ping.com/shared"}},
+		Jobs:      map[string]JobConfig{"main": {Heartbeat: heartbeat}, "analytics": {}},
+	}
+	if main, _ := withHeartbeats.forJob("main"); main.Heartbeat != heartbeat {
+		t.Errorf("Expected job main to use its own heartbeat, got %+v", main.Heartbeat)
+	}
+	if analytics, _ := withHeartbeats.forJob("analytics"); analytics.Heartbeat != nil {
+		t.Errorf("Expected job analytics not to share the heartbeat of the config, got %+v", analytics.Heartbeat)
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	configFlags := addConfigFlags(flags)
+	flags.Parse([]string{"-config", "_test_file.json", "-job", "reporting"})
+
+	if _, err := configFlags.load(); err == nil || !strings.Contains(err.Error(), "analytics, main") {
+		t.Errorf("Expected an unknown job to fail listing the jobs, got %v", err)
+	}
+}
@@ -0,0 +1,404 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+const daemonJobFull = "full"
+const daemonJobIncremental = "incremental"
+const daemonJobPrune = "prune"
+const daemonJobVerify = "verify"
+const daemonJobBinlogArchive = "binlog-archive"
+const daemonJobGCVolumes = "gc-volumes"
+const daemonJobDigest = "digest"
+
+// Not schedulable. Decides between a full and an incremental backup, and can be requested over the API
+const daemonJobPerform = "perform"
+
+// The command each job corresponds to, used as the job label in metrics
+var daemonJobCommands = map[string]string{
+	daemonJobPerform:       "perform",
+	daemonJobFull:          "perform-full",
+	daemonJobIncremental:   "perform-incremental",
+	daemonJobPrune:         "prune",
+	daemonJobVerify:        "verify",
+	daemonJobBinlogArchive: "archive-binlogs",
+	daemonJobGCVolumes:     "gc-volumes",
+	daemonJobDigest:        "send-digest",
+}
+
+type daemonJob struct {
+	Name     string
+	Schedule *pkg.CronSchedule
+	Run      func(ctx context.Context) error
+	NextRun  time.Time
+}
+
+// daemonTrigger asks the daemon to start a job now. The daemon answers on Result
+type daemonTrigger struct {
+	Job    *daemonJob
+	Result chan daemonTriggerResult
+}
+
+type daemonTriggerResult struct {
+	RunID string
+	Err   error
+}
+
+var errDaemonBusy = errors.New("Another job is running")
+var errDaemonStopping = errors.New("The daemon is stopping")
+
+// backupDaemon runs scheduled jobs until the process receives SIGINT or SIGTERM
+func backupDaemon(scheduleConfig *ScheduleConfig, hostname string, digitalOceanClient *pkg.DigitalOceanClient, minioClient *minio.Client) error {
+	if scheduleConfig == nil {
+		return errors.New("No schedule config. Add a `schedule` section to the config to use the daemon")
+	}
+
+	performRunner := func(backupType string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			return withHostLock("daemon "+backupType, hostname, true, minioClient, func() error {
+				return backupMysqlPerform(
+					ctx,
+					backupType,
+					hostname,
+					configStruct.DigitalOcean.SpaceName,
+					configStruct.Mysql.DataPath,
+					"",
+					"",
+					configStruct.PersistentStorage,
+					digitalOceanClient,
+					minioClient,
+				)
+			})
+		}
+	}
+
+	runners := map[string]func(ctx context.Context) error{
+		daemonJobPerform:     performRunner(backupTypeDecide),
+		daemonJobFull:        performRunner(backupTypeFull),
+		daemonJobIncremental: performRunner(backupTypeIncremental),
+		daemonJobPrune: func(ctx context.Context) error {
+			if configStruct.Retention == nil {
+				return errors.New("No retention config. Nothing to prune")
+			}
+
+			var deletedBackups []backupItem
+			err := withHostLock("daemon prune", hostname, true, minioClient, func() error {
+				var pruneErr error
+				deletedBackups, pruneErr = pruneBackups(hostname, configStruct.DigitalOcean.SpaceName, configStruct.Retention, minioClient)
+				return pruneErr
+			})
+			if err != nil {
+				alertError(errorStage(err), "Scheduled prune failed.", err)
+				return err
+			}
+
+			pkg.Log.Printf("Pruned %d %s\n", len(deletedBackups), pluralize(len(deletedBackups), "backup", "backups"))
+			return nil
+		},
+		daemonJobVerify: func(ctx context.Context) error {
+			err := backupMysqlVerify(hostname, configStruct.DigitalOcean.SpaceName, minioClient)
+			if err != nil {
+				alertError(errorStage(err), "Verification of latest backup failed.", err)
+			}
+			return err
+		},
+		daemonJobBinlogArchive: func(ctx context.Context) error {
+			err := backupArchiveBinlogs(ctx, hostname, configStruct.DigitalOcean.SpaceName, minioClient)
+			if err != nil {
+				alertError(errorStage(err), "Scheduled binary log archiving failed.", err)
+			}
+			return err
+		},
+		daemonJobGCVolumes: func(ctx context.Context) error {
+			olderThanHours := 0
+			if configStruct.Volumes != nil {
+				olderThanHours = configStruct.Volumes.GCOlderThanHours
+			}
+
+			err := withStage(stageVolume, backupGCVolumes(olderThanHours, false, true, digitalOceanClient))
+			if err != nil {
+				alertError(errorStage(err), "Scheduled volume garbage collection failed.", err)
+			}
+			return err
+		},
+		daemonJobDigest: func(ctx context.Context) error {
+			err := backupSendDigest(hostname, configStruct.PersistentStorage)
+			if err != nil {
+				alertError(errorStage(err), "Could not send backup digest.", err)
+			}
+			return err
+		},
+	}
+
+	jobs, err := buildDaemonJobs(scheduleConfig, runners)
+	if err != nil {
+		return err
+	}
+
+	if len(jobs) == 0 {
+		return errors.New("No jobs scheduled. Set at least one of `schedule.full`, `schedule.incremental`, `schedule.prune`, `schedule.verify`, `schedule.binlog_archive`, `schedule.gc_volumes` or `schedule.digest`")
+	}
+
+	if listenAddress := metrics.ListenAddress(); listenAddress != "" {
+		serveMux := http.NewServeMux()
+		serveMux.Handle("/metrics", metrics)
+
+		server := &http.Server{Addr: listenAddress, Handler: serveMux}
+		go func() {
+			pkg.Log.Printf("Serving metrics on %s/metrics\n", listenAddress)
+			if serveErr := server.ListenAndServe(); serveErr != nil && serveErr != http.ErrServerClosed {
+				pkg.ErrorLog.Println("Warning: Could not serve metrics.", serveErr)
+			}
+		}()
+		defer server.Close()
+	}
+
+	var triggers chan daemonTrigger
+	if configStruct.API != nil && configStruct.API.ListenAddress != "" {
+		triggers = make(chan daemonTrigger)
+
+		api := &apiServer{
+			Token:       configStruct.API.Token,
+			Hostname:    hostname,
+			Runners:     runners,
+			Triggers:    triggers,
+			MinioClient: minioClient,
+		}
+
+		server := &http.Server{Addr: configStruct.API.ListenAddress, Handler: api.Handler()}
+		go func() {
+			pkg.Log.Printf("Serving the management API on %s\n", configStruct.API.ListenAddress)
+			if serveErr := server.ListenAndServe(); serveErr != nil && serveErr != http.ErrServerClosed {
+				pkg.ErrorLog.Println("Warning: Could not serve the management API.", serveErr)
+			}
+		}()
+		defer server.Close()
+	}
+
+	return runDaemon(jobs, hostname, triggers)
+}
+
+func buildDaemonJobs(scheduleConfig *ScheduleConfig, runners map[string]func(ctx context.Context) error) ([]*daemonJob, error) {
+	expressions := []struct {
+		name       string
+		expression string
+	}{
+		{daemonJobFull, scheduleConfig.Full},
+		{daemonJobIncremental, scheduleConfig.Incremental},
+		{daemonJobPrune, scheduleConfig.Prune},
+		{daemonJobVerify, scheduleConfig.Verify},
+		{daemonJobBinlogArchive, scheduleConfig.BinlogArchive},
+		{daemonJobGCVolumes, scheduleConfig.GCVolumes},
+		{daemonJobDigest, scheduleConfig.Digest},
+	}
+
+	jobs := make([]*daemonJob, 0)
+	for _, entry := range expressions {
+		if entry.expression == "" {
+			continue
+		}
+
+		schedule, err := pkg.ParseCronSchedule(entry.expression)
+		if err != nil {
+			return nil, err
+		}
+
+		// Like `0 0 30 2 *`. The job would be due all the time otherwise
+		if schedule.Next(time.Now()).IsZero() {
+			return nil, fmt.Errorf("Cron expression `%s` of `schedule.%s` never matches", entry.expression, entry.name)
+		}
+
+		jobs = append(jobs, &daemonJob{
+			Name:     entry.name,
+			Schedule: schedule,
+			Run:      runners[entry.name],
+		})
+	}
+
+	return jobs, nil
+}
+
+func scheduleDaemonJobs(jobs []*daemonJob, fromTime time.Time) time.Time {
+	var earliest time.Time
+	for _, job := range jobs {
+		if job.NextRun.IsZero() || !job.NextRun.After(fromTime) {
+			job.NextRun = job.Schedule.Next(fromTime)
+		}
+
+		if earliest.IsZero() || job.NextRun.Before(earliest) {
+			earliest = job.NextRun
+		}
+	}
+	return earliest
+}
+
+// dueDaemonJobs returns the jobs that should run at nowTime, in the order they were configured.
+// An incremental backup due at the same time as a full backup is skipped since the full backup supersedes it.
+func dueDaemonJobs(jobs []*daemonJob, nowTime time.Time) []*daemonJob {
+	due := make([]*daemonJob, 0)
+	fullIsDue := false
+
+	for _, job := range jobs {
+		if job.NextRun.After(nowTime) {
+			continue
+		}
+
+		if job.Name == daemonJobFull {
+			fullIsDue = true
+		}
+
+		if job.Name == daemonJobIncremental && fullIsDue {
+			pkg.Log.Println("Skipping incremental backup since a full backup is scheduled at the same time")
+			continue
+		}
+
+		due = append(due, job)
+	}
+
+	return due
+}
+
+func logNextDaemonRuns(jobs []*daemonJob) {
+	for _, job := range jobs {
+		pkg.Log.Printf("Next %s run: %s (%s)\n", job.Name, job.NextRun.Format(time.RFC3339), job.Schedule.Expression)
+	}
+}
+
+// runDaemon runs jobs on their schedule, and the jobs received on triggers as soon as nothing else runs
+func runDaemon(jobs []*daemonJob, hostname string, triggers <-chan daemonTrigger) error {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	pkg.Log.Println("Daemon started")
+
+	nextRun := scheduleDaemonJobs(jobs, time.Now())
+	logNextDaemonRuns(jobs)
+
+	// Cancelled on the second signal to abort the running job. Its volume is still cleaned up
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jobDone := make(chan bool)
+	running := ""
+	stopping := false
+
+	for {
+		if stopping && running == "" {
+			pkg.Log.Println("Daemon stopped")
+			return nil
+		}
+
+		var timer *time.Timer
+		var timerChannel <-chan time.Time
+		if !stopping {
+			timer = time.NewTimer(time.Until(nextRun))
+			timerChannel = timer.C
+		}
+
+		select {
+		case receivedSignal := <-signals:
+			if stopping {
+				if ctx.Err() == nil {
+					pkg.ErrorLog.Printf("Received %s again. Aborting `%s` and cleaning up.\n", receivedSignal, running)
+					cancel()
+				}
+				continue
+			}
+
+			stopping = true
+			if running != "" {
+				pkg.Log.Printf("Received %s. Waiting for `%s` to finish before exiting.\n", receivedSignal, running)
+			} else {
+				pkg.Log.Printf("Received %s. Exiting.\n", receivedSignal)
+			}
+		case <-jobDone:
+			running = ""
+		case <-timerChannel:
+			now := time.Now()
+			dueJobs := dueDaemonJobs(jobs, now)
+
+			if running != "" {
+				for _, job := range dueJobs {
+					pkg.ErrorLog.Printf("Skipping scheduled %s run since `%s` is still running\n", job.Name, running)
+				}
+			} else if len(dueJobs) > 0 {
+				names := make([]string, len(dueJobs))
+				for index, job := range dueJobs {
+					names[index] = job.Name
+				}
+				running = strings.Join(names, ", ")
+
+				go func() {
+					for _, job := range dueJobs {
+						if ctx.Err() != nil {
+							break
+						}
+						runDaemonJob(ctx, job, hostname, newRunID())
+					}
+					jobDone <- true
+				}()
+			}
+
+			nextRun = scheduleDaemonJobs(jobs, now)
+			logNextDaemonRuns(jobs)
+		case trigger := <-triggers:
+			if stopping {
+				trigger.Result <- daemonTriggerResult{Err: errDaemonStopping}
+				break
+			}
+
+			if running != "" {
+				trigger.Result <- daemonTriggerResult{Err: fmt.Errorf("%w: %s", errDaemonBusy, running)}
+				break
+			}
+
+			runID := newRunID()
+			running = trigger.Job.Name
+
+			go func() {
+				runDaemonJob(ctx, trigger.Job, hostname, runID)
+				jobDone <- true
+			}()
+
+			trigger.Result <- daemonTriggerResult{RunID: runID}
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func runDaemonJob(ctx context.Context, job *daemonJob, hostname string, runID string) {
+	// Jobs without a schedule were requested over the API
+	kind := "Scheduled"
+	if job.Schedule == nil {
+		kind = "Requested"
+	}
+
+	run := startRunWithID(runID, daemonJobCommands[job.Name], hostname)
+	pkg.Log.Printf("Starting %s %s run\n", strings.ToLower(kind), job.Name)
+
+	err := job.Run(ctx)
+	recordJob(run, err)
+
+	if err != nil {
+		pkg.ErrorLog.Printf("%s %s run failed after %s: %s\n", kind, job.Name, run.duration().Truncate(time.Second), err)
+		return
+	}
+
+	pkg.Log.Printf("%s %s run completed in %s\n", kind, job.Name, run.duration().Truncate(time.Second))
+}
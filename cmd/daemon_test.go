@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+)
+
+func TestDaemonJobScheduling(t *testing.T) {
+	pkg.Log = log.New(ioutil.Discard, "", 0)
+
+	jobs, err := buildDaemonJobs(&ScheduleConfig{
+		Full:        "0 5 * * *",
+		Incremental: "0 * * * *",
+		Prune:       "30 5 * * *",
+	}, map[string]func(ctx context.Context) error{})
+
+	if err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	if len(jobs) != 3 {
+		t.Fatal("Expected 3 jobs, found", len(jobs))
+	}
+
+	nowTime := time.Date(2019, 1, 1, 4, 10, 0, 0, time.UTC)
+	nextRun := scheduleDaemonJobs(jobs, nowTime)
+	if expected := time.Date(2019, 1, 1, 5, 0, 0, 0, time.UTC); !nextRun.Equal(expected) {
+		t.Errorf("Incorrect next run: %s (expected %s)", nextRun, expected)
+	}
+
+	// At 05:00 both full and incremental are due, only full should run
+	dueJobs := dueDaemonJobs(jobs, nextRun)
+	if len(dueJobs) != 1 || dueJobs[0].Name != daemonJobFull {
+		t.Errorf("Expected only the full job to be due, found %d jobs", len(dueJobs))
+	}
+
+	nextRun = scheduleDaemonJobs(jobs, nextRun)
+	if expected := time.Date(2019, 1, 1, 5, 30, 0, 0, time.UTC); !nextRun.Equal(expected) {
+		t.Errorf("Incorrect next run: %s (expected %s)", nextRun, expected)
+	}
+
+	dueJobs = dueDaemonJobs(jobs, nextRun)
+	if len(dueJobs) != 1 || dueJobs[0].Name != daemonJobPrune {
+		t.Errorf("Expected only the prune job to be due, found %d jobs", len(dueJobs))
+	}
+}
+
+func TestDaemonJobInvalidSchedule(t *testing.T) {
+	_, err := buildDaemonJobs(&ScheduleConfig{Full: "every day"}, map[string]func(ctx context.Context) error{})
+	if err == nil {
+		t.Error("Expected error for invalid cron expression")
+	}
+}
+
+func TestDaemonJobScheduleThatNeverMatches(t *testing.T) {
+	_, err := buildDaemonJobs(&ScheduleConfig{Full: "0 0 30 2 *"}, map[string]func(ctx context.Context) error{})
+	if err == nil || !strings.Contains(err.Error(), "never matches") {
+		t.Error("Expected an error for a schedule that never runs. Got", err)
+	}
+}
+
+func TestDaemonJobBinlogArchive(t *testing.T) {
+	jobs, err := buildDaemonJobs(&ScheduleConfig{BinlogArchive: "*/5 * * * *"}, map[string]func(ctx context.Context) error{})
+	if err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	if len(jobs) != 1 || jobs[0].Name != daemonJobBinlogArchive || daemonJobCommands[jobs[0].Name] != "archive-binlogs" {
+		t.Errorf("Expected a binlog archive job, found %v", jobs)
+	}
+}
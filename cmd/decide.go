@@ -20,7 +20,12 @@ func backupDecide(retentionConfig *RetentionConfig, checkpointFilePath string, h
 	return decideBackupType(allBackups, time.Now(), retentionConfig), nil
 }
 
+// decideBackupType returns whether a full backup is due. Without a retention config every backup is a full backup
 func decideBackupType(allBackups []backupItem, nowTime time.Time, retentionConfig *RetentionConfig) string {
+	if retentionConfig == nil {
+		retentionConfig = &RetentionConfig{}
+	}
+
 	backupsSince := findRelevantBackupsUpTo(nowTime, allBackups)
 
 	// No good backups found, we need a full backup
@@ -47,4 +47,11 @@ func TestDeciding(t *testing.T) {
 	if backupType != backupTypeFull {
 		t.Errorf("Incorrect backupType: %s (expected %s)", backupType, backupTypeFull)
 	}
+
+	// No retention config, like a daemon that was asked to perform a backup over the API
+	backupType = decideBackupType(allBackups, testTime, nil)
+
+	if backupType != backupTypeFull {
+		t.Errorf("Incorrect backupType: %s (expected %s)", backupType, backupTypeFull)
+	}
 }
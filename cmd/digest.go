@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	"github.com/feederco/really-simple-db-backup/pkg/alerting"
+)
+
+const digestStateFileName = "digest.json"
+const digestDefaultPeriod = 24 * time.Hour
+
+type digestState struct {
+	LastSentAt time.Time `json:"last_sent_at"`
+}
+
+// digestReport summarises the runs since the last digest
+type digestReport struct {
+	Hostname string
+	Since    time.Time
+	Until    time.Time
+	Runs     []runRecord
+
+	Backups       int
+	BackupBytes   int64
+	Failures      int
+	PrunedBackups int
+}
+
+var digestTemplate = template.Must(template.New("digest").Funcs(template.FuncMap{
+	"size":     pkg.FormatBytes,
+	"duration": func(run runRecord) string { return run.duration().Truncate(time.Second).String() },
+}).Parse(`<html><body style="font-family: sans-serif">
+<h2>Backup report for {{ .Hostname }}</h2>
+<p>{{ .Since.Format "2006-01-02 15:04 MST" }} to {{ .Until.Format "2006-01-02 15:04 MST" }}</p>
+<p>{{ .Backups }} backups ({{ size .BackupBytes }}), {{ .Failures }} failed runs, {{ .PrunedBackups }} backups pruned.</p>
+{{ if .Runs }}<table cellpadding="4" cellspacing="0" border="1" style="border-collapse: collapse">
+<tr><th>Started</th><th>Job</th><th>Backup</th><th>Size</th><th>Duration</th><th>Pruned</th><th>Result</th></tr>
+{{ range .Runs }}<tr>
+<td>{{ .StartedAt.Format "2006-01-02 15:04" }}</td>
+<td>{{ .Job }}</td>
+<td>{{ if .BackupName }}{{ .BackupName }} ({{ .BackupType }}){{ end }}</td>
+<td align="right">{{ if .SizeInBytes }}{{ size .SizeInBytes }}{{ end }}</td>
+<td align="right">{{ duration . }}</td>
+<td align="right">{{ if .PrunedBackups }}{{ .PrunedBackups }}{{ end }}</td>
+<td>{{ if .Success }}<span style="color: #2eb67d">OK</span>{{ else }}<span style="color: #d70000">Failed in {{ .FailedStage }}: {{ .Error }}</span>{{ end }}</td>
+</tr>
+{{ end }}</table>{{ else }}<p>No runs.</p>{{ end }}
+</body></html>
+`))
+
+// backupSendDigest emails a report of all runs since the last digest was sent
+func backupSendDigest(hostname string, persistentStorageDirectory string) error {
+	if configStruct.Alerting == nil || configStruct.Alerting.Email == nil {
+		return errors.New("No email config. Add an `alerting.email` section to the config to send digests")
+	}
+
+	statePath := path.Join(persistentStorageDirectory, digestStateFileName)
+
+	now := time.Now()
+	since := now.Add(-digestDefaultPeriod)
+
+	var state digestState
+	contents, err := ioutil.ReadFile(statePath)
+	if err == nil && json.Unmarshal(contents, &state) == nil && !state.LastSentAt.IsZero() {
+		since = state.LastSentAt
+	}
+
+	runs, err := readJournal(persistentStorageDirectory, since)
+	if err != nil {
+		return err
+	}
+
+	report := buildDigestReport(hostname, since, now, runs)
+
+	var htmlBody bytes.Buffer
+	err = digestTemplate.Execute(&htmlBody, report)
+	if err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("Backup report for %s: %d backups, %d failures", hostname, report.Backups, report.Failures)
+	err = alerting.SendEmail(configStruct.Alerting.Email, subject, report.Text(), htmlBody.String())
+	if err != nil {
+		return err
+	}
+
+	pkg.Log.Printf("Sent digest of %d %s to %s\n", len(runs), pluralize(len(runs), "run", "runs"), strings.Join(configStruct.Alerting.Email.To, ", "))
+
+	contents, err = json.Marshal(digestState{LastSentAt: now})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(statePath, contents, os.FileMode(0600))
+}
+
+func buildDigestReport(hostname string, since time.Time, until time.Time, runs []runRecord) digestReport {
+	report := digestReport{
+		Hostname: hostname,
+		Since:    since,
+		Until:    until,
+		Runs:     runs,
+	}
+
+	for _, run := range runs {
+		if !run.Success {
+			report.Failures++
+		}
+		if run.Success && run.BackupName != "" {
+			report.Backups++
+			report.BackupBytes += run.SizeInBytes
+		}
+		report.PrunedBackups += run.PrunedBackups
+	}
+
+	return report
+}
+
+// Text returns the report as plain text
+func (report digestReport) Text() string {
+	lines := []string{
+		fmt.Sprintf("Backup report for %s, %s to %s", report.Hostname, report.Since.Format(time.RFC3339), report.Until.Format(time.RFC3339)),
+		fmt.Sprintf("%d backups (%s), %d failed runs, %d backups pruned.", report.Backups, pkg.FormatBytes(report.BackupBytes), report.Failures, report.PrunedBackups),
+		"",
+	}
+
+	for _, run := range report.Runs {
+		result := "OK"
+		if !run.Success {
+			result = fmt.Sprintf("FAILED in %s: %s", run.FailedStage, run.Error)
+		}
+
+		line := fmt.Sprintf("%s\t%s\t%s", run.StartedAt.Format("2006-01-02 15:04"), run.Job, run.duration().Truncate(time.Second))
+		if run.BackupName != "" {
+			line += fmt.Sprintf("\t%s (%s)", run.BackupName, pkg.FormatBytes(run.SizeInBytes))
+		}
+		if run.PrunedBackups > 0 {
+			line += fmt.Sprintf("\tpruned %d", run.PrunedBackups)
+		}
+		lines = append(lines, line+"\t"+result)
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalAndDigestReport(t *testing.T) {
+	directory, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	now := time.Date(2019, 1, 2, 8, 0, 0, 0, time.UTC)
+
+	runs := []*runRecord{
+		{Job: "perform", StartedAt: now.Add(-30 * time.Hour)},
+		{Job: "perform", StartedAt: now.Add(-6 * time.Hour)},
+		{Job: "perform", StartedAt: now.Add(-3 * time.Hour)},
+		{Job: "prune", StartedAt: now.Add(-2 * time.Hour)},
+	}
+	runs[0].noteBackup(backupTypeFull, "backup-old.xbstream", "db1/backup-old.xbstream", 100)
+	runs[1].noteBackup(backupTypeFull, "backup-1.xbstream", "db1/backup-1.xbstream", 1<<30)
+	runs[1].notePrunedBackups(2)
+	runs[3].notePrunedBackups(1)
+
+	for index, run := range runs {
+		var runErr error
+		if index == 2 {
+			runErr = withStage(stageUpload, errors.New("connection reset"))
+		}
+		run.finish(runErr)
+
+		if err := appendToJournal(directory, run); err != nil {
+			t.Fatal("Could not append to journal", err)
+		}
+	}
+
+	journal, err := readJournal(directory, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal("Could not read journal", err)
+	}
+
+	if len(journal) != 3 {
+		t.Fatalf("Expected 3 runs in the last day, got %d", len(journal))
+	}
+
+	if journal[1].Success || journal[1].FailedStage != stageUpload {
+		t.Errorf("Expected second run to have failed in upload: %v", journal[1])
+	}
+
+	report := buildDigestReport("db1", now.Add(-24*time.Hour), now, journal)
+	if report.Backups != 1 || report.BackupBytes != 1<<30 || report.Failures != 1 || report.PrunedBackups != 3 {
+		t.Errorf("Incorrect report: %+v", report)
+	}
+
+	if !strings.Contains(report.Text(), "1 backups (1.0 GiB), 1 failed runs, 3 backups pruned.") {
+		t.Errorf("Incorrect report text: %s", report.Text())
+	}
+
+	var html strings.Builder
+	if err := digestTemplate.Execute(&html, report); err != nil {
+		t.Fatal("Could not render digest", err)
+	}
+
+	if !strings.Contains(html.String(), "Failed in upload: connection reset") || !strings.Contains(html.String(), "backup-1.xbstream (full)") {
+		t.Errorf("Incorrect digest HTML: %s", html.String())
+	}
+}
+
+func TestReadMissingJournal(t *testing.T) {
+	journal, err := readJournal("/nonexistent", time.Time{})
+	if err != nil || len(journal) != 0 {
+		t.Error("Expected empty journal without error", journal, err)
+	}
+}
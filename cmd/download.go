@@ -0,0 +1,399 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"sync"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+const defaultDownloadConnections = 4
+const defaultDownloadPartSizeInMegaBytes = 64
+
+// downloadSettings are how the pieces of a restore are downloaded
+type downloadSettings struct {
+	Connections int
+	PartSize    int64
+}
+
+func newDownloadSettings(config *DownloadConfig) downloadSettings {
+	settings := downloadSettings{
+		Connections: defaultDownloadConnections,
+		PartSize:    defaultDownloadPartSizeInMegaBytes << 20,
+	}
+
+	if config != nil && config.Connections > 0 {
+		settings.Connections = config.Connections
+	}
+	if config != nil && config.PartSizeInMegaBytes > 0 {
+		settings.PartSize = int64(config.PartSizeInMegaBytes) << 20
+	}
+
+	return settings
+}
+
+// downloadCheckpoint records how far a piece of a restore got, so running `download` or `restore` again in the same
+// directory continues where the last run stopped. It is kept next to the downloaded piece
+type downloadCheckpoint struct {
+	Key      string `json:"key"`
+	ETag     string `json:"etag"`
+	Size     int64  `json:"size"`
+	PartSize int64  `json:"part_size"`
+
+	// Parts that were completely written to the downloaded file
+	Parts []bool `json:"parts"`
+
+	// The downloaded file was extracted into the download directory. The file is removed once it is
+	Extracted bool `json:"extracted"`
+
+	// The download directory was decompressed. Nothing is left to do for the piece
+	Decompressed bool `json:"decompressed"`
+
+	path  string
+	mutex sync.Mutex
+}
+
+// loadDownloadCheckpoint returns the checkpoint at checkpointPath when it is of the same object and parts,
+// or a new one that starts over
+func loadDownloadCheckpoint(checkpointPath string, object minio.ObjectInfo, partSize int64) *downloadCheckpoint {
+	fresh := &downloadCheckpoint{
+		Key:      object.Key,
+		ETag:     object.ETag,
+		Size:     object.Size,
+		PartSize: partSize,
+		Parts:    make([]bool, (object.Size+partSize-1)/partSize),
+		path:     checkpointPath,
+	}
+
+	contents, err := ioutil.ReadFile(checkpointPath)
+	if err != nil {
+		return fresh
+	}
+
+	saved := &downloadCheckpoint{path: checkpointPath}
+	err = json.Unmarshal(contents, saved)
+	if err != nil || saved.Key != fresh.Key || saved.ETag != fresh.ETag || saved.Size != fresh.Size || saved.PartSize != fresh.PartSize || len(saved.Parts) != len(fresh.Parts) {
+		pkg.Log.Printf("Starting the download of %s over. It doesn't match the checkpoint of the last run\n", object.Key)
+		return fresh
+	}
+
+	return saved
+}
+
+// save writes the checkpoint to a temporary file first, so a run that is killed never leaves half a checkpoint behind
+func (checkpoint *downloadCheckpoint) save() error {
+	contents, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(checkpoint.path+".tmp", contents, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(checkpoint.path+".tmp", checkpoint.path)
+}
+
+// completePart records that part was written and saves the checkpoint
+func (checkpoint *downloadCheckpoint) completePart(part int) error {
+	checkpoint.mutex.Lock()
+	defer checkpoint.mutex.Unlock()
+
+	checkpoint.Parts[part] = true
+	return checkpoint.save()
+}
+
+// missingParts returns the parts that still have to be downloaded, and the number of bytes of the parts that don't
+func (checkpoint *downloadCheckpoint) missingParts() ([]int, int64) {
+	missing := make([]int, 0)
+	downloadedBytes := int64(0)
+	for part, done := range checkpoint.Parts {
+		if done {
+			downloadedBytes += checkpoint.partLength(part)
+		} else {
+			missing = append(missing, part)
+		}
+	}
+
+	return missing, downloadedBytes
+}
+
+func (checkpoint *downloadCheckpoint) partLength(part int) int64 {
+	start := int64(part) * checkpoint.PartSize
+	if start+checkpoint.PartSize > checkpoint.Size {
+		return checkpoint.Size - start
+	}
+	return checkpoint.PartSize
+}
+
+// downloadBackups downloads, extracts and decompresses backups into a directory each in restoreDirectory and returns those directories.
+// Every piece is downloaded in parts side by side. A piece is extracted once it is downloaded, in the order of backups,
+// while the next piece downloads. Decompressing doesn't depend on the other pieces, so pieces are decompressed side by side.
+// What is done is recorded in a checkpoint per piece, so calling it again after a failure skips the work that was finished
+func downloadBackups(ctx context.Context, backups []backupItem, restoreDirectory string, bucketName string, settings downloadSettings, minioClient *minio.Client) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var firstErr error
+	var errMutex sync.Mutex
+	fail := func(err error) {
+		errMutex.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		errMutex.Unlock()
+		cancel()
+	}
+
+	downloadDirectories := make([]string, len(backups))
+	checkpoints := make([]*downloadCheckpoint, len(backups))
+
+	// Pieces are handed to the extractor in order
+	downloaded := make(chan int, len(backups))
+
+	var extracting sync.WaitGroup
+	extracting.Add(1)
+	go func() {
+		defer extracting.Done()
+
+		var decompressing sync.WaitGroup
+		defer decompressing.Wait()
+
+		for index := range downloaded {
+			err := extractBackupPiece(ctx, checkpoints[index], path.Join(restoreDirectory, path.Base(backups[index].Path)), downloadDirectories[index])
+			if err != nil {
+				fail(fmt.Errorf("Could not extract %s: %s", backups[index].Path, err))
+				return
+			}
+
+			decompressing.Add(1)
+			go func(index int) {
+				defer decompressing.Done()
+
+				err := decompressBackupPiece(ctx, checkpoints[index], downloadDirectories[index])
+				if err != nil {
+					fail(fmt.Errorf("Could not decompress %s: %s", backups[index].Path, err))
+				}
+			}(index)
+		}
+	}()
+
+	for index, backup := range backups {
+		if ctx.Err() != nil {
+			break
+		}
+
+		downloadDirectories[index] = backupDownloadDirectory(restoreDirectory, backup)
+
+		object, err := minioClient.StatObject(bucketName, backup.Path, minio.StatObjectOptions{})
+		if err != nil {
+			fail(err)
+			break
+		}
+
+		downloadFile := path.Join(restoreDirectory, path.Base(backup.Path))
+		checkpoints[index] = loadDownloadCheckpoint(downloadFile+".checkpoint", object, settings.PartSize)
+
+		if !checkpoints[index].Extracted {
+			err = downloadBackupPiece(ctx, checkpoints[index], bucketName, downloadFile, settings.Connections, minioClient)
+			if err != nil {
+				fail(err)
+				break
+			}
+		} else {
+			pkg.Log.Printf("Skipping the download of %s. It was downloaded by an earlier run\n", backup.Path)
+		}
+
+		downloaded <- index
+	}
+
+	close(downloaded)
+	extracting.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return downloadDirectories, nil
+}
+
+// downloadBackupPiece downloads the parts of the object of checkpoint that are missing into downloadFile, connections parts at a time
+func downloadBackupPiece(ctx context.Context, checkpoint *downloadCheckpoint, bucketName string, downloadFile string, connections int, minioClient *minio.Client) error {
+	file, err := os.OpenFile(downloadFile, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = file.Truncate(checkpoint.Size)
+	if err != nil {
+		return err
+	}
+
+	missingParts, downloadedBytes := checkpoint.missingParts()
+	if len(missingParts) == 0 {
+		return nil
+	}
+
+	if downloadedBytes > 0 {
+		pkg.Log.Printf("Resuming the download of %s at %s of %s\n", checkpoint.Key, pkg.FormatBytes(downloadedBytes), pkg.FormatBytes(checkpoint.Size))
+	}
+
+	progress := pkg.NewProgressReporter("Downloading "+checkpoint.Key, checkpoint.Size)
+	progress.Set(downloadedBytes)
+	progress.Start()
+	defer progress.Finish()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parts := make(chan int, len(missingParts))
+	for _, part := range missingParts {
+		parts <- part
+	}
+	close(parts)
+
+	if connections > len(missingParts) {
+		connections = len(missingParts)
+	}
+
+	errs := make(chan error, connections)
+	for worker := 0; worker < connections; worker++ {
+		go func() {
+			for part := range parts {
+				if ctx.Err() != nil {
+					break
+				}
+
+				err := pkg.WithRetryContext(ctx, "download "+checkpoint.Key, func() error {
+					return downloadPart(ctx, checkpoint, part, bucketName, file, progress, minioClient)
+				})
+				if err == nil {
+					err = checkpoint.completePart(part)
+				}
+				if err != nil {
+					cancel()
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+
+	var firstErr error
+	for worker := 0; worker < connections; worker++ {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// downloadPart writes part of the object of checkpoint at its offset in file. Fails when the object changed since the checkpoint
+func downloadPart(ctx context.Context, checkpoint *downloadCheckpoint, part int, bucketName string, file *os.File, progress *pkg.ProgressReporter, minioClient *minio.Client) error {
+	start := int64(part) * checkpoint.PartSize
+	length := checkpoint.partLength(part)
+
+	options := minio.GetObjectOptions{}
+	options.SetRange(start, start+length-1)
+	options.SetMatchETag(checkpoint.ETag)
+
+	object, err := minioClient.GetObjectWithContext(ctx, bucketName, checkpoint.Key, options)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	writer := &offsetWriter{file: file, offset: start}
+	written, err := io.CopyN(writer, progress.NewProxyReader(object), length)
+	if err != nil {
+		// The part is downloaded again from the start
+		progress.Add(-int(written))
+		return err
+	}
+
+	return nil
+}
+
+// extractBackupPiece extracts downloadFile into downloadDirectory with xbstream, unless an earlier run did, and removes downloadFile
+func extractBackupPiece(ctx context.Context, checkpoint *downloadCheckpoint, downloadFile string, downloadDirectory string) error {
+	if checkpoint.Extracted {
+		return nil
+	}
+
+	// Whatever an interrupted extraction left behind
+	err := os.RemoveAll(downloadDirectory)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(downloadDirectory, 0700)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(downloadFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	pkg.Log.Println("Extracting", checkpoint.Key)
+
+	_, err = pkg.RunCommand(ctx, xbstreamExtractCommand(downloadDirectory, file))
+	if err != nil {
+		return err
+	}
+
+	checkpoint.Extracted = true
+	err = checkpoint.save()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(downloadFile)
+}
+
+// decompressBackupPiece decompresses the extracted piece in downloadDirectory with as many cores as possible, unless an earlier run did
+func decompressBackupPiece(ctx context.Context, checkpoint *downloadCheckpoint, downloadDirectory string) error {
+	if checkpoint.Decompressed {
+		return nil
+	}
+
+	pkg.Log.Println("Decompressing", checkpoint.Key)
+
+	// Files that were decompressed by an interrupted run were removed, so only the rest is decompressed
+	_, err := pkg.PerformCommandContext(ctx, append([]string{"xtrabackup"}, xtrabackupDecompressArgs(downloadDirectory, runtime.NumCPU())...)...)
+	if err != nil {
+		return err
+	}
+
+	checkpoint.Decompressed = true
+	return checkpoint.save()
+}
+
+// offsetWriter writes to file from offset on
+type offsetWriter struct {
+	file   *os.File
+	offset int64
+}
+
+func (writer *offsetWriter) Write(p []byte) (int, error) {
+	written, err := writer.file.WriteAt(p, writer.offset)
+	writer.offset += int64(written)
+	return written, err
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+const downloadTestKey = "db1/mysql-backup-201901011000.full.xbstream"
+
+func newDownloadTest(t *testing.T, size int) (*fakeObjectStorage, *minio.Client, []byte, string) {
+	pkg.Log = log.New(ioutil.Discard, "", 0)
+
+	storage := newFakeObjectStorage()
+
+	contents := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(contents)
+	storage.Put("backups", downloadTestKey, contents)
+
+	minioClient, err := storage.MinioClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	directory, err := ioutil.TempDir("", "rsdb-download")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return storage, minioClient, contents, directory
+}
+
+func rangeRequests(storage *fakeObjectStorage) []string {
+	requests := make([]string, 0)
+	for _, request := range storage.Requests() {
+		if strings.HasPrefix(request, "GET "+"backups/"+downloadTestKey+" bytes=") {
+			requests = append(requests, strings.TrimPrefix(request, "GET backups/"+downloadTestKey+" "))
+		}
+	}
+	return requests
+}
+
+func TestDownloadBackupPieceInParts(t *testing.T) {
+	pkg.ShowProgressBars = false
+	defer func() { pkg.ShowProgressBars = true }()
+
+	storage, minioClient, contents, directory := newDownloadTest(t, 10000)
+	defer storage.Close()
+	defer os.RemoveAll(directory)
+
+	object, err := minioClient.StatObject("backups", downloadTestKey, minio.StatObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	downloadFile := path.Join(directory, "backup.xbstream")
+	checkpoint := loadDownloadCheckpoint(downloadFile+".checkpoint", object, 1000)
+
+	err = downloadBackupPiece(context.Background(), checkpoint, "backups", downloadFile, 3, minioClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	downloaded, _ := ioutil.ReadFile(downloadFile)
+	if !bytes.Equal(downloaded, contents) {
+		t.Errorf("Expected the downloaded file to be the object, got %d bytes", len(downloaded))
+	}
+
+	if requests := rangeRequests(storage); len(requests) != 10 {
+		t.Errorf("Expected a request per part, got %v", requests)
+	}
+
+	saved := loadDownloadCheckpoint(downloadFile+".checkpoint", object, 1000)
+	if missing, downloadedBytes := saved.missingParts(); len(missing) != 0 || downloadedBytes != 10000 {
+		t.Errorf("Expected every part to be recorded in the checkpoint, missing %v", missing)
+	}
+}
+
+func TestDownloadBackupPieceResumes(t *testing.T) {
+	pkg.ShowProgressBars = false
+	defer func() { pkg.ShowProgressBars = true }()
+
+	storage, minioClient, contents, directory := newDownloadTest(t, 2500)
+	defer storage.Close()
+	defer os.RemoveAll(directory)
+
+	object, err := minioClient.StatObject("backups", downloadTestKey, minio.StatObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A run that stopped after the second part
+	downloadFile := path.Join(directory, "backup.xbstream")
+	partial := make([]byte, 2500)
+	copy(partial[1000:2000], contents[1000:2000])
+	ioutil.WriteFile(downloadFile, partial, 0600)
+
+	checkpoint := loadDownloadCheckpoint(downloadFile+".checkpoint", object, 1000)
+	checkpoint.Parts[1] = true
+	checkpoint.save()
+
+	err = downloadBackupPiece(context.Background(), loadDownloadCheckpoint(downloadFile+".checkpoint", object, 1000), "backups", downloadFile, 4, minioClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	downloaded, _ := ioutil.ReadFile(downloadFile)
+	if !bytes.Equal(downloaded, contents) {
+		t.Errorf("Expected the downloaded file to be the object, got %d bytes", len(downloaded))
+	}
+
+	requests := rangeRequests(storage)
+	if len(requests) != 2 || strings.Contains(strings.Join(requests, " "), "bytes=1000-1999") {
+		t.Errorf("Expected only the missing parts to be downloaded, got %v", requests)
+	}
+}
+
+func TestDownloadCheckpointOfAChangedObjectStartsOver(t *testing.T) {
+	directory, err := ioutil.TempDir("", "rsdb-download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	checkpointPath := path.Join(directory, "backup.xbstream.checkpoint")
+	object := minio.ObjectInfo{Key: downloadTestKey, ETag: "abc", Size: 2500}
+
+	checkpoint := loadDownloadCheckpoint(checkpointPath, object, 1000)
+	checkpoint.Parts[0] = true
+	checkpoint.Extracted = true
+	checkpoint.save()
+
+	if resumed := loadDownloadCheckpoint(checkpointPath, object, 1000); !resumed.Parts[0] || !resumed.Extracted {
+		t.Error("Expected the checkpoint of the same object to be resumed")
+	}
+
+	object.ETag = "def"
+	if fresh := loadDownloadCheckpoint(checkpointPath, object, 1000); fresh.Parts[0] || fresh.Extracted || len(fresh.Parts) != 3 {
+		t.Errorf("Expected the download of a changed object to start over, got %+v", fresh)
+	}
+
+	object.ETag = "abc"
+	if fresh := loadDownloadCheckpoint(checkpointPath, object, 500); fresh.Parts[0] || len(fresh.Parts) != 5 {
+		t.Errorf("Expected the download with another part size to start over, got %+v", fresh)
+	}
+}
+
+func TestNewDownloadSettings(t *testing.T) {
+	settings := newDownloadSettings(nil)
+	if settings.Connections != 4 || settings.PartSize != 64<<20 {
+		t.Errorf("Wrong defaults. Got %+v", settings)
+	}
+
+	settings = newDownloadSettings(&DownloadConfig{Connections: 8, PartSizeInMegaBytes: 16})
+	if settings.Connections != 8 || settings.PartSize != 16<<20 {
+		t.Errorf("Wrong settings. Got %+v", settings)
+	}
+}
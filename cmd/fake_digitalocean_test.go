@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metadata "github.com/digitalocean/go-metadata"
+	"github.com/digitalocean/godo"
+)
+
+// fakeDigitalOcean serves the volume and action endpoints of the DigitalOcean API used by this tool,
+// and the metadata API of the droplet it pretends to run on. Actions complete immediately
+type fakeDigitalOcean struct {
+	*httptest.Server
+
+	Droplet metadata.Metadata
+
+	mutex        sync.Mutex
+	volumes      map[string]*godo.Volume
+	lastID       int
+	volumeEvents []string
+}
+
+func newFakeDigitalOcean() *fakeDigitalOcean {
+	fake := &fakeDigitalOcean{
+		Droplet: metadata.Metadata{DropletID: 1234, Hostname: "db1", Region: "ams3"},
+		volumes: make(map[string]*godo.Volume),
+	}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+	return fake
+}
+
+// Volumes returns the volumes that exist, by ID
+func (fake *fakeDigitalOcean) Volumes() []godo.Volume {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	volumes := make([]godo.Volume, 0, len(fake.volumes))
+	for _, volume := range fake.volumes {
+		volumes = append(volumes, *volume)
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].ID < volumes[j].ID })
+	return volumes
+}
+
+// AddVolume adds a volume that exists before the test runs
+func (fake *fakeDigitalOcean) AddVolume(volume godo.Volume) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	fake.volumes[volume.ID] = &volume
+}
+
+// VolumeEvents returns what happened to volumes, like `create mysql-backup-20190123203941`
+func (fake *fakeDigitalOcean) VolumeEvents() []string {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	return append([]string(nil), fake.volumeEvents...)
+}
+
+func (fake *fakeDigitalOcean) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	pieces := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case r.URL.Path == "/metadata/v1.json":
+		writeFakeJSON(w, http.StatusOK, fake.Droplet)
+	case len(pieces) == 2 && pieces[1] == "volumes" && r.Method == http.MethodGet:
+		volumes := make([]godo.Volume, 0, len(fake.volumes))
+		for _, volume := range fake.volumes {
+			volumes = append(volumes, *volume)
+		}
+		writeFakeJSON(w, http.StatusOK, map[string]interface{}{"volumes": volumes, "links": map[string]interface{}{}, "meta": map[string]int{"total": len(volumes)}})
+	case len(pieces) == 2 && pieces[1] == "volumes" && r.Method == http.MethodPost:
+		fake.createVolume(w, r)
+	case len(pieces) == 3 && pieces[1] == "volumes":
+		volume, exists := fake.volumes[pieces[2]]
+		if !exists {
+			writeFakeJSON(w, http.StatusNotFound, map[string]string{"id": "not_found", "message": "The resource you were accessing could not be found."})
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			delete(fake.volumes, volume.ID)
+			fake.volumeEvents = append(fake.volumeEvents, "destroy "+volume.Name)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeFakeJSON(w, http.StatusOK, map[string]interface{}{"volume": volume})
+	case len(pieces) == 4 && pieces[1] == "volumes" && pieces[3] == "actions" && r.Method == http.MethodPost:
+		fake.volumeAction(w, r, pieces[2])
+	case len(pieces) == 3 && pieces[1] == "actions":
+		id, _ := strconv.Atoi(pieces[2])
+		writeFakeJSON(w, http.StatusOK, map[string]interface{}{"action": godo.Action{ID: id, Status: "completed"}})
+	default:
+		writeFakeJSON(w, http.StatusNotFound, map[string]string{"id": "not_found", "message": r.Method + " " + r.URL.Path + " is not faked"})
+	}
+}
+
+func (fake *fakeDigitalOcean) createVolume(w http.ResponseWriter, r *http.Request) {
+	var createRequest godo.VolumeCreateRequest
+	err := json.NewDecoder(r.Body).Decode(&createRequest)
+	if err != nil {
+		writeFakeJSON(w, http.StatusBadRequest, map[string]string{"id": "bad_request", "message": err.Error()})
+		return
+	}
+
+	fake.lastID++
+	volume := &godo.Volume{
+		ID:             fmt.Sprintf("volume-%d", fake.lastID),
+		Region:         &godo.Region{Slug: createRequest.Region},
+		Name:           createRequest.Name,
+		SizeGigaBytes:  createRequest.SizeGigaBytes,
+		Description:    createRequest.Description,
+		DropletIDs:     []int{},
+		CreatedAt:      time.Now(),
+		FilesystemType: createRequest.FilesystemType,
+		Tags:           createRequest.Tags,
+	}
+	fake.volumes[volume.ID] = volume
+	fake.volumeEvents = append(fake.volumeEvents, "create "+volume.Name)
+
+	writeFakeJSON(w, http.StatusCreated, map[string]interface{}{"volume": volume})
+}
+
+func (fake *fakeDigitalOcean) volumeAction(w http.ResponseWriter, r *http.Request, volumeID string) {
+	volume, exists := fake.volumes[volumeID]
+	if !exists {
+		writeFakeJSON(w, http.StatusNotFound, map[string]string{"id": "not_found", "message": "Volume " + volumeID + " does not exist"})
+		return
+	}
+
+	var actionRequest struct {
+		Type      string `json:"type"`
+		DropletID int    `json:"droplet_id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&actionRequest)
+	if err != nil {
+		writeFakeJSON(w, http.StatusBadRequest, map[string]string{"id": "bad_request", "message": err.Error()})
+		return
+	}
+
+	switch actionRequest.Type {
+	case "attach":
+		volume.DropletIDs = []int{actionRequest.DropletID}
+	case "detach":
+		volume.DropletIDs = []int{}
+	default:
+		writeFakeJSON(w, http.StatusUnprocessableEntity, map[string]string{"id": "unprocessable_entity", "message": "Unknown action " + actionRequest.Type})
+		return
+	}
+	fake.volumeEvents = append(fake.volumeEvents, actionRequest.Type+" "+volume.Name)
+
+	fake.lastID++
+	writeFakeJSON(w, http.StatusCreated, map[string]interface{}{"action": godo.Action{ID: fake.lastID, Status: "in-progress", Type: actionRequest.Type}})
+}
+
+func writeFakeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio-go"
+)
+
+// fakeObjectStorage is an in-memory S3 compatible server with the calls minio-go makes for this tool:
+// listing, single part uploads, server side copies, ranged downloads and removals. Credentials aren't checked
+type fakeObjectStorage struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	objects  map[string]fakeObject
+	requests []string
+}
+
+type fakeObject struct {
+	Contents     []byte
+	LastModified time.Time
+}
+
+type fakeListBucketResult struct {
+	XMLName        xml.Name               `xml:"ListBucketResult"`
+	Name           string                 `xml:"Name"`
+	Prefix         string                 `xml:"Prefix"`
+	Delimiter      string                 `xml:"Delimiter,omitempty"`
+	KeyCount       int                    `xml:"KeyCount"`
+	MaxKeys        int                    `xml:"MaxKeys"`
+	IsTruncated    bool                   `xml:"IsTruncated"`
+	Contents       []fakeListObject       `xml:"Contents"`
+	CommonPrefixes []fakeListCommonPrefix `xml:"CommonPrefixes"`
+}
+
+type fakeListObject struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type fakeListCommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type fakeStorageError struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+	Key     string   `xml:"Key,omitempty"`
+}
+
+// newFakeObjectStorage serves over TLS like Spaces does, so uploads aren't sent with streaming signatures
+func newFakeObjectStorage() *fakeObjectStorage {
+	fake := &fakeObjectStorage{objects: make(map[string]fakeObject)}
+	fake.Server = httptest.NewTLSServer(http.HandlerFunc(fake.serveHTTP))
+	return fake
+}
+
+// Endpoint is the host and port to connect to, like digitalocean.space_endpoint
+func (fake *fakeObjectStorage) Endpoint() string {
+	return strings.TrimPrefix(fake.URL, "https://")
+}
+
+// MinioClient returns a client of the bucket that trusts the certificate of the server
+func (fake *fakeObjectStorage) MinioClient() (*minio.Client, error) {
+	minioClient, err := minio.NewWithRegion(fake.Endpoint(), "fake-key", "fake-secret", true, "us-east-1")
+	if err != nil {
+		return nil, err
+	}
+
+	minioClient.SetCustomTransport(fake.Client().Transport)
+	return minioClient, nil
+}
+
+// Requests returns the object requests made so far, like `GET db1/backup.xbstream bytes=0-99`
+func (fake *fakeObjectStorage) Requests() []string {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	return append([]string(nil), fake.requests...)
+}
+
+// Put stores an object at key in bucket
+func (fake *fakeObjectStorage) Put(bucket string, key string, contents []byte) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	fake.objects[bucket+"/"+key] = fakeObject{Contents: contents, LastModified: time.Now().UTC()}
+}
+
+// Get returns the object at key in bucket
+func (fake *fakeObjectStorage) Get(bucket string, key string) ([]byte, bool) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	object, exists := fake.objects[bucket+"/"+key]
+	return object.Contents, exists
+}
+
+// Keys returns the keys in bucket that start with prefix in lexical order
+func (fake *fakeObjectStorage) Keys(bucket string, prefix string) []string {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	return fake.keys(bucket, prefix)
+}
+
+func (fake *fakeObjectStorage) keys(bucket string, prefix string) []string {
+	keys := make([]string, 0)
+	for name := range fake.objects {
+		if strings.HasPrefix(name, bucket+"/"+prefix) {
+			keys = append(keys, strings.TrimPrefix(name, bucket+"/"))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (fake *fakeObjectStorage) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	pieces := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := pieces[0]
+	key := ""
+	if len(pieces) == 2 {
+		key = pieces[1]
+	}
+
+	switch {
+	case key == "" && r.URL.Query().Get("list-type") == "2":
+		fake.listObjects(w, r, bucket)
+	case key == "" && r.URL.Query()["location"] != nil:
+		writeFakeXML(w, http.StatusOK, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+			Region  string   `xml:",chardata"`
+		}{Region: "us-east-1"})
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case key == "":
+		writeFakeXML(w, http.StatusNotImplemented, fakeStorageError{Code: "NotImplemented", Message: r.Method + " on a bucket is not faked"})
+	case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodPut || r.Method == http.MethodDelete:
+		fake.requests = append(fake.requests, strings.TrimSpace(r.Method+" "+bucket+"/"+key+" "+r.Header.Get("Range")))
+		fake.serveObject(w, r, bucket, key)
+	default:
+		writeFakeXML(w, http.StatusNotImplemented, fakeStorageError{Code: "NotImplemented", Message: r.Method + " on an object is not faked"})
+	}
+}
+
+func (fake *fakeObjectStorage) serveObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	switch {
+	case r.Method == http.MethodPut:
+		fake.putObject(w, r, bucket, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, exists := fake.objects[bucket+"/"+key]
+		if !exists {
+			writeFakeXML(w, http.StatusNotFound, fakeStorageError{Code: "NoSuchKey", Message: "The specified key does not exist.", Key: key})
+			return
+		}
+
+		w.Header().Set("ETag", fakeETag(object.Contents))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, key, object.LastModified, bytes.NewReader(object.Contents))
+	case r.Method == http.MethodDelete:
+		delete(fake.objects, bucket+"/"+key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (fake *fakeObjectStorage) putObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	object := fakeObject{LastModified: time.Now().UTC()}
+
+	if copySource := r.Header.Get("X-Amz-Copy-Source"); copySource != "" {
+		sourceName, err := url.PathUnescape(strings.TrimPrefix(copySource, "/"))
+		if err != nil {
+			writeFakeXML(w, http.StatusBadRequest, fakeStorageError{Code: "InvalidArgument", Message: err.Error()})
+			return
+		}
+
+		source, exists := fake.objects[sourceName]
+		if !exists {
+			writeFakeXML(w, http.StatusNotFound, fakeStorageError{Code: "NoSuchKey", Message: "The specified key does not exist.", Key: sourceName})
+			return
+		}
+
+		object.Contents = source.Contents
+		fake.objects[bucket+"/"+key] = object
+		writeFakeXML(w, http.StatusOK, struct {
+			XMLName      xml.Name `xml:"CopyObjectResult"`
+			LastModified string   `xml:"LastModified"`
+			ETag         string   `xml:"ETag"`
+		}{LastModified: object.LastModified.Format(time.RFC3339), ETag: fakeETag(object.Contents)})
+		return
+	}
+
+	contents, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeFakeXML(w, http.StatusBadRequest, fakeStorageError{Code: "IncompleteBody", Message: err.Error()})
+		return
+	}
+
+	object.Contents = contents
+	fake.objects[bucket+"/"+key] = object
+
+	w.Header().Set("ETag", fakeETag(contents))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (fake *fakeObjectStorage) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+
+	result := fakeListBucketResult{Name: bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: 1000}
+	commonPrefixes := make(map[string]bool)
+	for _, key := range fake.keys(bucket, prefix) {
+		if delimiter != "" {
+			if index := strings.Index(strings.TrimPrefix(key, prefix), delimiter); index >= 0 {
+				commonPrefix := key[:len(prefix)+index+len(delimiter)]
+				if !commonPrefixes[commonPrefix] {
+					commonPrefixes[commonPrefix] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, fakeListCommonPrefix{Prefix: commonPrefix})
+				}
+				continue
+			}
+		}
+
+		object := fake.objects[bucket+"/"+key]
+		result.Contents = append(result.Contents, fakeListObject{
+			Key:          key,
+			LastModified: object.LastModified.Format(time.RFC3339),
+			ETag:         fakeETag(object.Contents),
+			Size:         int64(len(object.Contents)),
+			StorageClass: "STANDARD",
+		})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+
+	writeFakeXML(w, http.StatusOK, result)
+}
+
+func fakeETag(contents []byte) string {
+	sum := md5.Sum(contents)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeFakeXML(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(value)
+}
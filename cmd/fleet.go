@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+const fleetDefaultIntervalHours = 24
+
+// A host is behind when its last backup is older than this many times its expected interval
+const fleetStalenessFactor = 1.5
+
+// Fleet host states
+const fleetStatusOK = "ok"
+const fleetStatusBehind = "behind"
+const fleetStatusNoBackups = "no-backups"
+
+// Where the expected interval of a host comes from
+const fleetIntervalFromConfig = "config"
+const fleetIntervalInferred = "inferred"
+const fleetIntervalDefault = "default"
+
+// fleetHostStatus is one row of `fleet-status`
+type fleetHostStatus struct {
+	Hostname          string     `json:"hostname"`
+	Status            string     `json:"status"`
+	Reason            string     `json:"reason,omitempty"`
+	LastFullAt        *time.Time `json:"last_full_at"`
+	LastIncrementalAt *time.Time `json:"last_incremental_at"`
+	LastBackupAt      *time.Time `json:"last_backup_at"`
+	Backups           int        `json:"backups"`
+	Lineages          int        `json:"lineages"`
+	TotalSizeInBytes  int64      `json:"total_size_in_bytes"`
+
+	ExpectedIntervalHours     float64 `json:"expected_interval_hours"`
+	ExpectedIntervalSource    string  `json:"expected_interval_source"`
+	ExpectedFullIntervalHours float64 `json:"expected_full_interval_hours,omitempty"`
+}
+
+// backupFleetStatus prints the backup health of every host with backups in the bucket
+func backupFleetStatus(asJSON bool, minioClient *minio.Client) error {
+	bucket := configStruct.DigitalOcean.SpaceName
+	layout := configStruct.backupLayout()
+
+	hostnames, err := listBucketHostnames(bucket, layout.hostsPrefix(), minioClient)
+	if err != nil {
+		return withStage(stageList, err)
+	}
+
+	// Hosts that are expected to have backups are shown even if they have none
+	if configStruct.Fleet != nil {
+		for hostname := range configStruct.Fleet.Hosts {
+			hostnames = appendIfMissing(hostnames, hostname)
+		}
+	}
+	sort.Strings(hostnames)
+
+	now := time.Now()
+	statuses := make([]fleetHostStatus, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		backups, err := listAllBackups(layout.hostDirectory(hostname), bucket, minioClient)
+		if err != nil {
+			return withStage(stageList, err)
+		}
+
+		_, expected := configStruct.Fleet.hostConfig(hostname)
+		if len(backups) == 0 && !expected {
+			// Not a host, or a host that never finished a backup and isn't configured
+			continue
+		}
+
+		statuses = append(statuses, buildFleetHostStatus(hostname, backups, configStruct.Fleet, now))
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(statuses)
+		if err != nil {
+			return err
+		}
+	} else {
+		writeFleetStatusTable(os.Stdout, statuses, now)
+	}
+
+	behind := 0
+	for _, status := range statuses {
+		if status.Status != fleetStatusOK {
+			behind++
+		}
+	}
+
+	if behind > 0 {
+		return fmt.Errorf("%d of %d %s behind", behind, len(statuses), pluralize(len(statuses), "host is", "hosts are"))
+	}
+
+	return nil
+}
+
+// listBucketHostnames returns every directory in the bucket that starts with hostsPrefix, without hostsPrefix
+func listBucketHostnames(bucket string, hostsPrefix string, minioClient *minio.Client) ([]string, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	hostnames := make([]string, 0)
+	for object := range minioClient.ListObjectsV2(bucket, hostsPrefix, false, doneCh) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+
+		if strings.HasSuffix(object.Key, "/") {
+			hostnames = append(hostnames, strings.TrimSuffix(strings.TrimPrefix(object.Key, hostsPrefix), "/"))
+		}
+	}
+
+	return hostnames, nil
+}
+
+func buildFleetHostStatus(hostname string, backups []backupItem, fleetConfig *FleetConfig, now time.Time) fleetHostStatus {
+	status := fleetHostStatus{
+		Hostname: hostname,
+		Backups:  len(backups),
+	}
+
+	lineages := make(map[int64]bool)
+	fullBackups := make([]backupItem, 0)
+
+	// Backups are sorted newest first
+	for index := range backups {
+		backup := backups[index]
+
+		lineages[backup.LineageID] = true
+		status.TotalSizeInBytes += backup.Size
+
+		if status.LastBackupAt == nil {
+			status.LastBackupAt = &backup.CreatedAt
+		}
+
+		if backup.BackupType == backupTypeFull {
+			fullBackups = append(fullBackups, backup)
+			if status.LastFullAt == nil {
+				status.LastFullAt = &backup.CreatedAt
+			}
+		} else if status.LastIncrementalAt == nil {
+			status.LastIncrementalAt = &backup.CreatedAt
+		}
+	}
+	status.Lineages = len(lineages)
+
+	hostConfig, _ := fleetConfig.hostConfig(hostname)
+
+	status.ExpectedIntervalSource = fleetIntervalFromConfig
+	status.ExpectedIntervalHours = float64(hostConfig.ExpectedIntervalHours)
+	if status.ExpectedIntervalHours == 0 {
+		status.ExpectedIntervalHours, status.ExpectedIntervalSource = inferIntervalHours(backups)
+	}
+
+	status.ExpectedFullIntervalHours = float64(hostConfig.ExpectedFullIntervalHours)
+	if status.ExpectedFullIntervalHours == 0 {
+		status.ExpectedFullIntervalHours, _ = inferIntervalHours(fullBackups)
+		if len(fullBackups) < 2 {
+			status.ExpectedFullIntervalHours = 0
+		}
+	}
+
+	switch {
+	case status.LastBackupAt == nil:
+		status.Status = fleetStatusNoBackups
+		status.Reason = "no backups found"
+	case isBehind(*status.LastBackupAt, status.ExpectedIntervalHours, now):
+		status.Status = fleetStatusBehind
+		status.Reason = fmt.Sprintf("last backup is %s old, expected every %s", formatHours(now.Sub(*status.LastBackupAt).Hours()), formatHours(status.ExpectedIntervalHours))
+	case status.LastFullAt == nil:
+		status.Status = fleetStatusBehind
+		status.Reason = "no full backup found"
+	case status.ExpectedFullIntervalHours > 0 && isBehind(*status.LastFullAt, status.ExpectedFullIntervalHours, now):
+		status.Status = fleetStatusBehind
+		status.Reason = fmt.Sprintf("last full backup is %s old, expected every %s", formatHours(now.Sub(*status.LastFullAt).Hours()), formatHours(status.ExpectedFullIntervalHours))
+	default:
+		status.Status = fleetStatusOK
+	}
+
+	return status
+}
+
+// inferIntervalHours returns the median time between the most recent backups, which are sorted newest first
+func inferIntervalHours(backups []backupItem) (float64, string) {
+	const sampleSize = 20
+
+	gaps := make([]float64, 0, sampleSize)
+	for index := 1; index < len(backups) && index <= sampleSize; index++ {
+		gaps = append(gaps, backups[index-1].CreatedAt.Sub(backups[index].CreatedAt).Hours())
+	}
+
+	if len(gaps) == 0 {
+		return fleetDefaultIntervalHours, fleetIntervalDefault
+	}
+
+	sort.Float64s(gaps)
+	median := gaps[len(gaps)/2]
+	if len(gaps)%2 == 0 {
+		median = (gaps[len(gaps)/2-1] + gaps[len(gaps)/2]) / 2
+	}
+
+	if median <= 0 {
+		return fleetDefaultIntervalHours, fleetIntervalDefault
+	}
+
+	return median, fleetIntervalInferred
+}
+
+func isBehind(lastAt time.Time, expectedIntervalHours float64, now time.Time) bool {
+	return now.Sub(lastAt).Hours() > expectedIntervalHours*fleetStalenessFactor
+}
+
+func writeFleetStatusTable(output io.Writer, statuses []fleetHostStatus, now time.Time) {
+	if len(statuses) == 0 {
+		fmt.Fprintln(output, "No hosts with backups found")
+		return
+	}
+
+	formatAge := func(at *time.Time) string {
+		if at == nil {
+			return "never"
+		}
+		return formatHours(now.Sub(*at).Hours()) + " ago"
+	}
+
+	table := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "HOST\tSTATUS\tLAST FULL\tLAST INCREMENTAL\tEXPECTED EVERY\tLINEAGES\tBACKUPS\tSIZE\tREASON")
+	for _, status := range statuses {
+		expected := formatHours(status.ExpectedIntervalHours)
+		if status.ExpectedIntervalSource != fleetIntervalFromConfig {
+			expected += " (" + status.ExpectedIntervalSource + ")"
+		}
+
+		fmt.Fprintf(
+			table,
+			"%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			status.Hostname,
+			strings.ToUpper(status.Status),
+			formatAge(status.LastFullAt),
+			formatAge(status.LastIncrementalAt),
+			expected,
+			status.Lineages,
+			status.Backups,
+			pkg.FormatBytes(status.TotalSizeInBytes),
+			status.Reason,
+		)
+	}
+	table.Flush()
+}
+
+func formatHours(hours float64) string {
+	if hours >= 48 {
+		return fmt.Sprintf("%.1fd", hours/24)
+	}
+	if hours < 1 {
+		return fmt.Sprintf("%.0fm", hours*60)
+	}
+	return fmt.Sprintf("%.1fh", hours)
+}
+
+func appendIfMissing(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
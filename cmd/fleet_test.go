@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildFleetHostStatus(t *testing.T) {
+	backups := []backupItem{
+		buildBackup(2, "db1/mysql-backup-201901021200.incremental.xbstream", 10),
+		buildBackup(2, "db1/mysql-backup-201901021100.incremental.xbstream", 10),
+		buildBackup(2, "db1/mysql-backup-201901021000.full.xbstream", 100),
+		buildBackup(1, "db1/mysql-backup-201901011000.full.xbstream", 100),
+	}
+
+	now, _ := parseBackupTimestamp("201901021230")
+
+	status := buildFleetHostStatus("db1", backups, nil, now)
+
+	if status.Status != fleetStatusOK {
+		t.Errorf("Expected host to be ok: %s (%s)", status.Status, status.Reason)
+	}
+
+	if status.Lineages != 2 || status.Backups != 4 || status.TotalSizeInBytes != 220 {
+		t.Errorf("Incorrect counts: %d lineages, %d backups, %d bytes", status.Lineages, status.Backups, status.TotalSizeInBytes)
+	}
+
+	if status.LastFullAt == nil || status.LastFullAt.Format("200601021504") != "201901021000" {
+		t.Errorf("Incorrect last full backup: %v", status.LastFullAt)
+	}
+
+	if status.LastIncrementalAt == nil || status.LastIncrementalAt.Format("200601021504") != "201901021200" {
+		t.Errorf("Incorrect last incremental backup: %v", status.LastIncrementalAt)
+	}
+
+	if status.ExpectedIntervalHours != 1 || status.ExpectedIntervalSource != fleetIntervalInferred {
+		t.Errorf("Expected an inferred interval of 1 hour: %v (%s)", status.ExpectedIntervalHours, status.ExpectedIntervalSource)
+	}
+
+	if status.ExpectedFullIntervalHours != 24 {
+		t.Errorf("Expected an inferred full interval of 24 hours: %v", status.ExpectedFullIntervalHours)
+	}
+
+	status = buildFleetHostStatus("db1", backups, nil, now.Add(2*time.Hour))
+	if status.Status != fleetStatusBehind || status.Reason != "last backup is 2.5h old, expected every 1.0h" {
+		t.Errorf("Expected host to be behind: %s (%s)", status.Status, status.Reason)
+	}
+}
+
+func TestBuildFleetHostStatusWithConfig(t *testing.T) {
+	fleetConfig := &FleetConfig{
+		FleetHostConfig: FleetHostConfig{ExpectedIntervalHours: 1, ExpectedFullIntervalHours: 12},
+		Hosts: map[string]FleetHostConfig{
+			"db2": {ExpectedIntervalHours: 48},
+		},
+	}
+
+	backups := []backupItem{
+		buildBackup(1, "db2/mysql-backup-201901011000.full.xbstream", 100),
+	}
+
+	now, _ := parseBackupTimestamp("201901021200")
+
+	status := buildFleetHostStatus("db2", backups, fleetConfig, now)
+	if status.ExpectedIntervalHours != 48 || status.ExpectedIntervalSource != fleetIntervalFromConfig {
+		t.Errorf("Expected the host interval from the config: %v (%s)", status.ExpectedIntervalHours, status.ExpectedIntervalSource)
+	}
+
+	if status.Status != fleetStatusBehind || status.Reason != "last full backup is 26.0h old, expected every 12.0h" {
+		t.Errorf("Expected full backup to be behind: %s (%s)", status.Status, status.Reason)
+	}
+
+	status = buildFleetHostStatus("db3", nil, fleetConfig, now)
+	if status.Status != fleetStatusNoBackups {
+		t.Errorf("Expected host without backups: %s", status.Status)
+	}
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"github.com/feederco/really-simple-db-backup/pkg"
+)
+
+func heartbeatForJob(job string) *pkg.HeartbeatConfig {
+	if configStruct.Heartbeat == nil {
+		return nil
+	}
+
+	switch jobFamily(job) {
+	case "perform":
+		return configStruct.Heartbeat.Perform
+	case "prune":
+		return configStruct.Heartbeat.Prune
+	}
+	return nil
+}
+
+// pingHeartbeat pings the heartbeat of the job of run, if any. A failing ping never fails the run
+func pingHeartbeat(run *runRecord, signal string) {
+	config := heartbeatForJob(run.Job)
+	if config == nil {
+		return
+	}
+
+	err := pkg.PingHeartbeat(config, signal, pkg.HeartbeatPayload{
+		Job:             run.Job,
+		Hostname:        run.Hostname,
+		DurationSeconds: run.DurationSeconds,
+		BackupType:      run.BackupType,
+		BackupName:      run.BackupName,
+		SizeInBytes:     run.SizeInBytes,
+		Error:           run.Error,
+	})
+	if err != nil {
+		pkg.ErrorLog.Printf("Warning: Could not ping %s heartbeat. %s\n", signal, err)
+	}
+}
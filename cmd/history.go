@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+const historyDirectory = "_history"
+
+// Client used to mirror the run journal to the bucket. Nil until a bucket is configured
+var historyClient *minio.Client
+
+func historyObjectName(run *runRecord) string {
+	return path.Join(run.Hostname, historyDirectory, run.StartedAt.UTC().Format("20060102T150405Z")+"-"+run.ID+".json")
+}
+
+// mirrorRunToBucket uploads run to <hostname>/_history/ so the history survives the droplet
+func mirrorRunToBucket(run *runRecord) error {
+	if historyClient == nil || run.Hostname == "" {
+		return nil
+	}
+
+	contents, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	_, err = historyClient.PutObject(
+		configStruct.DigitalOcean.SpaceName,
+		historyObjectName(run),
+		bytes.NewReader(contents),
+		int64(len(contents)),
+		minio.PutObjectOptions{ContentType: "application/json"},
+	)
+	return err
+}
+
+// readBucketHistory returns the last limit runs of hostname mirrored to the bucket, oldest first
+func readBucketHistory(hostname string, bucket string, limit int, minioClient *minio.Client) ([]runRecord, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	objectNames := make([]string, 0)
+	for object := range minioClient.ListObjectsV2(bucket, path.Join(hostname, historyDirectory)+"/", true, doneCh) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objectNames = append(objectNames, object.Key)
+	}
+
+	// Object names start with the time the run started
+	sort.Strings(objectNames)
+	if limit > 0 && len(objectNames) > limit {
+		objectNames = objectNames[len(objectNames)-limit:]
+	}
+
+	runs := make([]runRecord, 0, len(objectNames))
+	for _, objectName := range objectNames {
+		object, err := minioClient.GetObject(bucket, objectName, minio.GetObjectOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		contents, err := ioutil.ReadAll(object)
+		object.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var run runRecord
+		if err := json.Unmarshal(contents, &run); err != nil {
+			pkg.ErrorLog.Printf("Warning: Skipping unreadable history entry %s. %s\n", objectName, err)
+			continue
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
+
+// loadRunHistory returns the last limit runs of hostname, oldest first. The local journal is used for this host,
+// the bucket mirror for other hosts
+func loadRunHistory(hostname string, bucket string, limit int, minioClient *minio.Client) ([]runRecord, error) {
+	if hostname != configStruct.hostDirectory() {
+		return readBucketHistory(hostname, bucket, limit, minioClient)
+	}
+
+	allRuns, err := readJournal(configStruct.PersistentStorage, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]runRecord, 0, len(allRuns))
+	for _, run := range allRuns {
+		// Runs recorded before hostnames were added to the journal
+		if run.Hostname == "" || run.Hostname == hostname {
+			runs = append(runs, run)
+		}
+	}
+
+	if limit > 0 && len(runs) > limit {
+		runs = runs[len(runs)-limit:]
+	}
+
+	return runs, nil
+}
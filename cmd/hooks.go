@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+// Points of a backup or restore where a hook can run
+const hookPreBackup = "pre_backup"
+const hookPostBackup = "post_backup"
+const hookOnFailure = "on_failure"
+const hookPreRestore = "pre_restore"
+const hookPostRestore = "post_restore"
+
+const hookOnFailureTimeout = 10 * time.Minute
+
+// HooksConfig contains the commands run around backups and restores
+type HooksConfig struct {
+	PreBackup   *pkg.HookConfig `json:"pre_backup"`
+	PostBackup  *pkg.HookConfig `json:"post_backup"`
+	OnFailure   *pkg.HookConfig `json:"on_failure"`
+	PreRestore  *pkg.HookConfig `json:"pre_restore"`
+	PostRestore *pkg.HookConfig `json:"post_restore"`
+}
+
+func (hooksConfig *HooksConfig) hook(name string) *pkg.HookConfig {
+	if hooksConfig == nil {
+		return nil
+	}
+
+	switch name {
+	case hookPreBackup:
+		return hooksConfig.PreBackup
+	case hookPostBackup:
+		return hooksConfig.PostBackup
+	case hookOnFailure:
+		return hooksConfig.OnFailure
+	case hookPreRestore:
+		return hooksConfig.PreRestore
+	case hookPostRestore:
+		return hooksConfig.PostRestore
+	}
+	return nil
+}
+
+// hookBackup describes the backup a hook runs for. Fields that aren't known yet are left empty
+type hookBackup struct {
+	Type    string
+	File    string // Local path
+	Object  string // Path in the bucket
+	Size    int64
+	Lineage string // Path in the bucket of the full backup the backup builds on
+}
+
+// hookRestore describes the backup being restored by the run in progress
+func hookRestore(run *runRecord) hookBackup {
+	if run == nil || len(run.restoredBackups) == 0 {
+		return hookBackup{}
+	}
+
+	newest := run.restoredBackups[0]
+	backup := hookBackup{
+		Type:    newest.BackupType,
+		Object:  newest.Path,
+		Lineage: run.restoredBackups[len(run.restoredBackups)-1].Path,
+	}
+	for _, piece := range run.restoredBackups {
+		backup.Size += piece.Size
+	}
+
+	return backup
+}
+
+// lineageOfLatestBackup returns the path of the full backup the latest backup of hostname builds on
+func lineageOfLatestBackup(hostname string, bucketName string, minioClient *minio.Client) (string, error) {
+	allBackups, err := listAllBackups(hostname, bucketName, minioClient)
+	if err != nil {
+		return "", err
+	}
+
+	backups := findRelevantBackupsUpTo(time.Now(), allBackups)
+	if len(backups) == 0 {
+		return "", nil
+	}
+	return backups[len(backups)-1].Path, nil
+}
+
+// hookEnvironment returns the variables describing run and backup to a hook
+func hookEnvironment(name string, run *runRecord, backup hookBackup) []string {
+	variables := map[string]string{
+		"BACKUP_HOOK":            name,
+		"BACKUP_TYPE":            backup.Type,
+		"BACKUP_FILE":            backup.File,
+		"BACKUP_OBJECT":          backup.Object,
+		"BACKUP_LINEAGE":         backup.Lineage,
+		"BACKUP_MYSQL_DATA_PATH": configStruct.Mysql.DataPath,
+	}
+
+	if backup.Size > 0 {
+		variables["BACKUP_SIZE"] = strconv.FormatInt(backup.Size, 10)
+	}
+
+	if run != nil {
+		variables["BACKUP_RUN_ID"] = run.ID
+		variables["BACKUP_JOB"] = run.Job
+		variables["BACKUP_HOSTNAME"] = run.Hostname
+		variables["BACKUP_FAILED_STAGE"] = run.FailedStage
+		variables["BACKUP_ERROR"] = run.Error
+	}
+
+	environment := make([]string, 0, len(variables))
+	for _, key := range []string{
+		"BACKUP_HOOK",
+		"BACKUP_RUN_ID",
+		"BACKUP_JOB",
+		"BACKUP_HOSTNAME",
+		"BACKUP_TYPE",
+		"BACKUP_FILE",
+		"BACKUP_OBJECT",
+		"BACKUP_SIZE",
+		"BACKUP_LINEAGE",
+		"BACKUP_MYSQL_DATA_PATH",
+		"BACKUP_FAILED_STAGE",
+		"BACKUP_ERROR",
+	} {
+		if variables[key] != "" {
+			environment = append(environment, key+"="+variables[key])
+		}
+	}
+
+	return environment
+}
+
+// runHook runs the hook called name, if configured. A failing pre hook with `abort_on_failure` returns an error that stops the run.
+// Other failures are alerted as warnings and the run continues
+func runHook(ctx context.Context, name string, backup hookBackup) error {
+	hook := configStruct.Hooks.hook(name)
+	if hook == nil || hook.Command == "" {
+		return nil
+	}
+
+	pkg.Log.Printf("Running %s hook\n", name)
+
+	err := pkg.RunHook(ctx, hook, hookEnvironment(name, activeRun, backup))
+	if err == nil {
+		return nil
+	}
+
+	if hook.AbortOnFailure && strings.HasPrefix(name, "pre_") {
+		err = fmt.Errorf("%s hook failed: %w", name, err)
+		alertError(stageHook, fmt.Sprintf("The %s hook failed. Stopping.", name), err)
+		return withStage(stageHook, err)
+	}
+
+	alertWarning(stageHook, fmt.Sprintf("The %s hook failed. Continuing.", name), err)
+	return nil
+}
+
+// runFailureHook runs the `on_failure` hook for a failed backup or restore. Its own failure is only logged
+func runFailureHook(run *runRecord) {
+	hook := configStruct.Hooks.hook(hookOnFailure)
+	if hook == nil || hook.Command == "" {
+		return
+	}
+
+	family := jobFamily(run.Job)
+	if family != "perform" && family != "restore" {
+		return
+	}
+
+	backup := hookRestore(run)
+	if family == "perform" {
+		backup = hookBackup{Type: run.BackupType, Object: run.BackupObject, Size: run.SizeInBytes}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookOnFailureTimeout)
+	defer cancel()
+
+	pkg.Log.Printf("Running %s hook\n", hookOnFailure)
+	err := pkg.RunHook(ctx, hook, hookEnvironment(hookOnFailure, run, backup))
+	if err != nil {
+		pkg.ErrorLog.Printf("Warning: The %s hook failed. %s\n", hookOnFailure, err)
+	}
+}
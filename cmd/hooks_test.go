@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+)
+
+func TestHookEnvironment(t *testing.T) {
+	previousConfig := configStruct
+	defer func() { configStruct = previousConfig }()
+	configStruct = ConfigStruct{}
+	configStruct.Mysql.DataPath = "/var/lib/mysql"
+
+	run := &runRecord{ID: "abc", Job: "perform-incremental", Hostname: "db1"}
+	run.restoredBackups = []backupItem{
+		buildBackup(1, "db1/mysql-backup-201901011200.incremental.xbstream", 10),
+		buildBackup(1, "db1/mysql-backup-201901011000.full.xbstream", 100),
+	}
+
+	environment := hookEnvironment(hookPreRestore, run, hookRestore(run))
+
+	expected := []string{
+		"BACKUP_HOOK=pre_restore",
+		"BACKUP_RUN_ID=abc",
+		"BACKUP_JOB=perform-incremental",
+		"BACKUP_HOSTNAME=db1",
+		"BACKUP_TYPE=incremental",
+		"BACKUP_OBJECT=db1/mysql-backup-201901011200.incremental.xbstream",
+		"BACKUP_SIZE=110",
+		"BACKUP_LINEAGE=db1/mysql-backup-201901011000.full.xbstream",
+		"BACKUP_MYSQL_DATA_PATH=/var/lib/mysql",
+	}
+
+	if !reflect.DeepEqual(environment, expected) {
+		t.Errorf("Incorrect environment:\n%v\nexpected:\n%v", environment, expected)
+	}
+}
+
+func TestRunHookAbortsOnlyPreHooks(t *testing.T) {
+	pkg.Log = log.New(ioutil.Discard, "", 0)
+	pkg.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	directory, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	failing := &pkg.HookConfig{Command: "exit 1", AbortOnFailure: true}
+
+	previousConfig := configStruct
+	defer func() { configStruct = previousConfig }()
+	configStruct = ConfigStruct{
+		PersistentStorage: directory,
+		Hooks:             &HooksConfig{PreBackup: failing, PostBackup: failing},
+	}
+
+	run := startRun("perform", "db1")
+	defer recordJob(run, nil)
+
+	err = runHook(context.Background(), hookPreBackup, hookBackup{Type: backupTypeFull})
+	if err == nil || errorStage(err) != stageHook {
+		t.Errorf("Expected a failing pre hook to stop the run: %v", err)
+	}
+
+	err = runHook(context.Background(), hookPostBackup, hookBackup{Type: backupTypeFull})
+	if err != nil {
+		t.Errorf("Expected a failing post hook to only warn: %v", err)
+	}
+
+	err = runHook(context.Background(), hookPreRestore, hookBackup{})
+	if err != nil {
+		t.Errorf("Expected a hook that isn't configured to do nothing: %v", err)
+	}
+}
+
+func TestFailureHookGetsTheUploadedObject(t *testing.T) {
+	pkg.Log = log.New(ioutil.Discard, "", 0)
+	pkg.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	directory, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	objectFile := path.Join(directory, "object")
+
+	previousConfig := configStruct
+	defer func() { configStruct = previousConfig }()
+	configStruct = ConfigStruct{
+		Hooks: &HooksConfig{OnFailure: &pkg.HookConfig{Command: "printf %s \"$BACKUP_OBJECT\" > " + objectFile}},
+	}
+
+	run := &runRecord{ID: "abc", Job: "perform-full", Hostname: "db1"}
+	run.noteBackup(backupTypeFull, "20190123T203941Z.xbstream", "backups/production/db1/full/20190123T203941Z.xbstream", 100)
+
+	runFailureHook(run)
+
+	object, err := ioutil.ReadFile(objectFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(object) != "backups/production/db1/full/20190123T203941Z.xbstream" {
+		t.Errorf("Expected the key the backup was uploaded as, got %s", object)
+	}
+}
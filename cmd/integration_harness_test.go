@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	metadata "github.com/digitalocean/go-metadata"
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+const integrationBucket = "backups"
+
+// Seconds so the backups of a test don't share a timestamp
+const integrationKeyTemplate = "{hostname}/mysql-backup-{timestamp_seconds}.{type}.xbstream"
+
+// integrationHarness runs commands end to end against a fake DigitalOcean API, a fake bucket and fake
+// MySQL and Percona binaries on PATH. Everything happens in a temporary directory, without network or root
+type integrationHarness struct {
+	t *testing.T
+
+	Root              string
+	DataPath          string
+	PersistentStorage string
+	ConfigPath        string
+
+	DigitalOcean *fakeDigitalOcean
+	Storage      *fakeObjectStorage
+
+	restore []func()
+}
+
+// fakeBinaries are shell scripts standing in for the programs run during backups and restores.
+// Every call is appended to commands.log in the root of the harness. The data directory of xtrabackup is {{datadir}}
+var fakeBinaries = map[string]string{
+	// Streams the data directory as a tar archive where the real one streams xbstream.
+	// LSNs grow by 100 every backup
+	"xtrabackup": `mode=""
+target=""
+datadir='{{datadir}}'
+lsndir=""
+incremental=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+		--backup|--prepare|--copy-back|--decompress) mode="$1" ;;
+		--target-dir) target="$2"; shift ;;
+		--datadir) datadir="$2"; shift ;;
+		--extra-lsndir) lsndir="$2"; shift ;;
+		--incremental-dir) incremental="$2"; shift ;;
+	esac
+	shift
+done
+
+case "$mode" in
+	--backup)
+		from=$(sed -n 's/^to_lsn = //p' "$lsndir/xtrabackup_checkpoints" 2>/dev/null)
+		from=${from:-0}
+		printf 'backup_type = full-backuped\nfrom_lsn = %s\nto_lsn = %s\nlast_lsn = %s\n' "$from" $((from + 100)) $((from + 100)) > "$lsndir/xtrabackup_checkpoints"
+		# Fails after moving the checkpoint on, when the test asks for it
+		if [ -e '{{root}}/fail-backup' ]; then
+			echo 'xtrabackup: failed to stream the backup' >&2
+			exit 1
+		fi
+		exec tar -C "$datadir" -cf - .
+		;;
+	--prepare)
+		if [ -n "$incremental" ]; then
+			cp -R "$incremental/." "$target/"
+		fi
+		;;
+	--copy-back)
+		mkdir -p "$datadir" && cp -R "$target/." "$datadir/"
+		;;
+esac
+`,
+	"xbstream": `while [ $# -gt 0 ]; do
+	case "$1" in
+		-C) directory="$2"; shift ;;
+	esac
+	shift
+done
+exec tar -xf - -C "$directory"
+`,
+	"mysqld": `case "$1" in
+	--version) echo '/usr/sbin/mysqld  Ver 8.0.19 for Linux on x86_64 (MySQL Community Server - GPL)' ;;
+	--verbose) printf 'Default options are read from the following files in the given order:\n%s\n' '{{root}}/my.cnf' ;;
+esac
+`,
+	// The binary logs are the files in {{root}}/binlogs
+	"mysql": `case "$*" in
+	*"SELECT @@version"*) echo 8.0.19 ;;
+	*"SELECT @@log_bin_basename"*) echo '{{root}}/binlogs/binlog' ;;
+	*"SHOW BINARY LOGS"*)
+		for file in '{{root}}'/binlogs/binlog.[0-9]*; do
+			printf '%s\t%s\tNo\n' "$(basename "$file")" "$(wc -c < "$file" | tr -d ' ')"
+		done
+		;;
+esac
+`,
+	// `restore` moves the data directory out of the way before copying the backup back
+	"mv": `rm -rf '{{root}}/mysql-before-restore'
+exec /bin/mv "$1" '{{root}}/mysql-before-restore'
+`,
+	"mount":  "",
+	"umount": "",
+	"chown":  "",
+	"qpress": "",
+}
+
+func newIntegrationHarness(t *testing.T) *integrationHarness {
+	if testing.Short() {
+		t.Skip("Skipping the integration test in short mode")
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("The fake binaries are shell scripts")
+	}
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("The fake xtrabackup needs tar")
+	}
+
+	root, err := ioutil.TempDir("", "rsdb-integration")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	harness := &integrationHarness{
+		t:                 t,
+		Root:              root,
+		DataPath:          path.Join(root, "mysql"),
+		PersistentStorage: path.Join(root, "persistent"),
+		ConfigPath:        path.Join(root, "config.json"),
+		DigitalOcean:      newFakeDigitalOcean(),
+		Storage:           newFakeObjectStorage(),
+	}
+
+	for _, directory := range []string{harness.DataPath, harness.PersistentStorage, path.Join(root, "bin"), path.Join(root, "mnt")} {
+		harness.must(os.MkdirAll(directory, 0700))
+	}
+	harness.must(ioutil.WriteFile(path.Join(root, "my.cnf"), []byte("[mysqld]\ndatadir="+harness.DataPath+"\n"), 0644))
+
+	for name, script := range fakeBinaries {
+		script = strings.NewReplacer("{{root}}", root, "{{datadir}}", harness.DataPath).Replace(script)
+		script = "#!/bin/sh\necho \"" + name + " $*\" >> '" + path.Join(root, "commands.log") + "'\n" + script
+		harness.must(ioutil.WriteFile(path.Join(root, "bin", name), []byte(script), 0755))
+	}
+
+	harness.setEnv("PATH", path.Join(root, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	metadataURL, _ := url.Parse(harness.DigitalOcean.URL)
+	previousMetadataOptions := pkg.MetadataOptions
+	previousSettleTime := pkg.VolumeSettleTime
+	previousMountRoot := volumeMountRoot
+	previousRunAs := requiredRunAs
+	previousConnectClients := connectClients
+	previousLog, previousErrorLog := pkg.Log, pkg.ErrorLog
+	previousRunner := pkg.CommandRunner
+	harness.restore = append(harness.restore, func() {
+		pkg.MetadataOptions = previousMetadataOptions
+		pkg.VolumeSettleTime = previousSettleTime
+		volumeMountRoot = previousMountRoot
+		requiredRunAs = previousRunAs
+		connectClients = previousConnectClients
+		pkg.Log, pkg.ErrorLog = previousLog, previousErrorLog
+		pkg.CommandRunner = previousRunner
+		configStruct = ConfigStruct{}
+		historyClient = nil
+	})
+
+	pkg.MetadataOptions = []metadata.ClientOption{metadata.WithBaseURL(metadataURL)}
+	pkg.VolumeSettleTime = 0
+	volumeMountRoot = path.Join(root, "mnt")
+	if currentUser, err := user.Current(); err == nil {
+		requiredRunAs = currentUser.Name
+	}
+	connectClients = harness.connectClients
+
+	harness.WriteConfig(nil)
+	return harness
+}
+
+// Close stops the fakes and removes everything the harness created
+func (harness *integrationHarness) Close() {
+	for index := len(harness.restore) - 1; index >= 0; index-- {
+		harness.restore[index]()
+	}
+
+	harness.DigitalOcean.Close()
+	harness.Storage.Close()
+	os.RemoveAll(harness.Root)
+}
+
+// WriteConfig writes the config commands run with. Keys of overrides replace the top level keys of the default config
+func (harness *integrationHarness) WriteConfig(overrides map[string]interface{}) {
+	config := map[string]interface{}{
+		"digitalocean": map[string]string{
+			"key":            "fake-token",
+			"space_endpoint": harness.Storage.Endpoint(),
+			"space_name":     integrationBucket,
+			"space_key":      "fake-key",
+			"space_secret":   "fake-secret",
+		},
+		"mysql":              map[string]string{"data_path": harness.DataPath},
+		"persistent_storage": harness.PersistentStorage,
+		"storage_prefix":     harness.DigitalOcean.Droplet.Hostname,
+		"layout":             map[string]string{"key_template": integrationKeyTemplate},
+	}
+
+	for key, value := range overrides {
+		config[key] = value
+	}
+
+	contents, err := json.MarshalIndent(config, "", "  ")
+	harness.must(err)
+	harness.must(ioutil.WriteFile(harness.ConfigPath, contents, 0600))
+}
+
+// Run runs a command of the CLI with the config of the harness
+func (harness *integrationHarness) Run(name string, args ...string) error {
+	cmd := findCommand(name)
+	if cmd == nil {
+		harness.t.Fatalf("Unknown command %s", name)
+	}
+
+	return runCommand(cmd, append(args, "-config", harness.ConfigPath))
+}
+
+// MustRun runs a command of the CLI and fails the test if it fails
+func (harness *integrationHarness) MustRun(name string, args ...string) {
+	if err := harness.Run(name, args...); err != nil {
+		harness.t.Fatalf("`%s %s` failed: %s", name, strings.Join(args, " "), err)
+	}
+}
+
+// Commands returns the calls to the fake binaries so far, like `xtrabackup --prepare --target-dir ...`
+func (harness *integrationHarness) Commands() []string {
+	contents, _ := ioutil.ReadFile(path.Join(harness.Root, "commands.log"))
+	return strings.Split(strings.TrimSpace(string(contents)), "\n")
+}
+
+// WriteData writes files relative to the MySQL data directory
+func (harness *integrationHarness) WriteData(files map[string]string) {
+	for name, contents := range files {
+		harness.must(os.MkdirAll(path.Dir(path.Join(harness.DataPath, name)), 0700))
+		harness.must(ioutil.WriteFile(path.Join(harness.DataPath, name), []byte(contents), 0600))
+	}
+}
+
+// ExpectData fails the test unless the files exist in the MySQL data directory with these contents
+func (harness *integrationHarness) ExpectData(files map[string]string) {
+	for name, expected := range files {
+		contents, err := ioutil.ReadFile(path.Join(harness.DataPath, name))
+		if err != nil {
+			harness.t.Errorf("Expected %s in the data directory: %s", name, err)
+		} else if string(contents) != expected {
+			harness.t.Errorf("Expected %s to contain %q, got %q", name, expected, contents)
+		}
+	}
+}
+
+// BackupKey returns the key of the backup of backupType created at createdAt in the layout of the harness
+func (harness *integrationHarness) BackupKey(createdAt time.Time, backupType string) string {
+	layout, err := newObjectLayout(&LayoutConfig{KeyTemplate: integrationKeyTemplate})
+	harness.must(err)
+	return layout.backupKey(harness.DigitalOcean.Droplet.Hostname, createdAt, backupType)
+}
+
+func (harness *integrationHarness) connectClients(config ConfigStruct) (*pkg.DigitalOceanClient, *minio.Client, error) {
+	digitalOceanClient := pkg.NewDigitalOceanClient(config.DigitalOcean.Key)
+	digitalOceanClient.Client.BaseURL, _ = url.Parse(harness.DigitalOcean.URL + "/")
+
+	minioClient, err := harness.Storage.MinioClient()
+	return digitalOceanClient, minioClient, err
+}
+
+func (harness *integrationHarness) setEnv(key string, value string) {
+	previous, existed := os.LookupEnv(key)
+	harness.restore = append(harness.restore, func() {
+		if existed {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+	os.Setenv(key, value)
+}
+
+func (harness *integrationHarness) must(err error) {
+	if err != nil {
+		harness.t.Fatal(err)
+	}
+}
@@ -0,0 +1,357 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestPerformRestoreAndPruneCycle(t *testing.T) {
+	harness := newIntegrationHarness(t)
+	defer harness.Close()
+
+	harness.WriteConfig(map[string]interface{}{
+		"retention": map[string]int{"retention_in_days": 30},
+		"commands":  map[string]string{"transcript_directory": path.Join(harness.Root, "transcripts")},
+	})
+
+	harness.WriteData(map[string]string{
+		"ibdata1":      "full",
+		"posts/1.ibd":  "first post",
+		"auto.cnf":     "server-uuid=1",
+		"mysql/db.ibd": "grants",
+	})
+	harness.MustRun("perform-full")
+
+	// Backup keys have a resolution of seconds
+	time.Sleep(time.Second)
+
+	harness.WriteData(map[string]string{
+		"ibdata1":     "incremental",
+		"posts/2.ibd": "second post",
+	})
+	harness.MustRun("perform-incremental")
+
+	backups := make([]string, 0)
+	for _, key := range harness.Storage.Keys(integrationBucket, "db1/") {
+		if !strings.Contains(key, "/_history/") {
+			backups = append(backups, key)
+		}
+	}
+	if len(backups) != 3 || !strings.HasSuffix(backups[0], ".full.server.tar.gz") || !strings.HasSuffix(backups[1], ".full.xbstream") || !strings.HasSuffix(backups[2], ".incremental.xbstream") {
+		t.Fatalf("Expected a full backup with its server config and an incremental backup, got %v", backups)
+	}
+
+	if !containsCommand(harness.Commands(), "xtrabackup --backup", "--incremental-lsn 100") {
+		t.Errorf("Expected the incremental backup to continue from the LSN of the full backup, ran %v", harness.Commands())
+	}
+
+	if volumes := harness.DigitalOcean.Volumes(); len(volumes) != 0 {
+		t.Errorf("Expected the volumes of the backups to be destroyed, found %v", volumes)
+	}
+
+	transcripts, _ := filepath.Glob(path.Join(harness.Root, "transcripts", "*-perform-incremental-*.log"))
+	if len(transcripts) != 1 {
+		t.Fatalf("Expected a transcript of the incremental backup, found %v", transcripts)
+	}
+	transcript, _ := ioutil.ReadFile(transcripts[0])
+	if !strings.Contains(string(transcript), "$ xtrabackup --backup") || !strings.Contains(string(transcript), "succeeded after") {
+		t.Errorf("Expected the transcript to record xtrabackup, got:\n%s", transcript)
+	}
+
+	harness.MustRun("list-backups")
+
+	harness.WriteData(map[string]string{"ibdata1": "corrupted"})
+	harness.MustRun("restore", "-yes")
+
+	harness.ExpectData(map[string]string{
+		"ibdata1":      "incremental",
+		"posts/1.ibd":  "first post",
+		"posts/2.ibd":  "second post",
+		"auto.cnf":     "server-uuid=1",
+		"mysql/db.ibd": "grants",
+	})
+
+	if !containsCommand(harness.Commands(), "xtrabackup --prepare", "--apply-log-only") || !containsCommand(harness.Commands(), "xtrabackup --prepare", "--incremental-dir") {
+		t.Errorf("Expected the full backup to be prepared for the incremental backup, ran %v", harness.Commands())
+	}
+
+	events := harness.DigitalOcean.VolumeEvents()
+	if len(events) != 12 || len(harness.DigitalOcean.Volumes()) != 0 {
+		t.Errorf("Expected 3 volumes to be created, attached, detached and destroyed, got %v", events)
+	}
+
+	// A lineage outside of the retention window
+	oldFull := harness.BackupKey(time.Now().AddDate(0, 0, -60), backupTypeFull)
+	oldIncremental := harness.BackupKey(time.Now().AddDate(0, 0, -59), backupTypeIncremental)
+	harness.Storage.Put(integrationBucket, oldFull, []byte("old"))
+	harness.Storage.Put(integrationBucket, serverConfigArchivePath(oldFull), []byte("old"))
+	harness.Storage.Put(integrationBucket, oldIncremental, []byte("old"))
+
+	harness.MustRun("prune", "-yes")
+
+	for _, key := range []string{oldFull, serverConfigArchivePath(oldFull), oldIncremental} {
+		if _, exists := harness.Storage.Get(integrationBucket, key); exists {
+			t.Errorf("Expected %s to be pruned", key)
+		}
+	}
+	for _, key := range backups {
+		if _, exists := harness.Storage.Get(integrationBucket, key); !exists {
+			t.Errorf("Expected %s to be kept", key)
+		}
+	}
+}
+
+func TestRestoreWithoutBackups(t *testing.T) {
+	harness := newIntegrationHarness(t)
+	defer harness.Close()
+
+	err := harness.Run("restore", "-yes")
+	if err == nil || errorStage(err) != stageList {
+		t.Errorf("Expected the restore to fail listing backups, got %v", err)
+	}
+
+	if volumes := harness.DigitalOcean.VolumeEvents(); len(volumes) != 0 {
+		t.Errorf("Expected no volume to be created, got %v", volumes)
+	}
+}
+
+func TestFailedBackupCleansUp(t *testing.T) {
+	harness := newIntegrationHarness(t)
+	defer harness.Close()
+
+	harness.WriteData(map[string]string{"ibdata1": "full"})
+	harness.MustRun("perform-full")
+
+	checkpointPath := path.Join(harness.PersistentStorage, "xtrabackup_checkpoints")
+	checkpoint, err := ioutil.ReadFile(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var alerts []string
+	var alertsMutex sync.Mutex
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		alertsMutex.Lock()
+		alerts = append(alerts, string(body))
+		alertsMutex.Unlock()
+	}))
+	defer webhook.Close()
+
+	harness.WriteConfig(map[string]interface{}{
+		"alerting": map[string]interface{}{
+			"webhooks":       []map[string]string{{"url": webhook.URL}},
+			"throttle_hours": -1,
+		},
+	})
+
+	ioutil.WriteFile(path.Join(harness.Root, "fail-backup"), nil, 0600)
+
+	err = harness.Run("perform-incremental")
+	if err == nil || errorStage(err) != stageXtrabackup {
+		t.Fatalf("Expected the backup to fail in xtrabackup, got %v", err)
+	}
+
+	alertsMutex.Lock()
+	if len(alerts) != 1 || !strings.Contains(alerts[0], "xtrabackup cmd failed") {
+		t.Errorf("Expected one alert about xtrabackup, got %v", alerts)
+	}
+	alertsMutex.Unlock()
+
+	if after, _ := ioutil.ReadFile(checkpointPath); string(after) != string(checkpoint) {
+		t.Errorf("Expected the checkpoint of the last uploaded backup to be put back, got:\n%s", after)
+	}
+
+	if volumes := harness.DigitalOcean.Volumes(); len(volumes) != 0 {
+		t.Errorf("Expected the volume of the failed backup to be destroyed, got %v", harness.DigitalOcean.VolumeEvents())
+	}
+}
+
+func TestGCVolumesLeavesAttachedVolumesAlone(t *testing.T) {
+	harness := newIntegrationHarness(t)
+	defer harness.Close()
+
+	longAgo := time.Now().Add(-30 * 24 * time.Hour)
+	harness.DigitalOcean.AddVolume(godo.Volume{ID: "orphan", Name: "mysql-backup-20190101000000", Tags: []string{volumeTag, volumeHostTag("db1")}, CreatedAt: longAgo})
+	harness.DigitalOcean.AddVolume(godo.Volume{ID: "foreign", Name: "mysql-backup-20190101000001", Tags: []string{volumeTag, volumeHostTag("db2")}, CreatedAt: longAgo})
+	harness.DigitalOcean.AddVolume(godo.Volume{ID: "kept", Name: "mysql-restore-20190101000000", Tags: []string{volumeTag}, DropletIDs: []int{harness.DigitalOcean.Droplet.DropletID}, CreatedAt: longAgo})
+	harness.DigitalOcean.AddVolume(godo.Volume{ID: "other", Name: "mysql-restore-20190102000000", Tags: []string{volumeTag}, DropletIDs: []int{1}, CreatedAt: longAgo})
+
+	harness.MustRun("gc-volumes", "-yes")
+
+	volumes := harness.DigitalOcean.Volumes()
+	if len(volumes) != 3 || volumes[0].ID != "foreign" || volumes[1].ID != "kept" || volumes[2].ID != "other" {
+		t.Errorf("Expected only the unattached volume of this host to be destroyed, got %v", harness.DigitalOcean.VolumeEvents())
+	}
+	if events := harness.DigitalOcean.VolumeEvents(); len(events) != 1 {
+		t.Errorf("Expected no volume to be detached, got %v", events)
+	}
+}
+
+// containsCommand returns whether one of commands starts with prefix and contains argument
+func containsCommand(commands []string, prefix string, argument string) bool {
+	for _, command := range commands {
+		if strings.HasPrefix(command, prefix) && strings.Contains(command, argument) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDryRunsChangeNothing(t *testing.T) {
+	harness := newIntegrationHarness(t)
+	defer harness.Close()
+
+	harness.WriteData(map[string]string{"ibdata1": "full"})
+	harness.MustRun("perform-full")
+
+	keys := harness.Storage.Keys(integrationBucket, "")
+	commands := harness.Commands()
+	events := harness.DigitalOcean.VolumeEvents()
+	journal, _ := ioutil.ReadFile(path.Join(harness.PersistentStorage, journalFileName))
+
+	harness.MustRun("perform", "-dry-run")
+	harness.MustRun("perform-incremental", "-dry-run")
+	harness.MustRun("restore", "-dry-run")
+
+	if after := harness.Storage.Keys(integrationBucket, ""); strings.Join(after, "\n") != strings.Join(keys, "\n") {
+		t.Errorf("Expected the bucket to be left alone, had %v and now %v", keys, after)
+	}
+	if after := harness.Commands(); len(after) != len(commands) {
+		t.Errorf("Expected no programs to run, ran %v", after[len(commands):])
+	}
+	if after := harness.DigitalOcean.VolumeEvents(); len(after) != len(events) {
+		t.Errorf("Expected no volumes to be touched, got %v", after[len(events):])
+	}
+	if after, _ := ioutil.ReadFile(path.Join(harness.PersistentStorage, journalFileName)); string(after) != string(journal) {
+		t.Errorf("Expected dry runs not to be recorded, journal is now:\n%s", after)
+	}
+
+	harness.ExpectData(map[string]string{"ibdata1": "full"})
+}
+
+func TestDownloadSkipsFinishedPieces(t *testing.T) {
+	harness := newIntegrationHarness(t)
+	defer harness.Close()
+
+	harness.WriteData(map[string]string{"ibdata1": "full"})
+	harness.MustRun("perform-full")
+	time.Sleep(time.Second)
+	harness.WriteData(map[string]string{"ibdata1": "incremental"})
+	harness.MustRun("perform-incremental")
+
+	restoreDirectory := path.Join(harness.Root, "restore")
+	harness.MustRun("download", "-existing-restore-directory", restoreDirectory)
+
+	downloads := func() int {
+		count := 0
+		for _, request := range harness.Storage.Requests() {
+			if strings.HasPrefix(request, "GET ") && strings.Contains(request, ".xbstream bytes=") {
+				count++
+			}
+		}
+		return count
+	}
+	commands := len(harness.Commands())
+	if downloads() != 2 {
+		t.Fatalf("Expected both pieces to be downloaded, got %v", harness.Storage.Requests())
+	}
+
+	checkpoints, _ := filepath.Glob(path.Join(restoreDirectory, restoreDirectoryName, "*.xbstream.checkpoint"))
+	if len(checkpoints) != 2 {
+		t.Errorf("Expected a checkpoint per piece, found %v", checkpoints)
+	}
+
+	harness.MustRun("download", "-existing-restore-directory", restoreDirectory)
+
+	if downloads() != 2 {
+		t.Errorf("Expected the pieces not to be downloaded again, got %v", harness.Storage.Requests())
+	}
+	for _, command := range harness.Commands()[commands:] {
+		if strings.HasPrefix(command, "xbstream") || strings.HasPrefix(command, "xtrabackup --decompress") {
+			t.Errorf("Expected finished pieces to be skipped, ran %s", command)
+		}
+	}
+}
+
+func TestArchiveBinlogsUploadsFinishedBinaryLogs(t *testing.T) {
+	harness := newIntegrationHarness(t)
+	defer harness.Close()
+
+	binlogPath := path.Join(harness.Root, "binlogs")
+	harness.must(os.MkdirAll(binlogPath, 0700))
+	for name, contents := range map[string]string{"binlog.000001": "first", "binlog.000002": "second", "binlog.index": "index"} {
+		harness.must(ioutil.WriteFile(path.Join(binlogPath, name), []byte(contents), 0600))
+	}
+
+	harness.MustRun("archive-binlogs")
+
+	if !containsCommand(harness.Commands(), "mysql", "FLUSH BINARY LOGS") {
+		t.Errorf("Expected the current binary log to be closed first, ran %v", harness.Commands())
+	}
+
+	// binlog.000002 is the one MySQL writes to
+	if keys := harness.Storage.Keys(integrationBucket, "db1/_binlogs/"); len(keys) != 1 || keys[0] != "db1/_binlogs/binlog.000001" {
+		t.Fatalf("Expected only the finished binary log to be archived, got %v", keys)
+	}
+
+	harness.must(ioutil.WriteFile(path.Join(binlogPath, "binlog.000003"), []byte("third"), 0600))
+	uploadsBefore := len(harness.Storage.Requests())
+	harness.MustRun("archive-binlogs")
+
+	if keys := harness.Storage.Keys(integrationBucket, "db1/_binlogs/"); len(keys) != 2 || keys[1] != "db1/_binlogs/binlog.000002" {
+		t.Fatalf("Expected the next binary log to be archived, got %v", keys)
+	}
+
+	for _, request := range harness.Storage.Requests()[uploadsBefore:] {
+		if strings.HasPrefix(request, "PUT") && strings.Contains(request, "binlog.000001") {
+			t.Errorf("Expected an archived binary log not to be uploaded again, got %s", request)
+		}
+	}
+
+	minioClient, err := harness.Storage.MinioClient()
+	harness.must(err)
+	backups, err := listAllBackups("db1", integrationBucket, minioClient)
+	harness.must(err)
+	if len(backups) != 0 {
+		t.Errorf("Expected archived binary logs not to be listed as backups, got %v", backups)
+	}
+}
+
+func TestMigrateLayoutCopiesBackups(t *testing.T) {
+	harness := newIntegrationHarness(t)
+	defer harness.Close()
+
+	harness.WriteData(map[string]string{"ibdata1": "full"})
+	harness.MustRun("perform-full")
+	oldKeys := harness.Storage.Keys(integrationBucket, "db1/")
+
+	if err := harness.Run("migrate-layout", "-from-template", integrationKeyTemplate, "-yes"); err == nil {
+		t.Error("Expected a migration within the directory of the host to need -remove-old")
+	}
+
+	harness.WriteConfig(map[string]interface{}{
+		"layout": map[string]string{"key_template": "{environment}/" + integrationKeyTemplate, "environment": "production"},
+	})
+	harness.MustRun("migrate-layout", "-from-template", integrationKeyTemplate, "-yes")
+
+	if keys := harness.Storage.Keys(integrationBucket, "db1/"); len(keys) != len(oldKeys) {
+		t.Errorf("Expected the old objects to be kept, got %v", keys)
+	}
+
+	for _, key := range oldKeys {
+		if _, exists := harness.Storage.Get(integrationBucket, "production/"+key); !exists {
+			t.Errorf("Expected %s to be copied to production/%s", key, key)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+)
+
+const journalFileName = "journal.jsonl"
+
+// runRecord is one line in the run journal
+type runRecord struct {
+	ID              string    `json:"id"`
+	Job             string    `json:"job"`
+	Hostname        string    `json:"hostname"`
+	StartedAt       time.Time `json:"started_at"`
+	EndedAt         time.Time `json:"ended_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Success         bool      `json:"success"`
+	FailedStage     string    `json:"failed_stage,omitempty"`
+	Error           string    `json:"error,omitempty"`
+
+	BackupType    string `json:"backup_type,omitempty"`
+	BackupName    string `json:"backup_name,omitempty"`
+	BackupObject  string `json:"backup_object,omitempty"`
+	SizeInBytes   int64  `json:"size_in_bytes,omitempty"`
+	PrunedBackups int    `json:"pruned_backups,omitempty"`
+
+	alertKeys       map[string]bool
+	restoredBackups []backupItem
+	transcript      *pkg.TranscriptRunner
+}
+
+// The run currently in progress. Runs never overlap, the daemon runs one job at a time.
+// Only changed by the goroutine running the job. Others read it with currentRun
+var activeRun *runRecord
+var activeRunMutex sync.Mutex
+
+func startRun(job string, hostname string) *runRecord {
+	return startRunWithID(newRunID(), job, hostname)
+}
+
+// startRunWithID starts a run with an ID that was handed out before it started
+func startRunWithID(runID string, job string, hostname string) *runRecord {
+	run := &runRecord{
+		ID:        runID,
+		Job:       job,
+		Hostname:  hostname,
+		StartedAt: time.Now(),
+	}
+
+	activeRunMutex.Lock()
+	activeRun = run
+	activeRunMutex.Unlock()
+
+	pkg.StartLogRun(run.ID, job, hostname)
+	startTranscript(run)
+
+	pingHeartbeat(run, pkg.HeartbeatStart)
+
+	return run
+}
+
+// currentRun returns a copy of the run in progress, if any
+func currentRun() (runRecord, bool) {
+	activeRunMutex.Lock()
+	defer activeRunMutex.Unlock()
+
+	if activeRun == nil {
+		return runRecord{}, false
+	}
+	return *activeRun, true
+}
+
+func newRunID() string {
+	randomBytes := make([]byte, 6)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(randomBytes)
+}
+
+func (run *runRecord) noteBackup(backupType string, backupName string, objectKey string, sizeInBytes int64) {
+	if run == nil {
+		return
+	}
+
+	activeRunMutex.Lock()
+	defer activeRunMutex.Unlock()
+
+	run.BackupType = backupType
+	run.BackupName = backupName
+	run.BackupObject = objectKey
+	run.SizeInBytes = sizeInBytes
+}
+
+func (run *runRecord) notePrunedBackups(count int) {
+	if run == nil {
+		return
+	}
+
+	activeRunMutex.Lock()
+	defer activeRunMutex.Unlock()
+
+	run.PrunedBackups += count
+}
+
+// noteRestoredBackups notes the backups a restore downloads, newest first
+func (run *runRecord) noteRestoredBackups(backups []backupItem) {
+	if run == nil {
+		return
+	}
+
+	run.restoredBackups = backups
+}
+
+func (run *runRecord) noteAlert(dedupKey string) {
+	if run.alertKeys == nil {
+		run.alertKeys = make(map[string]bool)
+	}
+	run.alertKeys[dedupKey] = true
+}
+
+func (run *runRecord) finish(err error) {
+	activeRunMutex.Lock()
+	defer activeRunMutex.Unlock()
+
+	run.EndedAt = time.Now()
+	run.DurationSeconds = run.EndedAt.Sub(run.StartedAt).Seconds()
+	run.Success = err == nil
+	if err != nil {
+		run.FailedStage = errorStage(err)
+		run.Error = err.Error()
+	}
+}
+
+func (run *runRecord) duration() time.Duration {
+	return time.Duration(run.DurationSeconds * float64(time.Second))
+}
+
+func appendToJournal(persistentStorageDirectory string, run *runRecord) error {
+	line, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	journalFile, err := os.OpenFile(path.Join(persistentStorageDirectory, journalFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = journalFile.Write(append(line, '\n'))
+	if err != nil {
+		journalFile.Close()
+		return err
+	}
+
+	return journalFile.Close()
+}
+
+// readJournal returns all runs that started at or after since, oldest first
+func readJournal(persistentStorageDirectory string, since time.Time) ([]runRecord, error) {
+	journalFile, err := os.Open(path.Join(persistentStorageDirectory, journalFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer journalFile.Close()
+
+	runs := make([]runRecord, 0)
+
+	scanner := bufio.NewScanner(journalFile)
+	for scanner.Scan() {
+		var run runRecord
+		if json.Unmarshal(scanner.Bytes(), &run) != nil {
+			// A partially written line from a crashed run
+			continue
+		}
+
+		if !run.StartedAt.Before(since) {
+			runs = append(runs, run)
+		}
+	}
+
+	return runs, scanner.Err()
+}
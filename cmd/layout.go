@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The key template of backups taken before the layout was configurable
+const defaultKeyTemplate = "{hostname}/mysql-backup-{timestamp}.{type}.xbstream"
+
+// Placeholders of key templates. The host placeholders are the same for every backup of a host
+const (
+	keyPlaceholderEnvironment      = "{environment}"
+	keyPlaceholderCluster          = "{cluster}"
+	keyPlaceholderHostname         = "{hostname}"
+	keyPlaceholderType             = "{type}"
+	keyPlaceholderTimestamp        = "{timestamp}"
+	keyPlaceholderTimestampSeconds = "{timestamp_seconds}"
+	keyPlaceholderTimestampUTC     = "{timestamp_utc}"
+)
+
+const backupKeyExtension = ".xbstream"
+
+type keyTimestampFormat struct {
+	Layout  string
+	Pattern string
+	UTC     bool
+}
+
+var keyTimestampFormats = map[string]keyTimestampFormat{
+	keyPlaceholderTimestamp:        {Layout: "200601021504", Pattern: `\d{12}`},
+	keyPlaceholderTimestampSeconds: {Layout: "20060102150405", Pattern: `\d{14}`},
+	keyPlaceholderTimestampUTC:     {Layout: "20060102T150405Z", Pattern: `\d{8}T\d{6}Z`, UTC: true},
+}
+
+var keyPlaceholderPattern = regexp.MustCompile(`\{[a-z_]*\}`)
+
+// objectLayout renders the keys of backups from a key template and parses them back.
+// The key template is split in the directory of a host, ending with {hostname}, and the name of a backup in it
+type objectLayout struct {
+	Template string
+
+	environment string
+	cluster     string
+
+	directory string
+	name      string
+
+	timestamp keyTimestampFormat
+	pattern   *regexp.Regexp
+
+	// Indexes of the submatches of pattern
+	timestampGroup int
+	typeGroup      int
+}
+
+// newObjectLayout checks the key template of layoutConfig and builds the parser of its keys. A nil layoutConfig is the default layout
+func newObjectLayout(layoutConfig *LayoutConfig) (*objectLayout, error) {
+	layout := &objectLayout{Template: defaultKeyTemplate}
+	if layoutConfig != nil {
+		if layoutConfig.KeyTemplate != "" {
+			layout.Template = layoutConfig.KeyTemplate
+		}
+		layout.environment = layoutConfig.Environment
+		layout.cluster = layoutConfig.Cluster
+	}
+
+	separator := strings.Index(layout.Template, keyPlaceholderHostname+"/")
+	if separator < 0 {
+		return nil, errors.New("should contain {hostname}/ since the backups of every host are stored in a directory of their own")
+	}
+
+	layout.directory = layout.Template[:separator+len(keyPlaceholderHostname)]
+	layout.name = layout.Template[separator+len(keyPlaceholderHostname)+1:]
+
+	for _, placeholder := range keyPlaceholderPattern.FindAllString(layout.directory[:separator], -1) {
+		switch placeholder {
+		case keyPlaceholderEnvironment:
+			if layout.environment == "" {
+				return nil, errors.New("uses {environment} but layout.environment is not set")
+			}
+		case keyPlaceholderCluster:
+			if layout.cluster == "" {
+				return nil, errors.New("uses {cluster} but layout.cluster is not set")
+			}
+		default:
+			return nil, fmt.Errorf("can't use %s before {hostname}/. Only {environment} and {cluster} describe the host", placeholder)
+		}
+	}
+
+	if !strings.HasSuffix(layout.name, backupKeyExtension) {
+		return nil, errors.New("should end with " + backupKeyExtension)
+	}
+
+	pattern := "^"
+	group := 0
+	literalStart := 0
+	for _, location := range keyPlaceholderPattern.FindAllStringIndex(layout.name, -1) {
+		pattern += regexp.QuoteMeta(layout.name[literalStart:location[0]])
+		literalStart = location[1]
+
+		placeholder := layout.name[location[0]:location[1]]
+		timestampFormat, isTimestamp := keyTimestampFormats[placeholder]
+		switch {
+		case isTimestamp && layout.timestampGroup == 0:
+			group++
+			layout.timestamp = timestampFormat
+			layout.timestampGroup = group
+			pattern += "(" + timestampFormat.Pattern + ")"
+		case isTimestamp:
+			return nil, errors.New("should contain one timestamp")
+		case placeholder == keyPlaceholderType && layout.typeGroup == 0:
+			group++
+			layout.typeGroup = group
+			pattern += "(" + backupTypeFull + "|" + backupTypeIncremental + ")"
+		case placeholder == keyPlaceholderType:
+			return nil, errors.New("should contain {type} once")
+		default:
+			return nil, fmt.Errorf("can't use %s after {hostname}/. Only {timestamp}, {timestamp_seconds}, {timestamp_utc} and {type} describe the backup", placeholder)
+		}
+	}
+	pattern += regexp.QuoteMeta(layout.name[literalStart:]) + "$"
+
+	if layout.timestampGroup == 0 {
+		return nil, errors.New("should contain {timestamp}, {timestamp_seconds} or {timestamp_utc}")
+	}
+	if layout.typeGroup == 0 {
+		return nil, errors.New("should contain {type}")
+	}
+
+	layout.pattern = regexp.MustCompile(pattern)
+	return layout, nil
+}
+
+// hostDirectory returns the directory of the backups of hostname
+func (layout *objectLayout) hostDirectory(hostname string) string {
+	return strings.NewReplacer(
+		keyPlaceholderEnvironment, layout.environment,
+		keyPlaceholderCluster, layout.cluster,
+		keyPlaceholderHostname, hostname,
+	).Replace(layout.directory)
+}
+
+// hostsPrefix returns what the directories of all hosts start with
+func (layout *objectLayout) hostsPrefix() string {
+	return layout.hostDirectory("")
+}
+
+// backupKey returns the key of the backup of backupType created at createdAt in hostDirectory
+func (layout *objectLayout) backupKey(hostDirectory string, createdAt time.Time, backupType string) string {
+	if layout.timestamp.UTC {
+		createdAt = createdAt.UTC()
+	}
+
+	timestamp := createdAt.Format(layout.timestamp.Layout)
+	replacements := []string{keyPlaceholderType, backupType}
+	for placeholder := range keyTimestampFormats {
+		replacements = append(replacements, placeholder, timestamp)
+	}
+
+	return hostDirectory + "/" + strings.NewReplacer(replacements...).Replace(layout.name)
+}
+
+// parseBackupKey returns when the backup at key in hostDirectory was created and its type.
+// Backups named before the layout was configured are recognized too
+func (layout *objectLayout) parseBackupKey(hostDirectory string, key string) (time.Time, string, error) {
+	if !strings.HasPrefix(key, hostDirectory+"/") {
+		return time.Time{}, "", errors.New("Not in " + hostDirectory + ": " + key)
+	}
+	name := strings.TrimPrefix(key, hostDirectory+"/")
+
+	matches := layout.pattern.FindStringSubmatch(name)
+	if matches == nil {
+		if strings.Contains(name, "/") {
+			return time.Time{}, "", errors.New("Incorrect format for backup: " + key)
+		}
+		return parseBackupName(name)
+	}
+
+	// backupKey writes the timestamps in local time, except {timestamp_utc}
+	location := time.Local
+	if layout.timestamp.UTC {
+		location = time.UTC
+	}
+
+	createdAt, err := time.ParseInLocation(layout.timestamp.Layout, matches[layout.timestampGroup], location)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	return createdAt, matches[layout.typeGroup], nil
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultObjectLayout(t *testing.T) {
+	layout, err := newObjectLayout(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createdAt := time.Date(2019, 1, 23, 20, 39, 41, 0, time.Local)
+	key := layout.backupKey(layout.hostDirectory("db1"), createdAt, backupTypeFull)
+	if key != "db1/mysql-backup-201901232039.full.xbstream" {
+		t.Errorf("Expected the default layout to match the keys of earlier versions, got %s", key)
+	}
+
+	parsedAt, backupType, err := layout.parseBackupKey("db1", key)
+	if err != nil || !parsedAt.Equal(createdAt.Truncate(time.Minute)) || backupType != backupTypeFull {
+		t.Errorf("Expected %s to parse back, got %s %s (%v)", key, parsedAt, backupType, err)
+	}
+
+	for _, key := range []string{"db10/mysql-backup-201901232039.full.xbstream", "db1/mysql-backup-201901232039.full.server.tar.gz", "db1/_lease.json", "db1/_history/20190123T203941Z-abc.json"} {
+		if _, _, err := layout.parseBackupKey("db1", key); err == nil {
+			t.Errorf("Expected %s not to be a backup of db1", key)
+		}
+	}
+}
+
+func TestObjectLayoutInLocalTime(t *testing.T) {
+	previousLocal := time.Local
+	defer func() { time.Local = previousLocal }()
+	time.Local = time.FixedZone("CET", 3600)
+
+	for _, template := range []string{defaultKeyTemplate, "{hostname}/{timestamp_seconds}.{type}.xbstream"} {
+		layout, err := newObjectLayout(&LayoutConfig{KeyTemplate: template})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		createdAt := time.Date(2019, 1, 23, 20, 39, 0, 0, time.UTC)
+		key := layout.backupKey("db1", createdAt.Local(), backupTypeFull)
+
+		parsedAt, _, err := layout.parseBackupKey("db1", key)
+		if err != nil || !parsedAt.Equal(createdAt) {
+			t.Errorf("Expected %s to parse back to %s, got %s (%v)", key, createdAt, parsedAt, err)
+		}
+	}
+}
+
+func TestCustomObjectLayout(t *testing.T) {
+	layout, err := newObjectLayout(&LayoutConfig{
+		KeyTemplate: "backups/{environment}/{cluster}/{hostname}/{type}/{timestamp_utc}.xbstream",
+		Environment: "production",
+		Cluster:     "posts",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostDirectory := layout.hostDirectory("db1")
+	if hostDirectory != "backups/production/posts/db1" || layout.hostsPrefix() != "backups/production/posts/" {
+		t.Errorf("Incorrect host directory %s or hosts prefix %s", hostDirectory, layout.hostsPrefix())
+	}
+
+	createdAt := time.Date(2019, 1, 23, 21, 39, 41, 0, time.FixedZone("CET", 3600))
+	key := layout.backupKey(hostDirectory, createdAt, backupTypeIncremental)
+	if key != "backups/production/posts/db1/incremental/20190123T203941Z.xbstream" {
+		t.Errorf("Incorrect key %s", key)
+	}
+
+	parsedAt, backupType, err := layout.parseBackupKey(hostDirectory, key)
+	if err != nil || !parsedAt.Equal(createdAt) || backupType != backupTypeIncremental {
+		t.Errorf("Expected %s to parse back, got %s %s (%v)", key, parsedAt, backupType, err)
+	}
+
+	// Backups uploaded before the layout changed are still found
+	_, backupType, err = layout.parseBackupKey(hostDirectory, hostDirectory+"/mysql-backup-201901232039.full.xbstream")
+	if err != nil || backupType != backupTypeFull {
+		t.Errorf("Expected a backup in the old format to parse, got %s (%v)", backupType, err)
+	}
+}
+
+func TestInvalidObjectLayouts(t *testing.T) {
+	templates := []string{
+		"mysql-backup-{timestamp}.{type}.xbstream",
+		"{environment}/{hostname}/{timestamp}.{type}.xbstream",
+		"{type}/{hostname}/{timestamp}.xbstream",
+		"{hostname}/{timestamp}.xbstream",
+		"{hostname}/{timestamp}-{timestamp_utc}.{type}.xbstream",
+		"{hostname}/{timestamp}.{type}.tar.gz",
+		"{hostname}/{cluster}-{timestamp}.{type}.xbstream",
+	}
+
+	for _, template := range templates {
+		if _, err := newObjectLayout(&LayoutConfig{KeyTemplate: template}); err == nil {
+			t.Errorf("Expected %s to be rejected", template)
+		}
+	}
+}
+
+func TestLayoutMoves(t *testing.T) {
+	fromLayout, _ := newObjectLayout(nil)
+	toLayout, _ := newObjectLayout(&LayoutConfig{KeyTemplate: "{environment}/{hostname}/mysql-backup-{timestamp_seconds}.{type}.xbstream", Environment: "production"})
+
+	objects := []string{
+		"db1/mysql-backup-201901232039.full.xbstream",
+		"db1/mysql-backup-201901232039.full.server.tar.gz",
+		"db1/mysql-backup-201901240039.incremental.xbstream",
+		"db1/mysql-backup-201901250039.incremental.xbstream",
+		"db1/_history/20190123T203941Z-abc.json",
+		"db1/_binlogs/binlog.000307",
+		"db1/_lease.json",
+	}
+
+	existing := map[string]bool{"production/db1/mysql-backup-20190125003900.incremental.xbstream": true}
+	for _, object := range objects {
+		existing[object] = true
+	}
+
+	moves, err := layoutMoves(objects, existing, fromLayout, "db1", toLayout, "production/db1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []layoutMove{
+		{"db1/mysql-backup-201901232039.full.xbstream", "production/db1/mysql-backup-20190123203900.full.xbstream"},
+		{"db1/mysql-backup-201901232039.full.server.tar.gz", "production/db1/mysql-backup-20190123203900.full.server.tar.gz"},
+		{"db1/mysql-backup-201901240039.incremental.xbstream", "production/db1/mysql-backup-20190124003900.incremental.xbstream"},
+		{"db1/_history/20190123T203941Z-abc.json", "production/db1/_history/20190123T203941Z-abc.json"},
+		{"db1/_binlogs/binlog.000307", "production/db1/_binlogs/binlog.000307"},
+	}
+
+	if len(moves) != len(expected) {
+		t.Fatalf("Expected %d moves, got %v", len(expected), moves)
+	}
+
+	for index, move := range moves {
+		if move != expected[index] {
+			t.Errorf("Expected %v, got %v", expected[index], move)
+		}
+	}
+}
+
+func TestLayoutMovesRefusesCollisions(t *testing.T) {
+	fromLayout, _ := newObjectLayout(&LayoutConfig{KeyTemplate: "{hostname}/mysql-backup-{timestamp_seconds}.{type}.xbstream"})
+	toLayout, _ := newObjectLayout(&LayoutConfig{KeyTemplate: "{environment}/{hostname}/mysql-backup-{timestamp}.{type}.xbstream", Environment: "production"})
+
+	// Taken in the same minute
+	objects := []string{
+		"db1/mysql-backup-20190123203900.incremental.xbstream",
+		"db1/mysql-backup-20190123203941.incremental.xbstream",
+	}
+
+	moves, err := layoutMoves(objects, map[string]bool{}, fromLayout, "db1", toLayout, "production/db1")
+	if err == nil || !strings.Contains(err.Error(), "production/db1/mysql-backup-201901232039.incremental.xbstream") {
+		t.Errorf("Expected backups with the same new key to be refused, got %v (%v)", moves, err)
+	}
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+const defaultLeaseDurationInHours = 24
+
+// withHostLock runs runner while holding the lock file in the persistent storage directory.
+// If writesToBucket is set and `lock.bucket_lease` is enabled a lease object is also taken under hostname in the bucket.
+func withHostLock(command string, hostname string, writesToBucket bool, minioClient *minio.Client, runner func() error) error {
+	lock, err := pkg.AcquireHostLock(configStruct.PersistentStorage, command)
+	if err != nil {
+		return withStage(stageLock, err)
+	}
+
+	defer func() {
+		if releaseErr := lock.Release(); releaseErr != nil {
+			pkg.ErrorLog.Println("Warning: Could not release lock.", releaseErr)
+		}
+	}()
+
+	if writesToBucket && configStruct.Lock != nil && configStruct.Lock.BucketLease {
+		thisHost, err := pkg.GetRunningInstanceData()
+		if err != nil {
+			return err
+		}
+
+		leaseDurationInHours := configStruct.Lock.LeaseDurationInHours
+		if leaseDurationInHours <= 0 {
+			leaseDurationInHours = defaultLeaseDurationInHours
+		}
+
+		lease, err := pkg.AcquireBucketLease(
+			configStruct.DigitalOcean.SpaceName,
+			path.Join(hostname, "_lease.json"),
+			fmt.Sprintf("droplet-%d", thisHost.DropletID),
+			command,
+			time.Duration(leaseDurationInHours)*time.Hour,
+			minioClient,
+		)
+		if err != nil {
+			return withStage(stageLock, err)
+		}
+
+		defer func() {
+			if releaseErr := pkg.ReleaseBucketLease(configStruct.DigitalOcean.SpaceName, lease, minioClient); releaseErr != nil {
+				pkg.ErrorLog.Println("Warning: Could not release bucket lease.", releaseErr)
+			}
+		}()
+	}
+
+	return runner()
+}
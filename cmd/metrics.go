@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"github.com/feederco/really-simple-db-backup/pkg"
+)
+
+var recordedJobs = map[string]bool{
+	"perform":             true,
+	"perform-full":        true,
+	"perform-incremental": true,
+	"restore":             true,
+	"download":            true,
+	"finalize-restore":    true,
+	"upload":              true,
+	"prune":               true,
+	"verify":              true,
+	"archive-binlogs":     true,
+	"gc-volumes":          true,
+}
+
+// Commands that do the same work with different options
+var jobFamilies = map[string]string{
+	"perform-full":        "perform",
+	"perform-incremental": "perform",
+	"download":            "restore",
+}
+
+func isRecordedJob(command string) bool {
+	return recordedJobs[command]
+}
+
+func jobFamily(job string) string {
+	if family, exists := jobFamilies[job]; exists {
+		return family
+	}
+	return job
+}
+
+// recordJob records the outcome of a run in the metrics and the run journal, and mirrors it to the bucket. Commands that don't touch backups are not recorded
+func recordJob(run *runRecord, err error) {
+	run.finish(err)
+	defer endTranscript(run)
+
+	activeRunMutex.Lock()
+	if activeRun == run {
+		activeRun = nil
+		defer pkg.EndLogRun()
+	}
+	activeRunMutex.Unlock()
+
+	if !isRecordedJob(run.Job) {
+		return
+	}
+
+	metrics.RecordJob(run.Job, run.duration(), run.FailedStage)
+
+	if run.Success {
+		resolveAlerts(run)
+		pingHeartbeat(run, pkg.HeartbeatSuccess)
+	} else {
+		pingHeartbeat(run, pkg.HeartbeatFail)
+		runFailureHook(run)
+	}
+
+	if journalErr := appendToJournal(configStruct.PersistentStorage, run); journalErr != nil {
+		pkg.ErrorLog.Println("Warning: Could not write to the run journal.", journalErr)
+	}
+
+	if mirrorErr := mirrorRunToBucket(run); mirrorErr != nil {
+		pkg.ErrorLog.Println("Warning: Could not mirror run to the bucket.", mirrorErr)
+	}
+
+	if flushErr := metrics.Flush(); flushErr != nil {
+		pkg.ErrorLog.Println("Warning: Could not write metrics.", flushErr)
+	}
+}
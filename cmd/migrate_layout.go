@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+// layoutMove is an object `migrate-layout` copies to its key in the new layout
+type layoutMove struct {
+	From string
+	To   string
+}
+
+// backupMigrateLayout copies the backups of the host stored under fromTemplate, with their server configs and run history,
+// to their keys in the layout of the config. The old objects are only removed once copied when removeOld is set
+func backupMigrateLayout(storagePrefix string, fromTemplate string, removeOld bool, skipConfirmation bool, bucket string, minioClient *minio.Client) error {
+	fromLayoutConfig := LayoutConfig{KeyTemplate: fromTemplate}
+	if configStruct.Layout != nil {
+		fromLayoutConfig.Environment = configStruct.Layout.Environment
+		fromLayoutConfig.Cluster = configStruct.Layout.Cluster
+	}
+
+	fromLayout, err := newObjectLayout(&fromLayoutConfig)
+	if err != nil {
+		return newUsageError("-from-template %s", err)
+	}
+	toLayout := configStruct.backupLayout()
+
+	fromDirectory := fromLayout.hostDirectory(storagePrefix)
+	toDirectory := toLayout.hostDirectory(storagePrefix)
+
+	// Old backups in the new directory would be listed next to their copies
+	if !removeOld && fromDirectory == toDirectory {
+		return newUsageError("The old and new layout store the backups of %s in the same directory, where the old backups would be listed next to their copies. Pass -remove-old to move them instead", storagePrefix)
+	}
+
+	pkg.Log.Printf("Finding backups of %s in %s/\n", storagePrefix, fromDirectory)
+
+	moves, err := planLayoutMigration(fromLayout, fromDirectory, toLayout, toDirectory, bucket, minioClient)
+	if err != nil {
+		return withStage(stageList, err)
+	}
+
+	if len(moves) == 0 {
+		pkg.Log.Println("Every backup is stored in the new layout. Nothing to do.")
+		return nil
+	}
+
+	fmt.Println("")
+	for index, move := range moves {
+		fmt.Printf("#%d: %s -> %s\n", index+1, move.From, move.To)
+	}
+
+	action := "Copy"
+	if removeOld {
+		action = "Move"
+	}
+
+	if !skipConfirmation && !askForConfirmation(fmt.Sprintf("\n%s %d %s: (yes or y to accept)", action, len(moves), pluralize(len(moves), "object", "objects"))) {
+		pkg.Log.Println("Everything left as-is.")
+		return nil
+	}
+
+	for index, move := range moves {
+		err = moveObject(move, removeOld, bucket, minioClient)
+		if err != nil {
+			return fmt.Errorf("Could not copy %s to %s. Migrated %d of %d %s before failing: %s", move.From, move.To, index, len(moves), pluralize(len(moves), "object", "objects"), err)
+		}
+
+		pkg.Log.Printf("Migrated %s to %s\n", move.From, move.To)
+	}
+
+	pkg.Log.Printf("Migrated %d %s\n", len(moves), pluralize(len(moves), "object", "objects"))
+	return nil
+}
+
+// planLayoutMigration returns the objects in fromDirectory that have a different key in the new layout.
+// Objects that already exist at their new key are left alone. Fails when two objects would get the same key
+func planLayoutMigration(fromLayout *objectLayout, fromDirectory string, toLayout *objectLayout, toDirectory string, bucket string, minioClient *minio.Client) ([]layoutMove, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	existing := make(map[string]bool)
+	objects := make([]string, 0)
+	for object := range minioClient.ListObjectsV2(bucket, fromDirectory+"/", true, doneCh) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		existing[object.Key] = true
+		objects = append(objects, object.Key)
+	}
+
+	if toDirectory != fromDirectory {
+		for object := range minioClient.ListObjectsV2(bucket, toDirectory+"/", true, doneCh) {
+			if object.Err != nil {
+				return nil, object.Err
+			}
+			existing[object.Key] = true
+		}
+	}
+
+	return layoutMoves(objects, existing, fromLayout, fromDirectory, toLayout, toDirectory)
+}
+
+func layoutMoves(objects []string, existing map[string]bool, fromLayout *objectLayout, fromDirectory string, toLayout *objectLayout, toDirectory string) ([]layoutMove, error) {
+	moves := make([]layoutMove, 0)
+
+	// The object copied to each new key. Like backups taken in the same minute when going from {timestamp_seconds} to {timestamp}
+	copiedFrom := make(map[string]string)
+	var collisions []string
+
+	addMove := func(from string, to string) {
+		if from == to || existing[to] {
+			return
+		}
+
+		if other, taken := copiedFrom[to]; taken {
+			collisions = append(collisions, fmt.Sprintf("%s and %s would both be copied to %s", other, from, to))
+			return
+		}
+
+		copiedFrom[to] = from
+		moves = append(moves, layoutMove{From: from, To: to})
+	}
+
+	for _, key := range objects {
+		// The run history and archived binary logs move with the host directory
+		if directory, name := hostDirectoryObject(fromDirectory, key); directory != "" {
+			addMove(key, path.Join(toDirectory, directory, name))
+			continue
+		}
+
+		createdAt, backupType, err := fromLayout.parseBackupKey(fromDirectory, key)
+		if err != nil {
+			continue
+		}
+
+		newKey := toLayout.backupKey(toDirectory, createdAt, backupType)
+		addMove(key, newKey)
+
+		if existing[serverConfigArchivePath(key)] {
+			addMove(serverConfigArchivePath(key), serverConfigArchivePath(newKey))
+		}
+	}
+
+	if len(collisions) > 0 {
+		return nil, errors.New("Objects would overwrite each other in the new layout. Use a template with a timestamp of a higher resolution:\n" + strings.Join(collisions, "\n"))
+	}
+	return moves, nil
+}
+
+// hostDirectoryObject returns the directory in hostDirectory key is in and its name in it, if it is one of the directories that aren't backups
+func hostDirectoryObject(hostDirectory string, key string) (string, string) {
+	for _, directory := range []string{historyDirectory, binlogDirectory} {
+		prefix := path.Join(hostDirectory, directory) + "/"
+		if strings.HasPrefix(key, prefix) {
+			return directory, strings.TrimPrefix(key, prefix)
+		}
+	}
+	return "", ""
+}
+
+func moveObject(move layoutMove, removeOld bool, bucket string, minioClient *minio.Client) error {
+	destination, err := minio.NewDestinationInfo(bucket, move.To, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	// ComposeObject copies objects over 5 GB in parts, CopyObject can't
+	err = minioClient.ComposeObject(destination, []minio.SourceInfo{minio.NewSourceInfo(bucket, move.From, nil)})
+	if err != nil {
+		return err
+	}
+
+	if !removeOld {
+		return nil
+	}
+	return minioClient.RemoveObject(bucket, move.From)
+}
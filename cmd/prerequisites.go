@@ -12,6 +12,12 @@ import (
 
 const requiredMysqlVersion = "8"
 
+// Backups and restores run as requiredRunAs or a user of the group with ID requiredGroupID
+var (
+	requiredRunAs   = "root"
+	requiredGroupID = "0" // Root in POSIX
+)
+
 func prerequisites(persistentStorageDirectory string) error {
 	pkg.Log.Println("Checking prerequisites overall")
 
@@ -29,7 +35,7 @@ func prerequisites(persistentStorageDirectory string) error {
 		pkg.Log.Println("Persistent storage directory did not exist. Attempting to create", persistentStorageDirectory)
 		err = os.Mkdir(persistentStorageDirectory, 0700)
 		if err != nil {
-			pkg.ErrorLog.Fatalf("Could not create persistent storage directory at %s: %s", persistentStorageDirectory, err)
+			return fmt.Errorf("Could not create persistent storage directory at %s: %s", persistentStorageDirectory, err)
 		}
 
 		dirInfo, _ = os.Stat(persistentStorageDirectory)
@@ -58,13 +64,19 @@ func backupPrerequisites() error {
 		return err
 	}
 
-	versionString := strings.Split(mysqlVersion, " ")
+	// Like `/usr/sbin/mysqld  Ver 8.0.19 for Linux on x86_64`. Nothing is printed by a dry run
+	versionString := strings.Fields(mysqlVersion)
 
-	if versionString[2] == "Ver" {
-		versionPieces := strings.Split(versionString[3], ".")
+	for index := 0; index+1 < len(versionString); index++ {
+		if versionString[index] != "Ver" {
+			continue
+		}
+
+		versionPieces := strings.Split(versionString[index+1], ".")
 		if versionPieces[0] != requiredMysqlVersion {
 			return fmt.Errorf("Incorrect MySQL version installed. error version. %s found, %s required", versionPieces[0], requiredMysqlVersion)
 		}
+		break
 	}
 
 	// Prerequisite: percona-xtrabackup installed
@@ -105,9 +117,6 @@ func backupPrerequisites() error {
 }
 
 func checkCorrectUser() error {
-	requiredRunAs := "root"
-	requiredUserID := "0" // Root in POSIX
-
 	currentUser, err := user.Current()
 	// When no $USER variable is setup (via cron) Go cannot determine the current user
 	// when the binary has been built without CGO. If this check fails, we should simply
@@ -116,7 +125,7 @@ func checkCorrectUser() error {
 		return nil
 	}
 
-	if currentUser.Name != requiredRunAs && currentUser.Gid != requiredUserID {
+	if currentUser.Name != requiredRunAs && currentUser.Gid != requiredGroupID {
 		return errors.New("This program can only be run as " + requiredRunAs)
 	}
 
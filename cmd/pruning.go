@@ -4,21 +4,57 @@ import (
 	"sort"
 	"time"
 
+	"github.com/feederco/really-simple-db-backup/pkg"
 	minio "github.com/minio/minio-go"
 )
 
 func removeBackups(backups []backupItem, bucketName string, minioClient *minio.Client) ([]backupItem, error) {
 	removedBackups := make([]backupItem, 0)
+	defer func() {
+		metrics.RecordPrunedBackups(len(removedBackups))
+		activeRun.notePrunedBackups(len(removedBackups))
+	}()
+
 	for _, backup := range backups {
 		err := minioClient.RemoveObject(bucketName, backup.Path)
 		if err != nil {
-			return removedBackups, err
+			return removedBackups, withStage(stagePrune, err)
 		}
 		removedBackups = append(removedBackups, backup)
+
+		if backup.BackupType == backupTypeFull {
+			// The server config captured with the full backup. Older backups don't have one
+			err = minioClient.RemoveObject(bucketName, serverConfigArchivePath(backup.Path))
+			if err != nil {
+				pkg.ErrorLog.Println("Warning: Could not remove the server config of", backup.Path, err)
+			}
+		}
 	}
 	return removedBackups, nil
 }
 
+// pruneBackups removes all backups of hostname that fall outside of the retention window without asking for confirmation
+func pruneBackups(hostname string, bucketName string, retentionConfig *RetentionConfig, minioClient *minio.Client) ([]backupItem, error) {
+	backupsToDelete, err := planPrune(hostname, bucketName, retentionConfig, minioClient)
+	if err != nil {
+		return nil, err
+	}
+
+	enterStage(stagePrune)
+	return removeBackups(backupsToDelete, bucketName, minioClient)
+}
+
+// planPrune returns the backups of hostname that a prune would remove right now
+func planPrune(hostname string, bucketName string, retentionConfig *RetentionConfig, minioClient *minio.Client) ([]backupItem, error) {
+	enterStage(stageList)
+	allBackups, err := listAllBackups(hostname, bucketName, minioClient)
+	if err != nil {
+		return nil, withStage(stageList, err)
+	}
+
+	return findBackupsThatCanBeDeleted(allBackups, time.Now(), retentionConfig), nil
+}
+
 func findBackupsThatCanBeDeleted(allBackups []backupItem, nowTime time.Time, retentionConfig *RetentionConfig) []backupItem {
 	if retentionConfig.RetentionInDays <= 0 && retentionConfig.RetentionInHours <= 0 {
 		return nil
@@ -37,13 +73,19 @@ func findBackupsThatCanBeDeleted(allBackups []backupItem, nowTime time.Time, ret
 	// Build a map of lineages. A lineage is only deleted if all backups are outside of the range
 	// If you delete at the end of the lineage all subsequent increment backups fail
 	lineages := make(map[int64][]backupItem)
+	lineageOrder := make([]int64, 0)
 	for _, backupItem := range allBackups {
+		if _, exists := lineages[backupItem.LineageID]; !exists {
+			lineageOrder = append(lineageOrder, backupItem.LineageID)
+		}
 		lineages[backupItem.LineageID] = append(lineages[backupItem.LineageID], backupItem)
 	}
 
 	oldBackups := make([]backupItem, 0)
 
-	for _, backupItems := range lineages {
+	// Newest lineage first, same order as allBackups
+	for _, lineageID := range lineageOrder {
+		backupItems := lineages[lineageID]
 		allStale := true
 		for _, backup := range backupItems {
 			if backup.CreatedAt.After(lastTimestamp) {
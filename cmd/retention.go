@@ -15,7 +15,8 @@ func getLastFullBackup() (time.Time, error) {
 }
 
 func listAllBackups(hostname string, doSpaceName string, minioClient *minio.Client) ([]backupItem, error) {
-	backupKey := hostname
+	// Trailing slash so db1 doesn't match the backups of db10
+	backupKey := hostname + "/"
 
 	items := minioClient.ListObjectsV2(
 		doSpaceName,
@@ -25,13 +26,14 @@ func listAllBackups(hostname string, doSpaceName string, minioClient *minio.Clie
 	)
 
 	backupItems := make([]backupItem, 0)
+	layout := configStruct.backupLayout()
 
 	for item := range items {
 		if item.Err != nil {
 			return nil, item.Err
 		}
 
-		backupItem, err := newBackupItemFromMinioObject(item)
+		backupItem, err := newBackupItemFromMinioObject(layout, hostname, item)
 		if err != nil {
 			continue
 		}
@@ -90,8 +92,8 @@ func findRelevantBackupsUpTo(sinceTimestamp time.Time, allBackups []backupItem)
 	return backups
 }
 
-func newBackupItemFromMinioObject(minioObject minio.ObjectInfo) (backupItem, error) {
-	createdAt, backupType, err := parseBackupName(minioObject.Key)
+func newBackupItemFromMinioObject(layout *objectLayout, hostDirectory string, minioObject minio.ObjectInfo) (backupItem, error) {
+	createdAt, backupType, err := layout.parseBackupKey(hostDirectory, minioObject.Key)
 	if err != nil {
 		return backupItem{}, err
 	}
@@ -136,8 +138,9 @@ func parseBackupName(backupPath string) (time.Time, string, error) {
 	return createdAt, backupType, err
 }
 
+// parseBackupTimestamp parses a {timestamp} like 201901232039. Backups are named in local time, so it is read in local time too
 func parseBackupTimestamp(timestamp string) (time.Time, error) {
-	return time.Parse("200601021504", timestamp)
+	return time.ParseInLocation("200601021504", timestamp, time.Local)
 }
 
 type byCreatedAt []backupItem
@@ -20,10 +20,11 @@ func TestParseFileName(t *testing.T) {
 		"post-contents-db1/new-format-mysql-backup-201901232039.imploded.xbstream",
 	}
 
+	createdAt := time.Date(2019, 1, 23, 20, 39, 0, 0, time.Local)
 	results := []retentionFilenameResult{
-		retentionFilenameResult{time.Unix(1548275940, 0), "incremental", nil},
-		retentionFilenameResult{time.Unix(1548275940, 0), "full", nil},
-		retentionFilenameResult{time.Unix(1548275940, 0), "", errors.New("Incorrect backup type: imploded")},
+		retentionFilenameResult{createdAt, "incremental", nil},
+		retentionFilenameResult{createdAt, "full", nil},
+		retentionFilenameResult{createdAt, "", errors.New("Incorrect backup type: imploded")},
 		retentionFilenameResult{time.Time{}, "", errors.New("Incorrect prefix for filename: new-format-mysql-backup-201901232039.imploded.xbstream")},
 	}
 
@@ -48,6 +49,33 @@ func TestParseFileName(t *testing.T) {
 	}
 }
 
+func TestParseBackupTimestampInLocalTime(t *testing.T) {
+	previousLocal := time.Local
+	defer func() { time.Local = previousLocal }()
+	time.Local = time.FixedZone("CET", 3600)
+
+	layout, err := newObjectLayout(&LayoutConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createdAt := time.Date(2019, 1, 23, 20, 39, 0, 0, time.UTC)
+	key := layout.backupKey("db1", createdAt.Local(), backupTypeFull)
+	if key != "db1/mysql-backup-201901232139.full.xbstream" {
+		t.Fatalf("Expected the key in local time, got %s", key)
+	}
+
+	parsedAt, err := parseBackupTimestamp("201901232139")
+	if err != nil || !parsedAt.Equal(createdAt) {
+		t.Errorf("Expected the timestamp to parse to %s, got %s (%v)", createdAt, parsedAt, err)
+	}
+
+	parsedAt, _, err = parseBackupName(key)
+	if err != nil || !parsedAt.Equal(createdAt) {
+		t.Errorf("Expected %s to parse to %s, got %s (%v)", key, createdAt, parsedAt, err)
+	}
+}
+
 func TestListingBackupsSince(t *testing.T) {
 	allBackups := []backupItem{
 		buildBackup(1, "a/mysql-backup-201812311000.incremental.xbstream", 0),
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+)
+
+// visitSecrets calls visit with every non-empty value of the fields tagged `secret:"true"` in value and replaces it with what visit returns.
+// path is the key of the value in the config file, like `alerting.webhooks[0].headers.Authorization`
+func visitSecrets(value reflect.Value, path string, visit func(path string, secret string) (string, error)) error {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			return visitSecrets(value.Elem(), path, visit)
+		}
+	case reflect.Slice:
+		for index := 0; index < value.Len(); index++ {
+			err := visitSecrets(value.Index(index), fmt.Sprintf("%s[%d]", path, index), visit)
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range value.MapKeys() {
+			element := value.MapIndex(key)
+
+			// Values of maps can't be set in place, like the ones of `jobs`. They are visited in a copy that is stored back
+			copied := element.Kind() != reflect.Ptr
+			if copied {
+				element = reflect.New(element.Type()).Elem()
+				element.Set(value.MapIndex(key))
+			}
+
+			err := visitSecrets(element, joinConfigPath(path, key.String()), visit)
+			if err != nil {
+				return err
+			}
+
+			if copied {
+				value.SetMapIndex(key, element)
+			}
+		}
+	case reflect.Struct:
+		for index := 0; index < value.NumField(); index++ {
+			field := value.Type().Field(index)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			fieldPath := path
+			if !field.Anonymous {
+				name := strings.Split(field.Tag.Get("json"), ",")[0]
+				if name == "" {
+					name = field.Name
+				}
+				fieldPath = joinConfigPath(path, name)
+			}
+
+			var err error
+			if field.Tag.Get("secret") == "true" {
+				err = visitSecret(value.Field(index), fieldPath, visit)
+			} else {
+				err = visitSecrets(value.Field(index), fieldPath, visit)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func visitSecret(value reflect.Value, path string, visit func(path string, secret string) (string, error)) error {
+	switch value.Kind() {
+	case reflect.String:
+		if value.String() == "" {
+			return nil
+		}
+
+		secret, err := visit(path, value.String())
+		if err != nil {
+			return err
+		}
+		value.SetString(secret)
+	case reflect.Map:
+		for _, key := range value.MapKeys() {
+			secret, err := visit(joinConfigPath(path, key.String()), value.MapIndex(key).String())
+			if err != nil {
+				return err
+			}
+			value.SetMapIndex(key, reflect.ValueOf(secret))
+		}
+	}
+
+	return nil
+}
+
+// resolveConfigSecrets replaces the `env:`, `file:` and `vault:` references in the secrets of config with the secrets they reference
+func resolveConfigSecrets(config *ConfigStruct) error {
+	resolver := &pkg.SecretResolver{}
+
+	// Vault needs its token before anything can be read from it
+	if config.Vault != nil {
+		if strings.HasPrefix(config.Vault.Token, pkg.SecretRefVault) {
+			return errors.New("vault.token can't be read from Vault. Use an env: or file: reference")
+		}
+
+		token, err := resolver.Resolve(config.Vault.Token)
+		if err != nil {
+			return fmt.Errorf("vault.token: %s", err)
+		}
+		config.Vault.Token = token
+		resolver.Vault = config.Vault
+	}
+
+	return visitSecrets(reflect.ValueOf(config).Elem(), "", func(path string, secret string) (string, error) {
+		resolved, err := resolver.Resolve(secret)
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", path, err)
+		}
+		return resolved, nil
+	})
+}
@@ -0,0 +1,472 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+// The server config of a full backup is stored next to it as mysql-backup-$TIMESTAMP.full.server.tar.gz
+const serverConfigArchiveSuffix = ".server.tar.gz"
+const serverConfigManifestName = "manifest.json"
+const serverConfigFilesDirectory = "config"
+
+// Read by mysqld when `mysqld --verbose --help` doesn't say otherwise
+var defaultMysqlOptionFiles = []string{"/etc/my.cnf", "/etc/mysql/my.cnf"}
+
+// serverConfigManifest describes the contents of a server config archive
+type serverConfigManifest struct {
+	CreatedAt   time.Time `json:"created_at"`
+	Hostname    string    `json:"hostname"`
+	Backup      string    `json:"backup"`
+	Version     string    `json:"version"`
+	ConfigFiles []string  `json:"config_files"`
+
+	// Parts that could not be captured
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type serverConfigFile struct {
+	Name     string
+	Mode     os.FileMode
+	Contents []byte
+}
+
+func serverConfigArchivePath(backupPath string) string {
+	return strings.TrimSuffix(backupPath, ".xbstream") + serverConfigArchiveSuffix
+}
+
+// backupServerConfig captures the config, grants, version and variables of the MySQL server and uploads them next to the backup at objectKey
+func backupServerConfig(ctx context.Context, backupFile string, objectKey string, hostname string, backupsBucket string, minioClient *minio.Client) error {
+	manifest, files := captureServerConfig(ctx, hostname, path.Base(backupFile))
+
+	archivePath := serverConfigArchivePath(backupFile)
+	err := writeServerConfigArchive(archivePath, manifest, files)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archivePath)
+
+	targetFileName := serverConfigArchivePath(objectKey)
+	err = pkg.WithRetryContext(ctx, "upload server config", func() error {
+		return pkg.UploadFileToBucket(ctx, backupsBucket, targetFileName, archivePath, minioClient)
+	})
+	if err != nil {
+		return err
+	}
+
+	pkg.Log.Printf("Uploaded server config with %d config %s to %s\n", len(manifest.ConfigFiles), pluralize(len(manifest.ConfigFiles), "file", "files"), targetFileName)
+
+	if len(manifest.Warnings) > 0 {
+		return errors.New("Server config is incomplete: " + strings.Join(manifest.Warnings, "; "))
+	}
+	return nil
+}
+
+// captureServerConfig collects everything it can. What fails is listed in the warnings of the manifest
+func captureServerConfig(ctx context.Context, hostname string, backupName string) (serverConfigManifest, []serverConfigFile) {
+	manifest := serverConfigManifest{
+		CreatedAt:   time.Now(),
+		Hostname:    hostname,
+		Backup:      backupName,
+		ConfigFiles: make([]string, 0),
+	}
+	files := make([]serverConfigFile, 0)
+
+	for _, optionFile := range collectMysqlOptionFiles(ctx) {
+		contents, err := ioutil.ReadFile(optionFile)
+		if err != nil {
+			manifest.Warnings = append(manifest.Warnings, err.Error())
+			continue
+		}
+
+		mode := os.FileMode(0644)
+		if stat, statErr := os.Stat(optionFile); statErr == nil {
+			mode = stat.Mode().Perm()
+		}
+
+		manifest.ConfigFiles = append(manifest.ConfigFiles, optionFile)
+		files = append(files, serverConfigFile{Name: path.Join(serverConfigFilesDirectory, optionFile), Mode: mode, Contents: contents})
+	}
+
+	version, err := mysqlQuery(ctx, "SELECT @@version")
+	if err != nil {
+		manifest.Warnings = append(manifest.Warnings, "Could not read the version: "+err.Error())
+	}
+	manifest.Version = strings.TrimSpace(version)
+
+	variables, err := mysqlQuery(ctx, "SHOW GLOBAL VARIABLES")
+	if err != nil {
+		manifest.Warnings = append(manifest.Warnings, "Could not read the variables: "+err.Error())
+	} else {
+		files = append(files, serverConfigFile{Name: "variables.tsv", Mode: 0600, Contents: []byte(variables)})
+	}
+
+	grants, err := captureGrants(ctx)
+	if err != nil {
+		manifest.Warnings = append(manifest.Warnings, "Could not read the grants: "+err.Error())
+	} else {
+		files = append(files, serverConfigFile{Name: "grants.sql", Mode: 0600, Contents: []byte(grants)})
+	}
+
+	return manifest, files
+}
+
+// collectMysqlOptionFiles returns the option files mysqld reads and the files they include.
+// Files in home directories are left out since they hold client credentials
+func collectMysqlOptionFiles(ctx context.Context) []string {
+	candidates := defaultMysqlOptionFiles
+	if helpOutput, err := pkg.PerformCommandContext(ctx, "mysqld", "--verbose", "--help"); err == nil {
+		if parsed := parseMysqldOptionFiles(helpOutput); len(parsed) > 0 {
+			candidates = parsed
+		}
+	}
+
+	found := make([]string, 0)
+	seen := make(map[string]bool)
+
+	var visit func(optionFile string)
+	visit = func(optionFile string) {
+		if seen[optionFile] {
+			return
+		}
+		seen[optionFile] = true
+
+		contents, err := ioutil.ReadFile(optionFile)
+		if err != nil {
+			return
+		}
+		found = append(found, optionFile)
+
+		includedFiles, includedDirectories := parseOptionFileIncludes(string(contents))
+		for _, directory := range includedDirectories {
+			matches, _ := filepath.Glob(path.Join(directory, "*.cnf"))
+			sort.Strings(matches)
+			includedFiles = append(includedFiles, matches...)
+		}
+
+		for _, includedFile := range includedFiles {
+			visit(includedFile)
+		}
+	}
+
+	for _, candidate := range candidates {
+		visit(candidate)
+	}
+
+	return found
+}
+
+// parseMysqldOptionFiles reads the option files from the output of `mysqld --verbose --help`
+func parseMysqldOptionFiles(helpOutput string) []string {
+	lines := strings.Split(helpOutput, "\n")
+	for index, line := range lines {
+		if !strings.HasPrefix(line, "Default options are read from the following files") || index+1 >= len(lines) {
+			continue
+		}
+
+		optionFiles := make([]string, 0)
+		for _, optionFile := range strings.Fields(lines[index+1]) {
+			if strings.HasPrefix(optionFile, "/") {
+				optionFiles = append(optionFiles, optionFile)
+			}
+		}
+		return optionFiles
+	}
+
+	return nil
+}
+
+// parseOptionFileIncludes returns the files of the !include and the directories of the !includedir directives in an option file
+func parseOptionFileIncludes(contents string) ([]string, []string) {
+	files := make([]string, 0)
+	directories := make([]string, 0)
+
+	for _, line := range strings.Split(contents, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "!include":
+			files = append(files, fields[1])
+		case "!includedir":
+			directories = append(directories, fields[1])
+		}
+	}
+
+	return files, directories
+}
+
+// captureGrants returns CREATE USER and GRANT statements for every user
+func captureGrants(ctx context.Context) (string, error) {
+	users, err := mysqlQuery(ctx, "SELECT CONCAT(QUOTE(user), '@', QUOTE(host)) FROM mysql.user ORDER BY user, host")
+	if err != nil {
+		return "", err
+	}
+
+	var grants bytes.Buffer
+	for _, user := range strings.Split(strings.TrimSpace(users), "\n") {
+		if user == "" {
+			continue
+		}
+
+		fmt.Fprintf(&grants, "-- %s\n", user)
+
+		// Not supported before MySQL 5.7
+		if createUser, createErr := mysqlQuery(ctx, "SHOW CREATE USER "+user); createErr == nil {
+			writeStatements(&grants, createUser)
+		}
+
+		userGrants, err := mysqlQuery(ctx, "SHOW GRANTS FOR "+user)
+		if err != nil {
+			return "", err
+		}
+		writeStatements(&grants, userGrants)
+		grants.WriteString("\n")
+	}
+
+	return grants.String(), nil
+}
+
+func writeStatements(output *bytes.Buffer, statements string) {
+	for _, statement := range strings.Split(strings.TrimSpace(statements), "\n") {
+		if statement != "" {
+			output.WriteString(statement + ";\n")
+		}
+	}
+}
+
+func mysqlQuery(ctx context.Context, query string) (string, error) {
+	args := []string{"--batch", "--raw", "--skip-column-names", "--execute", query}
+	if configStruct.Mysql.Socket != "" {
+		args = append(args, "--socket", configStruct.Mysql.Socket)
+	}
+
+	// Grants include password hashes
+	return pkg.RunCommand(ctx, pkg.Command{Name: "mysql", Args: args, PrivateOutput: true})
+}
+
+func writeServerConfigArchive(archivePath string, manifest serverConfigManifest, files []serverConfigFile) error {
+	manifestContents, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	files = append([]serverConfigFile{{Name: serverConfigManifestName, Mode: 0644, Contents: manifestContents}}, files...)
+
+	archiveFile, err := os.OpenFile(archivePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	gzipWriter := gzip.NewWriter(archiveFile)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, file := range files {
+		err = tarWriter.WriteHeader(&tar.Header{
+			Name:    file.Name,
+			Mode:    int64(file.Mode),
+			Size:    int64(len(file.Contents)),
+			ModTime: manifest.CreatedAt,
+		})
+		if err == nil {
+			_, err = tarWriter.Write(file.Contents)
+		}
+		if err != nil {
+			archiveFile.Close()
+			return err
+		}
+	}
+
+	for _, closer := range []io.Closer{tarWriter, gzipWriter, archiveFile} {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// extractServerConfigArchive extracts the archive in reader into directory and returns its manifest
+func extractServerConfigArchive(reader io.Reader, directory string) (serverConfigManifest, error) {
+	var manifest serverConfigManifest
+
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return manifest, err
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, err
+		}
+
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return manifest, errors.New("Unexpected path in server config archive: " + header.Name)
+		}
+
+		target := path.Join(directory, name)
+		err = os.MkdirAll(path.Dir(target), 0700)
+		if err != nil {
+			return manifest, err
+		}
+
+		contents, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return manifest, err
+		}
+
+		err = ioutil.WriteFile(target, contents, os.FileMode(header.Mode).Perm())
+		if err != nil {
+			return manifest, err
+		}
+
+		if name == serverConfigManifestName {
+			err = json.Unmarshal(contents, &manifest)
+			if err != nil {
+				return manifest, err
+			}
+		}
+	}
+
+	return manifest, nil
+}
+
+// restoreServerConfig downloads the server config captured with the full backup of the restored lineage
+// and offers to install the config files that differ from the ones on this server
+func restoreServerConfig(ctx context.Context, run *runRecord, assumeYes bool, backupsBucket string, minioClient *minio.Client) error {
+	if run == nil || len(run.restoredBackups) == 0 {
+		return nil
+	}
+
+	fullBackup := run.restoredBackups[len(run.restoredBackups)-1]
+	objectPath := serverConfigArchivePath(fullBackup.Path)
+
+	_, err := minioClient.StatObject(backupsBucket, objectPath, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			pkg.Log.Println("No server config was captured with this backup")
+			return nil
+		}
+		return err
+	}
+
+	object, err := minioClient.GetObjectWithContext(ctx, backupsBucket, objectPath, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	directory := path.Join(configStruct.PersistentStorage, "server-config", strings.TrimSuffix(path.Base(objectPath), serverConfigArchiveSuffix))
+	err = os.RemoveAll(directory)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := extractServerConfigArchive(object, directory)
+	if err != nil {
+		return err
+	}
+
+	pkg.Log.Printf("Server config of the backup, including its grants and variables, is in %s\n", directory)
+
+	if localVersion, versionErr := pkg.PerformCommand("mysqld", "--version"); versionErr == nil && manifest.Version != "" {
+		if !strings.Contains(localVersion, " "+majorMinorVersion(manifest.Version)+".") {
+			pkg.ErrorLog.Printf("Warning: The backup was taken with MySQL %s, this server has %s\n", manifest.Version, strings.TrimSpace(localVersion))
+		}
+	}
+
+	changedFiles := make([]string, 0)
+	for _, configFile := range manifest.ConfigFiles {
+		backedUp, readErr := ioutil.ReadFile(path.Join(directory, serverConfigFilesDirectory, configFile))
+		if readErr != nil {
+			return readErr
+		}
+
+		current, readErr := ioutil.ReadFile(configFile)
+		if readErr != nil || !bytes.Equal(current, backedUp) {
+			changedFiles = append(changedFiles, configFile)
+		}
+	}
+
+	if len(changedFiles) == 0 {
+		pkg.Log.Println("The MySQL config files of this server match the backup")
+		return nil
+	}
+
+	pkg.Log.Printf("%d MySQL config %s differ from the backup:\n", len(changedFiles), pluralize(len(changedFiles), "file", "files"))
+	for _, configFile := range changedFiles {
+		pkg.Log.Println("  " + configFile)
+	}
+
+	if !assumeYes && !askForConfirmation("\nInstall the config files of the backup before starting MySQL? Current files are kept as .before-restore (yes or y to accept)") {
+		pkg.Log.Println("Not installing the config files of the backup")
+		return nil
+	}
+
+	for _, configFile := range changedFiles {
+		backedUpPath := path.Join(directory, serverConfigFilesDirectory, configFile)
+		contents, readErr := ioutil.ReadFile(backedUpPath)
+		if readErr != nil {
+			return readErr
+		}
+
+		mode := os.FileMode(0644)
+		if stat, statErr := os.Stat(backedUpPath); statErr == nil {
+			mode = stat.Mode().Perm()
+		}
+
+		if stat, statErr := os.Stat(configFile); statErr == nil {
+			mode = stat.Mode().Perm()
+			err = os.Rename(configFile, configFile+".before-restore")
+			if err != nil {
+				return err
+			}
+		}
+
+		err = os.MkdirAll(path.Dir(configFile), 0755)
+		if err != nil {
+			return err
+		}
+
+		err = ioutil.WriteFile(configFile, contents, mode)
+		if err != nil {
+			return err
+		}
+
+		pkg.Log.Println("Installed", configFile)
+	}
+
+	return nil
+}
+
+// majorMinorVersion returns 5.7 for 5.7.26-log
+func majorMinorVersion(version string) string {
+	pieces := strings.SplitN(version, ".", 3)
+	if len(pieces) < 2 {
+		return version
+	}
+	return pieces[0] + "." + pieces[1]
+}
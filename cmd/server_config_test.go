@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMysqldOptionFiles(t *testing.T) {
+	helpOutput := `mysqld  Ver 5.7.26 for Linux on x86_64 (MySQL Community Server (GPL))
+Copyright (c) 2000, 2019, Oracle and/or its affiliates. All rights reserved.
+
+Starts the MySQL database server.
+
+Usage: mysqld [OPTIONS]
+
+Default options are read from the following files in the given order:
+/etc/my.cnf /etc/mysql/my.cnf ~/.my.cnf
+The following groups are read: mysqld server mysqld-5.7
+`
+
+	optionFiles := parseMysqldOptionFiles(helpOutput)
+	if !reflect.DeepEqual(optionFiles, []string{"/etc/my.cnf", "/etc/mysql/my.cnf"}) {
+		t.Errorf("Incorrect option files: %v", optionFiles)
+	}
+
+	if parseMysqldOptionFiles("mysqld: unknown option") != nil {
+		t.Error("Expected no option files without the list")
+	}
+}
+
+func TestParseOptionFileIncludes(t *testing.T) {
+	contents := `[mysqld]
+innodb_buffer_pool_size = 4G
+# !include /etc/mysql/commented.cnf
+!include /etc/mysql/extra.cnf
+!includedir /etc/mysql/conf.d/
+!includedir /etc/mysql/mysql.conf.d/
+`
+
+	files, directories := parseOptionFileIncludes(contents)
+	if !reflect.DeepEqual(files, []string{"/etc/mysql/extra.cnf"}) {
+		t.Errorf("Incorrect included files: %v", files)
+	}
+	if !reflect.DeepEqual(directories, []string{"/etc/mysql/conf.d/", "/etc/mysql/mysql.conf.d/"}) {
+		t.Errorf("Incorrect included directories: %v", directories)
+	}
+}
+
+func TestServerConfigArchiveIsNotABackup(t *testing.T) {
+	archivePath := serverConfigArchivePath("db1/mysql-backup-201901011000.full.xbstream")
+	if archivePath != "db1/mysql-backup-201901011000.full.server.tar.gz" {
+		t.Errorf("Incorrect archive path: %s", archivePath)
+	}
+
+	if _, _, err := parseBackupName(archivePath); err == nil {
+		t.Error("Expected the server config archive not to be listed as a backup")
+	}
+}
+
+func TestServerConfigArchiveRoundTrip(t *testing.T) {
+	directory, err := ioutil.TempDir("", "server-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	manifest := serverConfigManifest{
+		CreatedAt:   time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC),
+		Hostname:    "db1",
+		Backup:      "mysql-backup-201901011000.full.xbstream",
+		Version:     "5.7.26-log",
+		ConfigFiles: []string{"/etc/mysql/my.cnf"},
+	}
+	files := []serverConfigFile{
+		{Name: "config/etc/mysql/my.cnf", Mode: 0644, Contents: []byte("[mysqld]\n")},
+		{Name: "grants.sql", Mode: 0600, Contents: []byte("GRANT USAGE ON *.* TO 'app'@'%';\n")},
+	}
+
+	archivePath := path.Join(directory, "archive.tar.gz")
+	err = writeServerConfigArchive(archivePath, manifest, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	extractDirectory := path.Join(directory, "extracted")
+	extracted, err := extractServerConfigArchive(archive, extractDirectory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(extracted, manifest) {
+		t.Errorf("Incorrect manifest: %+v", extracted)
+	}
+
+	contents, _ := ioutil.ReadFile(path.Join(extractDirectory, "config/etc/mysql/my.cnf"))
+	if string(contents) != "[mysqld]\n" {
+		t.Errorf("Incorrect config file: %q", contents)
+	}
+
+	stat, err := os.Stat(path.Join(extractDirectory, "grants.sql"))
+	if err != nil || stat.Mode().Perm() != 0600 {
+		t.Errorf("Expected grants to keep their mode: %v %v", stat, err)
+	}
+}
+
+func TestExtractServerConfigArchiveRejectsEscapingPaths(t *testing.T) {
+	var archive bytes.Buffer
+	gzipWriter := gzip.NewWriter(&archive)
+	tarWriter := tar.NewWriter(gzipWriter)
+	tarWriter.WriteHeader(&tar.Header{Name: "../../etc/passwd", Mode: 0644, Size: 1})
+	tarWriter.Write([]byte("x"))
+	tarWriter.Close()
+	gzipWriter.Close()
+
+	directory, err := ioutil.TempDir("", "server-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	_, err = extractServerConfigArchive(&archive, path.Join(directory, "extracted"))
+	if err == nil {
+		t.Error("Expected a path outside of the directory to be rejected")
+	}
+}
+
+func TestMajorMinorVersion(t *testing.T) {
+	if version := majorMinorVersion("5.7.26-log"); version != "5.7" {
+		t.Errorf("Incorrect version: %s", version)
+	}
+	if version := majorMinorVersion("8"); version != "8" {
+		t.Errorf("Incorrect version: %s", version)
+	}
+}
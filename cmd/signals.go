@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+)
+
+// cancelOnSignal returns a context that is cancelled on the first SIGINT or SIGTERM.
+// Later signals are swallowed so the cleanup that follows the cancellation is not interrupted.
+func cancelOnSignal(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case receivedSignal := <-signals:
+			pkg.ErrorLog.Printf("Received %s. Aborting and cleaning up, this can take a few minutes.\n", receivedSignal)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+)
+
+// Stages a run goes through. Used to label failures in metrics and log lines
+const stagePrerequisites = "prerequisites"
+const stageLock = "lock"
+const stageDecide = "decide"
+const stageVolume = "volume"
+const stageXtrabackup = "xtrabackup"
+const stageUpload = "upload"
+const stagePrune = "prune"
+const stageList = "list"
+const stageDownload = "download"
+const stagePrepare = "prepare"
+const stageCopyBack = "copy-back"
+const stageVerify = "verify"
+const stageCleanup = "cleanup"
+const stageHook = "hook"
+const stageServerConfig = "server-config"
+const stageInterrupted = "interrupted"
+const stageUnknown = "unknown"
+
+type stageError struct {
+	Stage string
+	Err   error
+}
+
+func (err *stageError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *stageError) Unwrap() error {
+	return err.Err
+}
+
+// withStage marks err as having happened in stage. An error that already has a stage keeps it
+func withStage(stage string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var existing *stageError
+	if errors.As(err, &existing) {
+		return err
+	}
+
+	return &stageError{Stage: stage, Err: err}
+}
+
+func errorStage(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var existing *stageError
+	if errors.As(err, &existing) {
+		return existing.Stage
+	}
+
+	return stageUnknown
+}
+
+// enterStage marks the start of stage in the log lines of the current run
+func enterStage(stage string) {
+	pkg.SetLogStage(stage)
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+	minio "github.com/minio/minio-go"
+)
+
+// backupStatusReport is everything the `status` command shows
+type backupStatusReport struct {
+	Hostname string
+	Now      time.Time
+	Runs     []runRecord
+
+	// Oldest first, starting with the full backup
+	Lineage []backupItem
+
+	LastSuccessfulBackup *runRecord
+	LastBackupAt         time.Time
+
+	NextPerformType   string
+	NextPerformReason string
+}
+
+// backupStatus prints the last runs of hostname, its current lineage and what the next `perform` will do
+func backupStatus(hostname string, limit int, minioClient *minio.Client) error {
+	bucket := configStruct.DigitalOcean.SpaceName
+
+	runs, err := loadRunHistory(hostname, bucket, limit, minioClient)
+	if err != nil {
+		return err
+	}
+
+	allBackups, err := listAllBackups(hostname, bucket, minioClient)
+	if err != nil {
+		return err
+	}
+
+	// The checkpoint file only exists on the host itself
+	hasCheckpoint := true
+	if hostname == configStruct.hostDirectory() {
+		lastLsn, lsnErr := getLastLSNFromFile(path.Join(configStruct.PersistentStorage, "xtrabackup_checkpoints"))
+		hasCheckpoint = lsnErr == nil && len(lastLsn) > 0
+	}
+
+	report := buildBackupStatusReport(hostname, runs, allBackups, hasCheckpoint, configStruct.Retention, time.Now())
+	report.Write(os.Stdout)
+
+	return nil
+}
+
+func buildBackupStatusReport(hostname string, runs []runRecord, allBackups []backupItem, hasCheckpoint bool, retentionConfig *RetentionConfig, now time.Time) backupStatusReport {
+	report := backupStatusReport{
+		Hostname: hostname,
+		Now:      now,
+		Runs:     runs,
+	}
+
+	lineage := findRelevantBackupsUpTo(now, allBackups)
+	for index := len(lineage) - 1; index >= 0; index-- {
+		report.Lineage = append(report.Lineage, lineage[index])
+	}
+
+	if len(lineage) > 0 {
+		report.LastBackupAt = lineage[0].CreatedAt
+	}
+
+	for index := len(runs) - 1; index >= 0; index-- {
+		if runs[index].Success && runs[index].BackupName != "" {
+			report.LastSuccessfulBackup = &runs[index]
+			break
+		}
+	}
+
+	report.NextPerformType, report.NextPerformReason = describeNextPerform(lineage, allBackups, hasCheckpoint, retentionConfig, now)
+
+	return report
+}
+
+// describeNextPerform returns the backup type `perform` would decide on now, and why
+func describeNextPerform(lineage []backupItem, allBackups []backupItem, hasCheckpoint bool, retentionConfig *RetentionConfig, now time.Time) (string, string) {
+	if !hasCheckpoint {
+		return backupTypeFull, "there is no xtrabackup_checkpoints file in persistent storage"
+	}
+
+	if len(lineage) == 0 {
+		return backupTypeFull, "there is no full backup"
+	}
+
+	if retentionConfig == nil {
+		retentionConfig = &RetentionConfig{}
+	}
+
+	backupType := decideBackupType(allBackups, now, retentionConfig)
+	nextFullAt := lineage[len(lineage)-1].CreatedAt.Add(time.Duration(retentionConfig.HoursBetweenFullBackups) * time.Hour)
+
+	if backupType == backupTypeIncremental {
+		return backupType, fmt.Sprintf("next full backup due in %s", nextFullAt.Sub(now).Truncate(time.Minute))
+	}
+
+	return backupType, fmt.Sprintf("the last full backup is older than %d hours", retentionConfig.HoursBetweenFullBackups)
+}
+
+// Write prints the report
+func (report backupStatusReport) Write(output io.Writer) {
+	fmt.Fprintf(output, "Status of %s\n\n", report.Hostname)
+
+	fmt.Fprintf(output, "Last %d %s:\n", len(report.Runs), pluralize(len(report.Runs), "run", "runs"))
+	if len(report.Runs) == 0 {
+		fmt.Fprintln(output, "  No runs recorded")
+	} else {
+		table := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "  STARTED\tJOB\tRESULT\tDURATION\tBACKUP\tSIZE\tRUN")
+		for _, run := range report.Runs {
+			result := "OK"
+			if !run.Success {
+				result = "FAILED (" + run.FailedStage + ")"
+			}
+
+			size := ""
+			if run.SizeInBytes > 0 {
+				size = pkg.FormatBytes(run.SizeInBytes)
+			}
+
+			fmt.Fprintf(table, "  %s\t%s\t%s\t%s\t%s\t%s\t%s\n", run.StartedAt.Local().Format("2006-01-02 15:04"), run.Job, result, run.duration().Truncate(time.Second), run.BackupName, size, run.ID)
+		}
+		table.Flush()
+	}
+
+	fmt.Fprintln(output)
+
+	if len(report.Lineage) == 0 {
+		fmt.Fprintln(output, "Current lineage: no restorable backup")
+	} else {
+		totalSize := int64(0)
+		for _, backup := range report.Lineage {
+			totalSize += backup.Size
+		}
+
+		fmt.Fprintf(output, "Current lineage (%d %s, %s):\n", len(report.Lineage), pluralize(len(report.Lineage), "piece", "pieces"), pkg.FormatBytes(totalSize))
+		writeBackupTable(output, report.Lineage)
+	}
+
+	fmt.Fprintln(output)
+
+	if report.LastBackupAt.IsZero() {
+		fmt.Fprintln(output, "Last backup: never")
+	} else {
+		fmt.Fprintf(output, "Last backup: %s ago (%s)\n", report.Now.Sub(report.LastBackupAt).Truncate(time.Minute), report.LastBackupAt.Format("2006-01-02 15:04"))
+	}
+
+	if report.LastSuccessfulBackup != nil {
+		endedAt := report.LastSuccessfulBackup.EndedAt
+		if endedAt.IsZero() {
+			endedAt = report.LastSuccessfulBackup.StartedAt.Add(report.LastSuccessfulBackup.duration())
+		}
+		fmt.Fprintf(output, "Last successful backup run: %s ago (%s, run %s)\n", report.Now.Sub(endedAt).Truncate(time.Minute), report.LastSuccessfulBackup.Job, report.LastSuccessfulBackup.ID)
+	}
+
+	fmt.Fprintf(output, "Next perform: %s, %s\n", report.NextPerformType, report.NextPerformReason)
+}
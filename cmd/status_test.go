@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackupStatusReport(t *testing.T) {
+	retentionConfig := &RetentionConfig{
+		HoursBetweenFullBackups: 24,
+	}
+
+	allBackups := []backupItem{
+		buildBackup(1, "db1/mysql-backup-201901011000.full.xbstream", 100),
+		buildBackup(1, "db1/mysql-backup-201901011100.incremental.xbstream", 10),
+		buildBackup(1, "db1/mysql-backup-201901011200.incremental.xbstream", 20),
+	}
+
+	now, _ := parseBackupTimestamp("201901011300")
+
+	runs := []runRecord{
+		{ID: "run1", Job: "perform", StartedAt: now.Add(-2 * time.Hour), DurationSeconds: 60, Success: true, BackupType: backupTypeIncremental, BackupName: "mysql-backup-201901011100.incremental", SizeInBytes: 10},
+		{ID: "run2", Job: "perform", StartedAt: now.Add(-time.Hour), DurationSeconds: 30, Success: false, FailedStage: stageUpload},
+	}
+
+	report := buildBackupStatusReport("db1", runs, allBackups, true, retentionConfig, now)
+
+	if len(report.Lineage) != 3 || report.Lineage[0].BackupType != backupTypeFull {
+		t.Errorf("Expected lineage of 3 starting with the full backup: %v", report.Lineage)
+	}
+
+	if report.LastSuccessfulBackup == nil || report.LastSuccessfulBackup.ID != "run1" {
+		t.Errorf("Incorrect last successful backup: %v", report.LastSuccessfulBackup)
+	}
+
+	if report.NextPerformType != backupTypeIncremental || report.NextPerformReason != "next full backup due in 21h0m0s" {
+		t.Errorf("Incorrect next perform: %s, %s", report.NextPerformType, report.NextPerformReason)
+	}
+
+	var output bytes.Buffer
+	report.Write(&output)
+
+	for _, expected := range []string{
+		"Last 2 runs:",
+		"FAILED (upload)",
+		"Current lineage (3 pieces, 130 B):",
+		"Last backup: 1h0m0s ago",
+		"Next perform: incremental",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Expected %q in:\n%s", expected, output.String())
+		}
+	}
+
+	// Without a checkpoint file xtrabackup can't do an incremental backup
+	report = buildBackupStatusReport("db1", runs, allBackups, false, retentionConfig, now)
+	if report.NextPerformType != backupTypeFull {
+		t.Errorf("Expected full backup without checkpoint, got %s", report.NextPerformType)
+	}
+
+	// A day after the full backup
+	later, _ := parseBackupTimestamp("201901021100")
+	report = buildBackupStatusReport("db1", runs, allBackups, true, retentionConfig, later)
+	if report.NextPerformType != backupTypeFull || report.NextPerformReason != "the last full backup is older than 24 hours" {
+		t.Errorf("Incorrect next perform: %s, %s", report.NextPerformType, report.NextPerformReason)
+	}
+}
+
+func TestHistoryObjectName(t *testing.T) {
+	run := &runRecord{ID: "abc123", Hostname: "db1", StartedAt: time.Date(2019, 1, 1, 10, 0, 5, 0, time.UTC)}
+
+	objectName := historyObjectName(run)
+	if objectName != "db1/_history/20190101T100005Z-abc123.json" {
+		t.Errorf("Incorrect history object name: %s", objectName)
+	}
+
+	// History entries are never mistaken for backups
+	if _, _, err := parseBackupName(objectName); err == nil {
+		t.Error("Expected history object name not to parse as a backup")
+	}
+}
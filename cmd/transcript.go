@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"path"
+
+	"github.com/feederco/really-simple-db-backup/pkg"
+)
+
+// transcriptName is the file the programs of run are recorded in, like 20190123T203941Z-perform-full-4f2a9c1b3e7d.log
+func transcriptName(run *runRecord) string {
+	return run.StartedAt.UTC().Format("20060102T150405Z") + "-" + run.Job + "-" + run.ID + ".log"
+}
+
+// startTranscript records the programs run by run when commands.transcript_directory is set.
+// Only runs that work on backups are recorded
+func startTranscript(run *runRecord) {
+	if configStruct.Commands == nil || configStruct.Commands.TranscriptDirectory == "" || !isRecordedJob(run.Job) {
+		return
+	}
+
+	run.transcript = pkg.NewTranscriptRunner(pkg.CommandRunner, path.Join(configStruct.Commands.TranscriptDirectory, transcriptName(run)))
+	pkg.CommandRunner = run.transcript
+}
+
+// endTranscript stops recording the programs of run
+func endTranscript(run *runRecord) {
+	if run.transcript == nil {
+		return
+	}
+
+	pkg.CommandRunner = run.transcript.Runner
+	if err := run.transcript.Close(); err != nil {
+		pkg.ErrorLog.Println("Warning: Could not write the transcript of the commands of this run.", err)
+	}
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"path"
+	"regexp"
 	"strings"
 	"time"
 
@@ -9,6 +10,26 @@ import (
 	"github.com/feederco/really-simple-db-backup/pkg"
 )
 
+const volumePrefixBackup = "mysql-backup-"
+const volumePrefixRestore = "mysql-restore-"
+
+// Volumes are named after the second they are created in, so runs started in the same minute don't collide
+const volumeNameTimeFormat = "20060102150405"
+
+// volumeTag is added to every volume created by this tool so `gc-volumes` can find them
+const volumeTag = "really-simple-db-backup"
+
+// Tags can only contain letters, numbers, colons, dashes and underscores
+var volumeTagInvalidCharacters = regexp.MustCompile(`[^a-zA-Z0-9:_-]`)
+
+// volumeHostTag is added to every volume created on hostname, so `gc-volumes` only removes the volumes of its own host without asking
+func volumeHostTag(hostname string) string {
+	return volumeTag + ":" + volumeTagInvalidCharacters.ReplaceAllString(hostname, "_")
+}
+
+// Volumes are mounted in a directory of their own in volumeMountRoot
+var volumeMountRoot = "/mnt/"
+
 func createAndMountVolumeForUse(volumePrefix string, sizeInGb int64, digitalOceanClient *pkg.DigitalOceanClient, existingVolumeID string, existingDirectory string) (*godo.Volume, string, error) {
 	if existingDirectory != "" {
 		return nil, existingDirectory, nil
@@ -23,7 +44,7 @@ func createAndMountVolumeForUse(volumePrefix string, sizeInGb int64, digitalOcea
 	var volume *godo.Volume
 
 	if existingVolumeID == "" {
-		timeID := time.Now().Format("200601021504")
+		timeID := time.Now().Format(volumeNameTimeFormat)
 
 		volumeName := volumePrefix + timeID
 		volumeDescription := "Volume created for a full MySQL backup on " + thisHost.Region + "." + thisHost.Hostname + " at " + timeID
@@ -37,6 +58,7 @@ func createAndMountVolumeForUse(volumePrefix string, sizeInGb int64, digitalOcea
 			Description:    volumeDescription,
 			SizeGigaBytes:  sizeInGb,
 			FilesystemType: fileSystemForVolume,
+			Tags:           []string{volumeTag, volumeHostTag(thisHost.Hostname)},
 		}
 
 		volume, err = pkg.CreateVolume(createRequest, digitalOceanClient)
@@ -57,14 +79,14 @@ func createAndMountVolumeForUse(volumePrefix string, sizeInGb int64, digitalOcea
 	pkg.Log.Println("Volume is being mounted.")
 
 	// - Mount that volume
-	mountDirectory := path.Join("/mnt/", strings.Replace(volume.Name, "-", "_", -1))
+	mountDirectory := volumeMountDirectory(volume)
 	pkg.Log.Println("Volume is being mounted.", volume.DropletIDs)
 
 	if len(volume.DropletIDs) == 0 || volume.DropletIDs[0] != thisHost.DropletID {
 		err = pkg.MountVolume(volume.Name, mountDirectory, volume.ID, thisHost.DropletID, digitalOceanClient)
 
 		if err != nil {
-			pkg.AlertError(configStruct.Alerting, "Could not mount volume "+volume.ID, err)
+			alertError(stageVolume, "Could not mount volume "+volume.ID, err)
 			return volume, mountDirectory, err
 		}
 
@@ -77,3 +99,7 @@ func createAndMountVolumeForUse(volumePrefix string, sizeInGb int64, digitalOcea
 
 	return volume, mountDirectory, nil
 }
+
+func volumeMountDirectory(volume *godo.Volume) string {
+	return path.Join(volumeMountRoot, strings.Replace(volume.Name, "-", "_", -1))
+}
@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/feederco/really-simple-db-backup/pkg"
+)
+
+const defaultVolumeGCOlderThanHours = 48
+
+// Unattached volumes younger than this can belong to a run that has just created it and not attached it yet
+const volumeGCMinimumAge = time.Hour
+
+type orphanedVolume struct {
+	Volume godo.Volume
+	Reason string
+
+	// Attached to this droplet. Only removed after confirmation
+	Attached bool
+
+	// Not created on this host, it could belong to a run on another droplet. Only removed after confirmation
+	Foreign bool
+}
+
+// errVolumeBusy is returned when a volume attached to this droplet is still in use
+var errVolumeBusy = errors.New("Volume is in use")
+
+// backupGCVolumes finds volumes left behind by failed runs and destroys them. Without confirmation
+// (with `-yes` or from the daemon) only unattached volumes created on this host are destroyed. Volumes attached to another droplet are never touched
+func backupGCVolumes(olderThanHours int, includeUntagged bool, skipConfirmation bool, digitalOceanClient *pkg.DigitalOceanClient) error {
+	if olderThanHours <= 0 {
+		olderThanHours = defaultVolumeGCOlderThanHours
+	}
+
+	volumes, err := pkg.ListVolumes(digitalOceanClient)
+	if err != nil {
+		return err
+	}
+
+	// Only needed to find the volumes of this host, so it's fine if this isn't a droplet. Every volume is foreign then
+	thisDropletID := 0
+	thisHostname := ""
+	if thisHost, metadataErr := pkg.GetRunningInstanceData(); metadataErr == nil {
+		thisDropletID = thisHost.DropletID
+		thisHostname = thisHost.Hostname
+	}
+
+	orphans := findOrphanedVolumes(volumes, time.Now(), time.Duration(olderThanHours)*time.Hour, includeUntagged, thisDropletID, thisHostname)
+
+	if skipConfirmation {
+		unattached := make([]orphanedVolume, 0, len(orphans))
+		for _, orphan := range orphans {
+			if orphan.Attached {
+				pkg.Log.Printf("Leaving volume %s (%s) alone. It is attached to this droplet, run gc-volumes without -yes to destroy it\n", orphan.Volume.Name, orphan.Volume.ID)
+				continue
+			}
+			if orphan.Foreign {
+				pkg.Log.Printf("Leaving volume %s (%s) alone. It wasn't created on this host, run gc-volumes without -yes to destroy it\n", orphan.Volume.Name, orphan.Volume.ID)
+				continue
+			}
+			unattached = append(unattached, orphan)
+		}
+		orphans = unattached
+	}
+
+	if len(orphans) == 0 {
+		pkg.Log.Println("No orphaned volumes found.")
+		return nil
+	}
+
+	fmt.Println("")
+	for index, orphan := range orphans {
+		fmt.Printf(
+			"#%d: %s (%s, %d GB, created %s) %s\n",
+			index+1,
+			orphan.Volume.Name,
+			orphan.Volume.ID,
+			orphan.Volume.SizeGigaBytes,
+			orphan.Volume.CreatedAt.Format(time.RFC3339),
+			orphan.Reason,
+		)
+	}
+
+	if !skipConfirmation && !askForConfirmation(fmt.Sprintf("\nDetach and destroy %d %s: (yes or y to accept)", len(orphans), pluralize(len(orphans), "volume", "volumes"))) {
+		pkg.Log.Println("Everything left as-is.")
+		return nil
+	}
+
+	destroyed := make([]string, 0)
+	for _, orphan := range orphans {
+		if orphan.Attached {
+			if holder, held := hostLockHolder(); held {
+				pkg.Log.Printf("Skipping volume %s (%s). `%s` (pid %d) is running on this host and might be using it\n", orphan.Volume.Name, orphan.Volume.ID, holder.Command, holder.PID)
+				continue
+			}
+		}
+
+		err = destroyOrphanedVolume(orphan, thisDropletID, digitalOceanClient)
+		if err == errVolumeBusy {
+			pkg.Log.Printf("Skipping volume %s (%s). Its mount is in use\n", orphan.Volume.Name, orphan.Volume.ID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Could not destroy volume %s (%s). Destroyed %d %s before failing: %s", orphan.Volume.Name, orphan.Volume.ID, len(destroyed), pluralize(len(destroyed), "volume", "volumes"), err)
+		}
+
+		pkg.Log.Printf("Destroyed volume %s (%s)\n", orphan.Volume.Name, orphan.Volume.ID)
+		destroyed = append(destroyed, orphan.Volume.Name)
+	}
+
+	if len(destroyed) > 0 {
+		pkg.AlertMessage(configStruct.Alerting, fmt.Sprintf("Destroyed %d orphaned %s: %s", len(destroyed), pluralize(len(destroyed), "volume", "volumes"), strings.Join(destroyed, ", ")))
+	}
+
+	return nil
+}
+
+// findOrphanedVolumes returns the volumes created by this tool that are unattached, and the ones attached to
+// thisDropletID for longer than olderThan. Volumes attached to other droplets belong to runs on those droplets.
+// Unattached volumes not created on thisHostname are marked as foreign
+func findOrphanedVolumes(volumes []godo.Volume, nowTime time.Time, olderThan time.Duration, includeUntagged bool, thisDropletID int, thisHostname string) []orphanedVolume {
+	orphans := make([]orphanedVolume, 0)
+
+	for _, volume := range volumes {
+		if !strings.HasPrefix(volume.Name, volumePrefixBackup) && !strings.HasPrefix(volume.Name, volumePrefixRestore) {
+			continue
+		}
+
+		if !includeUntagged && !hasTag(volume.Tags, volumeTag) {
+			continue
+		}
+
+		age := nowTime.Sub(volume.CreatedAt)
+
+		if len(volume.DropletIDs) == 0 {
+			if age <= volumeGCMinimumAge {
+				continue
+			}
+
+			// Volumes of older versions don't have a host tag
+			if thisHostname == "" || !hasTag(volume.Tags, volumeHostTag(thisHostname)) {
+				orphans = append(orphans, orphanedVolume{Volume: volume, Reason: "unattached, not created on this host", Foreign: true})
+			} else {
+				orphans = append(orphans, orphanedVolume{Volume: volume, Reason: "unattached"})
+			}
+		} else if thisDropletID != 0 && len(volume.DropletIDs) == 1 && volume.DropletIDs[0] == thisDropletID && age > olderThan {
+			orphans = append(orphans, orphanedVolume{Volume: volume, Reason: fmt.Sprintf("attached to this droplet for longer than %s", olderThan), Attached: true})
+		}
+	}
+
+	return orphans
+}
+
+// destroyOrphanedVolume unmounts and detaches orphan if it is attached to this droplet, and destroys it.
+// Returns errVolumeBusy without changing anything when the mount is in use
+func destroyOrphanedVolume(orphan orphanedVolume, thisDropletID int, digitalOceanClient *pkg.DigitalOceanClient) error {
+	if orphan.Attached {
+		mountDirectory := volumeMountDirectory(&orphan.Volume)
+
+		// Volumes are mounted on a directory of their own, which is removed when they are unmounted
+		if _, err := os.Stat(mountDirectory); err == nil {
+			_, err = pkg.PerformCommand("umount", mountDirectory)
+			if err != nil && strings.Contains(err.Error(), "busy") {
+				return errVolumeBusy
+			}
+			if err != nil {
+				return err
+			}
+
+			os.Remove(mountDirectory)
+		}
+
+		err := pkg.DetachVolume(orphan.Volume.ID, thisDropletID, digitalOceanClient)
+		if err != nil {
+			return err
+		}
+	}
+
+	return pkg.DestroyVolume(orphan.Volume.ID, digitalOceanClient)
+}
+
+// hostLockHolder returns the lock of a run on this host, of any job
+func hostLockHolder() (*pkg.HostLock, bool) {
+	directories := []string{configStruct.PersistentStorage}
+	if configStruct.Job == "" {
+		for _, name := range configStruct.jobNames() {
+			if jobConfig, err := configStruct.forJob(name); err == nil {
+				directories = append(directories, jobConfig.PersistentStorage)
+			}
+		}
+	}
+
+	for _, directory := range directories {
+		if holder, held := pkg.HostLockHolder(directory); held {
+			return holder, true
+		}
+	}
+
+	return nil, false
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, existingTag := range tags {
+		if existingTag == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestFindOrphanedVolumes(t *testing.T) {
+	nowTime := time.Date(2019, 1, 10, 10, 0, 0, 0, time.UTC)
+	tagged := []string{volumeTag, volumeHostTag("db1")}
+
+	volumes := []godo.Volume{
+		// Unattached for a day
+		{ID: "1", Name: "mysql-backup-201901091000", Tags: tagged, CreatedAt: nowTime.Add(-24 * time.Hour)},
+		// Attached and old
+		{ID: "2", Name: "mysql-restore-201901011000", Tags: tagged, DropletIDs: []int{1}, CreatedAt: nowTime.Add(-9 * 24 * time.Hour)},
+		// Attached and in use by a running backup
+		{ID: "3", Name: "mysql-backup-201901100900", Tags: tagged, DropletIDs: []int{1}, CreatedAt: nowTime.Add(-time.Hour)},
+		// Just created, not attached yet
+		{ID: "4", Name: "mysql-backup-201901100955", Tags: tagged, CreatedAt: nowTime.Add(-5 * time.Minute)},
+		// Not created by us
+		{ID: "5", Name: "mysql-data", Tags: tagged, CreatedAt: nowTime.Add(-24 * time.Hour)},
+		// Created before tagging
+		{ID: "6", Name: "mysql-backup-201812011000", CreatedAt: nowTime.Add(-40 * 24 * time.Hour)},
+		// Attached to another droplet of the fleet and old, like a volume kept after `download`
+		{ID: "7", Name: "mysql-restore-201901011100", Tags: tagged, DropletIDs: []int{2}, CreatedAt: nowTime.Add(-9 * 24 * time.Hour)},
+		// Unattached, left behind by a run on another host
+		{ID: "8", Name: "mysql-backup-201901091100", Tags: []string{volumeTag, volumeHostTag("db2")}, CreatedAt: nowTime.Add(-23 * time.Hour)},
+	}
+
+	orphans := findOrphanedVolumes(volumes, nowTime, 48*time.Hour, false, 1, "db1")
+	if len(orphans) != 3 {
+		t.Fatal("Expected 3 orphaned volumes, found", len(orphans))
+	}
+
+	if orphans[0].Volume.ID != "1" || orphans[1].Volume.ID != "2" || orphans[2].Volume.ID != "8" {
+		t.Errorf("Incorrect orphaned volumes found: %s, %s, %s", orphans[0].Volume.ID, orphans[1].Volume.ID, orphans[2].Volume.ID)
+	}
+
+	if orphans[0].Attached || !orphans[1].Attached || orphans[2].Attached {
+		t.Error("Expected only the second volume to be attached to this droplet")
+	}
+
+	if orphans[0].Foreign || orphans[1].Foreign || !orphans[2].Foreign {
+		t.Error("Expected only the volume of db2 to be foreign")
+	}
+
+	orphans = findOrphanedVolumes(volumes, nowTime, 48*time.Hour, true, 1, "db1")
+	if len(orphans) != 4 || orphans[2].Volume.ID != "6" || !orphans[2].Foreign {
+		t.Fatal("Expected 4 orphaned volumes including the untagged one as foreign, found", orphans)
+	}
+
+	// Off a droplet only unattached volumes can be orphans, and none of them are known to be of this host
+	orphans = findOrphanedVolumes(volumes, nowTime, 48*time.Hour, false, 0, "")
+	if len(orphans) != 2 || orphans[0].Volume.ID != "1" || !orphans[0].Foreign || !orphans[1].Foreign {
+		t.Errorf("Expected only the unattached volumes as foreign, found %v", orphans)
+	}
+}
+
+func TestVolumeHostTag(t *testing.T) {
+	if tag := volumeHostTag("db1.example.com"); tag != "really-simple-db-backup:db1_example_com" {
+		t.Errorf("Expected a valid tag, got %s", tag)
+	}
+}
@@ -1,6 +1,7 @@
 module github.com/feederco/really-simple-db-backup
 
 require (
+	github.com/BurntSushi/toml v0.3.0
 	github.com/cheggaaa/pb v1.0.27
 	github.com/digitalocean/go-metadata v0.0.0-20180111002115-15bd36e5f6f7
 	github.com/digitalocean/godo v1.29.0
@@ -12,6 +13,7 @@ require (
 	golang.org/x/crypto v0.0.0-20190103213133-ff983b9c42bc // indirect
 	golang.org/x/oauth2 v0.0.0-20190402181905-9f3314589c9a
 	golang.org/x/sys v0.0.0-20190121090251-770c60269bf0 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 go 1.13
@@ -1,7 +1,6 @@
 package pkg
 
 import (
-	"fmt"
 	"os"
 	"runtime"
 	"time"
@@ -11,46 +10,130 @@ import (
 
 // AlertingConfig sub-config type for alerting related
 type AlertingConfig struct {
-	Slack *alerting.SlackConfig `json:"slack"`
+	Slack     *alerting.SlackConfig     `json:"slack"`
+	PagerDuty *alerting.PagerDutyConfig `json:"pagerduty"`
+	Opsgenie  *alerting.OpsgenieConfig  `json:"opsgenie"`
+	Teams     *alerting.TeamsConfig     `json:"teams"`
+	Discord   *alerting.DiscordConfig   `json:"discord"`
+	Email     *alerting.EmailConfig     `json:"email"`
+	Webhooks  []*alerting.WebhookConfig `json:"webhooks"`
+
+	// Repeats of an unresolved failure are sent at most once per this many hours (Default: 6)
+	ThrottleHours int `json:"throttle_hours"`
+}
+
+// Registry returns a registry with an alerter for every configured provider
+func (alertingConfig *AlertingConfig) Registry() *alerting.Registry {
+	registry := &alerting.Registry{}
+	if alertingConfig == nil {
+		return registry
+	}
+
+	if alertingConfig.Slack != nil {
+		registry.Register(&alerting.SlackAlerter{Config: alertingConfig.Slack})
+	}
+	if alertingConfig.PagerDuty != nil {
+		registry.Register(&alerting.PagerDutyAlerter{Config: alertingConfig.PagerDuty})
+	}
+	if alertingConfig.Opsgenie != nil {
+		registry.Register(&alerting.OpsgenieAlerter{Config: alertingConfig.Opsgenie})
+	}
+	if alertingConfig.Teams != nil {
+		registry.Register(&alerting.TeamsAlerter{Config: alertingConfig.Teams})
+	}
+	if alertingConfig.Discord != nil {
+		registry.Register(&alerting.DiscordAlerter{Config: alertingConfig.Discord})
+	}
+	if alertingConfig.Email != nil {
+		registry.Register(&alerting.EmailAlerter{Config: alertingConfig.Email})
+	}
+	for _, webhookConfig := range alertingConfig.Webhooks {
+		registry.Register(&alerting.WebhookAlerter{Config: webhookConfig})
+	}
+
+	return registry
 }
 
 // AlertError alerts an error to the system administrator
 func AlertError(alertingConfig *AlertingConfig, message string, err error) {
-	hostname, _ := os.Hostname()
+	SendAlert(alertingConfig, NewErrorAlert(alerting.SeverityCritical, message, err))
+}
+
+// AlertMessage simply alerts a message to the correct channels
+func AlertMessage(alertingConfig *AlertingConfig, message string) {
+	SendAlert(alertingConfig, newAlert(message))
+}
+
+// NewErrorAlert builds an alert for a failure, including the current stack
+func NewErrorAlert(severity alerting.Severity, message string, err error) alerting.Alert {
+	alert := newAlert(message)
+	alert.IsError = true
+	alert.Severity = severity
+	if err != nil {
+		alert.Error = err.Error()
+	}
 
 	buf := make([]byte, 3000)
-	buf = buf[:runtime.Stack(buf, false)]
+	alert.Stack = string(buf[:runtime.Stack(buf, false)])
 
-	fullMessage := fmt.Sprintf("[*BACKUP FAILURE*] [%s] [host: `%s`] `%s` with error: `%s`\n", time.Now().Format(time.RFC3339), hostname, message, err)
-	fullMessageWithStack := fullMessage + "\n\n```\n" + string(buf) + "```"
+	return alert
+}
 
-	if alertingConfig != nil && alertingConfig.Slack != nil {
-		err := alerting.SlackLog(fullMessageWithStack, alertingConfig.Slack)
+// NewResolvedAlert builds an alert announcing that the failure alerted with dedupKey is over
+func NewResolvedAlert(dedupKey string, message string) alerting.Alert {
+	alert := newAlert(message)
+	alert.Severity = alerting.SeverityInfo
+	alert.Resolved = true
+	alert.DedupKey = dedupKey
 
-		if err != nil {
-			ErrorLog.Println("Warning: Could not alert to Slack.", err)
-			ErrorLog.Println("Original error", fullMessage)
+	return alert
+}
+
+// SendAlert sends alert to every configured provider and logs it
+func SendAlert(alertingConfig *AlertingConfig, alert alerting.Alert) {
+	for _, result := range alertingConfig.Registry().Send(alert) {
+		if result.Err != nil {
+			if alert.IsError {
+				ErrorLog.Printf("Warning: Could not alert to %s. %s\n", result.Name, result.Err)
+				ErrorLog.Println("Original error", alert.Text())
+			} else {
+				Log.Printf("Warning: Could not alert to %s. %s\n", result.Name, result.Err)
+			}
 		}
 	}
 
-	// Always print to error log
-	ErrorLog.Print(fullMessage)
+	LogAlert(alert)
 }
 
-// AlertMessage simply alerts a message to the correct channels
-func AlertMessage(alertingConfig *AlertingConfig, message string) {
-	hostname, _ := os.Hostname()
+// LogAlert prints the alert to the log without sending it anywhere
+func LogAlert(alert alerting.Alert) {
+	switch {
+	case alert.IsError && alert.Level() == alerting.SeverityWarning:
+		ErrorLog.Printf("[*BACKUP WARNING*] [%s] [host: `%s`] `%s` with error: `%s`\n", alert.Time.Format(time.RFC3339), alert.Hostname, alert.Message, alert.Error)
+	case alert.IsError:
+		ErrorLog.Printf("[*BACKUP FAILURE*] [%s] [host: `%s`] `%s` with error: `%s`\n", alert.Time.Format(time.RFC3339), alert.Hostname, alert.Message, alert.Error)
+	case alert.Resolved:
+		Log.Printf("[backup resolved] [%s] [host: %s] %s", alert.Time.Format(time.RFC3339), alert.Hostname, alert.Message)
+	default:
+		Log.Printf("[backup message] [%s] [host: %s] %s", alert.Time.Format(time.RFC3339), alert.Hostname, alert.Message)
+	}
+}
+
+// SendTestAlert sends a test error to every configured provider and returns the result of each
+func SendTestAlert(alertingConfig *AlertingConfig) []alerting.Result {
+	alert := newAlert("This is a test alert. Please ignore.")
+	alert.IsError = true
+	alert.Error = "Test error"
 
-	fullMessage := fmt.Sprintf("[backup message] [%s] [host: %s] %s", time.Now().Format(time.RFC3339), hostname, message)
+	return alertingConfig.Registry().Send(alert)
+}
 
-	if alertingConfig != nil && alertingConfig.Slack != nil {
-		err := alerting.SlackLog(fullMessage, alertingConfig.Slack)
+func newAlert(message string) alerting.Alert {
+	hostname, _ := os.Hostname()
 
-		if err != nil {
-			Log.Println("Warning: Could not alert to Slack.", err)
-		}
+	return alerting.Alert{
+		Message:  message,
+		Hostname: hostname,
+		Time:     time.Now(),
 	}
-
-	// Always print to error log
-	Log.Print(fullMessage)
 }
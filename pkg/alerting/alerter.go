@@ -0,0 +1,165 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Severity of an alert
+type Severity string
+
+// Severities, from least to most severe
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+var severityRanks = map[Severity]int{
+	SeverityInfo:     0,
+	SeverityWarning:  1,
+	SeverityCritical: 2,
+}
+
+// Alert contains everything a provider might want to include in a notification
+type Alert struct {
+	IsError  bool      `json:"is_error"`
+	Severity Severity  `json:"severity"`
+	Message  string    `json:"message"`
+	Error    string    `json:"error,omitempty"`
+	Stack    string    `json:"stack,omitempty"`
+	Hostname string    `json:"hostname"`
+	Time     time.Time `json:"time"`
+
+	// Alerts with the same key are about the same failure. A resolved alert announces the failure is over
+	DedupKey string `json:"dedup_key,omitempty"`
+	Resolved bool   `json:"resolved,omitempty"`
+}
+
+// Level returns the severity of the alert, defaulting to critical for errors and info otherwise
+func (alert Alert) Level() Severity {
+	if alert.Severity != "" {
+		return alert.Severity
+	}
+	if alert.IsError {
+		return SeverityCritical
+	}
+	return SeverityInfo
+}
+
+// IsMessage returns true for informational alerts that are neither a failure nor a resolution of one
+func (alert Alert) IsMessage() bool {
+	return !alert.IsError && !alert.Resolved
+}
+
+// Title returns a one line summary of the alert
+func (alert Alert) Title() string {
+	switch {
+	case alert.Resolved:
+		return fmt.Sprintf("Backup resolved on %s: %s", alert.Hostname, alert.Message)
+	case alert.IsError && alert.Level() == SeverityWarning:
+		return fmt.Sprintf("Backup warning on %s: %s", alert.Hostname, alert.Message)
+	case alert.IsError:
+		return fmt.Sprintf("Backup failure on %s: %s", alert.Hostname, alert.Message)
+	}
+	return fmt.Sprintf("Backup message from %s: %s", alert.Hostname, alert.Message)
+}
+
+// Text returns the alert as plain text, including the error and stack if any
+func (alert Alert) Text() string {
+	text := fmt.Sprintf("[%s] [host: %s] %s", alert.Time.Format(time.RFC3339), alert.Hostname, alert.Message)
+	if alert.Error != "" {
+		text += "\nError: " + alert.Error
+	}
+	if alert.Stack != "" {
+		text += "\n\n" + alert.Stack
+	}
+	return text
+}
+
+// Alerter sends alerts to a provider
+type Alerter interface {
+	Name() string
+	Send(alert Alert) error
+}
+
+// Result is the outcome of sending an alert to one provider
+type Result struct {
+	Name string
+	Err  error
+}
+
+// Registry holds all configured alerters
+type Registry struct {
+	alerters []Alerter
+}
+
+// Register adds an alerter to the registry
+func (registry *Registry) Register(alerter Alerter) {
+	registry.alerters = append(registry.alerters, alerter)
+}
+
+// Alerters returns all registered alerters
+func (registry *Registry) Alerters() []Alerter {
+	return registry.alerters
+}
+
+// Send sends alert to every registered alerter and returns the result of each
+func (registry *Registry) Send(alert Alert) []Result {
+	results := make([]Result, len(registry.alerters))
+	for index, alerter := range registry.alerters {
+		results[index] = Result{
+			Name: alerter.Name(),
+			Err:  alerter.Send(alert),
+		}
+	}
+	return results
+}
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+func postJSON(url string, payload interface{}, headers map[string]string) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return sendRequest("POST", url, "application/json", bytes.NewReader(payloadBytes), headers)
+}
+
+func sendRequest(method string, url string, contentType string, body io.Reader, headers map[string]string) error {
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", contentType)
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		responseBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 500))
+		return fmt.Errorf("%s %s returned %s: %s", method, url, resp.Status, string(responseBody))
+	}
+
+	return nil
+}
+
+func truncate(text string, maxLength int) string {
+	if len(text) <= maxLength {
+		return text
+	}
+	return text[:maxLength-3] + "..."
+}
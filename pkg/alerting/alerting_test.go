@@ -0,0 +1,269 @@
+package alerting
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type receivedRequest struct {
+	Method  string
+	Headers http.Header
+	Body    string
+}
+
+func newTestServer(statusCode int) (*httptest.Server, *[]receivedRequest) {
+	requests := make([]receivedRequest, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+		requests = append(requests, receivedRequest{request.Method, request.Header, string(body)})
+		responseWriter.WriteHeader(statusCode)
+	}))
+
+	return server, &requests
+}
+
+func testAlert() Alert {
+	return Alert{
+		IsError:  true,
+		Message:  "Could not upload backup",
+		Error:    "connection reset",
+		Stack:    "goroutine 1",
+		Hostname: "db1",
+		Time:     time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC),
+	}
+}
+
+func decodeBody(t *testing.T, body string) map[string]interface{} {
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+		t.Fatalf("Body is not JSON: %s (%s)", body, err)
+	}
+	return decoded
+}
+
+func TestSlackAlerter(t *testing.T) {
+	server, requests := newTestServer(200)
+	defer server.Close()
+
+	err := (&SlackAlerter{Config: &SlackConfig{WebhookURL: server.URL, Channel: "#alerts"}}).Send(testAlert())
+	if err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	body := decodeBody(t, (*requests)[0].Body)
+	if body["channel"] != "#alerts" || body["username"] != slackDefaultUsername {
+		t.Errorf("Incorrect Slack payload: %v", body)
+	}
+	if !strings.HasPrefix(body["text"].(string), "[*BACKUP FAILURE*]") {
+		t.Errorf("Incorrect Slack text: %s", body["text"])
+	}
+}
+
+func TestPagerDutyAlerter(t *testing.T) {
+	server, requests := newTestServer(202)
+	defer server.Close()
+
+	alerter := &PagerDutyAlerter{Config: &PagerDutyConfig{RoutingKey: "routing-key", URL: server.URL}}
+
+	if err := alerter.Send(testAlert()); err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	body := decodeBody(t, (*requests)[0].Body)
+	payload := body["payload"].(map[string]interface{})
+	if body["routing_key"] != "routing-key" || body["event_action"] != "trigger" || payload["severity"] != "critical" || payload["source"] != "db1" {
+		t.Errorf("Incorrect PagerDuty payload: %v", body)
+	}
+
+	// Messages are not sent unless include_messages is set
+	message := testAlert()
+	message.IsError = false
+	if err := alerter.Send(message); err != nil {
+		t.Fatal("No error expected", err)
+	}
+	if len(*requests) != 1 {
+		t.Error("Expected message to be skipped")
+	}
+}
+
+func TestPagerDutyResolve(t *testing.T) {
+	server, requests := newTestServer(202)
+	defer server.Close()
+
+	alerter := &PagerDutyAlerter{Config: &PagerDutyConfig{RoutingKey: "routing-key", URL: server.URL}}
+
+	alert := testAlert()
+	alert.Severity = SeverityWarning
+	alert.DedupKey = "perform/upload"
+	if err := alerter.Send(alert); err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	resolved := Alert{Resolved: true, DedupKey: "perform/upload", Message: "perform succeeded again", Hostname: "db1"}
+	if err := alerter.Send(resolved); err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	trigger := decodeBody(t, (*requests)[0].Body)
+	if trigger["dedup_key"] != "perform/upload" || trigger["payload"].(map[string]interface{})["severity"] != "warning" {
+		t.Errorf("Incorrect PagerDuty trigger: %v", trigger)
+	}
+
+	resolve := decodeBody(t, (*requests)[1].Body)
+	if resolve["event_action"] != "resolve" || resolve["dedup_key"] != "perform/upload" {
+		t.Errorf("Incorrect PagerDuty resolve: %v", resolve)
+	}
+}
+
+func TestOpsgenieAlerter(t *testing.T) {
+	server, requests := newTestServer(202)
+	defer server.Close()
+
+	err := (&OpsgenieAlerter{Config: &OpsgenieConfig{APIKey: "api-key", URL: server.URL}}).Send(testAlert())
+	if err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	request := (*requests)[0]
+	if request.Headers.Get("Authorization") != "GenieKey api-key" {
+		t.Errorf("Incorrect Authorization header: %s", request.Headers.Get("Authorization"))
+	}
+
+	body := decodeBody(t, request.Body)
+	if body["priority"] != "P1" || len(body["message"].(string)) > 130 {
+		t.Errorf("Incorrect Opsgenie payload: %v", body)
+	}
+}
+
+func TestOpsgenieResolve(t *testing.T) {
+	var requestURI string
+	server := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		requestURI = request.RequestURI
+		responseWriter.WriteHeader(202)
+	}))
+	defer server.Close()
+
+	alerter := &OpsgenieAlerter{Config: &OpsgenieConfig{APIKey: "api-key", URL: server.URL + "/v2/alerts"}}
+
+	resolved := Alert{Resolved: true, DedupKey: "perform/upload", Message: "perform succeeded again", Hostname: "db1"}
+	if err := alerter.Send(resolved); err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	if requestURI != "/v2/alerts/perform%2Fupload/close?identifierType=alias" {
+		t.Errorf("Incorrect close URL: %s", requestURI)
+	}
+}
+
+func TestTeamsAlerter(t *testing.T) {
+	server, requests := newTestServer(200)
+	defer server.Close()
+
+	err := (&TeamsAlerter{Config: &TeamsConfig{WebhookURL: server.URL}}).Send(testAlert())
+	if err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	body := decodeBody(t, (*requests)[0].Body)
+	if body["@type"] != "MessageCard" || body["themeColor"] != "D70000" {
+		t.Errorf("Incorrect Teams payload: %v", body)
+	}
+}
+
+func TestDiscordAlerter(t *testing.T) {
+	server, requests := newTestServer(204)
+	defer server.Close()
+
+	alert := testAlert()
+	alert.Stack = strings.Repeat("x", 5000)
+
+	err := (&DiscordAlerter{Config: &DiscordConfig{WebhookURL: server.URL}}).Send(alert)
+	if err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	body := decodeBody(t, (*requests)[0].Body)
+	if len(body["content"].(string)) > discordMaxContentLength {
+		t.Errorf("Discord content too long: %d", len(body["content"].(string)))
+	}
+}
+
+func TestWebhookAlerter(t *testing.T) {
+	server, requests := newTestServer(200)
+	defer server.Close()
+
+	alerter := &WebhookAlerter{Config: &WebhookConfig{
+		URL:          server.URL,
+		Method:       "PUT",
+		Headers:      map[string]string{"X-Token": "secret"},
+		BodyTemplate: `{"text": {{ json .Title }}, "host": "{{ .Hostname }}"}`,
+	}}
+
+	if err := alerter.Send(testAlert()); err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	request := (*requests)[0]
+	if request.Method != "PUT" || request.Headers.Get("X-Token") != "secret" {
+		t.Errorf("Incorrect webhook request: %s %v", request.Method, request.Headers)
+	}
+
+	body := decodeBody(t, request.Body)
+	if body["text"] != "Backup failure on db1: Could not upload backup" || body["host"] != "db1" {
+		t.Errorf("Incorrect webhook body: %v", body)
+	}
+
+	// Without a template the alert is sent as JSON
+	alerter.Config.BodyTemplate = ""
+	if err := alerter.Send(testAlert()); err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	body = decodeBody(t, (*requests)[1].Body)
+	if body["message"] != "Could not upload backup" || body["is_error"] != true {
+		t.Errorf("Incorrect webhook body: %v", body)
+	}
+}
+
+func TestWebhookAlerterNameLeavesOutTheURL(t *testing.T) {
+	alerter := &WebhookAlerter{Config: &WebhookConfig{URL: "https://hooks.example.com/services/T0/B0/token?key=secret"}}
+	if alerter.Name() != "webhook https://hooks.example.com" {
+		t.Errorf("Expected only the host of the URL in the name, got %q", alerter.Name())
+	}
+
+	alerter.Config.Name = "chatops"
+	if alerter.Name() != "webhook chatops" {
+		t.Errorf("Expected the configured name, got %q", alerter.Name())
+	}
+}
+
+func TestRegistryReportsFailures(t *testing.T) {
+	okServer, _ := newTestServer(200)
+	defer okServer.Close()
+
+	failingServer, _ := newTestServer(500)
+	defer failingServer.Close()
+
+	registry := &Registry{}
+	registry.Register(&SlackAlerter{Config: &SlackConfig{WebhookURL: okServer.URL}})
+	registry.Register(&DiscordAlerter{Config: &DiscordConfig{WebhookURL: failingServer.URL}})
+
+	results := registry.Send(testAlert())
+	if len(results) != 2 {
+		t.Fatal("Expected 2 results, got", len(results))
+	}
+
+	if results[0].Name != "slack" || results[0].Err != nil {
+		t.Errorf("Expected Slack to succeed: %v", results[0])
+	}
+
+	if results[1].Name != "discord" || results[1].Err == nil {
+		t.Errorf("Expected Discord to fail: %v", results[1])
+	}
+}
@@ -0,0 +1,45 @@
+package alerting
+
+const discordMaxContentLength = 2000
+
+// DiscordConfig contains config values for a Discord webhook
+type DiscordConfig struct {
+	WebhookURL string `json:"webhook_url" secret:"true"`
+	Username   string `json:"username"`
+}
+
+type discordWebhook struct {
+	Username string `json:"username"`
+	Content  string `json:"content"`
+}
+
+// DiscordAlerter sends alerts to a Discord channel
+type DiscordAlerter struct {
+	Config *DiscordConfig
+}
+
+// Name returns the name of the provider
+func (alerter *DiscordAlerter) Name() string {
+	return "discord"
+}
+
+// Send posts the alert to Discord
+func (alerter *DiscordAlerter) Send(alert Alert) error {
+	username := alerter.Config.Username
+	if username == "" {
+		username = slackDefaultUsername
+	}
+
+	content := "**" + alert.Title() + "**"
+	if alert.Error != "" {
+		content += "\nError: `" + alert.Error + "`"
+	}
+	if alert.Stack != "" {
+		content += "\n```\n" + alert.Stack + "\n```"
+	}
+
+	return postJSON(alerter.Config.WebhookURL, discordWebhook{
+		Username: username,
+		Content:  truncate(content, discordMaxContentLength),
+	}, nil)
+}
@@ -0,0 +1,167 @@
+package alerting
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const emailDefaultPort = 587
+const emailBoundary = "really-simple-db-backup-boundary"
+const emailDialTimeout = 15 * time.Second
+
+// EmailConfig contains config values for sending alerts over SMTP
+type EmailConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"`
+	StartTLS bool     `json:"starttls"`
+	Username string   `json:"username"`
+	Password string   `json:"password" secret:"true"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+
+	// Errors only, unless set
+	IncludeMessages bool `json:"include_messages"`
+}
+
+// EmailAlerter sends alerts as HTML emails
+type EmailAlerter struct {
+	Config *EmailConfig
+}
+
+var emailAlertTemplate = template.Must(template.New("alert").Parse(`<html><body style="font-family: sans-serif">
+<h2 style="color: {{ if .IsError }}#d70000{{ else }}#2eb67d{{ end }}">{{ .Title }}</h2>
+<table cellpadding="4">
+<tr><th align="left">Host</th><td>{{ .Hostname }}</td></tr>
+<tr><th align="left">Time</th><td>{{ .Time.Format "2006-01-02 15:04:05 MST" }}</td></tr>
+<tr><th align="left">Message</th><td>{{ .Message }}</td></tr>
+{{ if .Error }}<tr><th align="left">Error</th><td><code>{{ .Error }}</code></td></tr>{{ end }}
+</table>
+{{ if .Stack }}<pre style="background: #f4f4f4; padding: 8px">{{ .Stack }}</pre>{{ end }}
+</body></html>
+`))
+
+// Name returns the name of the provider
+func (alerter *EmailAlerter) Name() string {
+	return "email"
+}
+
+// Send emails the alert. Messages that are neither failures nor resolutions are skipped unless `include_messages` is set
+func (alerter *EmailAlerter) Send(alert Alert) error {
+	if alert.IsMessage() && !alerter.Config.IncludeMessages {
+		return nil
+	}
+
+	var htmlBody bytes.Buffer
+	err := emailAlertTemplate.Execute(&htmlBody, alert)
+	if err != nil {
+		return err
+	}
+
+	return SendEmail(alerter.Config, alert.Title(), alert.Text(), htmlBody.String())
+}
+
+// SendEmail sends an email with a plain text and an HTML version of the body
+func SendEmail(config *EmailConfig, subject string, textBody string, htmlBody string) error {
+	if config.Host == "" || config.From == "" || len(config.To) == 0 {
+		return errors.New("email alerting requires host, from and to")
+	}
+
+	port := config.Port
+	if port == 0 {
+		port = emailDefaultPort
+	}
+
+	connection, err := net.DialTimeout("tcp", net.JoinHostPort(config.Host, strconv.Itoa(port)), emailDialTimeout)
+	if err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(connection, config.Host)
+	if err != nil {
+		connection.Close()
+		return err
+	}
+	defer client.Close()
+
+	if config.StartTLS {
+		err = client.StartTLS(&tls.Config{ServerName: config.Host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(config.From)
+	if err != nil {
+		return err
+	}
+
+	for _, recipient := range config.To {
+		err = client.Rcpt(recipient)
+		if err != nil {
+			return err
+		}
+	}
+
+	dataWriter, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = dataWriter.Write(buildEmail(config.From, config.To, subject, textBody, htmlBody, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	err = dataWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func buildEmail(from string, to []string, subject string, textBody string, htmlBody string, date time.Time) []byte {
+	var message bytes.Buffer
+
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&message, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", emailBoundary)
+
+	writePart := func(contentType string, body string) {
+		fmt.Fprintf(&message, "--%s\r\n", emailBoundary)
+		fmt.Fprintf(&message, "Content-Type: %s; charset=UTF-8\r\n", contentType)
+		fmt.Fprintf(&message, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+		encoder := quotedprintable.NewWriter(&message)
+		encoder.Write([]byte(body))
+		encoder.Close()
+
+		fmt.Fprintf(&message, "\r\n")
+	}
+
+	writePart("text/plain", textBody)
+	writePart("text/html", htmlBody)
+
+	fmt.Fprintf(&message, "--%s--\r\n", emailBoundary)
+
+	return message.Bytes()
+}
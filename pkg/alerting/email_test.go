@@ -0,0 +1,152 @@
+package alerting
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+type receivedEmail struct {
+	From string
+	To   []string
+	Data string
+}
+
+// newTestSMTPServer speaks just enough SMTP for net/smtp to deliver a message
+func newTestSMTPServer(t *testing.T) (string, int, chan receivedEmail) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emails := make(chan receivedEmail, 1)
+
+	go func() {
+		defer listener.Close()
+
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer connection.Close()
+
+		reader := bufio.NewReader(connection)
+		reply := func(line string) {
+			connection.Write([]byte(line + "\r\n"))
+		}
+
+		var email receivedEmail
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+			switch {
+			case command == "EHLO" || command == "HELO":
+				reply("250 localhost")
+			case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+				email.From = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				reply("250 OK")
+			case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+				email.To = append(email.To, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				reply("250 OK")
+			case command == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					dataLine, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if dataLine == ".\r\n" {
+						break
+					}
+					data.WriteString(dataLine)
+				}
+				email.Data = data.String()
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 Bye")
+				emails <- email
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+
+	address := listener.Addr().(*net.TCPAddr)
+	return address.IP.String(), address.Port, emails
+}
+
+func TestEmailAlerter(t *testing.T) {
+	host, port, emails := newTestSMTPServer(t)
+
+	alerter := &EmailAlerter{Config: &EmailConfig{
+		Host: host,
+		Port: port,
+		From: "backups@example.com",
+		To:   []string{"ops@example.com", "dba@example.com"},
+	}}
+
+	if err := alerter.Send(testAlert()); err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	email := <-emails
+	if email.From != "backups@example.com" || strings.Join(email.To, ",") != "ops@example.com,dba@example.com" {
+		t.Errorf("Incorrect envelope: %v", email)
+	}
+
+	if !strings.Contains(email.Data, "Subject: Backup failure on db1: Could not upload backup\r\n") {
+		t.Errorf("Incorrect subject: %s", email.Data)
+	}
+
+	if !strings.Contains(email.Data, "Content-Type: text/html") || !strings.Contains(email.Data, "<code>connection reset</code>") {
+		t.Errorf("Expected an HTML part with the error: %s", email.Data)
+	}
+}
+
+func TestEmailAlerterSkipsMessages(t *testing.T) {
+	alerter := &EmailAlerter{Config: &EmailConfig{Host: "127.0.0.1", Port: 1, From: "a@example.com", To: []string{"b@example.com"}}}
+
+	message := testAlert()
+	message.IsError = false
+
+	// Nothing listens on port 1, so this only succeeds if no connection is made
+	if err := alerter.Send(message); err != nil {
+		t.Error("Expected message to be skipped", err)
+	}
+}
+
+func TestSendEmailRequiresRecipients(t *testing.T) {
+	err := SendEmail(&EmailConfig{Host: "localhost", From: "a@example.com"}, "subject", "text", "<p>html</p>")
+	if err == nil {
+		t.Error("Expected error without recipients")
+	}
+}
+
+func TestBuildEmail(t *testing.T) {
+	message := string(buildEmail("a@example.com", []string{"b@example.com"}, "Hello", "text body", "<p>"+strings.Repeat("x", 100)+"</p>", testAlert().Time))
+
+	for _, expected := range []string{
+		"To: b@example.com\r\n",
+		"Date: Tue, 01 Jan 2019 10:00:00 +0000\r\n",
+		"boundary=" + emailBoundary,
+		"--" + emailBoundary + "--",
+	} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("Expected %q in %s", expected, message)
+		}
+	}
+
+	// Quoted-printable wraps long lines with soft line breaks
+	if !strings.Contains(message, "=\r\n") {
+		t.Errorf("Expected long HTML line to be wrapped: %s", message)
+	}
+}
@@ -0,0 +1,88 @@
+package alerting
+
+import (
+	neturl "net/url"
+	"strings"
+)
+
+const opsgenieDefaultURL = "https://api.opsgenie.com/v2/alerts"
+
+// OpsgenieConfig contains config values for Opsgenie
+type OpsgenieConfig struct {
+	APIKey          string `json:"api_key" secret:"true"`
+	URL             string `json:"url"`
+	Priority        string `json:"priority"`
+	IncludeMessages bool   `json:"include_messages"`
+}
+
+type opsgenieAlert struct {
+	Message     string   `json:"message"`
+	Alias       string   `json:"alias,omitempty"`
+	Description string   `json:"description"`
+	Source      string   `json:"source"`
+	Priority    string   `json:"priority"`
+	Tags        []string `json:"tags"`
+}
+
+type opsgenieCloseRequest struct {
+	Source string `json:"source"`
+	Note   string `json:"note"`
+}
+
+// OpsgenieAlerter creates Opsgenie alerts
+type OpsgenieAlerter struct {
+	Config *OpsgenieConfig
+}
+
+// Name returns the name of the provider
+func (alerter *OpsgenieAlerter) Name() string {
+	return "opsgenie"
+}
+
+// Send creates an alert. Messages that are neither failures nor resolutions are skipped unless `include_messages` is set
+func (alerter *OpsgenieAlerter) Send(alert Alert) error {
+	if alert.IsMessage() && !alerter.Config.IncludeMessages {
+		return nil
+	}
+
+	url := alerter.Config.URL
+	if url == "" {
+		url = opsgenieDefaultURL
+	}
+
+	headers := map[string]string{
+		"Authorization": "GenieKey " + alerter.Config.APIKey,
+	}
+
+	// Resolving closes the alert created with the same alias
+	if alert.Resolved {
+		if alert.DedupKey == "" {
+			return nil
+		}
+
+		return postJSON(strings.TrimSuffix(url, "/")+"/"+neturl.PathEscape(alert.DedupKey)+"/close?identifierType=alias", opsgenieCloseRequest{
+			Source: alert.Hostname,
+			Note:   truncate(alert.Message, 25000),
+		}, headers)
+	}
+
+	priority := alerter.Config.Priority
+	if priority == "" {
+		priority = "P1"
+	}
+	switch alert.Level() {
+	case SeverityWarning:
+		priority = "P3"
+	case SeverityInfo:
+		priority = "P5"
+	}
+
+	return postJSON(url, opsgenieAlert{
+		Message:     truncate(alert.Title(), 130),
+		Alias:       alert.DedupKey,
+		Description: truncate(alert.Text(), 15000),
+		Source:      alert.Hostname,
+		Priority:    priority,
+		Tags:        []string{"really-simple-db-backup"},
+	}, headers)
+}
@@ -0,0 +1,77 @@
+package alerting
+
+import "time"
+
+const pagerDutyDefaultURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDutyConfig contains config values for PagerDuty Events API v2
+type PagerDutyConfig struct {
+	RoutingKey      string `json:"routing_key" secret:"true"`
+	URL             string `json:"url"`
+	IncludeMessages bool   `json:"include_messages"`
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key,omitempty"`
+	Payload     pagerDutyPayload `json:"payload"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp"`
+	Component     string            `json:"component"`
+	CustomDetails map[string]string `json:"custom_details"`
+}
+
+// PagerDutyAlerter triggers PagerDuty incidents
+type PagerDutyAlerter struct {
+	Config *PagerDutyConfig
+}
+
+// Name returns the name of the provider
+func (alerter *PagerDutyAlerter) Name() string {
+	return "pagerduty"
+}
+
+// Send triggers an event. Messages that are neither failures nor resolutions are skipped unless `include_messages` is set
+func (alerter *PagerDutyAlerter) Send(alert Alert) error {
+	if alert.IsMessage() && !alerter.Config.IncludeMessages {
+		return nil
+	}
+
+	url := alerter.Config.URL
+	if url == "" {
+		url = pagerDutyDefaultURL
+	}
+
+	// Resolving closes the incident triggered with the same dedup key
+	eventAction := "trigger"
+	if alert.Resolved {
+		if alert.DedupKey == "" {
+			return nil
+		}
+		eventAction = "resolve"
+	}
+
+	return postJSON(url, pagerDutyEvent{
+		RoutingKey:  alerter.Config.RoutingKey,
+		EventAction: eventAction,
+		DedupKey:    alert.DedupKey,
+		Payload: pagerDutyPayload{
+			Summary:   truncate(alert.Title(), 1024),
+			Source:    alert.Hostname,
+			Severity:  string(alert.Level()),
+			Timestamp: alert.Time.Format(time.RFC3339),
+			Component: "really-simple-db-backup",
+			CustomDetails: map[string]string{
+				"message": alert.Message,
+				"error":   alert.Error,
+				"stack":   alert.Stack,
+			},
+		},
+	}, nil)
+}
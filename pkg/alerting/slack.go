@@ -1,9 +1,8 @@
 package alerting
 
 import (
-	"bytes"
-	"encoding/json"
-	"net/http"
+	"fmt"
+	"time"
 )
 
 const slackDefaultUsername = "BackupsBot"
@@ -11,18 +10,51 @@ const slackDefaultIconEmoji = ":card_file_box:"
 
 // SlackConfig contains config values for slack config
 type SlackConfig struct {
-	WebhookURL string `json:"webhook_url"`
+	WebhookURL string `json:"webhook_url" secret:"true"`
 	Channel    string `json:"channel"`
 	Username   string `json:"username"`
 	IconEmoji  string `json:"icon_emoji"`
 }
 
 type slackWebhook struct {
+	Channel   string `json:"channel,omitempty"`
 	Username  string `json:"username"`
 	Text      string `json:"text"`
 	IconEmoji string `json:"icon_emoji"`
 }
 
+// SlackAlerter sends alerts to a Slack incoming webhook
+type SlackAlerter struct {
+	Config *SlackConfig
+}
+
+// Name returns the name of the provider
+func (alerter *SlackAlerter) Name() string {
+	return "slack"
+}
+
+// Send sends the alert to Slack
+func (alerter *SlackAlerter) Send(alert Alert) error {
+	var message string
+	if alert.IsError {
+		prefix := "[*BACKUP FAILURE*]"
+		if alert.Level() == SeverityWarning {
+			prefix = "[*BACKUP WARNING*]"
+		}
+
+		message = fmt.Sprintf("%s [%s] [host: `%s`] `%s` with error: `%s`\n", prefix, alert.Time.Format(time.RFC3339), alert.Hostname, alert.Message, alert.Error)
+		if alert.Stack != "" {
+			message += "\n\n```\n" + alert.Stack + "```"
+		}
+	} else if alert.Resolved {
+		message = fmt.Sprintf("[*BACKUP RESOLVED*] [%s] [host: `%s`] %s", alert.Time.Format(time.RFC3339), alert.Hostname, alert.Message)
+	} else {
+		message = fmt.Sprintf("[backup message] [%s] [host: %s] %s", alert.Time.Format(time.RFC3339), alert.Hostname, alert.Message)
+	}
+
+	return SlackLog(message, alerter.Config)
+}
+
 // SlackLog log a message to my slack
 func SlackLog(message string, config *SlackConfig) error {
 	username := config.Username
@@ -36,22 +68,11 @@ func SlackLog(message string, config *SlackConfig) error {
 	}
 
 	data := slackWebhook{
+		Channel:   config.Channel,
 		Username:  username,
 		Text:      message,
 		IconEmoji: iconEmoji,
 	}
 
-	payloadBytes, err := json.Marshal(data)
-	if err != nil {
-		return err
-	}
-
-	body := bytes.NewReader(payloadBytes)
-	resp, err := http.Post(config.WebhookURL, "application/json", body)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	return nil
+	return postJSON(config.WebhookURL, data, nil)
 }
@@ -0,0 +1,53 @@
+package alerting
+
+// TeamsConfig contains config values for a Microsoft Teams incoming webhook
+type TeamsConfig struct {
+	WebhookURL string `json:"webhook_url" secret:"true"`
+}
+
+type teamsMessageCard struct {
+	Type       string `json:"@type"`
+	Context    string `json:"@context"`
+	Summary    string `json:"summary"`
+	ThemeColor string `json:"themeColor"`
+	Title      string `json:"title"`
+	Text       string `json:"text"`
+}
+
+// TeamsAlerter sends alerts to a Microsoft Teams channel
+type TeamsAlerter struct {
+	Config *TeamsConfig
+}
+
+// Name returns the name of the provider
+func (alerter *TeamsAlerter) Name() string {
+	return "teams"
+}
+
+// Send posts a message card to Teams
+func (alerter *TeamsAlerter) Send(alert Alert) error {
+	themeColor := "2EB67D"
+	if alert.IsError {
+		themeColor = "D70000"
+		if alert.Level() == SeverityWarning {
+			themeColor = "FFA500"
+		}
+	}
+
+	text := alert.Message
+	if alert.Error != "" {
+		text += "\n\nError: `" + alert.Error + "`"
+	}
+	if alert.Stack != "" {
+		text += "\n\n```\n" + alert.Stack + "\n```"
+	}
+
+	return postJSON(alerter.Config.WebhookURL, teamsMessageCard{
+		Type:       "MessageCard",
+		Context:    "https://schema.org/extensions",
+		Summary:    alert.Title(),
+		ThemeColor: themeColor,
+		Title:      alert.Title(),
+		Text:       text,
+	}, nil)
+}
@@ -0,0 +1,122 @@
+package alerting
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// OpenAlert is a failure that was alerted and has not been resolved yet
+type OpenAlert struct {
+	Key        string    `json:"key"`
+	Severity   Severity  `json:"severity"`
+	Message    string    `json:"message"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSent   time.Time `json:"last_sent"`
+	Suppressed int       `json:"suppressed"`
+}
+
+// Throttle keeps track of open alerts so a failure that keeps repeating is only sent once per period,
+// and so its resolution can be announced when it stops
+type Throttle struct {
+	path   string
+	period time.Duration
+
+	Open map[string]*OpenAlert `json:"open"`
+}
+
+// LoadThrottle reads the throttle state from statePath. A missing file is an empty state
+func LoadThrottle(statePath string, period time.Duration) (*Throttle, error) {
+	throttle := &Throttle{
+		path:   statePath,
+		period: period,
+	}
+
+	contents, err := ioutil.ReadFile(statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		err = json.Unmarshal(contents, throttle)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if throttle.Open == nil {
+		throttle.Open = make(map[string]*OpenAlert)
+	}
+
+	return throttle, nil
+}
+
+// Failure records alert and returns whether it should be sent, along with the number of alerts for the same key
+// that were suppressed since the last one was sent. Alerts without a dedup key are always sent.
+// An alert that is more severe than the last one sent is never suppressed
+func (throttle *Throttle) Failure(alert Alert, now time.Time) (bool, int) {
+	if alert.DedupKey == "" {
+		return true, 0
+	}
+
+	open, exists := throttle.Open[alert.DedupKey]
+	if !exists {
+		throttle.Open[alert.DedupKey] = &OpenAlert{
+			Key:       alert.DedupKey,
+			Severity:  alert.Level(),
+			Message:   alert.Message,
+			FirstSeen: now,
+			LastSent:  now,
+		}
+		return true, 0
+	}
+
+	escalated := severityRanks[alert.Level()] > severityRanks[open.Severity]
+	if !escalated && now.Sub(open.LastSent) < throttle.period {
+		open.Suppressed++
+		return false, open.Suppressed
+	}
+
+	suppressed := open.Suppressed
+	open.Severity = alert.Level()
+	open.Message = alert.Message
+	open.LastSent = now
+	open.Suppressed = 0
+
+	return true, suppressed
+}
+
+// Resolve removes and returns all open alerts whose key matches, ordered by key
+func (throttle *Throttle) Resolve(match func(key string) bool) []*OpenAlert {
+	resolved := make([]*OpenAlert, 0)
+	for key, open := range throttle.Open {
+		if match(key) {
+			resolved = append(resolved, open)
+			delete(throttle.Open, key)
+		}
+	}
+
+	sort.Slice(resolved, func(i, j int) bool {
+		return resolved[i].Key < resolved[j].Key
+	})
+
+	return resolved
+}
+
+// Save writes the throttle state back to disk
+func (throttle *Throttle) Save() error {
+	contents, err := json.Marshal(throttle)
+	if err != nil {
+		return err
+	}
+
+	temporaryPath := throttle.path + ".tmp"
+	err = ioutil.WriteFile(temporaryPath, contents, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporaryPath, throttle.path)
+}
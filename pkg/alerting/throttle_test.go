@@ -0,0 +1,81 @@
+package alerting
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	directory, err := ioutil.TempDir("", "throttle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	statePath := path.Join(directory, "alerts.json")
+	now := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	throttle, err := LoadThrottle(statePath, 6*time.Hour)
+	if err != nil {
+		t.Fatal("Missing state should not be an error", err)
+	}
+
+	alert := testAlert()
+	alert.Severity = SeverityWarning
+	alert.DedupKey = "perform/upload"
+
+	if send, _ := throttle.Failure(alert, now); !send {
+		t.Error("Expected first alert to be sent")
+	}
+
+	if send, suppressed := throttle.Failure(alert, now.Add(time.Hour)); send || suppressed != 1 {
+		t.Errorf("Expected repeat to be suppressed, got %v %d", send, suppressed)
+	}
+
+	// Escalating is never suppressed
+	alert.Severity = SeverityCritical
+	if send, suppressed := throttle.Failure(alert, now.Add(2*time.Hour)); !send || suppressed != 1 {
+		t.Errorf("Expected escalation to be sent, got %v %d", send, suppressed)
+	}
+
+	if send, _ := throttle.Failure(alert, now.Add(3*time.Hour)); send {
+		t.Error("Expected repeat to be suppressed")
+	}
+
+	if err := throttle.Save(); err != nil {
+		t.Fatal("Could not save", err)
+	}
+
+	// State survives between runs
+	throttle, err = LoadThrottle(statePath, 6*time.Hour)
+	if err != nil {
+		t.Fatal("Could not load", err)
+	}
+
+	if send, suppressed := throttle.Failure(alert, now.Add(8*time.Hour+time.Minute)); !send || suppressed != 1 {
+		t.Errorf("Expected alert to be sent after the throttle period, got %v %d", send, suppressed)
+	}
+
+	// Alerts without a key are never throttled
+	alert.DedupKey = ""
+	for i := 0; i < 2; i++ {
+		if send, _ := throttle.Failure(alert, now); !send {
+			t.Error("Expected alert without dedup key to be sent")
+		}
+	}
+
+	alert.DedupKey = "prune/list"
+	throttle.Failure(alert, now)
+
+	resolved := throttle.Resolve(func(key string) bool { return key == "perform/upload" })
+	if len(resolved) != 1 || resolved[0].Key != "perform/upload" || !resolved[0].FirstSeen.Equal(now) {
+		t.Errorf("Incorrect resolved alerts: %v", resolved)
+	}
+
+	if len(throttle.Open) != 1 || throttle.Open["prune/list"] == nil {
+		t.Errorf("Expected only prune/list to be open: %v", throttle.Open)
+	}
+}
@@ -0,0 +1,81 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"text/template"
+)
+
+// WebhookConfig contains config values for a generic webhook.
+// BodyTemplate is a Go text/template executed with the Alert. Without it the Alert is sent as JSON
+type WebhookConfig struct {
+	Name         string            `json:"name"`
+	URL          string            `json:"url" secret:"true"`
+	Method       string            `json:"method"`
+	Headers      map[string]string `json:"headers" secret:"true"`
+	ContentType  string            `json:"content_type"`
+	BodyTemplate string            `json:"body_template"`
+}
+
+// WebhookAlerter sends alerts to any HTTP endpoint
+type WebhookAlerter struct {
+	Config *WebhookConfig
+}
+
+var webhookTemplateFuncs = template.FuncMap{
+	// json encodes a value so it can be embedded in a JSON body: {"text": {{ json .Message }}}
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+}
+
+// Name returns the name of the provider
+func (alerter *WebhookAlerter) Name() string {
+	if alerter.Config.Name != "" {
+		return "webhook " + alerter.Config.Name
+	}
+
+	// The path and query of the URL often hold a token
+	parsedURL, err := url.Parse(alerter.Config.URL)
+	if err != nil || parsedURL.Host == "" {
+		return "webhook"
+	}
+	return "webhook " + parsedURL.Scheme + "://" + parsedURL.Host
+}
+
+// Send sends the alert to the webhook
+func (alerter *WebhookAlerter) Send(alert Alert) error {
+	body, err := alerter.render(alert)
+	if err != nil {
+		return err
+	}
+
+	method := alerter.Config.Method
+	if method == "" {
+		method = "POST"
+	}
+
+	contentType := alerter.Config.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	return sendRequest(method, alerter.Config.URL, contentType, bytes.NewReader(body), alerter.Config.Headers)
+}
+
+func (alerter *WebhookAlerter) render(alert Alert) ([]byte, error) {
+	if alerter.Config.BodyTemplate == "" {
+		return json.Marshal(alert)
+	}
+
+	bodyTemplate, err := template.New("body").Funcs(webhookTemplateFuncs).Parse(alerter.Config.BodyTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	err = bodyTemplate.Execute(&body, alert)
+	return body.Bytes(), err
+}
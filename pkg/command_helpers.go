@@ -1,91 +1,24 @@
 package pkg
 
 import (
-	"bufio"
-	"flag"
-	"fmt"
+	"context"
 	"os"
-	"os/exec"
 	"strings"
 )
 
-// ParseCommandLineFlags parsed flags defined by `flag` package. Required to work with sub-commands
-func ParseCommandLineFlags(args []string) error {
-	// If a commandline app works like this: ./app subcommand -flag -flag2
-	// `flag.Parse` won't parse anything after `subcommand`.
-	// To still be able to use `flag.String/flag.Int64` etc without creating
-	// a new `flag.FlagSet`, we need this hack to find the first arg that has a dash
-	// so we know when to start parsing
-	firstArgWithDash := 0
-	for i := 0; i < len(args); i++ {
-		firstArgWithDash = i
-
-		if len(args[i]) > 0 && args[i][0] == '-' {
-			break
-		}
-	}
-
-	return flag.CommandLine.Parse(args[firstArgWithDash:])
-}
-
 // PerformCommand performs a command line command with nice helpers
 func PerformCommand(cmdArgs ...string) (string, error) {
-	if VerboseMode {
-		Log.Printf("== `%s`\n", strings.Join(cmdArgs, " "))
-	}
-
-	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-	cmdReader, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", fmt.Errorf("%s failed with:\n%s", strings.Join(cmdArgs, " "), err.Error())
-	}
-
-	stdErrReader, stdErr := cmd.StderrPipe()
-	if stdErr != nil {
-		return "", fmt.Errorf("%s failed with:\n%s", strings.Join(cmdArgs, " "), stdErr.Error())
-	}
-
-	output := ""
-	var errOutput []string
-
-	scanner := bufio.NewScanner(cmdReader)
-	go func() {
-		for scanner.Scan() {
-			chunk := scanner.Text()
-			if VerboseMode {
-				fmt.Printf("%s\n", chunk)
-			}
-			output += chunk + "\n"
-		}
-	}()
-
-	errScanner := bufio.NewScanner(stdErrReader)
-	go func() {
-		for errScanner.Scan() {
-			chunk := errScanner.Text()
-			if VerboseMode {
-				fmt.Printf("%s\n", chunk)
-			}
-			errOutput = append(errOutput, chunk)
-		}
-	}()
-
-	err = cmd.Start()
-	if err != nil {
-		lastLogLines := lastLines(errOutput, 10)
-		if VerboseMode {
-			ErrorLog.Println("Error starting command", err, lastLogLines)
-		}
-		return "", fmt.Errorf("%s failed with:\n%s\n\nLast log lines:\n%s", strings.Join(cmdArgs, " "), err.Error(), lastLogLines)
-	}
+	return PerformCommandContext(context.Background(), cmdArgs...)
+}
 
-	err = cmd.Wait()
-	if err != nil {
-		lastLogLines := lastLines(errOutput, 10)
-		return "", fmt.Errorf("%s failed with:\n%s\n\nLast log lines:\n%s", strings.Join(cmdArgs, " "), err.Error(), lastLogLines)
-	}
+// PerformCommandContext performs a command line command that is killed if ctx is cancelled
+func PerformCommandContext(ctx context.Context, cmdArgs ...string) (string, error) {
+	return PerformCommandWithEnvContext(ctx, nil, cmdArgs...)
+}
 
-	return output, nil
+// PerformCommandWithEnvContext performs a command line command with environment added to the environment of this process
+func PerformCommandWithEnvContext(ctx context.Context, environment []string, cmdArgs ...string) (string, error) {
+	return RunCommand(ctx, Command{Name: cmdArgs[0], Args: cmdArgs[1:], Env: environment})
 }
 
 func lastLines(output []string, numberOfLines int) string {
@@ -102,26 +35,17 @@ func lastLines(output []string, numberOfLines int) string {
 
 // PerformCommandWithFileOutput performs a command with output to a file
 func PerformCommandWithFileOutput(outputFilename string, cmd string, cmdArgs ...string) error {
-	var err error
-	var outputFile *os.File
-	outputFile, err = os.Create(outputFilename)
-	if err != nil {
-		return err
-	}
-	defer outputFile.Close()
-
-	execCmd := exec.Command(cmd, cmdArgs...)
-	execCmd.Stdout = outputFile
-
-	err = execCmd.Start()
-	if err != nil {
-		return err
-	}
+	return PerformCommandWithFileOutputContext(context.Background(), outputFilename, cmd, cmdArgs...)
+}
 
-	err = execCmd.Wait()
+// PerformCommandWithFileOutputContext performs a command with output to a file. The command is killed if ctx is cancelled
+func PerformCommandWithFileOutputContext(ctx context.Context, outputFilename string, cmd string, cmdArgs ...string) error {
+	outputFile, err := os.Create(outputFilename)
 	if err != nil {
 		return err
 	}
+	defer outputFile.Close()
 
-	return nil
+	_, err = RunCommand(ctx, Command{Name: cmd, Args: cmdArgs, Stdout: outputFile})
+	return err
 }
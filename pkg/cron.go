@@ -0,0 +1,170 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed standard 5-field cron expression: minute hour day-of-month month day-of-week
+type CronSchedule struct {
+	Expression string
+
+	minutes     map[int]bool
+	hours       map[int]bool
+	daysOfMonth map[int]bool
+	months      map[int]bool
+	daysOfWeek  map[int]bool
+
+	// As in cron(8): if both day fields are restricted a day matches if either matches
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCronSchedule parses a cron expression such as `0 5 * * *` or `@daily`
+func ParseCronSchedule(expression string) (*CronSchedule, error) {
+	normalized := strings.TrimSpace(expression)
+	if descriptor, ok := cronDescriptors[normalized]; ok {
+		normalized = descriptor
+	}
+
+	fields := strings.Fields(normalized)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Invalid cron expression `%s`: expected 5 fields, found %d", expression, len(fields))
+	}
+
+	schedule := &CronSchedule{Expression: expression}
+
+	var err error
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("Invalid minute field in `%s`: %s", expression, err)
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("Invalid hour field in `%s`: %s", expression, err)
+	}
+	if schedule.daysOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("Invalid day-of-month field in `%s`: %s", expression, err)
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("Invalid month field in `%s`: %s", expression, err)
+	}
+	if schedule.daysOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("Invalid day-of-week field in `%s`: %s", expression, err)
+	}
+
+	// Both 0 and 7 mean Sunday
+	if schedule.daysOfWeek[7] {
+		schedule.daysOfWeek[0] = true
+		delete(schedule.daysOfWeek, 7)
+	}
+
+	schedule.anyDayOfMonth = strings.HasPrefix(fields[2], "*")
+	schedule.anyDayOfWeek = strings.HasPrefix(fields[4], "*")
+
+	return schedule, nil
+}
+
+// Next returns the first time after fromTime that matches the schedule
+func (schedule *CronSchedule) Next(fromTime time.Time) time.Time {
+	nextTime := fromTime.Truncate(time.Minute).Add(time.Minute)
+
+	// Five years is enough to find any valid combination, including the 29th of February
+	limit := nextTime.AddDate(5, 0, 0)
+
+	for nextTime.Before(limit) {
+		if !schedule.months[int(nextTime.Month())] {
+			nextTime = time.Date(nextTime.Year(), nextTime.Month()+1, 1, 0, 0, 0, 0, nextTime.Location())
+			continue
+		}
+
+		if !schedule.matchesDay(nextTime) {
+			nextTime = time.Date(nextTime.Year(), nextTime.Month(), nextTime.Day()+1, 0, 0, 0, 0, nextTime.Location())
+			continue
+		}
+
+		if !schedule.hours[nextTime.Hour()] {
+			nextTime = time.Date(nextTime.Year(), nextTime.Month(), nextTime.Day(), nextTime.Hour()+1, 0, 0, 0, nextTime.Location())
+			continue
+		}
+
+		if !schedule.minutes[nextTime.Minute()] {
+			nextTime = nextTime.Add(time.Minute)
+			continue
+		}
+
+		return nextTime
+	}
+
+	return time.Time{}
+}
+
+func (schedule *CronSchedule) matchesDay(checkTime time.Time) bool {
+	dayOfMonthMatches := schedule.daysOfMonth[checkTime.Day()]
+	dayOfWeekMatches := schedule.daysOfWeek[int(checkTime.Weekday())]
+
+	if schedule.anyDayOfMonth || schedule.anyDayOfWeek {
+		return dayOfMonthMatches && dayOfWeekMatches
+	}
+
+	return dayOfMonthMatches || dayOfWeekMatches
+}
+
+func parseCronField(field string, min int, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slashIndex := strings.Index(part, "/"); slashIndex != -1 {
+			var err error
+			step, err = strconv.Atoi(part[slashIndex+1:])
+			if err != nil || step <= 0 {
+				return nil, errors.New("invalid step in " + part)
+			}
+			part = part[:slashIndex]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			rangePieces := strings.SplitN(part, "-", 2)
+
+			var err error
+			start, err = strconv.Atoi(rangePieces[0])
+			if err != nil {
+				return nil, errors.New("invalid value " + rangePieces[0])
+			}
+
+			end = start
+			if len(rangePieces) == 2 {
+				end, err = strconv.Atoi(rangePieces[1])
+				if err != nil {
+					return nil, errors.New("invalid value " + rangePieces[1])
+				}
+			} else if step > 1 {
+				// `5/15` means every 15 starting at 5
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("%s is out of range %d-%d", part, min, max)
+		}
+
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	fromTime := time.Date(2019, 1, 4, 10, 30, 0, 0, time.UTC) // A Friday
+
+	tests := map[string]time.Time{
+		"* * * * *":        time.Date(2019, 1, 4, 10, 31, 0, 0, time.UTC),
+		"@hourly":          time.Date(2019, 1, 4, 11, 0, 0, 0, time.UTC),
+		"0 5 * * *":        time.Date(2019, 1, 5, 5, 0, 0, 0, time.UTC),
+		"*/20 * * * *":     time.Date(2019, 1, 4, 10, 40, 0, 0, time.UTC),
+		"15,45 9-17 * * *": time.Date(2019, 1, 4, 10, 45, 0, 0, time.UTC),
+		"0 3 * * 0":        time.Date(2019, 1, 6, 3, 0, 0, 0, time.UTC),
+		"0 3 * * 7":        time.Date(2019, 1, 6, 3, 0, 0, 0, time.UTC),
+		"0 0 1 * *":        time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":       time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC),
+		"0 0 15 * 1":       time.Date(2019, 1, 7, 0, 0, 0, 0, time.UTC),
+	}
+
+	for expression, expected := range tests {
+		schedule, err := ParseCronSchedule(expression)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", expression, err)
+			continue
+		}
+
+		if next := schedule.Next(fromTime); !next.Equal(expected) {
+			t.Errorf("%s: expected %s got %s", expression, expected, next)
+		}
+	}
+}
+
+func TestCronScheduleInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	}
+
+	for _, expression := range tests {
+		if _, err := ParseCronSchedule(expression); err == nil {
+			t.Errorf("%s: expected error but got none", expression)
+		}
+	}
+}
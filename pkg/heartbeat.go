@@ -0,0 +1,154 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Heartbeat signals
+const (
+	HeartbeatStart   = "start"
+	HeartbeatSuccess = "success"
+	HeartbeatFail    = "fail"
+)
+
+// Heartbeat URL styles
+const (
+	HeartbeatStyleHealthchecks = "healthchecks"
+	HeartbeatStyleCronitor     = "cronitor"
+)
+
+var heartbeatClient = &http.Client{Timeout: 10 * time.Second}
+
+// HeartbeatConfig configures a dead man's switch that is pinged around a job
+type HeartbeatConfig struct {
+	URL string `json:"url" secret:"true"`
+
+	// `healthchecks` (default) appends /start and /fail to the URL, `cronitor` adds ?state=run|complete|fail
+	Style string `json:"style"`
+
+	// Override the URL of a single signal
+	StartURL   string `json:"start_url" secret:"true"`
+	SuccessURL string `json:"success_url" secret:"true"`
+	FailURL    string `json:"fail_url" secret:"true"`
+}
+
+// HeartbeatPayload is sent along with every ping
+type HeartbeatPayload struct {
+	Job             string  `json:"job"`
+	Hostname        string  `json:"hostname"`
+	Status          string  `json:"status"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	BackupType      string  `json:"backup_type,omitempty"`
+	BackupName      string  `json:"backup_name,omitempty"`
+	SizeInBytes     int64   `json:"size_in_bytes,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// PingHeartbeat sends signal to the heartbeat URL. A nil config does nothing
+func PingHeartbeat(config *HeartbeatConfig, signal string, payload HeartbeatPayload) error {
+	if config == nil {
+		return nil
+	}
+
+	payload.Status = signal
+
+	pingURL, err := config.signalURL(signal, payload)
+	if err != nil || pingURL == "" {
+		return err
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := heartbeatClient.Post(pingURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		responseBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 500))
+		return fmt.Errorf("Heartbeat %s returned %s: %s", signal, resp.Status, string(responseBody))
+	}
+
+	return nil
+}
+
+func (config *HeartbeatConfig) signalURL(signal string, payload HeartbeatPayload) (string, error) {
+	switch signal {
+	case HeartbeatStart:
+		if config.StartURL != "" {
+			return config.StartURL, nil
+		}
+	case HeartbeatSuccess:
+		if config.SuccessURL != "" {
+			return config.SuccessURL, nil
+		}
+	case HeartbeatFail:
+		if config.FailURL != "" {
+			return config.FailURL, nil
+		}
+	}
+
+	if config.URL == "" {
+		return "", nil
+	}
+
+	switch config.Style {
+	case "", HeartbeatStyleHealthchecks:
+		base := strings.TrimSuffix(config.URL, "/")
+		switch signal {
+		case HeartbeatStart:
+			return base + "/start", nil
+		case HeartbeatFail:
+			return base + "/fail", nil
+		}
+		return base, nil
+	case HeartbeatStyleCronitor:
+		parsedURL, err := url.Parse(config.URL)
+		if err != nil {
+			return "", err
+		}
+
+		states := map[string]string{
+			HeartbeatStart:   "run",
+			HeartbeatSuccess: "complete",
+			HeartbeatFail:    "fail",
+		}
+
+		query := parsedURL.Query()
+		query.Set("state", states[signal])
+		query.Set("host", payload.Hostname)
+		if payload.DurationSeconds > 0 {
+			query.Set("metric", "duration:"+strconv.FormatFloat(payload.DurationSeconds, 'f', 3, 64))
+		}
+		if payload.Error != "" {
+			query.Set("message", truncateString(payload.Error, 2000))
+		} else if payload.BackupName != "" {
+			query.Set("message", fmt.Sprintf("%s (%d bytes)", payload.BackupName, payload.SizeInBytes))
+		}
+		parsedURL.RawQuery = query.Encode()
+
+		return parsedURL.String(), nil
+	}
+
+	return "", fmt.Errorf("Unknown heartbeat style: %s", config.Style)
+}
+
+func truncateString(text string, maxLength int) string {
+	if len(text) <= maxLength {
+		return text
+	}
+	return text[:maxLength-3] + "..."
+}
@@ -0,0 +1,101 @@
+package pkg
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type heartbeatRequest struct {
+	URI     string
+	Payload HeartbeatPayload
+}
+
+func newHeartbeatServer() (*httptest.Server, *[]heartbeatRequest) {
+	requests := make([]heartbeatRequest, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		var payload HeartbeatPayload
+		body, _ := ioutil.ReadAll(request.Body)
+		json.Unmarshal(body, &payload)
+
+		requests = append(requests, heartbeatRequest{request.RequestURI, payload})
+	}))
+
+	return server, &requests
+}
+
+func TestHealthchecksHeartbeat(t *testing.T) {
+	server, requests := newHeartbeatServer()
+	defer server.Close()
+
+	config := &HeartbeatConfig{URL: server.URL + "/ping/uuid"}
+	payload := HeartbeatPayload{Job: "perform", Hostname: "db1"}
+
+	for _, signal := range []string{HeartbeatStart, HeartbeatSuccess, HeartbeatFail} {
+		if err := PingHeartbeat(config, signal, payload); err != nil {
+			t.Fatal("No error expected", err)
+		}
+	}
+
+	expectedURIs := []string{"/ping/uuid/start", "/ping/uuid", "/ping/uuid/fail"}
+	for index, expectedURI := range expectedURIs {
+		if (*requests)[index].URI != expectedURI {
+			t.Errorf("Expected %s, got %s", expectedURI, (*requests)[index].URI)
+		}
+	}
+
+	if (*requests)[1].Payload.Status != HeartbeatSuccess || (*requests)[1].Payload.Hostname != "db1" {
+		t.Errorf("Incorrect payload: %v", (*requests)[1].Payload)
+	}
+}
+
+func TestCronitorHeartbeat(t *testing.T) {
+	server, requests := newHeartbeatServer()
+	defer server.Close()
+
+	config := &HeartbeatConfig{URL: server.URL + "/p/key/backups", Style: HeartbeatStyleCronitor}
+
+	err := PingHeartbeat(config, HeartbeatSuccess, HeartbeatPayload{
+		Job:             "perform",
+		Hostname:        "db1",
+		DurationSeconds: 12.5,
+		BackupName:      "backup-1.xbstream",
+		SizeInBytes:     1024,
+	})
+	if err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	expectedURI := "/p/key/backups?host=db1&message=backup-1.xbstream+%281024+bytes%29&metric=duration%3A12.500&state=complete"
+	if (*requests)[0].URI != expectedURI {
+		t.Errorf("Expected %s, got %s", expectedURI, (*requests)[0].URI)
+	}
+
+	if (*requests)[0].Payload.SizeInBytes != 1024 {
+		t.Errorf("Incorrect payload: %v", (*requests)[0].Payload)
+	}
+}
+
+func TestHeartbeatOverridesAndErrors(t *testing.T) {
+	server, requests := newHeartbeatServer()
+	defer server.Close()
+
+	config := &HeartbeatConfig{URL: server.URL + "/ping", FailURL: server.URL + "/custom-fail"}
+	if err := PingHeartbeat(config, HeartbeatFail, HeartbeatPayload{}); err != nil {
+		t.Fatal("No error expected", err)
+	}
+	if (*requests)[0].URI != "/custom-fail" {
+		t.Errorf("Expected fail URL override, got %s", (*requests)[0].URI)
+	}
+
+	if err := PingHeartbeat(nil, HeartbeatStart, HeartbeatPayload{}); err != nil {
+		t.Error("Expected nil config to do nothing", err)
+	}
+
+	if err := PingHeartbeat(&HeartbeatConfig{URL: server.URL, Style: "unknown"}, HeartbeatStart, HeartbeatPayload{}); err == nil {
+		t.Error("Expected error for unknown style")
+	}
+}
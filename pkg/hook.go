@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const hookDefaultTimeoutSeconds = 300
+
+// HookConfig is a shell command run at a point of a backup or restore
+type HookConfig struct {
+	Command string `json:"command"`
+
+	// The command is killed after this many seconds (Default: 300)
+	TimeoutSeconds int `json:"timeout_seconds"`
+
+	// Only for hooks that run before a backup or restore. Stops the run when the hook fails, instead of continuing with a warning
+	AbortOnFailure bool `json:"abort_on_failure"`
+}
+
+// RunHook runs the command of hook with `sh -c`. environment is added to the environment of this process
+func RunHook(ctx context.Context, hook *HookConfig, environment []string) error {
+	timeoutSeconds := hook.TimeoutSeconds
+	if timeoutSeconds <= 0 {
+		timeoutSeconds = hookDefaultTimeoutSeconds
+	}
+	timeout := time.Duration(timeoutSeconds) * time.Second
+
+	hookCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	output, err := PerformCommandWithEnvContext(hookCtx, environment, "/bin/sh", "-c", hook.Command)
+	if hookCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("Timed out after %s", timeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line != "" {
+			Log.Println("> " + line)
+		}
+	}
+
+	return nil
+}
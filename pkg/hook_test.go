@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunHook(t *testing.T) {
+	Log = log.New(ioutil.Discard, "", 0)
+	ErrorLog = log.New(ioutil.Discard, "", 0)
+
+	directory, err := ioutil.TempDir("", "hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	outputPath := path.Join(directory, "output")
+
+	err = RunHook(context.Background(), &HookConfig{Command: `echo "$BACKUP_TYPE" > ` + outputPath}, []string{"BACKUP_TYPE=full"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, _ := ioutil.ReadFile(outputPath)
+	if string(output) != "full\n" {
+		t.Errorf("Expected the environment to be passed to the hook: %q", output)
+	}
+
+	err = RunHook(context.Background(), &HookConfig{Command: "exit 3"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("Expected the exit status of a failing hook: %v", err)
+	}
+
+	startedAt := time.Now()
+	err = RunHook(context.Background(), &HookConfig{Command: "sleep 5; echo done", TimeoutSeconds: 1}, nil)
+	if err == nil || err.Error() != "Timed out after 1s" {
+		t.Errorf("Expected the hook to time out: %v", err)
+	}
+	if elapsed := time.Since(startedAt); elapsed > 3*time.Second {
+		t.Errorf("Expected the hook to be stopped after 1s, it took %s", elapsed)
+	}
+}
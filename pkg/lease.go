@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	minio "github.com/minio/minio-go"
+)
+
+// BucketLease is an object stored next to the backups of a host marking which droplet is currently writing to it
+type BucketLease struct {
+	ObjectName string    `json:"-"`
+	Owner      string    `json:"owner"`
+	Command    string    `json:"command"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// HeldByOther returns true if the lease is still valid and belongs to someone other than owner
+func (lease *BucketLease) HeldByOther(owner string, nowTime time.Time) bool {
+	return lease.Owner != owner && lease.ExpiresAt.After(nowTime)
+}
+
+// AcquireBucketLease writes a lease object unless another owner holds a lease that has not expired yet
+func AcquireBucketLease(bucketName string, objectName string, owner string, command string, duration time.Duration, minioClient *minio.Client) (*BucketLease, error) {
+	existingLease, err := readBucketLease(bucketName, objectName, minioClient)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if existingLease != nil && existingLease.HeldByOther(owner, now) {
+		return nil, fmt.Errorf(
+			"Lease %s is held by %s (`%s`) until %s",
+			objectName,
+			existingLease.Owner,
+			existingLease.Command,
+			existingLease.ExpiresAt.Format(time.RFC3339),
+		)
+	}
+
+	lease := &BucketLease{
+		ObjectName: objectName,
+		Owner:      owner,
+		Command:    command,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(duration),
+	}
+
+	contents, err := json.Marshal(lease)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = minioClient.PutObject(bucketName, objectName, bytes.NewReader(contents), int64(len(contents)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lease, nil
+}
+
+// ReleaseBucketLease removes the lease object if it is still owned by the lease's owner
+func ReleaseBucketLease(bucketName string, lease *BucketLease, minioClient *minio.Client) error {
+	existingLease, err := readBucketLease(bucketName, lease.ObjectName, minioClient)
+	if err != nil {
+		return err
+	}
+
+	if existingLease == nil {
+		return nil
+	}
+
+	if existingLease.Owner != lease.Owner {
+		return fmt.Errorf("Lease %s has been taken over by %s, not releasing it", lease.ObjectName, existingLease.Owner)
+	}
+
+	return minioClient.RemoveObject(bucketName, lease.ObjectName)
+}
+
+func readBucketLease(bucketName string, objectName string, minioClient *minio.Client) (*BucketLease, error) {
+	object, err := minioClient.GetObject(bucketName, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	contents, err := ioutil.ReadAll(object)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	lease := &BucketLease{ObjectName: objectName}
+	err = json.Unmarshal(contents, lease)
+	if err != nil {
+		// A broken lease object can't be honoured. Treat it as missing so it gets overwritten
+		Log.Println("Warning: Could not decode lease object", objectName, err)
+		return nil, nil
+	}
+
+	return lease, nil
+}
@@ -0,0 +1,174 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"time"
+)
+
+const hostLockFileName = "really-simple-db-backup.lock"
+
+// HostLock is a lock file that prevents two runs on the same host from touching the same persistent storage.
+// The file is locked with flock(2) while the run holds it, so the kernel releases it when the process dies
+type HostLock struct {
+	Path      string    `json:"-"`
+	PID       int       `json:"pid"`
+	Command   string    `json:"command"`
+	StartedAt time.Time `json:"started_at"`
+
+	file *os.File
+}
+
+// AcquireHostLock takes the lock in directory. A lock file left behind by a process that is no longer running is taken over
+func AcquireHostLock(directory string, command string) (*HostLock, error) {
+	err := os.MkdirAll(directory, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &HostLock{
+		Path:      path.Join(directory, hostLockFileName),
+		PID:       os.Getpid(),
+		Command:   command,
+		StartedAt: time.Now(),
+	}
+
+	contents, err := json.Marshal(lock)
+	if err != nil {
+		return nil, err
+	}
+
+	// A run releasing the lock removes the file, so the file that was locked might not be the lock file anymore
+	for tries := 0; tries < 3; tries++ {
+		lockFile, err := os.OpenFile(lock.Path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == syscall.EWOULDBLOCK {
+			lockFile.Close()
+			return nil, heldHostLockError(lock.Path)
+		}
+		if err != nil {
+			lockFile.Close()
+			return nil, err
+		}
+
+		if !isLockFile(lockFile, lock.Path) {
+			lockFile.Close()
+			continue
+		}
+
+		if existingLock, readErr := ReadHostLock(lock.Path); readErr == nil {
+			Log.Printf("Taking over the lock file %s left behind by `%s` (pid %d)\n", lock.Path, existingLock.Command, existingLock.PID)
+		}
+
+		err = lockFile.Truncate(0)
+		if err == nil {
+			_, err = lockFile.WriteAt(contents, 0)
+		}
+		if err != nil {
+			os.Remove(lock.Path)
+			lockFile.Close()
+			return nil, err
+		}
+
+		lock.file = lockFile
+		return lock, nil
+	}
+
+	return nil, fmt.Errorf("Could not acquire lock %s", lock.Path)
+}
+
+// HostLockHolder returns the lock in directory when another run holds it
+func HostLockHolder(directory string) (*HostLock, bool) {
+	lockPath := path.Join(directory, hostLockFileName)
+
+	lockFile, err := os.Open(lockPath)
+	if err != nil {
+		return nil, false
+	}
+	defer lockFile.Close()
+
+	if syscall.Flock(int(lockFile.Fd()), syscall.LOCK_SH|syscall.LOCK_NB) != syscall.EWOULDBLOCK {
+		return nil, false
+	}
+
+	existingLock, err := ReadHostLock(lockPath)
+	if err != nil {
+		// Held, but the holder hasn't written it yet
+		return &HostLock{Path: lockPath}, true
+	}
+
+	return existingLock, true
+}
+
+func heldHostLockError(lockPath string) error {
+	existingLock, err := ReadHostLock(lockPath)
+	if err != nil {
+		return fmt.Errorf("Another run is in progress. Lock file: %s", lockPath)
+	}
+
+	return fmt.Errorf(
+		"Another run is in progress: `%s` (pid %d) started at %s. Lock file: %s",
+		existingLock.Command,
+		existingLock.PID,
+		existingLock.StartedAt.Format(time.RFC3339),
+		lockPath,
+	)
+}
+
+// isLockFile returns whether lockFile is still the file at lockPath
+func isLockFile(lockFile *os.File, lockPath string) bool {
+	openInfo, err := lockFile.Stat()
+	if err != nil {
+		return false
+	}
+
+	pathInfo, err := os.Stat(lockPath)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(openInfo, pathInfo)
+}
+
+// ReadHostLock reads the lock file at lockPath
+func ReadHostLock(lockPath string) (*HostLock, error) {
+	contents, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &HostLock{Path: lockPath}
+	err = json.Unmarshal(contents, lock)
+	if err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// Release removes the lock file if it is still owned by this process
+func (lock *HostLock) Release() error {
+	// Closing the file unlocks it. That happens after removing it, so nobody can lock the file once it is released
+	if lock.file != nil {
+		defer lock.file.Close()
+	}
+
+	existingLock, err := ReadHostLock(lock.Path)
+	if err != nil {
+		return err
+	}
+
+	if existingLock.PID != lock.PID {
+		return fmt.Errorf("Lock %s is owned by pid %d, not releasing it", lock.Path, existingLock.PID)
+	}
+
+	return os.Remove(lock.Path)
+}
@@ -0,0 +1,135 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostLock(t *testing.T) {
+	Log = log.New(ioutil.Discard, "", 0)
+
+	directory, err := ioutil.TempDir("", "host-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	lock, err := AcquireHostLock(directory, "perform")
+	if err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	if lock.PID != os.Getpid() {
+		t.Errorf("Incorrect PID in lock: %d", lock.PID)
+	}
+
+	// This process is running, so the lock is not stale
+	if _, err = AcquireHostLock(directory, "prune"); err == nil {
+		t.Error("Expected error when lock is already held")
+	}
+
+	if holder, held := HostLockHolder(directory); !held || holder.Command != "perform" {
+		t.Errorf("Expected the lock to be held by perform, got %v", holder)
+	}
+
+	if err = lock.Release(); err != nil {
+		t.Error("No error expected on release", err)
+	}
+
+	if _, held := HostLockHolder(directory); held {
+		t.Error("Expected the lock not to be held after releasing it")
+	}
+
+	if _, err = os.Stat(lock.Path); !os.IsNotExist(err) {
+		t.Error("Expected lock file to be removed")
+	}
+}
+
+func TestHostLockStale(t *testing.T) {
+	Log = log.New(ioutil.Discard, "", 0)
+
+	directory, err := ioutil.TempDir("", "host-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	// A PID above the kernel's pid_max can never be running
+	staleLock := `{"pid": 2147483647, "command": "perform", "started_at": "2019-01-01T10:00:00Z"}`
+	ioutil.WriteFile(path.Join(directory, hostLockFileName), []byte(staleLock), 0600)
+
+	lock, err := AcquireHostLock(directory, "perform")
+	if err != nil {
+		t.Fatal("Expected stale lock to be taken over", err)
+	}
+
+	if lock.PID != os.Getpid() {
+		t.Errorf("Incorrect PID in lock: %d", lock.PID)
+	}
+
+	// Broken lock files are also considered stale
+	lock.Release()
+	ioutil.WriteFile(path.Join(directory, hostLockFileName), []byte("garbage"), 0600)
+
+	if _, err = AcquireHostLock(directory, "perform"); err != nil {
+		t.Error("Expected broken lock to be taken over", err)
+	}
+}
+
+func TestHostLockConcurrentRuns(t *testing.T) {
+	Log = log.New(ioutil.Discard, "", 0)
+
+	directory, err := ioutil.TempDir("", "host-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	// Every run that starts while the lock file is still empty must see it as held
+	for round := 0; round < 20; round++ {
+		locks := make(chan *HostLock, 10)
+		var starting sync.WaitGroup
+		for run := 0; run < 10; run++ {
+			starting.Add(1)
+			go func() {
+				defer starting.Done()
+				if lock, err := AcquireHostLock(directory, "perform"); err == nil {
+					locks <- lock
+				}
+			}()
+		}
+		starting.Wait()
+		close(locks)
+
+		if len(locks) != 1 {
+			t.Fatalf("Expected exactly one run to take the lock, %d did", len(locks))
+		}
+		(<-locks).Release()
+	}
+}
+
+func TestBucketLeaseHeldByOther(t *testing.T) {
+	nowTime := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	lease := &BucketLease{
+		Owner:     "droplet-1",
+		ExpiresAt: nowTime.Add(time.Hour),
+	}
+
+	if lease.HeldByOther("droplet-1", nowTime) {
+		t.Error("Lease should not be held by other for its own owner")
+	}
+
+	if !lease.HeldByOther("droplet-2", nowTime) {
+		t.Error("Lease should be held by other for another owner")
+	}
+
+	if lease.HeldByOther("droplet-2", nowTime.Add(2*time.Hour)) {
+		t.Error("Expired lease should not be held by anyone")
+	}
+}
@@ -1,6 +1,15 @@
 package pkg
 
-import "log"
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
 
 // VerboseMode is a global switch to turn verbose mode off or on
 var VerboseMode bool
@@ -10,3 +19,187 @@ var Log *log.Logger
 
 // ErrorLog is the default error log to use
 var ErrorLog *log.Logger
+
+// Log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogFields is the context of the current run, added to every log line
+type LogFields struct {
+	RunID    string
+	Job      string
+	Hostname string
+	Stage    string
+	Backup   string
+
+	runStartedAt   time.Time
+	stageStartedAt time.Time
+}
+
+var logContext struct {
+	sync.Mutex
+	fields LogFields
+}
+
+// ConfigureLogging replaces Log and ErrorLog with loggers that add the run context to every line.
+// Progress bars are only shown for text logs written to a terminal
+func ConfigureLogging(format string, stdout *os.File, stderr *os.File) error {
+	if format == "" {
+		format = LogFormatText
+	}
+
+	if format != LogFormatText && format != LogFormatJSON {
+		return fmt.Errorf("Unknown log format: %s. Should be %s or %s", format, LogFormatText, LogFormatJSON)
+	}
+
+	Log = log.New(&logWriter{output: stdout, level: "info", format: format}, "", 0)
+	ErrorLog = log.New(&logWriter{output: stderr, level: "error", format: format}, "", 0)
+
+	ShowProgressBars = format == LogFormatText && isTerminal(stdout)
+
+	return nil
+}
+
+// StartLogRun sets the context of a new run
+func StartLogRun(runID string, job string, hostname string) {
+	logContext.Lock()
+	defer logContext.Unlock()
+
+	now := time.Now()
+	logContext.fields = LogFields{
+		RunID:          runID,
+		Job:            job,
+		Hostname:       hostname,
+		runStartedAt:   now,
+		stageStartedAt: now,
+	}
+}
+
+// EndLogRun clears the context of the current run
+func EndLogRun() {
+	logContext.Lock()
+	defer logContext.Unlock()
+
+	logContext.fields = LogFields{}
+}
+
+// SetLogStage sets the stage the current run is in
+func SetLogStage(stage string) {
+	logContext.Lock()
+	defer logContext.Unlock()
+
+	logContext.fields.Stage = stage
+	logContext.fields.stageStartedAt = time.Now()
+}
+
+// SetLogBackup sets the name of the backup the current run works on
+func SetLogBackup(backup string) {
+	logContext.Lock()
+	defer logContext.Unlock()
+
+	logContext.fields.Backup = backup
+}
+
+// CurrentLogStage returns the stage the current run is in
+func CurrentLogStage() string {
+	return currentLogFields().Stage
+}
+
+func currentLogFields() LogFields {
+	logContext.Lock()
+	defer logContext.Unlock()
+
+	return logContext.fields
+}
+
+type logLine struct {
+	Time                string   `json:"time"`
+	Level               string   `json:"level"`
+	Message             string   `json:"msg"`
+	RunID               string   `json:"run_id,omitempty"`
+	Job                 string   `json:"job,omitempty"`
+	Hostname            string   `json:"hostname,omitempty"`
+	Stage               string   `json:"stage,omitempty"`
+	Backup              string   `json:"backup,omitempty"`
+	RunElapsedSeconds   *float64 `json:"run_elapsed_seconds,omitempty"`
+	StageElapsedSeconds *float64 `json:"stage_elapsed_seconds,omitempty"`
+}
+
+type logWriter struct {
+	mutex  sync.Mutex
+	output io.Writer
+	level  string
+	format string
+}
+
+// Write receives exactly one formatted line from log.Logger
+func (writer *logWriter) Write(message []byte) (int, error) {
+	line := writer.formatLine(time.Now(), currentLogFields(), strings.TrimRight(string(message), "\n"))
+
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	_, err := writer.output.Write(line)
+	if err != nil {
+		return 0, err
+	}
+	return len(message), nil
+}
+
+func (writer *logWriter) formatLine(now time.Time, fields LogFields, message string) []byte {
+	if writer.format == LogFormatJSON {
+		line := logLine{
+			Time:     now.Format(time.RFC3339Nano),
+			Level:    writer.level,
+			Message:  message,
+			RunID:    fields.RunID,
+			Job:      fields.Job,
+			Hostname: fields.Hostname,
+			Stage:    fields.Stage,
+			Backup:   fields.Backup,
+		}
+
+		if !fields.runStartedAt.IsZero() {
+			runElapsed := roundSeconds(now.Sub(fields.runStartedAt))
+			stageElapsed := roundSeconds(now.Sub(fields.stageStartedAt))
+			line.RunElapsedSeconds = &runElapsed
+			line.StageElapsedSeconds = &stageElapsed
+		}
+
+		encoded, err := json.Marshal(line)
+		if err != nil {
+			encoded = []byte(fmt.Sprintf(`{"level":"error","msg":%q}`, err.Error()))
+		}
+		return append(encoded, '\n')
+	}
+
+	prefix := now.Format("2006/01/02 15:04:05")
+	if fields.RunID != "" {
+		context := []string{"run=" + fields.RunID}
+		if fields.Stage != "" {
+			context = append(context, "stage="+fields.Stage)
+		}
+		if fields.Backup != "" {
+			context = append(context, "backup="+fields.Backup)
+		}
+		context = append(context, "elapsed="+now.Sub(fields.runStartedAt).Truncate(time.Second).String())
+
+		prefix += " [" + strings.Join(context, " ") + "]"
+	}
+
+	return []byte(prefix + " " + message + "\n")
+}
+
+func roundSeconds(duration time.Duration) float64 {
+	return float64(duration.Truncate(time.Millisecond)) / float64(time.Second)
+}
+
+func isTerminal(file *os.File) bool {
+	stat, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTextLogLine(t *testing.T) {
+	now := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+	writer := &logWriter{level: "info", format: LogFormatText}
+
+	line := string(writer.formatLine(now, LogFields{}, "Backup started"))
+	if line != "2019/01/01 10:00:00 Backup started\n" {
+		t.Errorf("Incorrect line without run: %q", line)
+	}
+
+	fields := LogFields{
+		RunID:          "abc123",
+		Job:            "perform",
+		Hostname:       "db1",
+		Stage:          "upload",
+		Backup:         "mysql-backup-1.full",
+		runStartedAt:   now.Add(-90 * time.Second),
+		stageStartedAt: now.Add(-10 * time.Second),
+	}
+
+	line = string(writer.formatLine(now, fields, "Uploading"))
+	if line != "2019/01/01 10:00:00 [run=abc123 stage=upload backup=mysql-backup-1.full elapsed=1m30s] Uploading\n" {
+		t.Errorf("Incorrect line with run: %q", line)
+	}
+}
+
+func TestJSONLogLine(t *testing.T) {
+	now := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+	writer := &logWriter{level: "error", format: LogFormatJSON}
+
+	fields := LogFields{
+		RunID:          "abc123",
+		Job:            "perform",
+		Hostname:       "db1",
+		Stage:          "upload",
+		runStartedAt:   now.Add(-90 * time.Second),
+		stageStartedAt: now.Add(-10 * time.Second),
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(writer.formatLine(now, fields, `Could not "upload"`), &decoded); err != nil {
+		t.Fatal("Line is not JSON", err)
+	}
+
+	expected := map[string]interface{}{
+		"time":                  "2019-01-01T10:00:00Z",
+		"level":                 "error",
+		"msg":                   `Could not "upload"`,
+		"run_id":                "abc123",
+		"job":                   "perform",
+		"hostname":              "db1",
+		"stage":                 "upload",
+		"run_elapsed_seconds":   90.0,
+		"stage_elapsed_seconds": 10.0,
+	}
+
+	for key, value := range expected {
+		if decoded[key] != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, decoded[key])
+		}
+	}
+
+	if _, exists := decoded["backup"]; exists {
+		t.Error("Expected empty backup to be left out")
+	}
+}
+
+func TestLogRunContext(t *testing.T) {
+	var output bytes.Buffer
+	logger := log.New(&logWriter{output: &output, level: "info", format: LogFormatJSON}, "", 0)
+
+	StartLogRun("abc123", "prune", "db1")
+	SetLogStage("list")
+	logger.Println("Listing")
+	EndLogRun()
+	logger.Println("Idle")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected one JSON line per log call, got %q", output.String())
+	}
+
+	if !strings.Contains(lines[0], `"run_id":"abc123"`) || !strings.Contains(lines[0], `"stage":"list"`) {
+		t.Errorf("Expected run context: %s", lines[0])
+	}
+
+	if strings.Contains(lines[1], "run_id") {
+		t.Errorf("Expected no run context after the run ended: %s", lines[1])
+	}
+}
+
+func TestProgressReporterDescription(t *testing.T) {
+	reporter := NewProgressReporter("Uploading backup", 4<<30)
+	reporter.startedAt = time.Now().Add(-10 * time.Second)
+	reporter.Set(1 << 30)
+
+	description := reporter.describe()
+	if !strings.HasPrefix(description, "Uploading backup: 1.0 GiB of 4.0 GiB (25%), ") || !strings.HasSuffix(description, "MiB/s") {
+		t.Errorf("Incorrect description: %s", description)
+	}
+
+	proxy := reporter.NewProxyReader(strings.NewReader("12345"))
+	buffer := make([]byte, 10)
+	proxy.Read(buffer)
+
+	if reporter.current != 1<<30+5 {
+		t.Errorf("Expected proxy reader to add to progress, got %d", reporter.current)
+	}
+}
+
+func TestConfigureLoggingRejectsUnknownFormat(t *testing.T) {
+	previousLog, previousErrorLog := Log, ErrorLog
+	defer func() { Log, ErrorLog = previousLog, previousErrorLog }()
+
+	if err := ConfigureLogging("xml", nil, nil); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
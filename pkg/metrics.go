@@ -0,0 +1,327 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsPrefix = "really_simple_db_backup_"
+
+// MetricsConfig sub-config type for metrics related
+type MetricsConfig struct {
+	TextfilePath  string `json:"textfile_path"`
+	ListenAddress string `json:"listen_address"`
+}
+
+// MetricsState is persisted between runs so every write of the metrics contains the full picture
+type MetricsState struct {
+	BackupLastSuccess           map[string]time.Time `json:"backup_last_success"`
+	BackupLastDurationSeconds   map[string]float64   `json:"backup_last_duration_seconds"`
+	BackupLastSizeBytes         map[string]int64     `json:"backup_last_size_bytes"`
+	BackupLastUploadBytesPerSec map[string]float64   `json:"backup_last_upload_bytes_per_second"`
+
+	JobLastSuccess         map[string]time.Time `json:"job_last_success"`
+	JobLastDurationSeconds map[string]float64   `json:"job_last_duration_seconds"`
+	JobFailures            map[string]int64     `json:"job_failures"` // Keyed by job + "/" + stage
+	JobLastFailure         map[string]time.Time `json:"job_last_failure"`
+
+	PrunedBackupsTotal int64 `json:"pruned_backups_total"`
+	LastPrunedBackups  int64 `json:"last_pruned_backups"`
+}
+
+// Metrics keeps track of backup health and exposes it in the Prometheus text format.
+// A nil *Metrics is valid and records nothing.
+type Metrics struct {
+	config    *MetricsConfig
+	statePath string
+
+	// The backup job of the config the metrics are of. Every metric is labelled with it, and written to a textfile of its own
+	backupJob string
+
+	mutex sync.Mutex
+	state MetricsState
+}
+
+// NewMetrics loads previously recorded metrics from persistentStorageDirectory. Returns nil if metrics aren't configured.
+// backupJob is the name of the job of the config, if any
+func NewMetrics(config *MetricsConfig, persistentStorageDirectory string, backupJob string) *Metrics {
+	if config == nil || (config.TextfilePath == "" && config.ListenAddress == "") {
+		return nil
+	}
+
+	metrics := &Metrics{
+		config:    config,
+		statePath: path.Join(persistentStorageDirectory, "metrics.json"),
+		backupJob: backupJob,
+	}
+
+	contents, err := ioutil.ReadFile(metrics.statePath)
+	if err == nil {
+		err = json.Unmarshal(contents, &metrics.state)
+		if err != nil {
+			ErrorLog.Println("Warning: Could not decode metrics state. Starting from scratch.", err)
+		}
+	}
+
+	metrics.state.init()
+
+	return metrics
+}
+
+func (state *MetricsState) init() {
+	if state.BackupLastSuccess == nil {
+		state.BackupLastSuccess = make(map[string]time.Time)
+	}
+	if state.BackupLastDurationSeconds == nil {
+		state.BackupLastDurationSeconds = make(map[string]float64)
+	}
+	if state.BackupLastSizeBytes == nil {
+		state.BackupLastSizeBytes = make(map[string]int64)
+	}
+	if state.BackupLastUploadBytesPerSec == nil {
+		state.BackupLastUploadBytesPerSec = make(map[string]float64)
+	}
+	if state.JobLastSuccess == nil {
+		state.JobLastSuccess = make(map[string]time.Time)
+	}
+	if state.JobLastDurationSeconds == nil {
+		state.JobLastDurationSeconds = make(map[string]float64)
+	}
+	if state.JobFailures == nil {
+		state.JobFailures = make(map[string]int64)
+	}
+	if state.JobLastFailure == nil {
+		state.JobLastFailure = make(map[string]time.Time)
+	}
+}
+
+// RecordBackup records a backup that was successfully uploaded
+func (metrics *Metrics) RecordBackup(backupType string, duration time.Duration, sizeInBytes int64, uploadDuration time.Duration) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.state.BackupLastSuccess[backupType] = time.Now()
+	metrics.state.BackupLastDurationSeconds[backupType] = duration.Seconds()
+	metrics.state.BackupLastSizeBytes[backupType] = sizeInBytes
+	if uploadDuration > 0 {
+		metrics.state.BackupLastUploadBytesPerSec[backupType] = float64(sizeInBytes) / uploadDuration.Seconds()
+	}
+}
+
+// RecordPrunedBackups records the number of backups removed by a prune
+func (metrics *Metrics) RecordPrunedBackups(count int) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.state.PrunedBackupsTotal += int64(count)
+	metrics.state.LastPrunedBackups = int64(count)
+}
+
+// RecordJob records the outcome of a command. failedStage is empty on success
+func (metrics *Metrics) RecordJob(job string, duration time.Duration, failedStage string) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	if failedStage == "" {
+		metrics.state.JobLastSuccess[job] = time.Now()
+		metrics.state.JobLastDurationSeconds[job] = duration.Seconds()
+	} else {
+		key := job + "/" + failedStage
+		metrics.state.JobFailures[key]++
+		metrics.state.JobLastFailure[key] = time.Now()
+	}
+}
+
+// Flush persists the metrics state and writes the textfile collector file, if configured
+func (metrics *Metrics) Flush() error {
+	if metrics == nil {
+		return nil
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	contents, err := json.Marshal(metrics.state)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomically(metrics.statePath, contents)
+	if err != nil {
+		return err
+	}
+
+	textfilePath := metrics.textfilePath()
+	if textfilePath == "" {
+		return nil
+	}
+
+	textfile, err := ioutil.TempFile(path.Dir(textfilePath), ".really-simple-db-backup-metrics")
+	if err != nil {
+		return err
+	}
+
+	metrics.state.WritePrometheusTextOfJob(textfile, metrics.backupJob)
+
+	err = textfile.Close()
+	if err != nil {
+		os.Remove(textfile.Name())
+		return err
+	}
+
+	// node_exporter needs to be able to read the file
+	os.Chmod(textfile.Name(), 0644)
+
+	return os.Rename(textfile.Name(), textfilePath)
+}
+
+// textfilePath is the textfile of the config, or one next to it for a backup job, like really_simple_db_backup-main.prom.
+// The textfile collector reads every file in the directory, so the jobs don't overwrite each other
+func (metrics *Metrics) textfilePath() string {
+	textfilePath := metrics.config.TextfilePath
+	if textfilePath == "" || metrics.backupJob == "" {
+		return textfilePath
+	}
+
+	extension := path.Ext(textfilePath)
+	return strings.TrimSuffix(textfilePath, extension) + "-" + metrics.backupJob + extension
+}
+
+// ListenAddress returns the address /metrics should be served on, if any
+func (metrics *Metrics) ListenAddress() string {
+	if metrics == nil {
+		return ""
+	}
+	return metrics.config.ListenAddress
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (metrics *Metrics) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	responseWriter.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.state.WritePrometheusTextOfJob(responseWriter, metrics.backupJob)
+}
+
+// WritePrometheusText writes the state in the Prometheus text format
+func (state *MetricsState) WritePrometheusText(writer io.Writer) {
+	state.WritePrometheusTextOfJob(writer, "")
+}
+
+// WritePrometheusTextOfJob writes the state in the Prometheus text format, with every metric labelled with backupJob if it isn't empty
+func (state *MetricsState) WritePrometheusTextOfJob(writer io.Writer, backupJob string) {
+	labels := ""
+	if backupJob != "" {
+		labels = fmt.Sprintf("backup_job=%q,", backupJob)
+	}
+
+	writeGaugeFamily(writer, "backup_last_success_timestamp_seconds", "Unix time of the last successful backup.", labels, "type", timestampValues(state.BackupLastSuccess))
+	writeGaugeFamily(writer, "backup_last_duration_seconds", "Duration of the last successful backup.", labels, "type", state.BackupLastDurationSeconds)
+	writeGaugeFamily(writer, "backup_last_size_bytes", "Size of the last successful backup.", labels, "type", int64Values(state.BackupLastSizeBytes))
+	writeGaugeFamily(writer, "backup_last_upload_bytes_per_second", "Upload throughput of the last successful backup.", labels, "type", state.BackupLastUploadBytesPerSec)
+
+	writeGaugeFamily(writer, "job_last_success_timestamp_seconds", "Unix time of the last successful run of a command.", labels, "job", timestampValues(state.JobLastSuccess))
+	writeGaugeFamily(writer, "job_last_duration_seconds", "Duration of the last successful run of a command.", labels, "job", state.JobLastDurationSeconds)
+
+	writeFamilyHeader(writer, "job_failures_total", "Number of failed runs of a command by the stage it failed in.", "counter")
+	for _, key := range sortedKeys(int64Values(state.JobFailures)) {
+		job, stage := splitFailureKey(key)
+		fmt.Fprintf(writer, "%sjob_failures_total{%sjob=%q,stage=%q} %d\n", metricsPrefix, labels, job, stage, state.JobFailures[key])
+	}
+
+	writeFamilyHeader(writer, "job_last_failure_timestamp_seconds", "Unix time of the last failed run of a command by the stage it failed in.", "gauge")
+	for _, key := range sortedKeys(timestampValues(state.JobLastFailure)) {
+		job, stage := splitFailureKey(key)
+		fmt.Fprintf(writer, "%sjob_last_failure_timestamp_seconds{%sjob=%q,stage=%q} %d\n", metricsPrefix, labels, job, stage, state.JobLastFailure[key].Unix())
+	}
+
+	unlabelled := ""
+	if labels != "" {
+		unlabelled = "{" + strings.TrimSuffix(labels, ",") + "}"
+	}
+
+	writeFamilyHeader(writer, "pruned_backups_total", "Number of backups removed by pruning.", "counter")
+	fmt.Fprintf(writer, "%spruned_backups_total%s %d\n", metricsPrefix, unlabelled, state.PrunedBackupsTotal)
+
+	writeFamilyHeader(writer, "last_pruned_backups", "Number of backups removed by the last prune.", "gauge")
+	fmt.Fprintf(writer, "%slast_pruned_backups%s %d\n", metricsPrefix, unlabelled, state.LastPrunedBackups)
+}
+
+func writeFamilyHeader(writer io.Writer, name string, help string, metricType string) {
+	fmt.Fprintf(writer, "# HELP %s%s %s\n", metricsPrefix, name, help)
+	fmt.Fprintf(writer, "# TYPE %s%s %s\n", metricsPrefix, name, metricType)
+}
+
+func writeGaugeFamily(writer io.Writer, name string, help string, labels string, labelName string, values map[string]float64) {
+	writeFamilyHeader(writer, name, help, "gauge")
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(writer, "%s%s{%s%s=%q} %s\n", metricsPrefix, name, labels, labelName, key, strconv.FormatFloat(values[key], 'f', -1, 64))
+	}
+}
+
+func timestampValues(values map[string]time.Time) map[string]float64 {
+	res := make(map[string]float64, len(values))
+	for key, value := range values {
+		res[key] = float64(value.Unix())
+	}
+	return res
+}
+
+func int64Values(values map[string]int64) map[string]float64 {
+	res := make(map[string]float64, len(values))
+	for key, value := range values {
+		res[key] = float64(value)
+	}
+	return res
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func splitFailureKey(key string) (string, string) {
+	pieces := strings.SplitN(key, "/", 2)
+	if len(pieces) != 2 {
+		return key, ""
+	}
+	return pieces[0], pieces[1]
+}
+
+func writeFileAtomically(filePath string, contents []byte) error {
+	temporaryPath := filePath + ".tmp"
+
+	err := ioutil.WriteFile(temporaryPath, contents, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporaryPath, filePath)
+}
@@ -0,0 +1,112 @@
+package pkg
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsPersistedBetweenRuns(t *testing.T) {
+	directory, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	config := &MetricsConfig{TextfilePath: path.Join(directory, "backup.prom")}
+
+	metrics := NewMetrics(config, directory, "")
+	metrics.RecordBackup("full", 10*time.Minute, 2000, 20*time.Second)
+	metrics.RecordJob("perform", 10*time.Minute, "")
+	metrics.RecordPrunedBackups(3)
+	if err = metrics.Flush(); err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	// A second run only records a failure, but previous values must still be written
+	metrics = NewMetrics(config, directory, "")
+	metrics.RecordJob("perform", time.Minute, "upload")
+	metrics.RecordPrunedBackups(1)
+	if err = metrics.Flush(); err != nil {
+		t.Fatal("No error expected", err)
+	}
+
+	contents, err := ioutil.ReadFile(config.TextfilePath)
+	if err != nil {
+		t.Fatal("Expected textfile to be written", err)
+	}
+
+	expectedLines := []string{
+		`really_simple_db_backup_backup_last_duration_seconds{type="full"} 600`,
+		`really_simple_db_backup_backup_last_size_bytes{type="full"} 2000`,
+		`really_simple_db_backup_backup_last_upload_bytes_per_second{type="full"} 100`,
+		`really_simple_db_backup_job_last_duration_seconds{job="perform"} 600`,
+		`really_simple_db_backup_job_failures_total{job="perform",stage="upload"} 1`,
+		`really_simple_db_backup_pruned_backups_total 4`,
+		`really_simple_db_backup_last_pruned_backups 1`,
+	}
+
+	for _, line := range expectedLines {
+		if !strings.Contains(string(contents), line+"\n") {
+			t.Errorf("Expected metrics to contain `%s`", line)
+		}
+	}
+}
+
+func TestMetricsOfJobsAreWrittenApart(t *testing.T) {
+	directory, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	config := &MetricsConfig{TextfilePath: path.Join(directory, "backup.prom")}
+
+	for _, job := range []string{"main", "analytics"} {
+		metrics := NewMetrics(config, path.Join(directory, job), job)
+		os.MkdirAll(path.Join(directory, job), 0700)
+		metrics.RecordJob("perform", time.Minute, "")
+		if err = metrics.Flush(); err != nil {
+			t.Fatal("No error expected", err)
+		}
+	}
+
+	for _, job := range []string{"main", "analytics"} {
+		contents, err := ioutil.ReadFile(path.Join(directory, "backup-"+job+".prom"))
+		if err != nil {
+			t.Fatal("Expected a textfile per job", err)
+		}
+
+		for _, line := range []string{
+			`really_simple_db_backup_job_last_duration_seconds{backup_job="` + job + `",job="perform"} 60`,
+			`really_simple_db_backup_pruned_backups_total{backup_job="` + job + `"} 0`,
+		} {
+			if !strings.Contains(string(contents), line+"\n") {
+				t.Errorf("Expected metrics to contain `%s`, got:\n%s", line, contents)
+			}
+		}
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	metrics := NewMetrics(nil, "", "")
+	if metrics != nil {
+		t.Fatal("Expected nil metrics without config")
+	}
+
+	// Recording on nil metrics must be a no-op
+	metrics.RecordJob("perform", time.Minute, "")
+	if err := metrics.Flush(); err != nil {
+		t.Error("No error expected", err)
+	}
+
+	var buffer bytes.Buffer
+	(&MetricsState{}).WritePrometheusText(&buffer)
+	if !strings.Contains(buffer.String(), "# TYPE really_simple_db_backup_pruned_backups_total counter") {
+		t.Error("Expected metric headers to be written")
+	}
+}
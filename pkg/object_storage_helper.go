@@ -1,30 +1,27 @@
 package pkg
 
 import (
+	"context"
 	"os"
 
-	"github.com/cheggaaa/pb"
 	minio "github.com/minio/minio-go"
 )
 
 // UploadFileToBucket uploads a file to a DigitalOcean bucket
-func UploadFileToBucket(bucketName string, objectName string, filePath string, minioClient *minio.Client) error {
+func UploadFileToBucket(ctx context.Context, bucketName string, objectName string, filePath string, minioClient *minio.Client) error {
 	stat, err := os.Stat(filePath)
 	if err != nil {
 		return err
 	}
 
-	progress := pb.New64(stat.Size())
-	progress.SetUnits(pb.U_BYTES)
-	progress.ShowSpeed = true
+	progress := NewProgressReporter("Uploading "+objectName, stat.Size())
 	progress.Start()
 
-	_, err = minioClient.FPutObject(bucketName, objectName, filePath, minio.PutObjectOptions{
+	_, err = minioClient.FPutObjectWithContext(ctx, bucketName, objectName, filePath, minio.PutObjectOptions{
 		Progress: progress,
 	})
 
-	// github.com/cheggaaa/pb does not end output with a newline. Add one here
-	Log.Print("\n")
+	progress.Finish()
 
 	return err
 }
@@ -1,32 +1,199 @@
 package pkg
 
 import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cheggaaa/pb"
 )
 
 const progressBarRecheckTime = 1
+const progressLogInterval = 30 * time.Second
 
-// ReportProgressOnFileSize will start printing the size of a file in relation to what the expected size is
-func ReportProgressOnFileSize(location string, expectedSize int64, doneChan chan bool) {
-	bar := pb.StartNew(int(expectedSize))
-	bar.SetUnits(pb.U_BYTES)
-	bar.ShowSpeed = true
-	closed := false
+// ShowProgressBars is true when progress is shown as a progress bar. Otherwise it is logged periodically
+var ShowProgressBars = true
+
+// The most recently started transfer that hasn't finished
+var currentTransfer struct {
+	sync.Mutex
+	reporter *ProgressReporter
+}
+
+// Progress is a snapshot of a transfer
+type Progress struct {
+	Label      string  `json:"label"`
+	BytesDone  int64   `json:"bytes_done"`
+	BytesTotal int64   `json:"bytes_total"`
+	Percent    float64 `json:"percent"`
+}
+
+// ProgressReporter shows the progress of a transfer, as a progress bar on a terminal and as periodic log lines otherwise
+type ProgressReporter struct {
+	label     string
+	total     int64
+	current   int64
+	startedAt time.Time
+
+	bar       *pb.ProgressBar
+	done      chan bool
+	closeOnce sync.Once
+}
+
+// NewProgressReporter creates a progress reporter for a transfer of total bytes. Call Start to start reporting
+func NewProgressReporter(label string, total int64) *ProgressReporter {
+	return &ProgressReporter{
+		label: label,
+		total: total,
+		done:  make(chan bool),
+	}
+}
+
+// Start starts showing progress
+func (reporter *ProgressReporter) Start() {
+	reporter.startedAt = time.Now()
+
+	currentTransfer.Lock()
+	currentTransfer.reporter = reporter
+	currentTransfer.Unlock()
+
+	if ShowProgressBars {
+		reporter.bar = pb.New64(reporter.total)
+		reporter.bar.SetUnits(pb.U_BYTES)
+		reporter.bar.ShowSpeed = true
+		reporter.bar.Start()
+		return
+	}
 
 	go func() {
-		<-doneChan
-		closed = true
+		ticker := time.NewTicker(progressLogInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-reporter.done:
+				return
+			case <-ticker.C:
+				Log.Println(reporter.describe())
+			}
+		}
 	}()
+}
+
+// Set sets the number of bytes transferred so far
+func (reporter *ProgressReporter) Set(current int64) {
+	atomic.StoreInt64(&reporter.current, current)
+	if reporter.bar != nil {
+		reporter.bar.Set64(current)
+	}
+}
+
+// Add adds to the number of bytes transferred so far
+func (reporter *ProgressReporter) Add(count int) {
+	current := atomic.AddInt64(&reporter.current, int64(count))
+	if reporter.bar != nil {
+		reporter.bar.Set64(current)
+	}
+}
+
+// Read counts len(p) bytes as transferred, so the reporter can be used as the progress reader of minio uploads
+func (reporter *ProgressReporter) Read(p []byte) (int, error) {
+	reporter.Add(len(p))
+	return len(p), nil
+}
+
+// NewProxyReader returns a reader that reports progress for everything read from reader
+func (reporter *ProgressReporter) NewProxyReader(reader io.Reader) io.Reader {
+	return &progressProxyReader{reader: reader, reporter: reporter}
+}
+
+// Finish stops showing progress
+func (reporter *ProgressReporter) Finish() {
+	reporter.closeOnce.Do(func() {
+		close(reporter.done)
+
+		currentTransfer.Lock()
+		if currentTransfer.reporter == reporter {
+			currentTransfer.reporter = nil
+		}
+		currentTransfer.Unlock()
 
-	for !closed {
+		if reporter.bar != nil {
+			reporter.bar.Finish()
+			return
+		}
+
+		Log.Println(reporter.describe())
+	})
+}
+
+// CurrentProgress returns the progress of the transfer in progress, or nil if there is none
+func CurrentProgress() *Progress {
+	currentTransfer.Lock()
+	reporter := currentTransfer.reporter
+	currentTransfer.Unlock()
+
+	if reporter == nil {
+		return nil
+	}
+
+	progress := &Progress{
+		Label:      reporter.label,
+		BytesDone:  atomic.LoadInt64(&reporter.current),
+		BytesTotal: reporter.total,
+	}
+	if progress.BytesTotal > 0 {
+		progress.Percent = float64(progress.BytesDone*1000/progress.BytesTotal) / 10
+	}
+
+	return progress
+}
+
+func (reporter *ProgressReporter) describe() string {
+	current := atomic.LoadInt64(&reporter.current)
+	elapsed := time.Since(reporter.startedAt)
+
+	description := fmt.Sprintf("%s: %s", reporter.label, FormatBytes(current))
+	if reporter.total > 0 {
+		description += fmt.Sprintf(" of %s (%d%%)", FormatBytes(reporter.total), current*100/reporter.total)
+	}
+	if elapsed > 0 {
+		description += fmt.Sprintf(", %s/s", FormatBytes(int64(float64(current)/elapsed.Seconds())))
+	}
+
+	return description
+}
+
+type progressProxyReader struct {
+	reader   io.Reader
+	reporter *ProgressReporter
+}
+
+func (proxy *progressProxyReader) Read(p []byte) (int, error) {
+	count, err := proxy.reader.Read(p)
+	proxy.reporter.Add(count)
+	return count, err
+}
+
+// ReportProgressOnFileSize will start printing the size of a file in relation to what the expected size is
+func ReportProgressOnFileSize(location string, expectedSize int64, doneChan chan bool) {
+	reporter := NewProgressReporter(location, expectedSize)
+	reporter.Start()
+	defer reporter.Finish()
+
+	for {
 		size, err := FileOrDirSize(location)
 		if err == nil {
-			bar.Set(int(size))
+			reporter.Set(size)
 		}
 
-		time.Sleep(progressBarRecheckTime * time.Second)
+		select {
+		case <-doneChan:
+			return
+		case <-time.After(progressBarRecheckTime * time.Second):
+		}
 	}
 }
 
@@ -40,3 +207,19 @@ func ReportProgressOnCopy(source string, destination string, doneChan chan bool)
 
 	ReportProgressOnFileSize(destination, sourceSize, doneChan)
 }
+
+// FormatBytes formats a number of bytes for humans, like 1.5 GiB
+func FormatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	divisor, exponent := int64(unit), 0
+	for remaining := bytes / unit; remaining >= unit; remaining /= unit {
+		divisor *= unit
+		exponent++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(divisor), "KMGTPE"[exponent])
+}
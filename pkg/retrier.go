@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"log"
 	"time"
 )
@@ -9,6 +10,11 @@ const maxTries = 5
 
 // WithRetry runs runner, and if it returns an error waits again, then tries again
 func WithRetry(tag string, runner func() error) error {
+	return WithRetryContext(context.Background(), tag, runner)
+}
+
+// WithRetryContext works like WithRetry but gives up as soon as ctx is cancelled
+func WithRetryContext(ctx context.Context, tag string, runner func() error) error {
 	var err error
 	for tries := 0; tries < maxTries; tries++ {
 		err = runner()
@@ -16,12 +22,20 @@ func WithRetry(tag string, runner func() error) error {
 			return nil
 		}
 
+		if ctx.Err() != nil {
+			return err
+		}
+
 		waitDuration := getWaitTime(tries)
 
 		log.Printf("%s try: %d, failed with error, sleeping %d\n", tag, tries, waitDuration)
 
 		// Wait exponentially 500 * x^2 milliseconds
-		time.Sleep(waitDuration)
+		select {
+		case <-time.After(waitDuration):
+		case <-ctx.Done():
+			return err
+		}
 	}
 	return err
 }
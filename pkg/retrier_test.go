@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
@@ -63,3 +64,22 @@ func TestRetryTimeout(t *testing.T) {
 		}
 	}
 }
+
+func TestRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := 0
+
+	err := WithRetryContext(ctx, "test", func() error {
+		runs++
+		cancel()
+		return fmt.Errorf("Error %d", runs)
+	})
+
+	if err == nil {
+		t.Error("Should return error")
+	}
+
+	if runs != 1 {
+		t.Error("Expected no retries after cancel, got", runs)
+	}
+}
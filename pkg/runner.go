@@ -0,0 +1,307 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Number of lines of the error output included in the error of a failed command
+const commandErrorLines = 10
+
+// CommandsConfig contains options for the programs run during backups and restores, like xtrabackup and mysql
+type CommandsConfig struct {
+	// Seconds after which a program is killed, by name of the program. Programs without one run until they finish
+	TimeoutSeconds map[string]int `json:"timeout_seconds"`
+
+	// Every program run by a backup or restore is recorded, with its output, in a file per run in this directory
+	TranscriptDirectory string `json:"transcript_directory"`
+}
+
+// Command is a program for a Runner to run
+type Command struct {
+	Name string
+	Args []string
+
+	// Added to the environment of this process
+	Env []string
+
+	// The input of the program. None when nil
+	Stdin io.Reader
+
+	// Receives the output of the program instead of it being returned by Run
+	Stdout io.Writer
+
+	// Also receives the error output of the program
+	Stderr io.Writer
+
+	// The output is left out of transcripts and the verbose log, like the grants returned by mysql
+	PrivateOutput bool
+}
+
+var shellSafeArgument = regexp.MustCompile(`^[a-zA-Z0-9_./:=@%+,-]+$`)
+
+// String returns the command line of command, quoted so it can be pasted in a shell
+func (command Command) String() string {
+	words := make([]string, 0, len(command.Args)+1)
+	for _, word := range append([]string{command.Name}, command.Args...) {
+		if !shellSafeArgument.MatchString(word) {
+			word = "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " ")
+}
+
+// Runner runs programs. The program is killed when ctx is cancelled.
+// Run returns the output of the program unless command.Stdout is set
+type Runner interface {
+	Run(ctx context.Context, command Command) (string, error)
+}
+
+// CommandRunner runs every program of backups and restores
+var CommandRunner Runner = &ExecRunner{}
+
+// RunCommand runs command with CommandRunner
+func RunCommand(ctx context.Context, command Command) (string, error) {
+	return CommandRunner.Run(ctx, command)
+}
+
+// ExecRunner runs programs on this machine
+type ExecRunner struct {
+	// Seconds after which a program is killed, by name of the program. Programs without one run until they finish
+	TimeoutSeconds map[string]int
+}
+
+// NewExecRunner returns a runner with the timeouts of config. A nil config has no timeouts
+func NewExecRunner(config *CommandsConfig) *ExecRunner {
+	runner := &ExecRunner{}
+	if config != nil {
+		runner.TimeoutSeconds = config.TimeoutSeconds
+	}
+	return runner
+}
+
+// Run runs command and waits for it to finish
+func (runner *ExecRunner) Run(ctx context.Context, command Command) (string, error) {
+	if VerboseMode {
+		Log.Printf("== `%s`\n", command)
+	}
+
+	runCtx := ctx
+	timeout := time.Duration(runner.TimeoutSeconds[path.Base(command.Name)]) * time.Second
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	execCmd := exec.Command(command.Name, command.Args...)
+
+	// The program runs in a process group of its own, and the whole group is killed when runCtx is done. Otherwise only
+	// the program is killed and Run keeps waiting for the programs it started, like the ones of `sh -c`
+	execCmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if len(command.Env) > 0 {
+		execCmd.Env = append(os.Environ(), command.Env...)
+	}
+	execCmd.Stdin = command.Stdin
+
+	var output bytes.Buffer
+	execCmd.Stdout = &output
+	if command.Stdout != nil {
+		execCmd.Stdout = command.Stdout
+	} else if VerboseMode && !command.PrivateOutput {
+		execCmd.Stdout = io.MultiWriter(&output, &lineWriter{onLine: func(line string) { Log.Println(line) }})
+	}
+
+	var errOutput []string
+	errWriter := &lineWriter{onLine: func(line string) {
+		if VerboseMode {
+			Log.Println(line)
+		}
+
+		errOutput = append(errOutput, line)
+		if len(errOutput) > commandErrorLines {
+			errOutput = errOutput[len(errOutput)-commandErrorLines:]
+		}
+	}}
+	execCmd.Stderr = errWriter
+	if command.Stderr != nil {
+		execCmd.Stderr = io.MultiWriter(errWriter, command.Stderr)
+	}
+
+	err := execCmd.Start()
+	if err == nil {
+		finished := make(chan struct{})
+		go func() {
+			select {
+			case <-runCtx.Done():
+				syscall.Kill(-execCmd.Process.Pid, syscall.SIGKILL)
+			case <-finished:
+			}
+		}()
+
+		// Wait waits until the output has been copied
+		err = execCmd.Wait()
+		close(finished)
+	}
+	errWriter.Flush()
+
+	if err != nil && timeout > 0 && runCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		err = fmt.Errorf("Timed out after %s", timeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s failed with:\n%s\n\nLast log lines:\n%s", command, err.Error(), lastLines(errOutput, commandErrorLines))
+	}
+
+	return output.String(), nil
+}
+
+// DryRunRunner prints the programs it is asked to run instead of running them
+type DryRunRunner struct {
+	Output io.Writer
+}
+
+// Run prints command and returns no output
+func (runner *DryRunRunner) Run(ctx context.Context, command Command) (string, error) {
+	_, err := fmt.Fprintf(runner.Output, "Would run: %s\n", command)
+	return "", err
+}
+
+// TranscriptRunner runs programs with Runner and records them, with their error output and result, in the file at Path.
+// The file is only created once the first program runs. Every line starts with the time and the number of the program in the run
+type TranscriptRunner struct {
+	Runner Runner
+	Path   string
+
+	mutex    sync.Mutex
+	file     *os.File
+	failed   bool
+	commands int
+}
+
+// NewTranscriptRunner returns a runner that records the programs run by runner in the file at transcriptPath
+func NewTranscriptRunner(runner Runner, transcriptPath string) *TranscriptRunner {
+	return &TranscriptRunner{Runner: runner, Path: transcriptPath}
+}
+
+// Run runs command with the wrapped runner and records it
+func (runner *TranscriptRunner) Run(ctx context.Context, command Command) (string, error) {
+	runner.mutex.Lock()
+	runner.commands++
+	number := runner.commands
+	runner.mutex.Unlock()
+
+	startedAt := time.Now()
+	runner.record(number, "$ "+command.String())
+
+	errWriter := &lineWriter{onLine: func(line string) { runner.record(number, "stderr: "+line) }}
+	if command.Stderr != nil {
+		command.Stderr = io.MultiWriter(command.Stderr, errWriter)
+	} else {
+		command.Stderr = errWriter
+	}
+
+	output, err := runner.Runner.Run(ctx, command)
+	errWriter.Flush()
+
+	switch {
+	case command.Stdout != nil:
+	case command.PrivateOutput:
+		runner.record(number, "stdout: (left out of the transcript)")
+	default:
+		for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+			if line != "" {
+				runner.record(number, "stdout: "+line)
+			}
+		}
+	}
+
+	duration := time.Since(startedAt).Round(time.Millisecond)
+	if err != nil {
+		runner.record(number, fmt.Sprintf("failed after %s: %s", duration, strings.SplitN(err.Error(), "\n", 2)[0]))
+	} else {
+		runner.record(number, fmt.Sprintf("succeeded after %s", duration))
+	}
+
+	return output, err
+}
+
+// Close closes the transcript file
+func (runner *TranscriptRunner) Close() error {
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+
+	if runner.file == nil {
+		return nil
+	}
+
+	err := runner.file.Close()
+	runner.file = nil
+	return err
+}
+
+// record writes a line to the transcript. A transcript that can't be written is skipped with a warning
+func (runner *TranscriptRunner) record(number int, line string) {
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+
+	if runner.failed {
+		return
+	}
+
+	if runner.file == nil {
+		err := os.MkdirAll(path.Dir(runner.Path), 0700)
+		if err == nil {
+			runner.file, err = os.OpenFile(runner.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		}
+		if err != nil {
+			runner.failed = true
+			ErrorLog.Println("Warning: Could not write the transcript of the commands of this run.", err)
+			return
+		}
+
+		Log.Printf("Recording the commands of this run in %s\n", runner.Path)
+	}
+
+	fmt.Fprintf(runner.file, "%s #%d %s\n", time.Now().UTC().Format(time.RFC3339), number, line)
+}
+
+// lineWriter calls onLine for every line written to it
+type lineWriter struct {
+	onLine  func(line string)
+	partial []byte
+}
+
+func (writer *lineWriter) Write(p []byte) (int, error) {
+	writer.partial = append(writer.partial, p...)
+	for {
+		index := bytes.IndexByte(writer.partial, '\n')
+		if index < 0 {
+			break
+		}
+
+		writer.onLine(string(bytes.TrimSuffix(writer.partial[:index], []byte("\r"))))
+		writer.partial = writer.partial[index+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush passes on the last line if it didn't end with a newline
+func (writer *lineWriter) Flush() {
+	if len(writer.partial) > 0 {
+		writer.onLine(string(writer.partial))
+		writer.partial = nil
+	}
+}
@@ -0,0 +1,150 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecRunnerReturnsAllOutput(t *testing.T) {
+	runner := &ExecRunner{}
+
+	output, err := runner.Run(context.Background(), Command{Name: "sh", Args: []string{"-c", "seq 1 5000; echo done >&2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 5000 || lines[4999] != "5000" {
+		t.Errorf("Expected 5000 lines of output, got %d", len(lines))
+	}
+}
+
+func TestExecRunnerIncludesErrorOutputInErrors(t *testing.T) {
+	runner := &ExecRunner{}
+
+	_, err := runner.Run(context.Background(), Command{Name: "sh", Args: []string{"-c", "echo first >&2; echo last >&2; exit 3"}})
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	if !strings.Contains(err.Error(), "exit status 3") || !strings.HasSuffix(err.Error(), "first\nlast") {
+		t.Error("Wrong. Got", err)
+	}
+}
+
+func TestExecRunnerLeavesPrivateOutputOutOfTheLog(t *testing.T) {
+	var logOutput bytes.Buffer
+	previousLog := Log
+	defer func() { Log = previousLog; VerboseMode = false }()
+	Log = log.New(&logOutput, "", 0)
+	VerboseMode = true
+
+	runner := &ExecRunner{}
+
+	output, err := runner.Run(context.Background(), Command{Name: "sh", Args: []string{"-c", "echo \"$GRANTS\""}, Env: []string{"GRANTS=GRANT ALL"}, PrivateOutput: true})
+	if err != nil || output != "GRANT ALL\n" {
+		t.Fatalf("Expected the output to be returned, got %q (%v)", output, err)
+	}
+
+	if strings.Contains(logOutput.String(), "GRANT ALL") {
+		t.Errorf("Expected private output not to be logged, got:\n%s", logOutput.String())
+	}
+
+	runner.Run(context.Background(), Command{Name: "sh", Args: []string{"-c", "echo public"}})
+	if !strings.Contains(logOutput.String(), "public") {
+		t.Errorf("Expected other output to be logged in verbose mode, got:\n%s", logOutput.String())
+	}
+}
+
+func TestExecRunnerTimesOut(t *testing.T) {
+	runner := &ExecRunner{TimeoutSeconds: map[string]int{"sleep": 1}}
+
+	_, err := runner.Run(context.Background(), Command{Name: "/bin/sleep", Args: []string{"5"}})
+	if err == nil || !strings.Contains(err.Error(), "Timed out after 1s") {
+		t.Error("Expected a timeout. Got", err)
+	}
+}
+
+func TestExecRunnerKillsProgramsStartedByTheProgram(t *testing.T) {
+	runner := &ExecRunner{TimeoutSeconds: map[string]int{"sh": 1}}
+
+	startedAt := time.Now()
+	_, err := runner.Run(context.Background(), Command{Name: "sh", Args: []string{"-c", "sleep 5; echo done"}})
+	if err == nil || !strings.Contains(err.Error(), "Timed out after 1s") {
+		t.Error("Expected a timeout. Got", err)
+	}
+
+	if elapsed := time.Since(startedAt); elapsed > 3*time.Second {
+		t.Errorf("Expected the program to be stopped after 1s, it took %s", elapsed)
+	}
+}
+
+func TestDryRunRunnerPrintsCommands(t *testing.T) {
+	var output bytes.Buffer
+	runner := &DryRunRunner{Output: &output}
+
+	result, err := runner.Run(context.Background(), Command{Name: "mysql", Args: []string{"-e", "SHOW GRANTS", "--user=root"}})
+	if err != nil || result != "" {
+		t.Fatal("Wrong. Got", result, err)
+	}
+
+	if output.String() != "Would run: mysql -e 'SHOW GRANTS' --user=root\n" {
+		t.Error("Wrong. Got", output.String())
+	}
+}
+
+func TestTranscriptRunnerRecordsCommands(t *testing.T) {
+	directory, err := ioutil.TempDir("", "transcript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	transcriptPath := path.Join(directory, "runs", "run.log")
+	runner := NewTranscriptRunner(&ExecRunner{}, transcriptPath)
+	defer runner.Close()
+
+	if _, err := os.Stat(transcriptPath); !os.IsNotExist(err) {
+		t.Fatal("Expected no transcript before a command runs")
+	}
+
+	output, err := runner.Run(context.Background(), Command{Name: "sh", Args: []string{"-c", "echo hello; echo warning >&2"}})
+	if err != nil || output != "hello\n" {
+		t.Fatal("Wrong. Got", output, err)
+	}
+	runner.Run(context.Background(), Command{Name: "sh", Args: []string{"-c", "echo secret"}, PrivateOutput: true})
+	runner.Run(context.Background(), Command{Name: "false"})
+
+	transcript, err := ioutil.ReadFile(transcriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"#1 $ sh -c 'echo hello; echo warning >&2'",
+		"#1 stderr: warning",
+		"#1 stdout: hello",
+		"#1 succeeded after",
+		"#2 stdout: (left out of the transcript)",
+		"#3 failed after",
+	} {
+		if !strings.Contains(string(transcript), expected) {
+			t.Errorf("Expected the transcript to contain %q, got:\n%s", expected, transcript)
+		}
+	}
+	if strings.Contains(string(transcript), "secret\n") {
+		t.Errorf("Expected private output to be left out, got:\n%s", transcript)
+	}
+
+	info, err := os.Stat(transcriptPath)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Error("Expected the transcript to only be readable by its owner. Got", info.Mode(), err)
+	}
+}
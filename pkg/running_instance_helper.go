@@ -6,12 +6,15 @@ import (
 	"github.com/digitalocean/go-metadata"
 )
 
+// MetadataOptions configures the client of the droplet metadata API. The integration tests point it at a fake
+var MetadataOptions []metadata.ClientOption
+
 // GetRunningInstanceData returns current droplets data
 func GetRunningInstanceData() (*metadata.Metadata, error) {
 	var err error
 	var result *metadata.Metadata
 	for tries := 0; tries < 5; tries++ {
-		client := metadata.NewClient()
+		client := metadata.NewClient(MetadataOptions...)
 		result, err = client.Metadata()
 		if err == nil {
 			return result, nil
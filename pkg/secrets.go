@@ -0,0 +1,162 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Prefixes of secret references
+const (
+	SecretRefEnv   = "env:"
+	SecretRefFile  = "file:"
+	SecretRefVault = "vault:"
+)
+
+var vaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// VaultConfig contains where secrets referenced as `vault:path#key` are read from
+type VaultConfig struct {
+	// Default: $VAULT_ADDR
+	Address string `json:"address"`
+
+	// Default: $VAULT_TOKEN
+	Token string `json:"token" secret:"true"`
+
+	// Enterprise namespace sent as X-Vault-Namespace
+	Namespace string `json:"namespace"`
+}
+
+// SecretResolver resolves secret references. Vault secrets are read once per path
+type SecretResolver struct {
+	Vault *VaultConfig
+
+	vaultSecrets map[string]map[string]interface{}
+}
+
+// IsSecretRef returns whether value references a secret instead of being one
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, SecretRefEnv) || strings.HasPrefix(value, SecretRefFile) || strings.HasPrefix(value, SecretRefVault)
+}
+
+// Resolve returns the secret value references. Values that aren't references are returned as is
+func (resolver *SecretResolver) Resolve(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, SecretRefEnv):
+		name := strings.TrimPrefix(value, SecretRefEnv)
+
+		secret, isSet := os.LookupEnv(name)
+		if !isSet {
+			return "", fmt.Errorf("Environment variable %s is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, SecretRefFile):
+		secretPath := strings.TrimPrefix(value, SecretRefFile)
+
+		contents, err := ioutil.ReadFile(secretPath)
+		if err != nil {
+			return "", fmt.Errorf("Could not read secret file: %s", err)
+		}
+
+		// Files written by editors and `echo` end with a newline that isn't part of the secret
+		return strings.TrimRight(string(contents), "\r\n"), nil
+	case strings.HasPrefix(value, SecretRefVault):
+		reference := strings.TrimPrefix(value, SecretRefVault)
+
+		separator := strings.LastIndex(reference, "#")
+		if separator <= 0 || separator == len(reference)-1 {
+			return "", fmt.Errorf("Vault reference %s should be in the format vault:path#key", value)
+		}
+
+		return resolver.readVaultSecret(reference[:separator], reference[separator+1:])
+	}
+
+	return value, nil
+}
+
+func (resolver *SecretResolver) readVaultSecret(secretPath string, key string) (string, error) {
+	secrets, cached := resolver.vaultSecrets[secretPath]
+	if !cached {
+		var err error
+		secrets, err = resolver.fetchVaultSecret(secretPath)
+		if err != nil {
+			return "", err
+		}
+
+		if resolver.vaultSecrets == nil {
+			resolver.vaultSecrets = make(map[string]map[string]interface{})
+		}
+		resolver.vaultSecrets[secretPath] = secrets
+	}
+
+	secret, exists := secrets[key]
+	if !exists {
+		return "", fmt.Errorf("Vault secret %s has no key %s", secretPath, key)
+	}
+
+	secretString, isString := secret.(string)
+	if !isString {
+		return "", fmt.Errorf("Key %s of Vault secret %s is not a string", key, secretPath)
+	}
+
+	return secretString, nil
+}
+
+// fetchVaultSecret reads secretPath from Vault. KV version 2 paths include `data/`, like `secret/data/backups`
+func (resolver *SecretResolver) fetchVaultSecret(secretPath string) (map[string]interface{}, error) {
+	vaultConfig := VaultConfig{}
+	if resolver.Vault != nil {
+		vaultConfig = *resolver.Vault
+	}
+	if vaultConfig.Address == "" {
+		vaultConfig.Address = os.Getenv("VAULT_ADDR")
+	}
+	if vaultConfig.Token == "" {
+		vaultConfig.Token = os.Getenv("VAULT_TOKEN")
+	}
+
+	if vaultConfig.Address == "" || vaultConfig.Token == "" {
+		return nil, errors.New("Vault address and token are required for vault: references. Set vault.address and vault.token or $VAULT_ADDR and $VAULT_TOKEN")
+	}
+
+	request, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(vaultConfig.Address, "/")+"/v1/"+strings.TrimPrefix(secretPath, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("X-Vault-Token", vaultConfig.Token)
+	if vaultConfig.Namespace != "" {
+		request.Header.Set("X-Vault-Namespace", vaultConfig.Namespace)
+	}
+
+	response, err := vaultHTTPClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read Vault secret %s: %s", secretPath, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Could not read Vault secret %s: Vault responded with %s", secretPath, response.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode Vault secret %s: %s", secretPath, err)
+	}
+
+	// KV version 2 nests the secret with its metadata
+	nested, isNested := body.Data["data"].(map[string]interface{})
+	if _, hasMetadata := body.Data["metadata"]; isNested && hasMetadata {
+		return nested, nil
+	}
+
+	return body.Data, nil
+}
@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestResolveEnvAndFileSecrets(t *testing.T) {
+	os.Setenv("RSDB_TEST_SECRET", "from-env")
+	defer os.Unsetenv("RSDB_TEST_SECRET")
+
+	directory, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	secretPath := path.Join(directory, "secret")
+	ioutil.WriteFile(secretPath, []byte("from-file\n"), 0600)
+
+	resolver := &SecretResolver{}
+
+	tests := map[string]string{
+		"plain":                 "plain",
+		"env:RSDB_TEST_SECRET":  "from-env",
+		"file:" + secretPath:    "from-file",
+		"https://example.com/x": "https://example.com/x",
+	}
+
+	for value, expected := range tests {
+		resolved, err := resolver.Resolve(value)
+		if err != nil || resolved != expected {
+			t.Errorf("Expected %s to resolve to %s, got %s (%v)", value, expected, resolved, err)
+		}
+	}
+
+	for _, value := range []string{"env:RSDB_TEST_UNSET", "file:" + path.Join(directory, "missing"), "vault:secret/backups", "vault:#key"} {
+		if _, err := resolver.Resolve(value); err == nil {
+			t.Errorf("Expected %s to fail", value)
+		}
+	}
+}
+
+func TestResolveVaultSecrets(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		requests++
+
+		if request.Header.Get("X-Vault-Token") != "token" || request.Header.Get("X-Vault-Namespace") != "ops" {
+			responseWriter.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch request.URL.Path {
+		case "/v1/secret/data/backups":
+			responseWriter.Write([]byte(`{"data": {"data": {"space_secret": "kv2-secret"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/backups":
+			responseWriter.Write([]byte(`{"data": {"space_secret": "kv1-secret", "port": 25}}`))
+		default:
+			responseWriter.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	resolver := &SecretResolver{Vault: &VaultConfig{Address: server.URL, Token: "token", Namespace: "ops"}}
+
+	tests := map[string]string{
+		"vault:secret/data/backups#space_secret": "kv2-secret",
+		"vault:kv/backups#space_secret":          "kv1-secret",
+	}
+
+	for value, expected := range tests {
+		resolved, err := resolver.Resolve(value)
+		if err != nil || resolved != expected {
+			t.Errorf("Expected %s to resolve to %s, got %s (%v)", value, expected, resolved, err)
+		}
+	}
+
+	for _, value := range []string{"vault:kv/backups#missing", "vault:kv/backups#port", "vault:kv/unknown#key"} {
+		if _, err := resolver.Resolve(value); err == nil {
+			t.Errorf("Expected %s to fail", value)
+		}
+	}
+
+	if requests != 3 {
+		t.Errorf("Expected every path to be read once, got %d requests", requests)
+	}
+}
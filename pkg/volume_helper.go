@@ -8,6 +8,9 @@ import (
 	"github.com/digitalocean/godo"
 )
 
+// VolumeSettleTime is how long MountVolume waits for the attached volume to show up on the droplet
+var VolumeSettleTime = 30 * time.Second
+
 // FindVolume finds a DigitalOcean volume by ID
 func FindVolume(id string, digitalOceanClient *DigitalOceanClient) (*godo.Volume, error) {
 	volume, _, err := digitalOceanClient.Client.Storage.GetVolume(
@@ -52,7 +55,7 @@ func MountVolume(volumeName string, mountPoint string, volumeID string, dropletI
 	// Wait for system to settle
 	Log.Println("Mounted volume on host.")
 	Log.Println("Waiting for system to settle down.")
-	time.Sleep(30 * time.Second)
+	time.Sleep(VolumeSettleTime)
 
 	// Volume is now available in /dev/disk/by-id/scsi-0DO_Volume_$VOLUME_NAME
 	diskLocation := "/dev/disk/by-id/scsi-0DO_Volume_" + volumeName
@@ -67,7 +70,7 @@ func MountVolume(volumeName string, mountPoint string, volumeID string, dropletI
 	// In my tests this command returned 32 for any reason even though the mount was correct
 	if err != nil {
 		Log.Println("mount indicated it did not succeed. Running manual test to confirm", err)
-		time.Sleep(30 * time.Second)
+		time.Sleep(VolumeSettleTime)
 
 		file, testErr := os.Create(mountPoint + "/test-mount")
 		if testErr != nil {
@@ -98,6 +101,11 @@ func UnmountVolume(mountPoint string, volumeID string, dropletID int, digitalOce
 	}
 
 	// - Detach volume
+	return DetachVolume(volumeID, dropletID, digitalOceanClient)
+}
+
+// DetachVolume detaches a volume from a droplet and won't return until complete
+func DetachVolume(volumeID string, dropletID int, digitalOceanClient *DigitalOceanClient) error {
 	action, _, err := digitalOceanClient.Client.StorageActions.DetachByDropletID(
 		digitalOceanClient.Context,
 		volumeID,
@@ -108,9 +116,38 @@ func UnmountVolume(mountPoint string, volumeID string, dropletID int, digitalOce
 		return err
 	}
 
-	err = digitalOceanWaitForAction(action, digitalOceanClient)
+	return digitalOceanWaitForAction(action, digitalOceanClient)
+}
 
-	return err
+// ListVolumes lists all volumes in the account
+func ListVolumes(digitalOceanClient *DigitalOceanClient) ([]godo.Volume, error) {
+	volumes := make([]godo.Volume, 0)
+	listOptions := &godo.ListOptions{PerPage: 200}
+
+	for {
+		page, response, err := digitalOceanClient.Client.Storage.ListVolumes(
+			digitalOceanClient.Context,
+			&godo.ListVolumeParams{ListOptions: listOptions},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		volumes = append(volumes, page...)
+
+		if response.Links == nil || response.Links.IsLastPage() {
+			break
+		}
+
+		currentPage, err := response.Links.CurrentPage()
+		if err != nil {
+			return nil, err
+		}
+
+		listOptions.Page = currentPage + 1
+	}
+
+	return volumes, nil
 }
 
 // DestroyVolume destroys a volume